@@ -0,0 +1,120 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// AmbiguousApply is a command log whose apply future failed with
+// raft.ErrLeadershipLost, along with the ground truth about whether it was
+// committed anyway. See ApplyLost().
+type AmbiguousApply struct {
+	control *Control
+	future  raft.ApplyFuture
+	index   uint64 // Index of the command log
+	term    uint64 // Term of the command log
+}
+
+// ApplyLost applies a command log with the given data on the current leader
+// and deposes the leader before the command log gets committed, so that its
+// apply future fails with raft.ErrLeadershipLost.
+//
+// If committed is true, the leader is deposed after the command log has been
+// appended to all followers: the next leader will commit it, even if the
+// client saw an error. Otherwise the leader is deposed before the command log
+// reaches any follower, and it will never be committed.
+//
+// It's meant to test client retry logic against both outcomes of an ambiguous
+// apply. After calling it a new leader must be elected with Elect(), and then
+// AmbiguousApply.Committed() reports the ground truth.
+func (c *Control) ApplyLost(data []byte, committed bool) *AmbiguousApply {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: apply lost: no leader was elected")
+	}
+	leader := c.term.id
+
+	// Make sure all previous command logs have been appended, so we know
+	// which one is the next.
+	c.Barrier()
+	n := c.network.CommandsAppended(leader) + 1
+
+	dispatch := c.term.When().Command(n)
+	if committed {
+		dispatch.Appended().Depose()
+	} else {
+		dispatch.Enqueued().Depose()
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: apply lost: server %s: command %d (committed=%v)", leader, n, committed))
+
+	future := c.servers[leader].Apply(data, Duration(time.Second))
+	if err := future.Error(); err != raft.ErrLeadershipLost {
+		c.t.Fatalf("raft-test: apply lost: server %s: expected leadership lost, got: %v", leader, err)
+	}
+
+	log := raft.Log{}
+	if err := c.stores.Get(leader).GetLog(future.Index(), &log); err != nil {
+		c.t.Fatalf("raft-test: apply lost: server %s: failed to get log %d: %v", leader, future.Index(), err)
+	}
+
+	return &AmbiguousApply{
+		control: c,
+		future:  future,
+		index:   log.Index,
+		term:    log.Term,
+	}
+}
+
+// Future returns the apply future of the command log, which failed with
+// raft.ErrLeadershipLost.
+func (a *AmbiguousApply) Future() raft.ApplyFuture {
+	return a.future
+}
+
+// Committed reports whether the command log was actually committed by the
+// cluster, regardless of the error returned by its apply future.
+//
+// A new leader must have been elected with Elect(). The cluster is settled
+// with Barrier() before checking.
+func (a *AmbiguousApply) Committed() bool {
+	c := a.control
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: apply lost: no leader was elected")
+	}
+	leader := c.term.id
+
+	c.Barrier()
+
+	if c.servers[leader].AppliedIndex() < a.index {
+		return false
+	}
+	log := raft.Log{}
+	if err := c.stores.Get(leader).GetLog(a.index, &log); err != nil {
+		if err == raft.ErrLogNotFound {
+			return false
+		}
+		c.t.Fatalf("raft-test: apply lost: server %s: failed to get log %d: %v", leader, a.index, err)
+	}
+
+	return log.Term == a.term
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A command log whose apply failed with ErrLeadershipLost gets committed by
+// the next leader.
+func TestControl_ApplyLost_Committed(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+
+	apply := control.ApplyLost([]byte{}, true)
+	assert.Equal(t, raft.ErrLeadershipLost, apply.Future().Error())
+
+	control.Elect("1")
+	assert.True(t, apply.Committed())
+	assert.Equal(t, uint64(2), control.Commands("1"))
+}
+
+// A command log whose apply failed with ErrLeadershipLost is never
+// committed.
+func TestControl_ApplyLost_NotCommitted(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+
+	apply := control.ApplyLost([]byte{}, false)
+
+	control.Elect("1")
+	require.NoError(t, rafts["1"].Apply([]byte{}, time.Second).Error())
+
+	assert.False(t, apply.Committed())
+	assert.Equal(t, uint64(2), control.Commands("1"))
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ApplyN applies n empty command logs through the current leader and waits
+// for all of them to be committed and applied to its FSM, returning the index
+// of the last one. This is handy to advance the log by a bunch of entries,
+// for example to trigger a snapshot, without computing indexes by hand.
+//
+// Commands are pipelined, and the given timeout (the default one if zero, see
+// Duration()) applies to each of them. The test fails if there's no leader or
+// if any command fails.
+//
+// If the leader was elected with Elect(), it then waits for the FSMs of all
+// connected followers to catch up, as BarrierTimeout() does with the same
+// timeout.
+func (c *Control) ApplyN(n int, timeout time.Duration) uint64 {
+	c.t.Helper()
+
+	id := c.leader()
+	if id == "" {
+		c.t.Fatalf("raft-test: apply: no leader:\n%s", c)
+	}
+	r := c.servers[id]
+	timeout = waitTimeout(c.t, timeout)
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: apply %d commands", id, n))
+
+	futures := make([]raft.ApplyFuture, n)
+	for i := range futures {
+		futures[i] = r.Apply([]byte{}, timeout)
+	}
+
+	index := uint64(0)
+	for i, future := range futures {
+		if err := future.Error(); err != nil {
+			c.t.Fatalf("raft-test: apply: server %s: command %d of %d failed: %v", id, i+1, n, err)
+		}
+		index = future.Index()
+	}
+
+	c.BarrierTimeout(timeout)
+
+	return index
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Apply a bunch of commands through the leader and wait for followers to
+// catch up.
+func TestControl_ApplyN(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	index := control.ApplyN(10, time.Second)
+	for id, r := range rafts {
+		assert.Equal(t, uint64(10), control.Commands(id))
+		assert.True(t, r.AppliedIndex() >= index)
+	}
+}
+
+// If there's no leader, applying commands fails.
+func TestControl_ApplyN_NoLeader(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	assert.Panics(t, func() { control.ApplyN(3, time.Second) })
+	assert.Contains(t, buffer.String(), "raft-test: apply: no leader")
+}
+
+// If a follower doesn't catch up within the timeout, the barrier fails.
+func TestControl_BarrierTimeout(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.SetFSMHooks("1", rafttest.FSMHooks{
+		Apply: func(n uint64, log *raft.Log) interface{} {
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		},
+	})
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+
+	assert.Panics(t, func() { control.BarrierTimeout(50 * time.Millisecond) })
+	assert.Contains(t, buffer.String(), "raft-test: barrier: servers 1 did not apply 1 commands")
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunSummary is the outcome of a whole test run, as saved by the
+// ResultsArchive option.
+type RunSummary struct {
+	Scenario      string        // Name of the test
+	Revision      string        // Revision of the code under test
+	Time          time.Time     // When the cluster was closed
+	Duration      time.Duration // How long the cluster was running
+	Failed        bool          // Whether the test failed
+	LeaderChanges uint64        // See Control.LeaderChanges()
+	ElectRetries  uint64        // Elections that Elect() had to retry
+	Steps         []StepResult  // See Control.Results()
+	Violations    []string      // Violations found by the Invariants option
+}
+
+// Environment variable holding the revision used by the ResultsArchive option.
+const revisionEnv = "RAFT_TEST_REVISION"
+
+// Relative growth of durations and counts that CompareRuns() is given when
+// the cluster is closed.
+const archiveTolerance = 0.5
+
+// LoadRuns returns the summaries archived by the ResultsArchive option in the
+// given directory for the test with the given name, oldest first.
+func LoadRuns(dir, scenario string) ([]RunSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, scenarioName(scenario), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]RunSummary, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		run := RunSummary{}
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.Before(runs[j].Time) })
+
+	return runs, nil
+}
+
+// CompareRuns returns a description of the significant regressions of the
+// current run with respect to the previous one: the run or one of its steps
+// failing, durations and counts growing by more than the given fraction (for
+// example 0.5 for 50%), more invariant violations and steps checking fewer
+// invariants.
+func CompareRuns(previous, current RunSummary, tolerance float64) []string {
+	regressions := make([]string, 0)
+
+	if current.Failed && !previous.Failed {
+		regressions = append(regressions, "run failed")
+	}
+	if grew(uint64(previous.Duration), uint64(current.Duration), tolerance) {
+		regressions = append(regressions, fmt.Sprintf("duration grew from %s to %s", previous.Duration, current.Duration))
+	}
+	if grew(previous.LeaderChanges, current.LeaderChanges, tolerance) {
+		regressions = append(regressions, fmt.Sprintf("leader changes grew from %d to %d", previous.LeaderChanges, current.LeaderChanges))
+	}
+	if grew(previous.ElectRetries, current.ElectRetries, tolerance) {
+		regressions = append(regressions, fmt.Sprintf("election retries grew from %d to %d", previous.ElectRetries, current.ElectRetries))
+	}
+	if len(current.Violations) > len(previous.Violations) {
+		regressions = append(regressions, fmt.Sprintf("invariant violations grew from %d to %d", len(previous.Violations), len(current.Violations)))
+	}
+
+	steps := make(map[string]StepResult)
+	for _, step := range previous.Steps {
+		steps[step.Name] = step
+	}
+	for _, step := range current.Steps {
+		before, ok := steps[step.Name]
+		if !ok {
+			continue
+		}
+		if step.Failed && !before.Failed {
+			regressions = append(regressions, fmt.Sprintf("step %s failed", step.Name))
+		}
+		if grew(uint64(before.Duration), uint64(step.Duration), tolerance) {
+			regressions = append(regressions, fmt.Sprintf("step %s: duration grew from %s to %s", step.Name, before.Duration, step.Duration))
+		}
+		if len(step.Invariants) < len(before.Invariants) {
+			regressions = append(regressions, fmt.Sprintf("step %s: invariants checked dropped from %d to %d", step.Name, len(before.Invariants), len(step.Invariants)))
+		}
+	}
+
+	return regressions
+}
+
+// Return true if the current value exceeds the previous one by more than the
+// given fraction of it.
+func grew(previous, current uint64, tolerance float64) bool {
+	return float64(current) > float64(previous)*(1+tolerance)
+}
+
+// Save a summary of the run to the directory set with the ResultsArchive
+// option, if any, logging regressions with respect to the latest one of a
+// different revision.
+func (c *Control) archiveRun() {
+	if c.archiveDir == "" {
+		return
+	}
+
+	current := RunSummary{
+		Scenario:      c.testName(),
+		Revision:      revision(),
+		Time:          time.Now(),
+		Duration:      time.Since(c.uptime.created),
+		Failed:        c.failed(),
+		LeaderChanges: c.LeaderChanges(),
+		ElectRetries:  c.electRetries,
+		Steps:         c.Results(),
+	}
+	if c.invariants != nil {
+		current.Violations = c.invariants.Stop()
+	}
+
+	runs, err := LoadRuns(c.archiveDir, current.Scenario)
+	if err != nil {
+		c.t.Errorf("raft-test: close: archive: %v", err)
+		return
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		previous := runs[i]
+		if previous.Revision == current.Revision {
+			continue
+		}
+		for _, regression := range CompareRuns(previous, current, archiveTolerance) {
+			c.t.Logf("raft-test: close: archive: regression since revision %s: %s", previous.Revision, regression)
+		}
+		break
+	}
+
+	dir := filepath.Join(c.archiveDir, scenarioName(current.Scenario))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.t.Errorf("raft-test: close: archive: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		c.t.Errorf("raft-test: close: archive: %v", err)
+		return
+	}
+	name := fmt.Sprintf("%s-%d.json", unsafeFilenameChars.ReplaceAllString(current.Revision, "_"), current.Time.UnixNano())
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		c.t.Errorf("raft-test: close: archive: %v", err)
+	}
+}
+
+// Return true if the test using the cluster has failed.
+func (c *Control) failed() bool {
+	if c.errored {
+		return true
+	}
+	reporter, ok := c.t.(interface{ Failed() bool })
+	return ok && reporter.Failed()
+}
+
+// Match characters that are not safe to use in file names.
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Return the name of the archive directory of the given scenario.
+func scenarioName(scenario string) string {
+	return unsafeFilenameChars.ReplaceAllString(scenario, "_")
+}
+
+// Return the revision of the code under test, or "unknown".
+func revision() string {
+	if env := os.Getenv(revisionEnv); env != "" {
+		return env
+	}
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run summaries are archived by revision, and compared with the previous one.
+func TestResultsArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	if env, ok := os.LookupEnv("RAFT_TEST_REVISION"); ok {
+		defer os.Setenv("RAFT_TEST_REVISION", env)
+	} else {
+		defer os.Unsetenv("RAFT_TEST_REVISION")
+	}
+
+	os.Setenv("RAFT_TEST_REVISION", "r1")
+	_, control := rafttest.Cluster(rafttest.NewReporter(ioutil.Discard), rafttest.FSMs(3), rafttest.ResultsArchive(dir), rafttest.DiscardLogger())
+	control.Close()
+
+	os.Setenv("RAFT_TEST_REVISION", "r2")
+	buffer := bytes.NewBuffer(nil)
+	reporter := &failedReporter{Reporter: rafttest.NewReporter(buffer)}
+	_, control = rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.ResultsArchive(dir), rafttest.DiscardLogger())
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "regression since revision r1: run failed")
+
+	// A second run at the same revision is archived too, and still compared
+	// with the previous revision.
+	buffer.Reset()
+	_, control = rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.ResultsArchive(dir), rafttest.DiscardLogger())
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "regression since revision r1: run failed")
+
+	runs, err := rafttest.LoadRuns(dir, "raft-test")
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	assert.Equal(t, "r1", runs[0].Revision)
+	assert.Equal(t, "r2", runs[1].Revision)
+	assert.Equal(t, "r2", runs[2].Revision)
+	assert.False(t, runs[0].Failed)
+	assert.True(t, runs[1].Failed)
+	assert.True(t, runs[2].Failed)
+}
+
+// Regressions beyond the tolerance are reported.
+func TestCompareRuns(t *testing.T) {
+	previous := rafttest.RunSummary{
+		Duration:      time.Second,
+		LeaderChanges: 1,
+		Steps: []rafttest.StepResult{
+			{Name: "a", Duration: time.Second, Invariants: []string{"x", "y"}},
+			{Name: "b", Duration: time.Second},
+		},
+	}
+	current := rafttest.RunSummary{
+		Duration:      1200 * time.Millisecond,
+		Failed:        true,
+		LeaderChanges: 2,
+		ElectRetries:  1,
+		Violations:    []string{"two leaders in term 2"},
+		Steps: []rafttest.StepResult{
+			{Name: "a", Duration: 2 * time.Second, Invariants: []string{"x"}},
+			{Name: "b", Duration: time.Second, Failed: true},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"run failed",
+		"leader changes grew from 1 to 2",
+		"election retries grew from 0 to 1",
+		"invariant violations grew from 0 to 1",
+		"step a: duration grew from 1s to 2s",
+		"step a: invariants checked dropped from 2 to 1",
+		"step b failed",
+	}, rafttest.CompareRuns(previous, current, 0.5))
+	assert.Empty(t, rafttest.CompareRuns(previous, previous, 0.5))
+}
+
+// Reporter of a test that has already failed.
+type failedReporter struct {
+	rafttest.Reporter
+}
+
+func (r *failedReporter) Failed() bool {
+	return true
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/raft"
+)
+
+// Environment variable holding the default directory of the Artifacts option.
+const artifactsEnv = "RAFT_TEST_ARTIFACTS"
+
+// Write the artifacts of a failed test to a new sub-directory of the
+// directory set with the Artifacts option.
+func (c *Control) writeArtifacts() {
+	if c.artifactsDir == "" || !c.failed() {
+		return
+	}
+
+	name := unsafeFilenameChars.ReplaceAllString(c.testName(), "_")
+
+	if err := os.MkdirAll(c.artifactsDir, 0755); err != nil {
+		c.t.Errorf("raft-test: close: artifacts: %v", err)
+		return
+	}
+	dir, err := ioutil.TempDir(c.artifactsDir, name+"-")
+	if err != nil {
+		c.t.Errorf("raft-test: close: artifacts: %v", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.txt"), []byte(c.diagnostics()+"\n"), 0644); err != nil {
+		c.t.Errorf("raft-test: close: artifacts: %v", err)
+	}
+	for _, d := range c.nodes {
+		if err := writeNodeArtifacts(filepath.Join(dir, string(d.Conf.LocalID)), d); err != nil {
+			c.t.Errorf("raft-test: close: artifacts: server %s: %v", d.Conf.LocalID, err)
+		}
+	}
+
+	c.t.Logf("raft-test: close: artifacts written to %s", dir)
+}
+
+// Write the raft log output, the log store entries and the snapshots of the
+// server with the given dependencies to the given directory.
+func writeNodeArtifacts(dir string, d *dependencies) error {
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0755); err != nil {
+		return err
+	}
+
+	output := strings.Join(d.Capture.Lines(), "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "raft.log"), []byte(output), 0644); err != nil {
+		return err
+	}
+
+	if err := writeLogStore(filepath.Join(dir, "logs.txt"), d); err != nil {
+		return fmt.Errorf("log store: %v", err)
+	}
+
+	snapshots, err := d.Snaps.List()
+	if err != nil {
+		return fmt.Errorf("list snapshots: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		if err := writeSnapshot(filepath.Join(dir, "snapshots", snapshot.ID), d, snapshot.ID); err != nil {
+			return fmt.Errorf("snapshot %s: %v", snapshot.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Write a table with the entries of the log store of the server with the given
+// dependencies to the given file, one per line.
+func writeLogStore(path string, d *dependencies) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	first, err := d.Logs.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := d.Logs.LastIndex()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "index\tterm\ttype\tdata")
+	for index := first; index <= last && last != 0; index++ {
+		log := raft.Log{}
+		if err := d.Logs.GetLog(index, &log); err != nil {
+			fmt.Fprintf(w, "%d\t\t\terror: %v\n", index, err)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%x\n", log.Index, log.Term, logTypeName(log.Type), log.Data)
+	}
+
+	return w.Flush()
+}
+
+// Write the metadata and the content of the snapshot with the given ID to the
+// given path, with .json and .bin extensions respectively.
+func writeSnapshot(path string, d *dependencies, id string) error {
+	meta, reader, err := d.Snaps.Open(id)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".json", data, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path + ".bin")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, reader)
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// If the test fails, logs, log stores and snapshots of all servers are written
+// to the artifacts directory.
+func TestArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.Artifacts(dir), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte("hello"), time.Second).Error())
+	require.NoError(t, control.Snapshot(rafts["0"]))
+
+	assert.Panics(t, func() { control.WaitIndex("1", 100, 50*time.Millisecond) })
+	control.Close()
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Contains(t, buffer.String(), "artifacts written to "+filepath.Join(dir, entries[0].Name()))
+
+	dir = filepath.Join(dir, entries[0].Name())
+
+	cluster, err := ioutil.ReadFile(filepath.Join(dir, "cluster.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(cluster), "server  state")
+
+	output, err := ioutil.ReadFile(filepath.Join(dir, "0", "raft.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "entering Leader state")
+
+	logs, err := ioutil.ReadFile(filepath.Join(dir, "0", "logs.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(logs), "command")
+	assert.Contains(t, string(logs), "68656c6c6f")
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, "0", "snapshots", "*.bin"))
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+}
+
+// If the test succeeds, no artifact is written.
+func TestArtifacts_Passed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Artifacts(dir), rafttest.DiscardLogger())
+	control.Elect("0")
+	control.Close()
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
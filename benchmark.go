@@ -0,0 +1,159 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Bench measures the performance of a cluster created with BenchmarkCluster(),
+// reporting results as custom benchmark metrics.
+type Bench struct {
+	b       *testing.B
+	control *Control
+	leader  *raft.Raft
+}
+
+// BenchmarkCluster creates a cluster like Cluster() does, for use in the given
+// benchmark, and elects its first server as leader. Raft's logger is
+// discarded, unless options override it.
+//
+// To compare cluster sizes, call it from sub-benchmarks:
+//
+//	for _, n := range []int{1, 3, 5} {
+//		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+//			bench := rafttest.BenchmarkCluster(b, rafttest.FSMs(n))
+//			defer bench.Close()
+//			bench.ApplyThroughput(nil)
+//		})
+//	}
+func BenchmarkCluster(b *testing.B, fsms []raft.FSM, options ...Option) *Bench {
+	b.Helper()
+
+	options = append([]Option{DiscardLogger()}, options...)
+	rafts, control := Cluster(b, fsms, options...)
+	control.Elect("0")
+
+	return &Bench{b: b, control: control, leader: rafts["0"]}
+}
+
+// Control returns the Control instance of the benchmarked cluster.
+func (b *Bench) Control() *Control {
+	return b.control
+}
+
+// Close closes the benchmarked cluster.
+func (b *Bench) Close() {
+	b.control.Close()
+}
+
+// ApplyThroughput applies b.N times the given command through the leader,
+// without waiting for each apply before issuing the next one, and reports
+// the number of applies per second.
+func (b *Bench) ApplyThroughput(command []byte) {
+	b.b.Helper()
+
+	b.b.ResetTimer()
+	start := time.Now()
+
+	futures := make([]raft.ApplyFuture, b.b.N)
+	for i := range futures {
+		futures[i] = b.leader.Apply(command, 0)
+	}
+	for i, future := range futures {
+		if err := future.Error(); err != nil {
+			b.b.Fatalf("raft-test: benchmark: apply %d failed: %v", i, err)
+		}
+	}
+
+	elapsed := time.Since(start)
+	b.b.StopTimer()
+
+	b.b.ReportMetric(float64(b.b.N)/elapsed.Seconds(), "applies/s")
+}
+
+// CommitLatency applies b.N times the given command through the leader, one
+// at a time, and reports the 50th, 90th and 99th percentiles of the time it
+// took for each of them to be committed and applied to the leader's FSM.
+func (b *Bench) CommitLatency(command []byte) {
+	b.b.Helper()
+
+	latencies := make([]time.Duration, b.b.N)
+
+	b.b.ResetTimer()
+	for i := range latencies {
+		start := time.Now()
+		if err := b.leader.Apply(command, 0).Error(); err != nil {
+			b.b.Fatalf("raft-test: benchmark: apply %d failed: %v", i, err)
+		}
+		latencies[i] = time.Since(start)
+	}
+	b.b.StopTimer()
+
+	reportPercentiles(b.b, latencies, "commit")
+}
+
+// SnapshotDuration makes the leader take b.N snapshots, applying the given
+// number of empty commands before each one, and reports the 50th, 90th and
+// 99th percentiles of the time each snapshot took. Only snapshots are timed.
+func (b *Bench) SnapshotDuration(commands int) {
+	b.b.Helper()
+
+	if commands < 1 {
+		commands = 1 // Raft refuses to take a snapshot with nothing new.
+	}
+
+	durations := make([]time.Duration, b.b.N)
+
+	b.b.ResetTimer()
+	for i := range durations {
+		b.b.StopTimer()
+		for j := 0; j < commands; j++ {
+			if err := b.leader.Apply([]byte{}, 0).Error(); err != nil {
+				b.b.Fatalf("raft-test: benchmark: apply failed: %v", err)
+			}
+		}
+		b.b.StartTimer()
+
+		start := time.Now()
+		if err := b.leader.Snapshot().Error(); err != nil {
+			b.b.Fatalf("raft-test: benchmark: snapshot %d failed: %v", i, err)
+		}
+		durations[i] = time.Since(start)
+	}
+	b.b.StopTimer()
+
+	reportPercentiles(b.b, durations, "snapshot")
+}
+
+// Report the 50th, 90th and 99th percentiles of the given durations, in
+// milliseconds, as metrics with the given name prefix.
+func reportPercentiles(b *testing.B, durations []time.Duration, name string) {
+	if len(durations) == 0 {
+		return
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for _, p := range []int{50, 90, 99} {
+		d := sorted[(len(sorted)-1)*p/100]
+		b.ReportMetric(float64(d)/float64(time.Millisecond), fmt.Sprintf("%s-p%d-ms", name, p))
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CanonicalLtd/raft-test"
+)
+
+func BenchmarkCluster_ApplyThroughput(b *testing.B) {
+	for _, n := range []int{1, 3, 5} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			bench := rafttest.BenchmarkCluster(b, rafttest.FSMs(n))
+			defer bench.Close()
+
+			bench.ApplyThroughput(nil)
+		})
+	}
+}
+
+func BenchmarkCluster_CommitLatency(b *testing.B) {
+	bench := rafttest.BenchmarkCluster(b, rafttest.FSMs(3))
+	defer bench.Close()
+
+	bench.CommitLatency(nil)
+}
+
+func BenchmarkCluster_SnapshotDuration(b *testing.B) {
+	bench := rafttest.BenchmarkCluster(b, rafttest.FSMs(3))
+	defer bench.Close()
+
+	bench.SnapshotDuration(10)
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/raft"
+)
+
+// Rebootstrap attempts to bootstrap again the server with the given index,
+// using the same initial configuration that Cluster() used, as application
+// start-up code retrying a bootstrap would do.
+//
+// The test fails unless the attempt returns raft.ErrCantBootstrap and leaves
+// the server state untouched: same last log index, same current term and,
+// if the server is running, same configuration.
+//
+// If the server is running the attempt is made with raft.BootstrapCluster()
+// on its raft instance, otherwise (e.g. after Kill()) with the standalone
+// raft.BootstrapCluster() function on its stores.
+func (c *Control) Rebootstrap(i int) {
+	c.t.Helper()
+
+	if i < 0 || i >= len(c.nodes) {
+		c.t.Fatalf("raft-test: rebootstrap: server index %d out of range (%d servers)", i, len(c.nodes))
+	}
+	d := c.nodes[i]
+	id := d.Conf.LocalID
+	r, running := c.servers[id]
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: rebootstrap: server %s", id))
+
+	before := c.bootstrapState(d, r)
+
+	configuration := initialConfiguration(c.nodes)
+	var err error
+	if running {
+		err = r.BootstrapCluster(configuration).Error()
+	} else {
+		err = raft.BootstrapCluster(d.Conf, d.Logs, d.Stable, d.Snaps, d.Trans, configuration)
+	}
+	if err != raft.ErrCantBootstrap {
+		c.t.Fatalf("raft-test: rebootstrap: server %s: expected %q, got %v", id, raft.ErrCantBootstrap, err)
+	}
+
+	after := c.bootstrapState(d, r)
+	if !reflect.DeepEqual(before, after) {
+		c.t.Fatalf("raft-test: rebootstrap: server %s: state changed from %+v to %+v", id, before, after)
+	}
+}
+
+// State of a server that a bootstrap attempt must not change.
+type bootstrapState struct {
+	LastIndex     uint64
+	CurrentTerm   uint64
+	Configuration raft.Configuration
+}
+
+// Return the state of the server with the given dependencies. If r is not
+// nil, the configuration is included too.
+func (c *Control) bootstrapState(d *dependencies, r *raft.Raft) bootstrapState {
+	c.t.Helper()
+
+	id := d.Conf.LocalID
+	state := bootstrapState{}
+
+	var err error
+	state.LastIndex, err = d.Logs.LastIndex()
+	if err != nil {
+		c.t.Fatalf("raft-test: rebootstrap: server %s: can't get last index: %v", id, err)
+	}
+
+	// The key is not there if the server never voted or got elected.
+	state.CurrentTerm, _ = d.Stable.GetUint64([]byte("CurrentTerm"))
+
+	if r != nil {
+		state.Configuration = c.configuration(id)
+	}
+
+	return state
+}
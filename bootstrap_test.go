@@ -0,0 +1,57 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/require"
+)
+
+// Bootstrapping again a running server fails without touching its state.
+func TestControl_Rebootstrap(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Rebootstrap(0)
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	control.Rebootstrap(0)
+	control.Rebootstrap(1)
+}
+
+// Bootstrapping again the stores of a killed server fails without touching
+// them, and the server can still be restarted.
+func TestControl_Rebootstrap_Killed(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	control.Kill(rafts["2"])
+	control.Rebootstrap(2)
+	control.Restart(2)
+
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	require.Equal(t, uint64(2), control.Commands("2"))
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Cancellation is an in-flight Apply() future, tracked with Track(), that
+// failed because its server was shut down.
+type Cancellation struct {
+	Server   raft.ServerID // Server the command was applied to
+	Index    uint64        // Index of the command log, zero if not dispatched
+	Err      error         // Either raft.ErrRaftShutdown or raft.ErrLeadershipLost
+	During   string        // What shut the server down: "close", "kill" or "crash"
+	Observed bool          // Whether the application got the error from Error()
+}
+
+// Track registers an Apply() future issued against the given server, so that
+// if the server gets shut down by Close(), Kill() or Crash() while the future
+// is in flight, the cancellation gets recorded, see Cancellations().
+//
+// The application must use the returned future in place of the given one,
+// so the harness can tell whether the cancellation was observed.
+func (c *Control) Track(r *raft.Raft, future raft.ApplyFuture) raft.ApplyFuture {
+	c.t.Helper()
+
+	tracked := &trackedApply{
+		ApplyFuture: future,
+		server:      c.serverID(r, "track"),
+		done:        make(chan struct{}),
+	}
+	go func() {
+		tracked.err = future.Error()
+		close(tracked.done)
+	}()
+
+	c.tracker.Add(tracked)
+
+	return tracked
+}
+
+// Cancellations returns all tracked Apply() futures cancelled so far because
+// their server was shut down.
+func (c *Control) Cancellations() []Cancellation {
+	return c.tracker.Cancellations()
+}
+
+// AssertCancellationsObserved fails the test if the application didn't get
+// the error of any tracked Apply() future cancelled so far, i.e. it never
+// called Error() on it.
+func (c *Control) AssertCancellationsObserved() {
+	c.t.Helper()
+
+	for _, cancellation := range c.Cancellations() {
+		if !cancellation.Observed {
+			c.t.Fatalf(
+				"raft-test: server %s: cancellation of command log %d during %s not observed",
+				cancellation.Server, cancellation.Index, cancellation.During)
+		}
+	}
+}
+
+// Record the cancellations of the futures tracked for the given server, which
+// has just been shut down for the given reason.
+func (c *Control) recordCancellations(id raft.ServerID, during string) {
+	c.tracker.Shutdown(id, during)
+}
+
+// Apply future registered with Track().
+type trackedApply struct {
+	raft.ApplyFuture
+	server raft.ServerID
+
+	// Closed once the wrapped future has completed, with its error.
+	done chan struct{}
+	err  error
+
+	// Non-zero if the application got a cancellation error.
+	observed int32
+}
+
+// Error waits for the wrapped future and returns its error. The wrapped
+// future must not be waited for concurrently, so a single goroutine does
+// that on behalf of both the application and the harness.
+func (f *trackedApply) Error() error {
+	<-f.done
+	if isCancellation(f.err) {
+		atomic.StoreInt32(&f.observed, 1)
+	}
+	return f.err
+}
+
+// Return true if the given error is returned by futures cancelled by a
+// shutdown.
+func isCancellation(err error) bool {
+	return err == raft.ErrRaftShutdown || err == raft.ErrLeadershipLost
+}
+
+// Keep track of Apply() futures and of their cancellations.
+type applyTracker struct {
+	mu            sync.Mutex
+	futures       []*trackedApply
+	cancellations []cancellation
+}
+
+// A recorded cancellation.
+type cancellation struct {
+	future *trackedApply
+	during string
+}
+
+// Add a future to be tracked.
+func (t *applyTracker) Add(future *trackedApply) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.futures = append(t.futures, future)
+}
+
+// Stop tracking the futures of the given server, which has been shut down for
+// the given reason, recording the ones that got cancelled.
+func (t *applyTracker) Shutdown(id raft.ServerID, during string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Once a server is shut down, raft has responded to all its in-flight
+	// futures, but the goroutines waiting for them might not have run yet.
+	timeout := time.After(Duration(time.Second))
+
+	futures := make([]*trackedApply, 0, len(t.futures))
+	for _, future := range t.futures {
+		if future.server != id {
+			futures = append(futures, future)
+			continue
+		}
+		select {
+		case <-future.done:
+		default:
+			select {
+			case <-future.done:
+			case <-timeout:
+				continue
+			}
+		}
+		if isCancellation(future.err) {
+			t.cancellations = append(t.cancellations, cancellation{future: future, during: during})
+		}
+	}
+	t.futures = futures
+}
+
+// Return all cancellations recorded so far.
+func (t *applyTracker) Cancellations() []Cancellation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cancellations := make([]Cancellation, len(t.cancellations))
+	for i, c := range t.cancellations {
+		cancellations[i] = Cancellation{
+			Server:   c.future.server,
+			Index:    c.future.Index(),
+			Err:      c.future.err,
+			During:   c.during,
+			Observed: atomic.LoadInt32(&c.future.observed) == 1,
+		}
+	}
+	return cancellations
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// In-flight tracked futures cancelled by Close() are recorded.
+func TestControl_Cancellations(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	committed := control.Track(r, r.Apply([]byte{}, time.Second))
+	require.NoError(t, committed.Error())
+
+	// Make sure the next command can't be committed.
+	control.LogStoreErrors("1", 0)
+	control.LogStoreErrors("2", 0)
+	future := control.Track(r, r.Apply([]byte{}, time.Second))
+
+	control.Close()
+
+	cancellations := control.Cancellations()
+	require.Len(t, cancellations, 1)
+	assert.Equal(t, raft.ServerID("0"), cancellations[0].Server)
+	assert.Equal(t, "close", cancellations[0].During)
+	assert.False(t, cancellations[0].Observed)
+
+	assert.Equal(t, raft.ErrLeadershipLost, future.Error())
+	assert.True(t, control.Cancellations()[0].Observed)
+	control.AssertCancellationsObserved()
+}
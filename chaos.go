@@ -0,0 +1,247 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ChaosOptions tunes the faults injected by Control.Chaos().
+type ChaosOptions struct {
+	Duration time.Duration // How long to inject faults for, 1 second if zero
+	Dwell    time.Duration // How long each fault stays in place, twice the election timeout if zero
+	Delay    time.Duration // Additional write latency, for FaultSlowDisk
+	Kinds    []FaultKind   // Faults to pick from, see below
+}
+
+// Chaos starts a background goroutine that, for the configured duration,
+// randomly partitions, delays and restarts servers of the cluster, one at a
+// time. All random choices are derived from the given seed, which is printed
+// if the run fails, so a failure can be reproduced by running the test again
+// with the same seed. The injected faults are also recorded, see Recording()
+// and Replay().
+//
+// Unless overridden by opts.Kinds, the faults injected are FaultPartition of
+// any server, FaultSlowDisk of the leader, by opts.Delay or 5 milliseconds,
+// and FaultRestart of a follower.
+//
+// A leader must have been elected with Elect() beforehand. While the chaos
+// runs, the test may apply command logs to the cluster, but it must not use
+// Control, except for calling Wait() on the returned ChaosRun, which must be
+// done before any further use. Close() stops a chaos run still in progress.
+//
+// Failures hit while injecting faults are recorded in the run and reported,
+// together with the seed, by Wait() or Close().
+func (c *Control) Chaos(seed int64, opts ChaosOptions) *ChaosRun {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: chaos: no leader was elected")
+	}
+	if c.chaos != nil {
+		c.t.Fatalf("raft-test: chaos: already running")
+	}
+
+	if opts.Duration == 0 {
+		opts.Duration = time.Second
+	}
+	if opts.Dwell == 0 {
+		opts.Dwell = 2 * maximumElectionTimeout(c.confs)
+	}
+	if opts.Delay == 0 {
+		opts.Delay = 5 * time.Millisecond
+	}
+	if len(opts.Kinds) == 0 {
+		opts.Kinds = []FaultKind{FaultPartition, FaultSlowDisk, FaultRestart}
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: chaos: start (seed %d, duration %s)", seed, opts.Duration))
+
+	run := &ChaosRun{
+		control:  c,
+		reporter: c.t,
+		seed:     seed,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.chaos = run
+
+	// Faults are injected by a goroutine other than the test one, which
+	// must not report failures directly: record them in the run instead,
+	// until it's over.
+	c.t = &chaosReporter{Reporter: c.t, run: run}
+
+	nemesis := &chaosNemesis{
+		rand: rand.New(rand.NewSource(seed)),
+		opts: opts,
+	}
+	go c.runChaos(run, nemesis, opts.Duration)
+
+	return run
+}
+
+// ChaosRun is a chaos run started by Control.Chaos().
+type ChaosRun struct {
+	control  *Control
+	reporter Reporter // Reporter of the test, restored when the run is over
+	seed     int64
+	stop     chan struct{} // Closed to stop the run before its duration
+	done     chan struct{} // Closed when the run is over
+	steps    int           // Number of faults injected
+
+	mu       sync.Mutex
+	failures []string // Failures hit while injecting faults
+}
+
+// Wait blocks until the chaos run is over, leaving the cluster with a leader
+// and no fault in place, and returns the number of faults injected.
+//
+// It fails the test if the run failed, printing the seed to use to reproduce
+// the failure.
+func (r *ChaosRun) Wait() int {
+	r.reporter.Helper()
+
+	if failure := r.finish(); failure != "" {
+		r.reporter.Fatalf("raft-test: chaos: seed %d: %s", r.seed, failure)
+	}
+
+	return r.steps
+}
+
+// Wait for the run to be over and give the control its reporter back. Return
+// a description of the failures of the run, if any.
+func (r *ChaosRun) finish() string {
+	<-r.done
+	r.control.t = r.reporter
+	r.control.chaos = nil
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.failures, "; ")
+}
+
+// Record a failure of the run at the current step.
+func (r *ChaosRun) fail(failure string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, fmt.Sprintf("step %d: %s", r.steps, failure))
+}
+
+// Reporter used while a chaos run is in progress, recording failures in the
+// run. Since Fatalf must not return, it stops the calling goroutine, as
+// testing.T does.
+type chaosReporter struct {
+	Reporter
+	run *ChaosRun
+}
+
+func (r *chaosReporter) Errorf(format string, args ...interface{}) {
+	r.run.fail(fmt.Sprintf(format, args...))
+}
+
+func (r *chaosReporter) Fatalf(format string, args ...interface{}) {
+	r.run.fail(fmt.Sprintf(format, args...))
+	runtime.Goexit()
+}
+
+// Inject the faults selected by the given nemesis until the given duration
+// expires or the run gets stopped.
+func (c *Control) runChaos(run *ChaosRun, nemesis Nemesis, duration time.Duration) {
+	defer close(run.done)
+	defer func() {
+		if r := recover(); r != nil {
+			run.fail(fmt.Sprint(r))
+		}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < duration {
+		select {
+		case <-run.stop:
+			return
+		default:
+		}
+
+		action := nemesis.Next(c.clusterView(run.steps))
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: chaos: step %d: %s", run.steps, action))
+
+		heal := c.injectFault(action)
+		select {
+		case <-time.After(action.Dwell):
+		case <-run.stop:
+		}
+		heal()
+		run.steps++
+
+		if c.term == nil {
+			run.fail(fmt.Sprintf("%s: no leader after healing", action))
+			return
+		}
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: chaos: done (%d steps)", run.steps))
+}
+
+// Stop the chaos run in progress, if any, wait for it to be over and report
+// its failures.
+func (c *Control) stopChaos() {
+	run := c.chaos
+	if run == nil {
+		return
+	}
+	close(run.stop)
+	if failure := run.finish(); failure != "" {
+		c.t.Errorf("raft-test: close: chaos: seed %d: %s", run.seed, failure)
+	}
+}
+
+// Nemesis picking a random fault among the configured kinds at every step.
+type chaosNemesis struct {
+	rand *rand.Rand
+	opts ChaosOptions
+}
+
+func (n *chaosNemesis) Next(view ClusterView) FaultAction {
+	action := FaultAction{
+		Kind:  n.opts.Kinds[n.rand.Intn(len(n.opts.Kinds))],
+		Dwell: n.opts.Dwell,
+	}
+
+	var targets []raft.ServerID
+	switch action.Kind {
+	case FaultNone:
+	case FaultDepose, FaultSlowDisk:
+		targets = []raft.ServerID{view.Leader}
+	case FaultDisconnect, FaultRestart:
+		targets = view.Followers()
+	default:
+		targets = view.Servers
+	}
+	if len(targets) > 0 {
+		action.Target = targets[n.rand.Intn(len(targets))]
+	}
+	if action.Kind == FaultSlowDisk {
+		action.Delay = n.opts.Delay
+	}
+
+	return action
+}
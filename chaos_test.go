@@ -0,0 +1,102 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Inject random faults while applying commands, and check that the cluster
+// converges once the chaos is over.
+func TestControl_Chaos(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.KVFSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	// The map returned by Cluster() gets modified when servers restart.
+	servers := make([]*raft.Raft, 0, len(rafts))
+	for _, r := range rafts {
+		servers = append(servers, r)
+	}
+
+	run := control.Chaos(1, rafttest.ChaosOptions{
+		Duration: time.Second,
+		Dwell:    200 * time.Millisecond,
+	})
+
+	// Keep applying commands through whichever server is the leader.
+	for i := 0; i < 20; i++ {
+		for _, r := range servers {
+			if r.State() == raft.Leader {
+				r.Apply(rafttest.KVSet("a", strconv.Itoa(i)), 100*time.Millisecond).Error()
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.True(t, run.Wait() > 0)
+
+	control.AssertFSMsEqual(time.Second)
+}
+
+// The seed is reported if a chaos run fails.
+func TestControl_Chaos_Failure(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	run := control.Chaos(42, rafttest.ChaosOptions{
+		Kinds: []rafttest.FaultKind{rafttest.FaultKind(99)},
+		Dwell: time.Millisecond,
+	})
+	require.NotNil(t, run)
+
+	assert.Panics(t, func() { run.Wait() })
+	assert.Contains(t, buffer.String(), "raft-test: chaos: seed 42: step 0: raft-test: nemesis: ")
+}
+
+// Failures of a chaos run stopped by Close() are reported with the seed.
+func TestControl_Chaos_FailureOnClose(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+
+	control.Elect("0")
+
+	injected := make(chan struct{})
+	control.OnFaultInjected(func(rafttest.FaultAction) {
+		close(injected)
+		panic("boom")
+	})
+
+	control.Chaos(42, rafttest.ChaosOptions{
+		Kinds: []rafttest.FaultKind{rafttest.FaultSlowDisk},
+		Dwell: time.Millisecond,
+	})
+	<-injected
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: chaos: seed 42: step 0: boom")
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ChurnPeers runs a membership churn stress scenario for the given number of
+// iterations.
+//
+// At each iteration the leader applies a command log and then the membership
+// of the cluster changes: servers leave and join in turn, the one that joined
+// the earliest (other than the leader) being removed with Remove(), and a
+// brand new server backed by a dummy FSM being added with Add(). The pool of
+// servers thus stays bounded, between the initial size and one less. If no
+// server other than the leader is left, a server joins instead.
+//
+// Once done, it checks that all servers have converged to the same
+// configuration and applied the same command logs, and that their logs match
+// (see CompareLogs()).
+//
+// A leader must have been elected with Elect() beforehand.
+func (c *Control) ChurnPeers(iterations int) {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: churn: no leader was elected")
+	}
+
+	leader := c.term.id
+	r := c.servers[leader]
+	timeout := Duration(time.Second)
+
+	// The members of the cluster other than the leader, in the order they
+	// joined.
+	members := make([]raft.ServerID, 0)
+	for _, server := range c.configuration(leader).Servers {
+		if server.ID != leader {
+			members = append(members, server.ID)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+
+	for i := 0; i < iterations; i++ {
+		if err := c.await(r.Apply([]byte{}, timeout), "server %s: churn apply %d", leader, i); err != nil {
+			c.t.Fatalf("raft-test: churn: iteration %d: apply failed: %v", i, err)
+		}
+
+		if i%2 == 0 && len(members) > 0 {
+			id := members[0]
+			members = members[1:]
+			c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: churn: iteration %d: server %s: leave", i, id))
+			c.Remove(c.servers[id])
+			continue
+		}
+
+		added := c.Add(FSM())
+		id := c.serverID(added, "churn")
+		members = append(members, id)
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: churn: iteration %d: server %s: joined", i, id))
+	}
+
+	c.Barrier()
+
+	// Check that the configuration converged.
+	expected := c.configuration(leader)
+	for _, server := range expected.Servers {
+		id := server.ID
+		check := func() bool {
+			return reflect.DeepEqual(c.configuration(id), expected)
+		}
+		if !c.poll(check, timeout) {
+			c.t.Fatalf("raft-test: churn: server %s: configuration did not converge", id)
+		}
+	}
+
+	// Check that all data was replicated.
+	n := c.Commands(leader)
+	for _, server := range expected.Servers {
+		if commands := c.Commands(server.ID); commands != n {
+			c.t.Fatalf("raft-test: churn: server %s: applied %d commands instead of %d", server.ID, commands, n)
+		}
+	}
+	c.CompareLogs()
+}
+
+// Churn runs a log compaction stress scenario, pushing the given number of
+// command logs through the leader and making it take a snapshot every
+// snapshotEvery of them, which truncates its log down to TrailingLogs entries
+// (1 by default).
+//
+// The follower with the lowest ID is disconnected for the first half of the
+// run and reconnected mid-stream, when the logs it missed have already been
+// compacted. Once done, it checks that the follower recovered by restoring a
+// snapshot sent with InstallSnapshot, and that all servers applied the same
+// command logs.
+//
+// A leader must have been elected with Elect() beforehand, no follower must be
+// disconnected, and snapshotEvery must be at most half of the entries. If the
+// disconnected follower is a voter, the others must still form a quorum, so
+// at least 3 voters are needed.
+func (c *Control) Churn(entries int, snapshotEvery int) {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: churn: no leader was elected")
+	}
+	if snapshotEvery < 1 || snapshotEvery > entries/2 {
+		c.t.Fatalf("raft-test: churn: snapshot every %d entries out of %d: must be between 1 and half of them", snapshotEvery, entries)
+	}
+
+	term := c.term
+	leader := term.id
+	r := c.servers[leader]
+	timeout := Duration(time.Second)
+
+	follower := raft.ServerID("")
+	for _, id := range c.serverIDs() {
+		if id != leader {
+			follower = id
+			break
+		}
+	}
+	if follower == "" {
+		c.t.Fatalf("raft-test: churn: no follower")
+	}
+	voters := 0
+	for _, server := range c.configuration(leader).Servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	if c.suffrage(leader, follower) == raft.Voter && voters-1 < voters/2+1 {
+		c.t.Fatalf("raft-test: churn: disconnecting server %s would lose quorum (%d voters)", follower, voters)
+	}
+	restores := c.Restores(follower)
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: churn: server %s: disconnect", follower))
+	term.Disconnect(follower)
+
+	for i := 1; i <= entries; i++ {
+		if err := c.await(r.Apply([]byte{}, timeout), "server %s: churn apply %d", leader, i); err != nil {
+			c.t.Fatalf("raft-test: churn: entry %d: apply failed: %v", i, err)
+		}
+		if i%snapshotEvery == 0 {
+			if err := c.Snapshot(r); err != nil {
+				c.t.Fatalf("raft-test: churn: entry %d: snapshot failed: %v", i, err)
+			}
+		}
+		if i == entries/2 {
+			c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: churn: server %s: reconnect", follower))
+			term.Reconnect(follower)
+		}
+	}
+
+	c.Barrier()
+
+	// Check that all data was replicated.
+	index := r.LastIndex()
+	n := c.Commands(leader)
+	for _, id := range c.serverIDs() {
+		c.WaitIndex(id, index, 0)
+		if commands := c.Commands(id); commands != n {
+			c.t.Fatalf("raft-test: churn: server %s: applied %d commands instead of %d", id, commands, n)
+		}
+	}
+
+	// Check that the follower caught up with a snapshot.
+	if c.Restores(follower) == restores {
+		c.t.Fatalf("raft-test: churn: server %s: caught up without restoring a snapshot (check TrailingLogs)", follower)
+	}
+}
+
+// Return the suffrage that the server with the given ID has in the latest
+// configuration of the given leader, or raft.Staging if it's not part of it.
+func (c *Control) suffrage(leader, id raft.ServerID) raft.ServerSuffrage {
+	for _, server := range c.configuration(leader).Servers {
+		if server.ID == id {
+			return server.Suffrage
+		}
+	}
+	return raft.Staging
+}
+
+// Poll the given function until it returns true or the timeout expires, as
+// measured by the clock of the cluster. Return false in case of timeout.
+func (c *Control) poll(f func() bool, timeout time.Duration) bool {
+	deadline := c.clock.Now().Add(timeout)
+	for !f() {
+		if c.clock.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
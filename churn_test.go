@@ -0,0 +1,77 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Churn the membership of a cluster, with servers leaving and joining in turn.
+func TestControl_ChurnPeers(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.ChurnPeers(10)
+
+	// Servers 1 and 2 left, and the five servers that joined after them
+	// were removed in turn, except for the last two.
+	servers := control.Running()
+	assert.Len(t, servers, 3)
+	assert.Contains(t, servers, raft.ServerID("7"))
+	assert.Contains(t, servers, raft.ServerID("6"))
+}
+
+// Push entries through the leader while compacting its log, with a follower
+// catching up via InstallSnapshot.
+func TestControl_Churn(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.Churn(40, 5)
+
+	assert.Equal(t, uint64(40), control.Commands("1"))
+	assert.True(t, control.Snapshots("0") >= 8)
+}
+
+// The snapshot interval must leave room for the follower to fall behind.
+func TestControl_Churn_BadInterval(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Panics(t, func() { control.Churn(10, 6) })
+	assert.Contains(t, buffer.String(), "churn: snapshot every 6 entries out of 10")
+}
+
+// Disconnecting a voter of a 2-voter cluster would lose quorum.
+func TestControl_Churn_NoQuorum(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Panics(t, func() { control.Churn(10, 5) })
+	assert.Contains(t, buffer.String(), "churn: disconnecting server 1 would lose quorum (2 voters)")
+}
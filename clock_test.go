@@ -0,0 +1,57 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+)
+
+// With a manual clock, waits time out only when the clock is advanced past
+// their timeout.
+func TestTimeSource(t *testing.T) {
+	clock := rafttest.NewManualClock(time.Now())
+
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.TimeSource(clock), rafttest.DiscardLogger())
+	defer control.Close()
+
+	advanced := int32(0)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&advanced, 1)
+		clock.Advance(time.Second)
+	}()
+
+	assert.Panics(t, func() { control.WaitStableLeader(10 * time.Millisecond) })
+	assert.Equal(t, int32(1), atomic.LoadInt32(&advanced))
+	assert.Contains(t, buffer.String(), "no stable leader within 1s")
+}
+
+// The manual clock moves forward only when advanced.
+func TestManualClock(t *testing.T) {
+	now := time.Now()
+	clock := rafttest.NewManualClock(now)
+
+	assert.Equal(t, now, clock.Now())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, now.Add(time.Minute), clock.Now())
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/CanonicalLtd/raft-test/internal/stores"
+	"github.com/hashicorp/raft"
+)
+
+// Clone creates a second, independent cluster whose servers start from a copy
+// of the log, stable and snapshot stores of the servers of this cluster, so
+// that different strategies can be applied to identical starting conditions
+// and their outcomes compared.
+//
+// The given FSMs are used by the servers of the new cluster, and must be as
+// many as the servers of this cluster: they get restored from the copied
+// snapshots, if any, and catch up with the copied logs once a leader is
+// elected with Elect(). The given options are applied after the stores have
+// been set up, so options replacing stores should not be used.
+//
+// Servers of this cluster keep running while their stores are copied, so
+// Barrier() should typically be called before cloning. The stores of the new
+// cluster are always in-memory ones, as are the ones of servers that are
+// currently killed or crashed, which get cloned too.
+func (c *Control) Clone(fsms []raft.FSM, options ...Option) (map[raft.ServerID]*raft.Raft, *Control) {
+	c.t.Helper()
+
+	if len(fsms) != len(c.nodes) {
+		c.t.Fatalf("raft-test: clone: got %d FSMs for %d servers", len(fsms), len(c.nodes))
+	}
+
+	type clonedStores struct {
+		store *raft.InmemStore
+		snaps *stores.SnapshotStore
+	}
+	cloned := make([]clonedStores, len(c.nodes))
+	for i, d := range c.nodes {
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: clone: server %s", d.Conf.LocalID))
+		cloned[i].store = c.cloneLogs(d)
+		cloned[i].snaps = c.cloneSnapshots(d)
+	}
+
+	clone := func(t Reporter, nodes []*dependencies) {
+		for i, node := range nodes {
+			node.Logs = cloned[i].store
+			node.Stable = cloned[i].store
+			node.Snaps = cloned[i].snaps
+		}
+	}
+
+	return Cluster(c.t, fsms, append([]Option{clone}, options...)...)
+}
+
+// Keys of the integer values that raft saves in the stable store.
+var stableUint64Keys = []string{"CurrentTerm", "LastVoteTerm"}
+
+// Copy the logs and the stable store entries of the server with the given
+// dependencies into a new in-memory store.
+func (c *Control) cloneLogs(d *dependencies) *raft.InmemStore {
+	c.t.Helper()
+
+	id := d.Conf.LocalID
+	store := raft.NewInmemStore()
+
+	first, err := d.Logs.FirstIndex()
+	if err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't get first index: %v", id, err)
+	}
+	last, err := d.Logs.LastIndex()
+	if err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't get last index: %v", id, err)
+	}
+	for index := first; index <= last && last != 0; index++ {
+		log := &raft.Log{}
+		if err := d.Logs.GetLog(index, log); err != nil {
+			c.t.Fatalf("raft-test: clone: server %s: can't get log %d: %v", id, index, err)
+		}
+		if err := store.StoreLog(log); err != nil {
+			c.t.Fatalf("raft-test: clone: server %s: can't store log %d: %v", id, index, err)
+		}
+	}
+
+	for _, key := range stableUint64Keys {
+		value, err := d.Stable.GetUint64([]byte(key))
+		if err != nil || value == 0 {
+			continue
+		}
+		if err := store.SetUint64([]byte(key), value); err != nil {
+			c.t.Fatalf("raft-test: clone: server %s: can't set %s: %v", id, key, err)
+		}
+	}
+	if candidate, err := d.Stable.Get([]byte("LastVoteCand")); err == nil && len(candidate) > 0 {
+		if err := store.Set([]byte("LastVoteCand"), candidate); err != nil {
+			c.t.Fatalf("raft-test: clone: server %s: can't set LastVoteCand: %v", id, err)
+		}
+	}
+
+	return store
+}
+
+// Copy the latest snapshot of the server with the given dependencies, if any,
+// into a new in-memory snapshot store.
+func (c *Control) cloneSnapshots(d *dependencies) *stores.SnapshotStore {
+	c.t.Helper()
+
+	id := d.Conf.LocalID
+	snaps := stores.NewSnapshotStore(1)
+
+	snapshots, err := d.Snaps.List()
+	if err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't list snapshots: %v", id, err)
+	}
+	if len(snapshots) == 0 {
+		return snaps
+	}
+
+	meta, reader, err := d.Snaps.Open(snapshots[0].ID)
+	if err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't open snapshot %s: %v", id, snapshots[0].ID, err)
+	}
+	defer reader.Close()
+
+	sink, err := snaps.Create(meta.Version, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, d.Trans)
+	if err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't create snapshot: %v", id, err)
+	}
+	if _, err := io.Copy(sink, reader); err != nil {
+		sink.Cancel()
+		c.t.Fatalf("raft-test: clone: server %s: can't copy snapshot %s: %v", id, meta.ID, err)
+	}
+	if err := sink.Close(); err != nil {
+		c.t.Fatalf("raft-test: clone: server %s: can't close snapshot: %v", id, err)
+	}
+
+	return snaps
+}
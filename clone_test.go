@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A cloned cluster starts from the same state as the original, and then
+// evolves independently.
+func TestControl_Clone(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithSnapshots().Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+	require.NoError(t, rafts["1"].Snapshot().Error())
+
+	clones, cloneControl := control.Clone(rafttest.NewFSMs(3).WithSnapshots().Build(), rafttest.DiscardLogger())
+	defer cloneControl.Close()
+
+	cloneControl.Elect("1")
+	require.NoError(t, clones["1"].Apply([]byte{}, time.Second).Error())
+	cloneControl.Barrier()
+
+	assert.Equal(t, uint64(4), cloneControl.Commands("0"))
+	assert.Equal(t, uint64(4), cloneControl.Commands("1"))
+	assert.Equal(t, uint64(4), cloneControl.Commands("2"))
+	assert.Equal(t, uint64(1), cloneControl.Restores("1"))
+
+	assert.Equal(t, uint64(3), control.Commands("0"))
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+}
@@ -17,13 +17,13 @@ package rafttest
 import (
 	"fmt"
 	"strconv"
-	"testing"
 	"time"
 
 	"github.com/CanonicalLtd/raft-test/internal/election"
 	"github.com/CanonicalLtd/raft-test/internal/fsms"
 	"github.com/CanonicalLtd/raft-test/internal/logging"
 	"github.com/CanonicalLtd/raft-test/internal/network"
+	"github.com/CanonicalLtd/raft-test/internal/stores"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 )
@@ -41,21 +41,29 @@ import (
 // - in-memory log and stable stores
 // - in-memory snapshot stores
 //
-// You can tweak the default dependencies using the Config, Transport and
-// LogStore options.
+// You can tweak the default dependencies using the Config, Transport,
+// Transports, LogStore, Stores and Disk options.
 //
 // All created raft servers will be part of the cluster and act as voting
-// servers, unless the Servers option is used.
+// servers, unless the Servers, NonVoters or Staging options are used.
 //
 // If a GO_RAFT_TEST_LATENCY environment is found, the default configuration
 // timeouts will be scaled up accordingly (useful when running tests on slow
 // hardware). A latency of 1.0 is a no-op, since it just keeps the default
 // values unchanged. A value greater than 1.0 increases the default timeouts by
 // that factor. See also the Duration helper.
-func Cluster(t testing.TB, fsms []raft.FSM, options ...Option) (map[raft.ServerID]*raft.Raft, *Control) {
+//
+// The returned map holds the servers created initially and is not updated
+// when servers are added, removed or restarted: use Control.Running() for
+// that.
+func Cluster(t Reporter, fsms []raft.FSM, options ...Option) (map[raft.ServerID]*raft.Raft, *Control) {
 	logger := logging.New(t, "DEBUG")
 	logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: start (%d servers)", len(fsms)))
 
+	// Goroutines running before the cluster gets created, which are not
+	// leaked by it.
+	baseline, _ := goroutines()
+
 	// Create a set of default dependencies for each server.
 	dependencies := make([]*dependencies, len(fsms))
 	for i, fsm := range fsms {
@@ -64,12 +72,22 @@ func Cluster(t testing.TB, fsms []raft.FSM, options ...Option) (map[raft.ServerI
 
 	// Customize the default dependencies by applying the given options.
 	for _, option := range options {
-		option(dependencies)
+		option(t, dependencies)
 	}
 
+	// Catch misbehaving options before using their dependencies.
+	validateDependencies(t, dependencies)
+
+	// Warn about snapshot settings that can't work as intended.
+	strict := isStrict(dependencies)
+	checkSnapshotConfig(t, strict, dependencies)
+
 	// Honor the GO_RAFT_TEST_LATENCY env var, if set.
 	setTimeouts(dependencies)
 
+	// Make in-memory snapshot stores keep all snapshots, if requested.
+	archiveSnapshots(dependencies)
+
 	// Instrument the Config of each server with a NotifyCh and return a
 	// leadership object for watching them.
 	leadership := instrumentConfigs(t, logger, dependencies)
@@ -78,14 +96,33 @@ func Cluster(t testing.TB, fsms []raft.FSM, options ...Option) (map[raft.ServerI
 	// wrappers, creating a network object to control them.
 	network := instrumentTransports(logger, dependencies)
 
+	// Wrap the instrumented transports with user decorators, if any.
+	decorateTransports(t, dependencies)
+
 	// Instrument all servers by replacing their fsms with wrapper fsms,
 	// creating a watcher to observe them.
 	watcher := instrumentFSMs(logger, dependencies)
 
+	// Instrument all servers by replacing their log and stable stores with
+	// wrappers, creating a stores object to inject faults into them.
+	stores := instrumentLogStores(logger, dependencies)
+
 	// Bootstrap the initial cluster configuration.
 	bootstrapCluster(t, logger, dependencies)
 
+	// Write any state set with the Bootstrap option.
+	seedStores(t, logger, dependencies)
+
+	// Get notified when servers should be forced to take a snapshot.
+	snapshotChs := make(map[raft.ServerID]<-chan struct{})
+	for _, d := range dependencies {
+		if d.SnapshotAfter != 0 {
+			snapshotChs[d.Conf.LocalID] = watcher.SnapshotEvery(d.Conf.LocalID, d.SnapshotAfter)
+		}
+	}
+
 	// Start the individual servers.
+	uptime := newUptimeTracker()
 	servers := make(map[raft.ServerID]*raft.Raft)
 	confs := make(map[raft.ServerID]*raft.Config)
 	for _, d := range dependencies {
@@ -93,10 +130,11 @@ func Cluster(t testing.TB, fsms []raft.FSM, options ...Option) (map[raft.ServerI
 		logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: server %s: start", id))
 		raft, err := newRaft(d)
 		if err != nil {
-			logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: error: server %s failed to start: %v", id, err))
+			t.Fatalf("raft-test: setup: error: server %s failed to start: %v", id, err)
 		}
 		confs[id] = d.Conf
 		servers[id] = raft
+		uptime.Start(id)
 	}
 
 	// Create the Control instance for this cluster
@@ -106,18 +144,91 @@ func Cluster(t testing.TB, fsms []raft.FSM, options ...Option) (map[raft.ServerI
 		election: leadership,
 		network:  network,
 		watcher:  watcher,
+		stores:   stores,
 		confs:    confs,
 		servers:  servers,
+		nodes:    dependencies,
+		watchdog: newWatchdog(),
+		uptime:   uptime,
+		stopCh:   make(chan struct{}),
+
+		bootRestores:   make(map[raft.ServerID]map[uint64]Restore),
+		waitedRestores: make(map[raft.ServerID]uint64),
+		crashed:        make(map[raft.ServerID]*raft.Raft),
+		nextIndex:      len(dependencies),
+		tracker:        &applyTracker{},
+		operations:     &operationRecorder{},
+		events:         &eventBus{},
+		clock:          dependencies[0].Clock,
+
+		reportedFSMFailures: make(map[raft.ServerID]int),
+	}
+
+	// Raft restores the latest snapshot synchronously at startup, if any,
+	// for example one seeded with the Bootstrap option.
+	for _, d := range dependencies {
+		if n := watcher.Restores(d.Conf.LocalID); n > 0 {
+			control.recordBootRestore(d.Conf.LocalID, n, d.Snaps)
+		}
+	}
+
+	// Expose the RPCs recorded by the Trace option, if used.
+	for _, d := range dependencies {
+		if d.Tracer != nil {
+			control.tracer = d.Tracer
+		}
+	}
+
+	// Write artifacts and raft logs on failure, if requested.
+	for _, d := range dependencies {
+		if d.ArtifactsDir != "" {
+			control.artifactsDir = d.ArtifactsDir
+		}
+		if d.LogOnFailure {
+			control.logOnFailure = true
+		}
+	}
+
+	// Report the fault seed on failure if links have jitter.
+	for _, d := range dependencies {
+		if d.LinkJitter > 0 {
+			control.randomFaults = true
+		}
+	}
+
+	// Check for leaked goroutines on close, if requested.
+	for _, d := range dependencies {
+		if d.LeakCheck {
+			control.goroutines = baseline
+		}
+	}
+
+	// Start observing raft invariants, if requested.
+	if checksInvariants(dependencies) {
+		control.invariants = newInvariantsChecker(control)
+		go control.invariants.run()
+	}
+
+	// Start forcing snapshots, if requested.
+	for id, ch := range snapshotChs {
+		go control.snapshotEvery(id, ch)
+	}
+
+	// Archive a summary of the run on close, if requested.
+	for _, d := range dependencies {
+		if d.ArchiveDir != "" {
+			control.archiveDir = d.ArchiveDir
+		}
 	}
 
 	logger.Debug("[DEBUG] raft-test: setup: done")
 
-	return servers, control
+	return control.running(), control
 }
 
 // Option can be used to tweak the dependencies of test Raft servers created with
 // Cluster() or Server().
-type Option func([]*dependencies)
+type Option func(Reporter, []*dependencies)
 
 // Hold dependencies for a single dependencies.
 type dependencies struct {
@@ -128,18 +239,67 @@ type dependencies struct {
 	Snaps         raft.SnapshotStore
 	Configuration *raft.Configuration
 	Trans         raft.Transport
-	Voter         bool // Whether this is voter server in the initial configuration
+	Voter         bool            // Whether this is voter server in the initial configuration
+	NonVoter      bool            // Whether this is non-voter server in the initial configuration
+	Staging       bool            // Whether this is staging server in the initial configuration
+	Dir           string          // Temporary directory holding on-disk data, if any
+	Capture       *logging.Buffer // Captured raft log output
+	ArchiveDir    string          // Where to archive the run summary, see ResultsArchive()
+	LinkLatency   time.Duration   // Delay added to RPCs sent to other servers
+	LinkJitter    time.Duration   // Random variation of LinkLatency
+	SnapshotAfter uint64          // Take a snapshot every this many commands
+	Strict        bool            // Whether warnings fail the test, see Strict()
+	Invariants    bool            // Whether to check raft invariants, see Invariants()
+	Clock         Clock           // Clock used by Control to measure timeouts, see TimeSource()
+	LeakCheck     bool            // Whether to check for leaked goroutines, see LeakCheck()
+	ArtifactsDir  string          // Where to write artifacts on failure, see Artifacts()
+	LogOnFailure  bool            // Whether to write raft logs on failure, see Logging()
+	CustomLogger  bool            // Whether a custom logger is set, see Loggers()
+	Seed          *Seed           // State to write into the stores, see Bootstrap()
+
+	// Whether FSMs keep the data of applied logs, see CommandHistory().
+	CommandHistory bool
+
+	// Seed of the probabilistic RPC faults, see FaultSeed().
+	FaultSeed *int64
+
+	// Whether in-memory snapshot stores keep all snapshots, see
+	// SnapshotArchive().
+	SnapshotArchive bool
+
+	// Factory of the transport, if it was created with Transports(), in
+	// which case the harness closes it.
+	NewTransport func(int) (raft.Transport, error)
+
+	// Recorder of the RPCs sent by the server, see Trace().
+	Tracer *rpcTracer
+
+	// Decorators to apply to the instrumented transport, in order.
+	Decorators []func(raft.Transport) raft.Transport
+
+	// Test the server belongs to, used by options to report errors.
+	t Reporter
 }
 
+// Default snapshot settings, high enough that raft never takes snapshots on
+// its own.
+const (
+	defaultSnapshotInterval  = 24 * time.Hour
+	defaultSnapshotThreshold = 4096
+)
+
 // Create default dependencies for a single raft server.
-func newDefaultDependencies(t testing.TB, logger hclog.Logger, i int, fsm raft.FSM) *dependencies {
+func newDefaultDependencies(t Reporter, logger hclog.Logger, i int, fsm raft.FSM) *dependencies {
 	// Use the server's index as its server ID and address.
 	addr := strconv.Itoa(i)
 	_, transport := raft.NewInmemTransport(raft.ServerAddress(addr))
 
+	// Capture the raft log output of each server separately.
+	capture := logging.NewBuffer()
+
 	conf := raft.DefaultConfig()
 	conf.LocalID = raft.ServerID(addr)
-	conf.Logger = logger
+	conf.Logger = logging.NewNode(t, "DEBUG", addr, capture)
 
 	// Set low timeouts.
 	conf.HeartbeatTimeout = 15 * time.Millisecond
@@ -148,8 +308,8 @@ func newDefaultDependencies(t testing.TB, logger hclog.Logger, i int, fsm raft.F
 	conf.LeaderLeaseTimeout = 10 * time.Millisecond
 
 	// Set very high values to prevent snapshots to happen randomly.
-	conf.SnapshotInterval = 24 * time.Hour
-	conf.SnapshotThreshold = 4096
+	conf.SnapshotInterval = defaultSnapshotInterval
+	conf.SnapshotThreshold = defaultSnapshotThreshold
 
 	// Set the snapshot to retain only one log, since the most common use
 	// case is to test an FSM restore from a snapshot.
@@ -157,14 +317,140 @@ func newDefaultDependencies(t testing.TB, logger hclog.Logger, i int, fsm raft.F
 
 	store := raft.NewInmemStore()
 	return &dependencies{
-		Conf:   conf,
-		FSM:    fsm,
-		Logs:   store,
-		Stable: store,
-		Snaps:  raft.NewInmemSnapshotStore(),
-		Trans:  transport,
-		Voter:  true,
+		Conf:    conf,
+		FSM:     fsm,
+		Logs:    store,
+		Stable:  store,
+		Snaps:   stores.NewSnapshotStore(1),
+		Trans:   transport,
+		Voter:   true,
+		Capture: capture,
+		Clock:   systemClock{},
+		t:       t,
+	}
+}
+
+// Make the default in-memory snapshot store of each server that requested it
+// with SnapshotArchive() keep all snapshots.
+func archiveSnapshots(dependencies []*dependencies) {
+	for _, d := range dependencies {
+		if !d.SnapshotArchive {
+			continue
+		}
+		if store, ok := d.Snaps.(*stores.SnapshotStore); ok {
+			store.KeepAll()
+		}
+	}
+}
+
+// Check that the dependencies of each server, possibly provided by options,
+// are usable.
+func validateDependencies(t Reporter, dependencies []*dependencies) {
+	t.Helper()
+
+	ids := make(map[raft.ServerID]int)
+	addresses := make(map[raft.ServerAddress]raft.ServerID)
+	for i, d := range dependencies {
+		if d.Conf == nil {
+			t.Fatalf("raft-test: setup: error: server %d: no config set (check Config options)", i)
+		}
+		id := d.Conf.LocalID
+		missing := ""
+		switch {
+		case d.FSM == nil:
+			missing = "FSM (check the FSMs passed to Cluster)"
+		case d.Logs == nil:
+			missing = "log store (check LogStore options)"
+		case d.Stable == nil:
+			missing = "stable store"
+		case d.Snaps == nil:
+			missing = "snapshot store"
+		case d.Trans == nil:
+			missing = "transport (check Transport options)"
+		}
+		if missing != "" {
+			t.Fatalf("raft-test: setup: error: server %s: no %s set", id, missing)
+		}
+		if err := raft.ValidateConfig(d.Conf); err != nil {
+			t.Fatalf("raft-test: setup: error: server %s: invalid config (check Config options): %v", id, err)
+		}
+		if other, ok := ids[id]; ok {
+			t.Fatalf("raft-test: setup: error: servers %d and %d have the same ID %s", other, i, id)
+		}
+		ids[id] = i
+		address := d.Trans.LocalAddr()
+		if other, ok := addresses[address]; ok {
+			t.Fatalf("raft-test: setup: error: servers %s and %s have the same transport address %s", other, id, address)
+		}
+		addresses[address] = id
+	}
+}
+
+// Log a warning for each server whose snapshot settings are unlikely to work
+// as the test intends.
+func checkSnapshotConfig(t Reporter, strict bool, dependencies []*dependencies) {
+	t.Helper()
+
+	for _, d := range dependencies {
+		id := d.Conf.LocalID
+		if d.SnapshotAfter != 0 {
+			continue
+		}
+		if d.Conf.SnapshotThreshold != defaultSnapshotThreshold && d.Conf.SnapshotInterval >= defaultSnapshotInterval {
+			warnf(t, strict,
+				"raft-test: setup: warning: server %s: SnapshotThreshold is set to %d but SnapshotInterval is %s, "+
+					"so raft will never check whether to take a snapshot during the test (use ForceSnapshotAfter)",
+				id, d.Conf.SnapshotThreshold, d.Conf.SnapshotInterval)
+		}
+		if d.Conf.SnapshotInterval < defaultSnapshotInterval && d.Conf.SnapshotThreshold == defaultSnapshotThreshold {
+			warnf(t, strict,
+				"raft-test: setup: warning: server %s: SnapshotInterval is set to %s but SnapshotThreshold is %d, "+
+					"so raft will take a snapshot only after that many new log entries (use ForceSnapshotAfter)",
+				id, d.Conf.SnapshotInterval, d.Conf.SnapshotThreshold)
+		}
+	}
+}
+
+// Return true if the Strict option was used.
+func isStrict(dependencies []*dependencies) bool {
+	for _, d := range dependencies {
+		if d.Strict {
+			return true
+		}
 	}
+	return false
+}
+
+// Return true if the CommandHistory option was used.
+func recordsCommands(dependencies []*dependencies) bool {
+	for _, d := range dependencies {
+		if d.CommandHistory {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if the Invariants option was used.
+func checksInvariants(dependencies []*dependencies) bool {
+	for _, d := range dependencies {
+		if d.Invariants {
+			return true
+		}
+	}
+	return false
+}
+
+// Report a warning about a harness-detected anomaly, failing the test if
+// strict is true.
+func warnf(t Reporter, strict bool, format string, args ...interface{}) {
+	t.Helper()
+
+	if strict {
+		t.Errorf(format, args...)
+		return
+	}
+	t.Logf(format, args...)
 }
 
 // Set scaled timeouts on all servers, to match GO_RAFT_TEST_LATENCY (if set).
@@ -178,7 +464,7 @@ func setTimeouts(dependencies []*dependencies) {
 }
 
 // Set leader notification channels on all servers.
-func instrumentConfigs(t testing.TB, logger hclog.Logger, dependencies []*dependencies) *election.Tracker {
+func instrumentConfigs(t Reporter, logger hclog.Logger, dependencies []*dependencies) *election.Tracker {
 	t.Helper()
 
 	tracker := election.NewTracker(logger)
@@ -206,20 +492,59 @@ func instrumentTransports(logger hclog.Logger, dependencies []*dependencies) *ne
 	// performed by the network object
 	connectLoobackTransports(dependencies)
 
-	network := network.New(logger)
+	seed := time.Now().UnixNano()
+	for _, d := range dependencies {
+		if d.FaultSeed != nil {
+			seed = *d.FaultSeed
+		}
+	}
+	network := network.New(logger, seed)
 
 	for _, d := range dependencies {
 		d.Trans = network.Add(d.Conf.LocalID, d.Trans)
 	}
 
+	for _, d := range dependencies {
+		if d.LinkLatency == 0 && d.LinkJitter == 0 {
+			continue
+		}
+		for _, other := range dependencies {
+			if other != d {
+				network.SetLatency(d.Conf.LocalID, other.Conf.LocalID, d.LinkLatency, d.LinkJitter)
+			}
+		}
+	}
+
 	return network
 }
 
+// Apply the decorators of each server to its instrumented transport.
+func decorateTransports(t Reporter, dependencies []*dependencies) {
+	t.Helper()
+
+	for _, d := range dependencies {
+		id := d.Conf.LocalID
+		address := d.Trans.LocalAddr()
+		for _, decorator := range d.Decorators {
+			d.Trans = decorator(d.Trans)
+			if d.Trans == nil {
+				t.Fatalf("raft-test: setup: error: server %s: transport decorator returned nil", id)
+			}
+			if d.Trans.LocalAddr() != address {
+				t.Fatalf("raft-test: setup: error: server %s: transport decorator changed address from %s to %s", id, address, d.Trans.LocalAddr())
+			}
+		}
+	}
+}
+
 // Replace the dependencies.FSM object on each server with a wrapper FSM that
 // wraps the real FSM. Return a watcher object that can be used to get notified
 // of various events.
 func instrumentFSMs(logger hclog.Logger, dependencies []*dependencies) *fsms.Watcher {
 	watcher := fsms.New(logger)
+	if recordsCommands(dependencies) {
+		watcher.RecordData()
+	}
 
 	for _, d := range dependencies {
 		d.FSM = watcher.Add(d.Conf.LocalID, d.FSM)
@@ -228,6 +553,21 @@ func instrumentFSMs(logger hclog.Logger, dependencies []*dependencies) *fsms.Wat
 	return watcher
 }
 
+// Replace the dependencies.Logs object on each server with a wrapper log store
+// that wraps the real one. Return a stores object that can be used to inject
+// faults.
+func instrumentLogStores(logger hclog.Logger, dependencies []*dependencies) *stores.Stores {
+	stores := stores.New(logger)
+
+	for _, d := range dependencies {
+		d.Logs = stores.Add(d.Conf.LocalID, d.Logs)
+		d.Stable = stores.AddStable(d.Conf.LocalID, d.Stable)
+		d.Snaps = stores.AddSnapshots(d.Conf.LocalID, d.Snaps)
+	}
+
+	return stores
+}
+
 // Connect loopback transports from servers that have them.
 func connectLoobackTransports(dependencies []*dependencies) {
 	loopbacks := make([]raft.LoopbackTransport, 0)
@@ -251,37 +591,31 @@ func connectLoobackTransports(dependencies []*dependencies) {
 
 // Bootstrap the cluster, including in the initial configuration of each voting
 // server.
-func bootstrapCluster(t testing.TB, logger hclog.Logger, dependencies []*dependencies) {
+func bootstrapCluster(t Reporter, logger hclog.Logger, dependencies []*dependencies) {
 	t.Helper()
 
-	// Figure out which servers should be part of the initial
-	// configuration.
-	servers := make([]raft.Server, 0)
-	for _, d := range dependencies {
+	// Create the initial cluster configuration.
+	configuration := initialConfiguration(dependencies)
+	for i := 0; i < len(dependencies); i++ {
+		d := dependencies[i]
 		id := d.Conf.LocalID
-		if !d.Voter {
+		if !d.Voter && !d.NonVoter && !d.Staging {
 			// If the server is not initially part of the cluster,
 			// there's nothing to do.
 			logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: server %s: skip bootstrap (not part of initial configuration)", id))
 			continue
 		}
-		server := raft.Server{
-			ID:      id,
-			Address: d.Trans.LocalAddr(),
+		existing, err := raft.HasExistingState(d.Logs, d.Stable, d.Snaps)
+		if err != nil {
+			t.Fatalf("raft-test: setup: error: server %s: failed to check existing state: %v", id, err)
 		}
-		servers = append(servers, server)
-	}
-
-	// Create the initial cluster configuration.
-	configuration := raft.Configuration{Servers: servers}
-	for i := 0; i < len(dependencies); i++ {
-		d := dependencies[i]
-		id := d.Conf.LocalID
-		if !d.Voter {
+		if existing {
+			// The stores were pre-populated, e.g. by Clone().
+			logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: server %s: skip bootstrap (existing state)", id))
 			continue
 		}
 		logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: server %s: bootstrap", id))
-		err := raft.BootstrapCluster(
+		err = raft.BootstrapCluster(
 			d.Conf,
 			d.Logs,
 			d.Stable,
@@ -296,6 +630,31 @@ func bootstrapCluster(t testing.TB, logger hclog.Logger, dependencies []*depende
 
 }
 
+// Figure out which servers should be part of the initial configuration.
+func initialConfiguration(dependencies []*dependencies) raft.Configuration {
+	servers := make([]raft.Server, 0)
+	for _, d := range dependencies {
+		if !d.Voter && !d.NonVoter && !d.Staging {
+			continue
+		}
+		suffrage := raft.Voter
+		switch {
+		case d.Voter:
+		case d.Staging:
+			suffrage = raft.Staging
+		default:
+			suffrage = raft.Nonvoter
+		}
+		server := raft.Server{
+			Suffrage: suffrage,
+			ID:       d.Conf.LocalID,
+			Address:  d.Trans.LocalAddr(),
+		}
+		servers = append(servers, server)
+	}
+	return raft.Configuration{Servers: servers}
+}
+
 // Convenience around raft.NewRaft for creating a new Raft instance using the
 // given dependencies.
 func newRaft(d *dependencies) (*raft.Raft, error) {
@@ -0,0 +1,135 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// CompareLogs reads the log store of every server, including the ones that
+// are currently killed or crashed, and fails the test if the Log Matching
+// property doesn't hold: if two logs contain an entry with the same index and
+// term, then they must be identical in all entries up to that index.
+//
+// The failure message reports the first index at which the logs of two
+// servers diverge. It's typically useful to validate custom LogStore
+// implementations.
+func (c *Control) CompareLogs() {
+	c.t.Helper()
+
+	// Killed and crashed servers are gone from c.servers, but their
+	// dependencies still hold the log stores they used.
+	nodes := c.allNodes()
+	for i, nodeA := range nodes {
+		for _, nodeB := range nodes[i+1:] {
+			a := nodeA.Conf.LocalID
+			b := nodeB.Conf.LocalID
+			if err := compareLogs(a, nodeA.Logs, b, nodeB.Logs); err != nil {
+				c.t.Fatalf("raft-test: compare logs: servers %s and %s: %v", a, b, err)
+			}
+		}
+	}
+}
+
+// Check the Log Matching property for the log stores of the two given servers.
+func compareLogs(a raft.ServerID, storeA raft.LogStore, b raft.ServerID, storeB raft.LogStore) error {
+
+	first, last, err := overlappingLogs(storeA, storeB)
+	if err != nil {
+		return err
+	}
+	if first == 0 || first > last {
+		return nil
+	}
+
+	// Find the highest index at which the two logs have an entry with the
+	// same term: all entries up to it must be identical.
+	matched := uint64(0)
+	for index := last; index >= first; index-- {
+		termA, err := logTerm(storeA, index)
+		if err != nil {
+			return err
+		}
+		termB, err := logTerm(storeB, index)
+		if err != nil {
+			return err
+		}
+		if termA != 0 && termA == termB {
+			matched = index
+			break
+		}
+	}
+
+	for index := first; index <= matched; index++ {
+		entryA := raft.Log{}
+		entryB := raft.Log{}
+		if err := storeA.GetLog(index, &entryA); err != nil {
+			return fmt.Errorf("index %d: entry missing from server %s: %v", index, a, err)
+		}
+		if err := storeB.GetLog(index, &entryB); err != nil {
+			return fmt.Errorf("index %d: entry missing from server %s: %v", index, b, err)
+		}
+		if entryA.Term != entryB.Term || entryA.Type != entryB.Type || !bytes.Equal(entryA.Data, entryB.Data) {
+			return fmt.Errorf(
+				"logs diverge at index %d, but match at index %d: term %d type %d %q vs term %d type %d %q",
+				index, matched, entryA.Term, entryA.Type, entryA.Data, entryB.Term, entryB.Type, entryB.Data)
+		}
+	}
+
+	return nil
+}
+
+// Return the range of indexes present in both the given log stores.
+func overlappingLogs(a, b raft.LogStore) (uint64, uint64, error) {
+	first := uint64(0)
+	last := ^uint64(0)
+	for _, store := range []raft.LogStore{a, b} {
+		index, err := store.FirstIndex()
+		if err != nil {
+			return 0, 0, err
+		}
+		if index == 0 {
+			return 0, 0, nil
+		}
+		if index > first {
+			first = index
+		}
+		index, err = store.LastIndex()
+		if err != nil {
+			return 0, 0, err
+		}
+		if index < last {
+			last = index
+		}
+	}
+	return first, last, nil
+}
+
+// Return the term of the entry at the given index, or zero if there's no
+// such entry.
+func logTerm(store raft.LogStore, index uint64) (uint64, error) {
+	log := raft.Log{}
+	err := store.GetLog(index, &log)
+	if err == raft.ErrLogNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("index %d: %v", index, err)
+	}
+	return log.Term, nil
+}
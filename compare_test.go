@@ -0,0 +1,101 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The logs of servers that replicated the same entries match.
+func TestControl_CompareLogs(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3))
+	defer control.Close()
+
+	control.Elect("0")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{byte(i)}, time.Second).Error())
+	}
+	control.Barrier()
+
+	// A follower lagging behind doesn't break the property.
+	control.Kill(rafts["2"])
+	require.NoError(t, rafts["0"].Apply([]byte{3}, time.Second).Error())
+
+	control.CompareLogs()
+}
+
+// If two logs have a matching entry, but differ at an earlier index, the test
+// fails.
+func TestControl_CompareLogs_Diverge(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	stores := make([]*raft.InmemStore, 3)
+	factory := func(i int) raft.LogStore {
+		stores[i] = raft.NewInmemStore()
+		return stores[i]
+	}
+	rafts, control := rafttest.Cluster(
+		rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LogStore(factory), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	future := rafts["0"].Apply([]byte{1}, time.Second)
+	require.NoError(t, future.Error())
+	require.NoError(t, rafts["0"].Apply([]byte{2}, time.Second).Error())
+	control.Barrier()
+
+	log := raft.Log{}
+	require.NoError(t, stores[1].GetLog(future.Index(), &log))
+	log.Data = []byte{9}
+	require.NoError(t, stores[1].StoreLog(&log))
+
+	assert.Panics(t, control.CompareLogs)
+	assert.Contains(t, buffer.String(), "servers 0 and 1: logs diverge at index")
+	assert.Contains(t, buffer.String(), `"\x01" vs term`)
+}
+
+// The logs of killed servers are compared too.
+func TestControl_CompareLogs_Killed(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	stores := make([]*raft.InmemStore, 3)
+	factory := func(i int) raft.LogStore {
+		stores[i] = raft.NewInmemStore()
+		return stores[i]
+	}
+	rafts, control := rafttest.Cluster(
+		rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LogStore(factory), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	future := rafts["0"].Apply([]byte{1}, time.Second)
+	require.NoError(t, future.Error())
+	require.NoError(t, rafts["0"].Apply([]byte{2}, time.Second).Error())
+	control.Barrier()
+	control.Kill(rafts["2"])
+
+	log := raft.Log{}
+	require.NoError(t, stores[2].GetLog(future.Index(), &log))
+	log.Data = []byte{9}
+	require.NoError(t, stores[2].StoreLog(&log))
+
+	assert.Panics(t, control.CompareLogs)
+	assert.Contains(t, buffer.String(), "servers 0 and 2: logs diverge at index")
+}
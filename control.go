@@ -17,14 +17,19 @@ package rafttest
 import (
 	"context"
 	"fmt"
-	"runtime"
-	"testing"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/CanonicalLtd/raft-test/internal/election"
 	"github.com/CanonicalLtd/raft-test/internal/event"
 	"github.com/CanonicalLtd/raft-test/internal/fsms"
 	"github.com/CanonicalLtd/raft-test/internal/network"
+	"github.com/CanonicalLtd/raft-test/internal/stores"
 	"github.com/hashicorp/raft"
 	"github.com/hashicorp/go-hclog"
 )
@@ -32,15 +37,25 @@ import (
 // Control the events happening in a cluster of raft servers, such has leadership
 // changes, failures and shutdowns.
 type Control struct {
-	t        testing.TB
+	t        Reporter
 	logger   hclog.Logger
 	election *election.Tracker
 	network  *network.Network
 	watcher  *fsms.Watcher
+	stores   *stores.Stores
 	confs    map[raft.ServerID]*raft.Config
 	servers  map[raft.ServerID]*raft.Raft
+	nodes    []*dependencies
+	watchdog *watchdog
 	errored  bool
-	deposing chan struct{}
+	deposing chan error
+
+	// Protects servers, confs and nodes, which Kill(), Crash(), Restart(),
+	// Add() and Remove() change while background goroutines read them.
+	mu sync.RWMutex
+
+	// Index to give to the next server created by Add().
+	nextIndex int
 
 	// Current Term after Elect() was called, if any.
 	term *Term
@@ -48,6 +63,110 @@ type Control struct {
 	// Future of any pending snapshot that has been scheduled with an
 	// event.
 	snapshotFuture raft.SnapshotFuture
+
+	// Log patterns that no server should emit, see ForbidLogPattern().
+	forbidden []forbiddenPattern
+
+	// If non-zero, waits are progress-aware, see SetStallTimeout().
+	stallTimeout time.Duration
+
+	// Start, stop and partition times of all servers.
+	uptime *uptimeTracker
+
+	// Callbacks to invoke when faults are injected or healed.
+	hooks faultHooks
+
+	// Faults injected so far, see Recording().
+	recorder faultRecorder
+
+	// Leader deposed with Depose(), until a new leader gets elected.
+	deposed raft.ServerID
+
+	// Current delay set with SlowLeaderDisk().
+	slowDisk time.Duration
+
+	// Number of times Elect() had to retry an election.
+	electRetries uint64
+
+	// Directory where a summary of the run is archived, see the
+	// ResultsArchive option.
+	archiveDir string
+
+	// Servers isolated from the leader by Partition(), until Heal().
+	isolated []raft.ServerID
+
+	// Whether probabilistic RPC faults were injected, see FaultSeed().
+	randomFaults bool
+
+	// Maximum number of leader changes, see AssertLeaderChangesAtMost().
+	maxLeaderChanges *uint64
+
+	// Maximum apply stall caused by snapshots, see
+	// AssertSnapshotStallAtMost().
+	maxSnapshotStall *time.Duration
+
+	// Closed when the cluster gets closed, to stop background goroutines.
+	stopCh chan struct{}
+
+	// Observer of raft invariants, if enabled with the Invariants option.
+	invariants *invariantsChecker
+
+	// Recorder of RPCs, if enabled with the Trace option.
+	tracer *rpcTracer
+
+	// Chaos run in progress, if any.
+	chaos *ChaosRun
+
+	// Goroutines running before the cluster was created, if the LeakCheck
+	// option is used.
+	goroutines map[string]string
+
+	// Directory where artifacts are written if the test fails, see the
+	// Artifacts option.
+	artifactsDir string
+
+	// Whether raft logs should be written to the testing log if the test
+	// fails, see the Logging option.
+	logOnFailure bool
+
+	// Source of time for measuring timeouts, see the TimeSource option.
+	clock Clock
+
+	// Events delivered by Events().
+	events *eventBus
+
+	// Restores performed by restarted servers, keyed by the FSM restores
+	// count, and number of restores already returned by WaitRestore().
+	bootRestores   map[raft.ServerID]map[uint64]Restore
+	waitedRestores map[raft.ServerID]uint64
+
+	// Raft instances of servers crashed with Crash(), until they get shut
+	// down when restarted or closed.
+	crashed map[raft.ServerID]*raft.Raft
+
+	// Apply futures registered with Track().
+	tracker *applyTracker
+
+	// Number of FSM failures already reported for each server, see
+	// fsmFailures().
+	reportedFSMFailures map[raft.ServerID]int
+
+	// Operations performed with Apply(), see CheckLinearizable().
+	operations *operationRecorder
+
+	// Outcome of scenario steps run so far, and path of the file they
+	// should be written to, see WriteResults().
+	results     []StepResult
+	resultsPath string
+}
+
+// A log pattern forbidden by ForbidLogPattern().
+type forbiddenPattern struct {
+	re *regexp.Regexp
+
+	// Number of log entries each server had already emitted when the
+	// pattern was forbidden.
+	offsets map[raft.ServerID]int
 }
 
 // Close the control for this raft cluster, shutting down all servers and
@@ -57,23 +176,76 @@ type Control struct {
 func (c *Control) Close() {
 	c.logger.Debug("[DEBUG] raft-test: close: start")
 
+	// Stop injecting random faults, if we were.
+	c.stopChaos()
+
 	// First tell the election tracker that we don't care anymore about
 	// notifications. Any value received from the NotifyCh's will be dropped
 	// on the floor.
 	c.election.Ignore()
 
-	// Now shutdown the servers.
+	// Stop background goroutines, such as the ones forcing snapshots.
+	close(c.stopCh)
+
+	// Now shutdown the servers, and wait for crashed ones to be gone.
 	c.shutdownServers()
+	c.reapCrashed()
+	for id := range c.servers {
+		c.recordCancellations(id, "close")
+	}
+
+	// Check that no forbidden log entry was emitted.
+	c.checkForbiddenLogs()
+
+	// Check that there was no election churn.
+	c.checkLeaderChanges()
+
+	// Check that snapshots didn't stall applies for too long.
+	c.checkSnapshotStall()
+
+	// Check that no raft invariant was violated.
+	c.checkInvariants()
+
+	// Check that logs were applied to FSMs as raft guarantees.
+	for _, failure := range c.fsmFailures() {
+		c.t.Errorf("raft-test: close: fsm: %s", failure)
+	}
+
+	// Report the seed of random RPC faults, if the test failed.
+	c.reportFaultSeed()
+
+	// Report the outcome of scenario steps, if requested.
+	c.writeResults()
+
+	// Dump logs, stores and snapshots if the test failed, if requested.
+	c.writeArtifacts()
+	c.writeLogsOnFailure()
+
+	// Archive a summary of the run, if requested.
+	c.archiveRun()
 
 	// Finally shutdown the election tracker since nothing will be
 	// sending to NotifyCh's.
 	c.election.Close()
 
+	// Release any on-disk data and real transports.
+	c.removeData()
+	c.closeTransports()
+
+	// Check that all goroutines of the cluster are gone.
+	c.checkLeaks()
+
 	c.logger.Debug("[DEBUG] raft-test: close: done")
 }
 
 // Elect a server as leader.
 //
+// The election is deterministic: only the transport of the given server gets
+// connected to the others, so no other server can win it, and the method
+// returns only once leadership is stable, retrying if needed. There's no need
+// to loop on LeadershipAcquired(). Use Term.Leader() to get the raft instance
+// of the elected server.
+//
 // When calling this method there must be no leader in the cluster and server
 // transports must all be disconnected from eacher.
 func (c *Control) Elect(id raft.ServerID) *Term {
@@ -83,7 +255,8 @@ func (c *Control) Elect(id raft.ServerID) *Term {
 
 	// Wait for the current leader (if any) to be fully deposed.
 	if c.deposing != nil {
-		<-c.deposing
+		c.endTerm(<-c.deposing)
+		c.deposing = nil
 	}
 
 	// Sanity check that no server is the leader.
@@ -104,6 +277,7 @@ func (c *Control) Elect(id raft.ServerID) *Term {
 		if leadership == nil {
 			if n < maxElectionRounds {
 				c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: elect: server %s: retry %d ", id, n+1))
+				c.electRetries++
 				continue
 			}
 		}
@@ -114,6 +288,7 @@ func (c *Control) Elect(id raft.ServerID) *Term {
 		if !c.waitLeadershipPropagated(id, leadership) {
 			if n < maxElectionRounds {
 				c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: elect: server %s: retry %d ", id, n+1))
+				c.electRetries++
 				continue
 			}
 		}
@@ -135,10 +310,16 @@ func (c *Control) Elect(id raft.ServerID) *Term {
 			leadership: leadership,
 		}
 		c.term = term
+		c.recorder.Elected(id)
+
+		if deposed := c.deposed; deposed != "" {
+			c.deposed = ""
+			c.faultHealed(FaultAction{Kind: FaultDepose, Target: deposed})
+		}
 
 		return term
 	}
-	c.t.Fatalf("raft-test: server %s: did not acquire stable leadership", id)
+	c.t.Fatalf("raft-test: server %s: did not acquire stable leadership:\n%s", id, c.diagnostics())
 
 	return nil
 }
@@ -150,30 +331,46 @@ func (c *Control) Elect(id raft.ServerID) *Term {
 //
 // Usually you don't wan't to concurrently keep invoking Apply() on the cluster
 // raft instances while Barrier() is running.
+//
+// It's the same as BarrierTimeout(0).
 func (c *Control) Barrier() {
+	c.t.Helper()
+
+	c.BarrierTimeout(0)
+}
+
+// BarrierTimeout is like Barrier(), but it fails the test if the cluster
+// doesn't settle within the given timeout. A zero timeout means the default
+// one, see Duration().
+func (c *Control) BarrierTimeout(timeout time.Duration) {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+	deadline := time.After(timeout)
+
 	// Wait for snapshots to complete.
 	if c.snapshotFuture != nil {
-		if err := c.snapshotFuture.Error(); err != nil {
+		if err := c.await(c.snapshotFuture, "snapshot"); err != nil {
 			c.t.Fatalf("raft-test: snapshot failed: %v", err)
 		}
 	}
 
 	// Wait for inflight commands to be applied to the leader's FSM.
 	if c.term.id != "" {
-		// Set a relatively high timeout.
-		//
-		// TODO: let users specify the maximum amount of time a single
-		// Apply() to their FSM should take, and calculate this value
-		// accordingly.
-		timeout := Duration(time.Second)
-
-		if err := c.servers[c.term.id].Barrier(timeout).Error(); err != nil {
+		if err := c.await(c.servers[c.term.id].Barrier(timeout), "server %s: barrier", c.term.id); err != nil {
 			c.t.Fatalf("raft-test: leader barrier: %v", err)
 		}
 
+		// Get the current configuration, since servers that have been
+		// removed from the cluster won't catch up.
+		members := make(map[raft.ServerID]bool)
+		for _, server := range c.configuration(c.term.id).Servers {
+			members[server.ID] = true
+		}
+
 		// Wait for follower FSMs to catch up.
 		n := c.Commands(c.term.id)
-		events := make([]*event.Event, 0)
+		events := make(map[raft.ServerID]*event.Event)
 		for id := range c.servers {
 			if id == c.term.id {
 				continue
@@ -182,12 +379,34 @@ func (c *Control) Barrier() {
 			if !c.network.PeerConnected(c.term.id, id) {
 				continue
 			}
-			event := c.watcher.WhenApplied(id, n)
-			events = append(events, event)
+			// Skip servers not part of the cluster.
+			if !members[id] {
+				continue
+			}
+			events[id] = c.watcher.WhenApplied(id, n)
 		}
-		for _, event := range events {
-			<-event.Watch()
-			event.Ack()
+		for id, event := range events {
+			select {
+			case <-event.Watch():
+				event.Ack()
+			case <-deadline:
+				// Ack all remaining events, so FSMs don't block
+				// when they eventually fire them.
+				lagging := []string{}
+				for id, event := range events {
+					select {
+					case <-event.Watch():
+					default:
+						lagging = append(lagging, string(id))
+					}
+					event.Ack()
+				}
+				sort.Strings(lagging)
+				c.t.Fatalf(
+					"raft-test: barrier: servers %s did not apply %d commands within %s:\n%s",
+					strings.Join(lagging, ", "), n, timeout, c.diagnostics())
+			}
+			delete(events, id)
 		}
 	}
 }
@@ -200,10 +419,328 @@ func (c *Control) Barrier() {
 // It must not be called if the current term has scheduled a depose action with
 // Action.Depose().
 func (c *Control) Depose() {
+	id := c.term.id
+	c.depose()
+
+	c.deposed = id
+	c.faultInjected(FaultAction{Kind: FaultDepose, Target: id})
+}
+
+// DeposeLeader is like Depose(), but it takes the raft instance of the leader
+// to depose, failing the test if it's not the current leader, and it waits at
+// most the given timeout for it to step down, instead of the leader lease
+// timeout.
+func (c *Control) DeposeLeader(r *raft.Raft, timeout time.Duration) {
+	c.t.Helper()
+
+	id := c.serverID(r, "depose")
+	if c.term == nil || c.term.id != id {
+		c.t.Fatalf("raft-test: depose: server %s is not the leader", id)
+	}
+
+	c.deposeWithin(timeout)
+	if r.State() == raft.Leader {
+		c.t.Fatalf("raft-test: depose: server %s did not step down within %s:\n%s", id, timeout, c.diagnostics())
+	}
+
+	c.deposed = id
+	c.faultInjected(FaultAction{Kind: FaultDepose, Target: id})
+}
+
+// Depose the current leader and wait for it to lose leadership.
+func (c *Control) depose() {
+	c.deposeWithin(maximumLeaderLeaseTimeout(c.confs))
+}
+
+// Depose the current leader and wait at most the given timeout for it to lose
+// leadership.
+func (c *Control) deposeWithin(timeout time.Duration) {
+	c.checkLeader(c.term.id)
+
 	event := event.New()
-	go c.deposeUponEvent(event, c.term.id, c.term.leadership)
+	done := make(chan error, 1)
+	go c.deposeUponEvent(event, c.term.id, c.term.leadership, timeout, done)
 	event.Fire()
 	event.Block()
+	c.endTerm(<-done)
+}
+
+// Report any failure to depose the leader of the current term, and forget
+// about the term.
+func (c *Control) endTerm(err error) {
+	if err != nil {
+		c.t.Errorf("raft-test: %v:\n%s", err, c.diagnostics())
+		c.errored = true
+	}
+	c.term = nil
+}
+
+// Panic if the server with the given ID is not the leader, which is a bug in
+// the harness.
+func (c *Control) checkLeader(id raft.ServerID) {
+	if r := c.servers[id]; r.State() != raft.Leader {
+		panic(fmt.Errorf("raft-test: server %s: is not leader", id))
+	}
+}
+
+// AssertSameLeader runs the given function and fails the test if leadership
+// changes while it runs.
+//
+// The leader is looked up before and after the function runs, and observed in
+// between, so that even a leader that steps down and gets re-elected during
+// the function is detected. A leader must have been elected beforehand.
+func (c *Control) AssertSameLeader(f func()) {
+	c.t.Helper()
+
+	before := c.leader()
+	if before == "" {
+		c.t.Fatalf("raft-test: assert same leader: no leader before running function")
+	}
+
+	// Register an observer on the leader, watching for leader and state
+	// changes, which also catches other servers winning an election, since
+	// the leader would then step down. The channel is buffered and the
+	// observer is not blocking, so raft won't get stuck on us: dropped
+	// observations still count as changes.
+	r := c.server(before)
+	observationCh := make(chan raft.Observation, 64)
+	filter := func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.RaftState:
+			return true
+		}
+		return false
+	}
+	observer := raft.NewObserver(observationCh, false, filter)
+	r.RegisterObserver(observer)
+	defer r.DeregisterObserver(observer)
+
+	f()
+
+	after := c.leader()
+
+	changes := len(observationCh) + int(observer.GetNumDropped())
+
+	if after != before {
+		c.t.Fatalf("raft-test: assert same leader: leader changed from server %s to server %q", before, after)
+	}
+	if changes > 0 {
+		c.t.Fatalf("raft-test: assert same leader: server %s lost and regained leadership", before)
+	}
+}
+
+// AssertNoWritesAfterRemoval fails the test if the server with the given ID,
+// which must have been removed from the cluster configuration, was sent or
+// has applied any command log with an index higher than the one of the
+// configuration change that removed it.
+//
+// It catches bugs where stale replication streams keep feeding removed
+// servers.
+func (c *Control) AssertNoWritesAfterRemoval(id raft.ServerID) {
+	c.t.Helper()
+
+	leader := c.leader()
+	if leader == "" {
+		c.t.Fatalf("raft-test: assert no writes after removal: no leader")
+	}
+
+	future := c.servers[leader].GetConfiguration()
+	if err := c.await(future, "server %s: get configuration", leader); err != nil {
+		c.t.Fatalf("raft-test: control: server %s: failed to get configuration: %v", leader, err)
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID == id {
+			c.t.Fatalf("raft-test: assert no writes after removal: server %s was not removed", id)
+		}
+	}
+	index := future.Index()
+
+	if sent := c.network.LastCommandSentTo(id); sent > index {
+		c.t.Fatalf("raft-test: assert no writes after removal: server %s was removed at index %d but was sent command log %d", id, index, sent)
+	}
+	if applied := c.watcher.LastIndex(id); applied > index {
+		c.t.Fatalf("raft-test: assert no writes after removal: server %s was removed at index %d but applied command log %d", id, index, applied)
+	}
+}
+
+// HeartbeatOnly sets whether links between servers should deliver only
+// heartbeats, dropping any RPC that carries log entries or snapshots.
+//
+// While enabled the cluster looks healthy, since the leader keeps its
+// leadership and followers don't start elections, but no command log can be
+// committed. It's meant to test progress watchdogs in applications.
+func (c *Control) HeartbeatOnly(enabled bool) {
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: heartbeat only: %v", enabled))
+	c.network.HeartbeatOnly(enabled)
+}
+
+// HeartbeatOnlyLink is like HeartbeatOnly(), but it only affects the link from
+// the server with ID from to the server with ID to, so for example a single
+// follower can be starved of log entries while the rest of the cluster makes
+// progress.
+func (c *Control) HeartbeatOnlyLink(from, to raft.ServerID, enabled bool) {
+	c.t.Helper()
+	c.checkLink("heartbeat only", from, to)
+	c.network.SetHeartbeatOnly(from, to, enabled)
+}
+
+// SetLinkLatency adds the given delay to every RPC that the server with ID
+// from sends to the server with ID to, replacing any delay previously set with
+// the LinkLatency option or with this method. If jitter is non-zero, each
+// delay is randomized by up to jitter in either direction. Zero durations
+// restore a zero-latency link.
+func (c *Control) SetLinkLatency(from, to raft.ServerID, delay, jitter time.Duration) {
+	c.t.Helper()
+	c.checkLink("link latency", from, to)
+	c.usingRandomFaults(jitter > 0)
+	c.network.SetLatency(from, to, delay, jitter)
+}
+
+// SlowLeaderDisk makes every write to the log store of the current leader take
+// at least the given additional amount of time. The fault follows leadership
+// as it moves from one server to another, while followers keep writing at
+// full speed. A zero duration disables the fault.
+//
+// It's meant to measure how sensitive commit latency is to the performance
+// of the leader's disk.
+func (c *Control) SlowLeaderDisk(delay time.Duration) {
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: slow leader disk by %s", delay))
+
+	// Store writes happen on raft goroutines, possibly while servers get
+	// killed or restarted.
+	c.stores.SlowLeader(delay, func(id raft.ServerID) bool {
+		r := c.server(id)
+		return r != nil && r.State() == raft.Leader
+	})
+
+	previous := c.slowDisk
+	c.slowDisk = delay
+	if delay != 0 {
+		c.faultInjected(FaultAction{Kind: FaultSlowDisk, Target: c.leader(), Delay: delay})
+	} else if previous != 0 {
+		c.faultHealed(FaultAction{Kind: FaultSlowDisk, Target: c.leader(), Delay: previous})
+	}
+}
+
+// SlowApplyChannel delays by the given amount of time the handoff of each
+// committed command log to the FSM of the server with the given ID, throttling
+// the rate at which it applies them. A zero duration disables the fault.
+//
+// Unlike an FSM that is slow to apply, the delay happens before the FSM is
+// invoked and is not accounted as apply time: it's meant to build up a backlog
+// of committed but not yet applied command logs, to test how applications
+// surface apply lag.
+func (c *Control) SlowApplyChannel(id raft.ServerID, delay time.Duration) {
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: server %s: slow apply channel by %s", id, delay))
+	c.watcher.SetHandoffDelay(id, delay)
+}
+
+// FSMHooks intercept the calls made by raft to the FSM of a server, to inject
+// latency or errors. See SetFSMHooks().
+//
+// Hooks run in raft's goroutines, where a panic would crash the test binary:
+// panics are instead recovered and make the test fail at Close(), in which
+// case the log is handed to the FSM as if there was no hook, and snapshots or
+// restores fail.
+type FSMHooks struct {
+	// Called before handing a command log to the FSM, with n being the
+	// value that Commands() will have once the log is applied: it restarts
+	// from zero when the server restarts, and from the count stored in the
+	// snapshot when the FSM gets restored. It can sleep to make the FSM
+	// slow. If it returns a non-nil value, such as an error, the log is not
+	// handed to the FSM, the value is returned as apply response and the
+	// log is not counted by Commands(), so the next log gets the same n.
+	Apply func(n uint64, log *raft.Log) interface{}
+
+	// Called before taking a snapshot of the FSM. If it returns an error,
+	// the snapshot fails with it.
+	Snapshot func() error
+
+	// Called before restoring the FSM from a snapshot. If it returns an
+	// error, the restore fails with it.
+	Restore func() error
+}
+
+// SetFSMHooks sets hooks intercepting the calls made by raft to the FSM of the
+// server with the given ID, replacing any hooks previously set. Passing zero
+// FSMHooks removes them.
+//
+// For example, an Apply hook sleeping for a long time once n reaches a
+// certain value makes the FSM of a follower pathologically slow from then on.
+func (c *Control) SetFSMHooks(id raft.ServerID, hooks FSMHooks) {
+	c.t.Helper()
+
+	c.node(id)
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: server %s: set FSM hooks", id))
+	c.watcher.SetHooks(id, fsms.Hooks(hooks))
+}
+
+// ErrLogStore is the error returned by log store writes failing because of
+// LogStoreErrors().
+var ErrLogStore = stores.ErrFault
+
+// LogStoreErrors makes StoreLog(), StoreLogs() and DeleteRange() on the log
+// store of the server with the given ID return ErrLogStore, once the given
+// number of further writes have succeeded. A negative number disables the
+// fault.
+//
+// It's meant to simulate disk write failures. A follower keeps rejecting the
+// entries sent by the leader for as long as the fault is in place. A leader
+// steps down as soon as one of its writes fails, and gets cut off from its
+// peers so it can't win an election again: once the fault is disabled, it's
+// elected again, as with Heal().
+func (c *Control) LogStoreErrors(id raft.ServerID, after int) {
+	c.t.Helper()
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: server %s: log store errors after %d writes", id, after))
+	c.stores.FailAfter(id, after)
+
+	if c.term == nil || c.term.id != id {
+		return
+	}
+	leadership := c.term.leadership
+	if after >= 0 {
+		go c.deposeUponLoss(id, leadership)
+		return
+	}
+	select {
+	case <-leadership.Lost():
+		c.Elect(id)
+	default:
+	}
+}
+
+// Cut the server with the given ID off from its peers as soon as it loses the
+// given leadership on its own, so it can't get elected again until the test
+// asks for it.
+func (c *Control) deposeUponLoss(id raft.ServerID, leadership *election.Leadership) {
+	select {
+	case <-leadership.Lost():
+	case <-c.stopCh:
+		return
+	}
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: leadership lost on its own", id))
+	c.network.Deposing(id)
+}
+
+// ErrStableStore is the error returned by stable store writes failing because
+// of StableStoreErrors().
+var ErrStableStore = stores.ErrStableFault
+
+// StableStoreErrors makes Set() and SetUint64() on the stable store of the
+// server with the given ID return ErrStableStore, once the given number of
+// further writes have succeeded. If any key is given, only writes of those
+// keys are affected. A negative number disables the fault.
+//
+// It's meant to test persistence failures during vote and term updates. Note
+// that raft panics if it fails to persist a new current term, so failing
+// writes of the "CurrentTerm" key takes the whole test down: to exercise vote
+// persistence only, pass the "LastVoteTerm" and "LastVoteCand" keys.
+func (c *Control) StableStoreErrors(id raft.ServerID, after int, keys ...string) {
+	c.t.Helper()
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: server %s: stable store errors after %d writes", id, after))
+	c.stores.FailStableAfter(id, after, keys...)
 }
 
 // Commands returns the total number of command logs applied by the FSM of the
@@ -218,12 +755,123 @@ func (c *Control) Snapshots(id raft.ServerID) uint64 {
 	return c.watcher.Snapshots(id)
 }
 
+// SnapshotStall returns the longest time for which applies on the FSM of the
+// server with the given ID were stalled by a snapshot, either because raft
+// was waiting for the FSM's Snapshot() method to return, or because a single
+// apply took that long while the snapshot was being persisted.
+func (c *Control) SnapshotStall(id raft.ServerID) time.Duration {
+	return c.watcher.SnapshotStall(id)
+}
+
 // Restores returns the total number of restores performed by the FSM of the
 // server with the given ID.
 func (c *Control) Restores(id raft.ServerID) uint64 {
 	return c.watcher.Restores(id)
 }
 
+// Logs returns all entries logged so far by the raft instance of the server
+// with the given ID, in order. Entries are captured even if the DiscardLogger
+// option is used, but not if a custom logger is set with the Config option.
+// See LogEntries() for parsed entries that can be filtered.
+func (c *Control) Logs(id raft.ServerID) []string {
+	c.t.Helper()
+	return c.node(id).Capture.Lines()
+}
+
+// LogsMatching returns the entries logged by the raft instance of the server
+// with the given ID that match the given regular expression.
+func (c *Control) LogsMatching(id raft.ServerID, re *regexp.Regexp) []string {
+	c.t.Helper()
+
+	lines := make([]string, 0)
+	for _, line := range c.Logs(id) {
+		if re.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// ForbidLogPattern makes the test fail if any server emits a log entry matching
+// the given regular expression from now on, e.g. to turn a noisy raft warning
+// into a regression check. The check is performed when the cluster is closed.
+func (c *Control) ForbidLogPattern(re *regexp.Regexp) {
+	c.t.Helper()
+
+	offsets := make(map[raft.ServerID]int)
+	for id := range c.servers {
+		offsets[id] = len(c.Logs(id))
+	}
+	c.forbidden = append(c.forbidden, forbiddenPattern{re: re, offsets: offsets})
+}
+
+// LeaderChanges returns the number of times any server has acquired
+// leadership since the cluster was created, including the first election.
+func (c *Control) LeaderChanges() uint64 {
+	return c.election.Acquisitions()
+}
+
+// AssertLeaderChangesAtMost makes the test fail if the total number of leader
+// changes over the life of the cluster exceeds the given budget. The check is
+// performed when the cluster is closed, see LeaderChanges().
+//
+// It's meant to guard against regressions causing election churn.
+func (c *Control) AssertLeaderChangesAtMost(n uint64) {
+	c.maxLeaderChanges = &n
+}
+
+// AssertSnapshotStallAtMost makes the test fail if snapshots stalled applies
+// on any server for longer than the given bound. The check is performed when
+// the cluster is closed, see SnapshotStall().
+//
+// It's meant to validate that the FSM's snapshot implementation is
+// sufficiently non-blocking, i.e. that Snapshot() returns quickly and that
+// persisting the snapshot doesn't hold locks that Apply() needs.
+func (c *Control) AssertSnapshotStallAtMost(bound time.Duration) {
+	c.maxSnapshotStall = &bound
+}
+
+// Fail the test if applies were stalled by snapshots for longer than the bound
+// set with AssertSnapshotStallAtMost().
+func (c *Control) checkSnapshotStall() {
+	if c.maxSnapshotStall == nil {
+		return
+	}
+	for _, node := range c.nodes {
+		id := node.Conf.LocalID
+		if stall := c.SnapshotStall(id); stall > *c.maxSnapshotStall {
+			c.t.Errorf("raft-test: close: server %s: applies stalled by snapshot for %s, expected at most %s", id, stall, *c.maxSnapshotStall)
+		}
+	}
+}
+
+// Fail the test if the leader changes budget set with
+// AssertLeaderChangesAtMost() was exceeded.
+func (c *Control) checkLeaderChanges() {
+	if c.maxLeaderChanges == nil {
+		return
+	}
+	if changes := c.LeaderChanges(); changes > *c.maxLeaderChanges {
+		c.t.Errorf("raft-test: close: %d leader changes, expected at most %d", changes, *c.maxLeaderChanges)
+	}
+}
+
+// Fail the test if any server emitted a log entry matching a forbidden
+// pattern.
+func (c *Control) checkForbiddenLogs() {
+	c.t.Helper()
+
+	for _, pattern := range c.forbidden {
+		for id, offset := range pattern.offsets {
+			for _, line := range c.Logs(id)[offset:] {
+				if pattern.re.MatchString(line) {
+					c.t.Errorf("raft-test: close: server %s: emitted forbidden log entry matching %q: %s", id, pattern.re, line)
+				}
+			}
+		}
+	}
+}
+
 // Shutdown all raft nodes and fail the test if any of them errors out while
 // doing so.
 func (c *Control) shutdownServers() {
@@ -261,6 +909,7 @@ func (c *Control) shutdownServers() {
 func (c *Control) shutdownServer(id raft.ServerID) {
 	r := c.servers[id]
 	future := r.Shutdown()
+	c.uptime.Stop(id, false)
 
 	// Expect the shutdown to happen within two seconds by default.
 	timeout := Duration(2 * time.Second)
@@ -284,13 +933,139 @@ func (c *Control) shutdownServer(id raft.ServerID) {
 
 	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: close: server %s: shutdown failed: %s", id, err))
 
-	buf := make([]byte, 1<<16)
-	n := runtime.Stack(buf, true)
-
-	c.t.Errorf("\n\t%s", buf[:n])
+	c.t.Errorf("\n\t%s", c.stacks())
 	c.t.Fatalf("raft-test: close: error: server %s: shutdown error: %v", id, err)
 }
 
+// Return the ID of the server currently in the leader state, or an empty
+// string if there's none.
+func (c *Control) leader() raft.ServerID {
+	for id, r := range c.running() {
+		if r.State() == raft.Leader {
+			return id
+		}
+	}
+	return ""
+}
+
+// Return the raft instance of the server with the given ID, or nil if it's
+// not running. Safe to call from background goroutines.
+func (c *Control) server(id raft.ServerID) *raft.Raft {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.servers[id]
+}
+
+// DataDir returns the directory holding the on-disk stores of the server with
+// the given ID, or an empty string if it uses in-memory stores (see the Disk
+// option).
+func (c *Control) DataDir(id raft.ServerID) string {
+	c.t.Helper()
+	return c.node(id).Dir
+}
+
+// Running returns the servers that are currently running, including the ones
+// added with Add() or restarted with Restart(). The returned map is a copy.
+func (c *Control) Running() map[raft.ServerID]*raft.Raft {
+	return c.running()
+}
+
+// Return a copy of the map of running servers. Safe to call from background
+// goroutines.
+func (c *Control) running() map[raft.ServerID]*raft.Raft {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	servers := make(map[raft.ServerID]*raft.Raft, len(c.servers))
+	for id, r := range c.servers {
+		servers[id] = r
+	}
+	return servers
+}
+
+// Return a copy of the dependencies of all servers, including stopped ones.
+// Safe to call from background goroutines.
+func (c *Control) allNodes() []*dependencies {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nodes := make([]*dependencies, len(c.nodes))
+	copy(nodes, c.nodes)
+	return nodes
+}
+
+// Add a newly started server to the running ones.
+func (c *Control) addServer(id raft.ServerID, r *raft.Raft, conf *raft.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.servers[id] = r
+	c.confs[id] = conf
+}
+
+// Remove a stopped server from the running ones.
+func (c *Control) dropServer(id raft.ServerID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.servers, id)
+	delete(c.confs, id)
+}
+
+// Return the latest configuration of the server with the given ID.
+func (c *Control) configuration(id raft.ServerID) raft.Configuration {
+	c.t.Helper()
+
+	future := c.servers[id].GetConfiguration()
+	if err := c.await(future, "server %s: get configuration", id); err != nil {
+		c.t.Fatalf("raft-test: control: server %s: failed to get configuration: %v", id, err)
+	}
+	return future.Configuration()
+}
+
+// Close the transports created with the Transports option.
+func (c *Control) closeTransports() {
+	for _, node := range c.nodes {
+		c.closeTransport(node)
+	}
+}
+
+// Close the transport of the given server, if it was created with the
+// Transports option.
+func (c *Control) closeTransport(node *dependencies) {
+	if node.NewTransport == nil {
+		return
+	}
+	id := node.Conf.LocalID
+	closer, ok := c.network.Underlying(id).(raft.WithClose)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: close: server %s: failed to close transport: %v", id, err))
+	}
+}
+
+// Close on-disk stores and remove their temporary directories.
+func (c *Control) removeData() {
+	for _, node := range c.nodes {
+		c.removeNodeData(node)
+	}
+}
+
+// Close the on-disk stores of the given server, if any, and remove its
+// temporary directory.
+func (c *Control) removeNodeData(node *dependencies) {
+	if node.Dir == "" {
+		return
+	}
+	id := node.Conf.LocalID
+	if closer, ok := node.Logs.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.t.Errorf("raft-test: close: server %s: failed to close store: %v", id, err)
+		}
+	}
+	if err := os.RemoveAll(node.Dir); err != nil {
+		c.t.Errorf("raft-test: close: server %s: failed to remove data dir: %v", id, err)
+	}
+}
+
 // Wait for the given server to acquire leadership. Returns true on success,
 // false otherwise (i.e. if the timeout expires).
 func (c *Control) waitLeadershipAcquired(id raft.ServerID) *election.Leadership {
@@ -335,7 +1110,7 @@ func (c *Control) waitLeadershipPropagated(id raft.ServerID, leadership *electio
 	// excluded with the Servers option).
 	r := c.servers[id]
 	future := r.GetConfiguration()
-	if err := future.Error(); err != nil {
+	if err := c.await(future, "server %s: get configuration", id); err != nil {
 		c.t.Fatalf("raft-test: control: server %s: failed to get configuration: %v", id, err)
 	}
 	servers := future.Configuration().Servers
@@ -347,7 +1122,11 @@ func (c *Control) waitLeadershipPropagated(id raft.ServerID, leadership *electio
 		if other == id {
 			continue
 		}
-		r := c.servers[server.ID]
+		r, ok := c.servers[server.ID]
+		if !ok {
+			// The server was killed, see Kill().
+			continue
+		}
 		for {
 			// Check that we didn't lose leadership in the meantime.
 			select {
@@ -394,37 +1173,26 @@ func (c *Control) whenCommandCommitted(id raft.ServerID, n uint64) *event.Event
 	return c.watcher.WhenApplied(id, n)
 }
 
-// Depose the server with the given ID when the given event fires.
-func (c *Control) deposeUponEvent(event *event.Event, id raft.ServerID, leadership *election.Leadership) {
-	// Sanity checks.
-	r := c.servers[id]
-	if r.State() != raft.Leader {
-		panic(fmt.Errorf("raft-test: server %s: is not leader", id))
-	}
-
+// Depose the server with the given ID when the given event fires, and send
+// the outcome to the given channel. It runs in its own goroutine, so the
+// outcome is reported and the term cleared by the test goroutine receiving it.
+func (c *Control) deposeUponEvent(event *event.Event, id raft.ServerID, leadership *election.Leadership, timeout time.Duration, done chan<- error) {
 	<-event.Watch()
 
 	c.network.Deposing(id)
 
-	timeout := maximumLeaderLeaseTimeout(c.confs)
-
 	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: node %s: state: wait leadership lost (timeout=%s)", id, timeout))
 
+	var err error
 	select {
 	case <-leadership.Lost():
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: leadership lost", id))
 	case <-time.After(timeout):
-		c.t.Errorf("raft-test: server %s: error: timeout: leadership not lost", id)
-		c.errored = true
+		err = fmt.Errorf("server %s: error: timeout: leadership not lost", id)
 	}
 	event.Ack()
 
-	if !c.errored {
-		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: leadership lost", id))
-	}
-
-	c.deposing <- struct{}{}
-	c.deposing = nil
-	c.term = nil
+	done <- err
 }
 
 // Take a snapshot on the server with the given ID when the given event fires.
@@ -433,12 +1201,37 @@ func (c *Control) snapshotUponEvent(event *event.Event, id raft.ServerID) {
 
 	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: control: take snapshot", id))
 
-	r := c.servers[id]
+	r := c.server(id)
 	c.snapshotFuture = r.Snapshot()
 
 	event.Ack()
 }
 
+// Take a snapshot on the server with the given ID every time the given channel
+// is notified, until the cluster is closed. See ForceSnapshotAfter().
+//
+// The raft instance is looked up each time, so servers that get restarted keep
+// being covered. Servers that are not running are skipped.
+func (c *Control) snapshotEvery(id raft.ServerID, ch <-chan struct{}) {
+	for {
+		select {
+		case <-ch:
+		case <-c.stopCh:
+			return
+		}
+		r := c.server(id)
+		if r == nil {
+			continue
+		}
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: control: force snapshot", id))
+		switch err := r.Snapshot().Error(); err {
+		case nil, raft.ErrNothingNewToSnapshot, raft.ErrRaftShutdown:
+		default:
+			c.t.Errorf("raft-test: server %s: forced snapshot failed: %v", id, err)
+		}
+	}
+}
+
 // Compute the maximum time a leader election should take, according to the
 // given nodes configs.
 func maximumElectionTimeout(confs map[raft.ServerID]*raft.Config) time.Duration {
@@ -479,26 +1272,47 @@ const (
 // WaitLeader blocks until the given raft instance sets a leader (which
 // could possibly be the instance itself).
 //
-// It fails the test if this doesn't happen within the specified timeout.
-func WaitLeader(t testing.TB, raft *raft.Raft, timeout time.Duration) {
+// It fails the test if this doesn't happen within the specified timeout. If the
+// timeout is zero, the default one is used, see Duration().
+func WaitLeader(t Reporter, raft *raft.Raft, timeout time.Duration) {
+	timeout = waitTimeout(t, timeout)
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	waitLeader(ctx, t, raft)
 }
 
-func waitLeader(ctx context.Context, t testing.TB, raft *raft.Raft) {
+// WaitLeaderCtx is like WaitLeader, but it's bound to the given context
+// instead of a timeout.
+//
+// It fails the test if the context deadline expires, while it returns
+// context.Canceled if the context gets canceled first. If the context has no
+// deadline, the one of WaitLeader with a zero timeout applies.
+func WaitLeaderCtx(ctx context.Context, t Reporter, raft *raft.Raft) error {
+	t.Helper()
+
+	ctx, cancel := waitContext(ctx, t)
+	defer cancel()
+
+	return waitLeader(ctx, t, raft)
+}
+
+func waitLeader(ctx context.Context, t Reporter, r *raft.Raft) error {
 	t.Helper()
 
 	check := func() bool {
-		return raft.Leader() != ""
+		return r.Leader() != ""
 	}
-	wait(ctx, t, check, 25*time.Millisecond, "no leader was set")
+	rafts := map[string]*raft.Raft{"raft instance": r}
+	return wait(ctx, t, check, 25*time.Millisecond, rafts, "no leader was set")
 }
 
 // Poll the given function at the given internval, until it returns true, or
-// the given context expires.
-func wait(ctx context.Context, t testing.TB, f func() bool, interval time.Duration, message string) {
+// the given context expires. On timeout, the goroutine stacks of the given
+// raft instances are logged. If the context gets canceled, its error is
+// returned.
+func wait(ctx context.Context, t Reporter, f func() bool, interval time.Duration, rafts map[string]*raft.Raft, message string) error {
 	t.Helper()
 
 	start := time.Now()
@@ -506,13 +1320,14 @@ func wait(ctx context.Context, t testing.TB, f func() bool, interval time.Durati
 		select {
 		case <-ctx.Done():
 			if err := ctx.Err(); err == context.Canceled {
-				return
+				return err
 			}
+			t.Errorf("\n\t%s", goroutineStacks(rafts))
 			t.Fatalf("%s within %s", message, time.Since(start))
 		default:
 		}
 		if f() {
-			return
+			return nil
 		}
 		time.Sleep(interval)
 	}
@@ -15,7 +15,13 @@
 package rafttest_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,6 +46,32 @@ func TestControl_ElectAndDepose(t *testing.T) {
 	assert.NotEqual(t, raft.Leader, r.State())
 }
 
+// Depose a leader given its raft instance, waiting for it to step down.
+func TestControl_DeposeLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	control.DeposeLeader(r, time.Second)
+	assert.NotEqual(t, raft.Leader, r.State())
+
+	control.Elect("1")
+	require.NoError(t, rafts["1"].Apply([]byte{}, time.Second).Error())
+}
+
+// Any server can be elected, and its raft instance is returned by the term.
+func TestControl_ElectLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	r := control.Elect("2").Leader()
+	assert.Equal(t, rafts["2"], r)
+	assert.Equal(t, raft.Leader, r.State())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+}
+
 // Depose a previously elected leader after a certain command log gets
 // enqueued.
 func TestControl_DeposeAfterCommandEnqueued(t *testing.T) {
@@ -237,3 +269,731 @@ func TestControl_RestoreAfterDisconnection(t *testing.T) {
 	assert.Equal(t, uint64(6), control.Commands("1"))
 	assert.Equal(t, uint64(6), control.Commands("2"))
 }
+
+// Leadership does not change while applying a command log.
+func TestControl_AssertSameLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	control.AssertSameLeader(func() {
+		err := r.Apply([]byte{}, time.Second).Error()
+		require.NoError(t, err)
+	})
+}
+
+// A leader deposed while the function runs fails the assertion.
+func TestControl_AssertSameLeader_Deposed(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Panics(t, func() { control.AssertSameLeader(control.Depose) })
+	assert.Contains(t, buffer.String(), "assert same leader: leader changed from server 0")
+}
+
+// Without a leader the function is not run at all.
+func TestControl_AssertSameLeader_NoLeader(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	called := false
+	assert.Panics(t, func() { control.AssertSameLeader(func() { called = true }) })
+	assert.False(t, called)
+	assert.Contains(t, buffer.String(), "assert same leader: no leader before running function")
+}
+
+// A server removed from the configuration doesn't receive any further command
+// log.
+func TestControl_AssertNoWritesAfterRemoval(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	require.NoError(t, r.RemoveServer("2", 0, time.Second).Error())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	control.AssertNoWritesAfterRemoval("2")
+
+	assert.Equal(t, uint64(1), control.Commands("2"))
+}
+
+// If no timeout is given, WaitLeader infers it from the test deadline.
+func TestWaitLeader_InferTimeout(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	rafttest.WaitLeader(t, rafts["1"], 0)
+	assert.Equal(t, raft.ServerAddress("0"), rafts["1"].Leader())
+}
+
+// Writes to the leader's log store can be slowed down.
+func TestControl_SlowLeaderDisk(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.SlowLeaderDisk(10 * time.Millisecond)
+
+	r := rafts["0"]
+	start := time.Now()
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+
+	control.SlowLeaderDisk(0)
+}
+
+// The staleness of followers can be sampled while applying command logs.
+func TestControl_SampleStaleness(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	sampler := control.SampleStaleness(time.Millisecond)
+
+	// Server 2 misses all logs until the partition is healed.
+	control.Partition([]*raft.Raft{rafts["0"], rafts["1"]}, []*raft.Raft{rafts["2"]})
+
+	r := rafts["0"]
+	for i := 0; i < 10; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	control.Heal()
+	control.Barrier()
+
+	reports := sampler.Stop()
+	for _, id := range []raft.ServerID{"1", "2"} {
+		report, ok := reports[id]
+		require.True(t, ok)
+		assert.True(t, report.Samples > 0)
+		assert.True(t, report.EntriesP50 <= report.EntriesMax)
+		assert.True(t, report.TimeP99 <= report.TimeMax)
+	}
+
+	assert.True(t, reports["1"].EntriesMax < 10, reports["1"].String())
+
+	report := reports["2"]
+	assert.True(t, report.EntriesMax >= 10, report.String())
+	assert.True(t, report.TimeMax > 0, report.String())
+}
+
+// The latency of applied command logs can be broken down into phases.
+func TestControl_LatencyBreakdown(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithApplyDelay(time.Millisecond).Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.SlowLeaderDisk(time.Millisecond)
+
+	r := rafts["0"]
+	for i := 0; i < 5; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+
+	breakdown := control.LatencyBreakdown()
+	assert.Equal(t, 5, breakdown.Apply.Count)
+	assert.Equal(t, 5, breakdown.Append.Count)
+	assert.True(t, breakdown.Append.Max >= time.Millisecond)
+	assert.True(t, breakdown.Apply.Max >= time.Millisecond)
+}
+
+// Only the command logs appended during the current term are included in the
+// latency breakdown.
+func TestControl_LatencyBreakdown_Term(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	assert.Equal(t, 3, control.LatencyBreakdown().Apply.Count)
+
+	control.Depose()
+	control.Elect("1")
+	control.Depose()
+	control.Elect("0")
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	assert.Equal(t, 2, control.LatencyBreakdown().Apply.Count)
+}
+
+// When only heartbeats are delivered, the leader keeps its leadership but
+// can't commit any command log.
+func TestControl_HeartbeatOnly(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.HeartbeatOnly(true)
+
+	r := rafts["0"]
+	future := r.Apply([]byte{}, time.Second)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, raft.Leader, r.State())
+	assert.Equal(t, uint64(0), control.Commands("0"))
+
+	control.HeartbeatOnly(false)
+
+	require.NoError(t, future.Error())
+	assert.Equal(t, uint64(1), control.Commands("0"))
+}
+
+// A single link can deliver only heartbeats, starving one follower while the
+// rest of the cluster makes progress.
+func TestControl_HeartbeatOnlyLink(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.HeartbeatOnlyLink("0", "1", true)
+	assert.Contains(t, control.String(), "up,heartbeat-only")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.WaitIndex("2", r.AppliedIndex(), 0)
+	assert.Equal(t, uint64(0), control.Commands("1"))
+
+	control.HeartbeatOnlyLink("0", "1", false)
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("1"))
+}
+
+// The raft log output of each server is captured.
+func TestControl_Logs(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.NotEmpty(t, control.Logs("1"))
+	assert.Len(t, control.LogsMatching("0", regexp.MustCompile("entering Leader state")), 1)
+	assert.Empty(t, control.LogsMatching("1", regexp.MustCompile("entering Leader state")))
+}
+
+// Log entries matching a forbidden pattern make the test fail.
+func TestControl_ForbidLogPattern(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.ForbidLogPattern(regexp.MustCompile("rejecting vote"))
+
+	control.Elect("0")
+}
+
+// A forbidden log entry emitted after the pattern was set fails the test when
+// the cluster is closed.
+func TestControl_ForbidLogPattern_Emitted(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+
+	control.ForbidLogPattern(regexp.MustCompile("entering Leader state"))
+
+	control.Elect("0")
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: server 0: emitted forbidden log entry matching \"entering Leader state\"")
+}
+
+// Leader changes are counted over the life of the cluster.
+func TestControl_LeaderChanges(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.AssertLeaderChangesAtMost(2)
+
+	control.Elect("0")
+	control.Depose()
+	control.Elect("1")
+
+	assert.Equal(t, uint64(2), control.LeaderChanges())
+}
+
+// Exceeding the leader changes budget makes the test fail at close.
+func TestControl_AssertLeaderChangesAtMost(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+
+	_, control := rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.DiscardLogger())
+	control.AssertLeaderChangesAtMost(1)
+
+	control.Elect("0")
+	control.Depose()
+	control.Elect("1")
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "2 leader changes, expected at most 1")
+}
+
+// Snapshots stalling applies for longer than the bound make the test fail at
+// close.
+func TestControl_AssertSnapshotStallAtMost(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+
+	fsms := []raft.FSM{&slowSnapshotFSM{FSM: rafttest.FSM(), delay: 30 * time.Millisecond}, rafttest.FSM(), rafttest.FSM()}
+	rafts, control := rafttest.Cluster(reporter, fsms, rafttest.Latency(10.0), rafttest.DiscardLogger())
+	control.AssertSnapshotStallAtMost(10 * time.Millisecond)
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	require.NoError(t, rafts["0"].Snapshot().Error())
+
+	assert.True(t, control.SnapshotStall("0") >= 30*time.Millisecond)
+	assert.Equal(t, time.Duration(0), control.SnapshotStall("1"))
+
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "server 0: applies stalled by snapshot for")
+	assert.NotContains(t, buffer.String(), "server 1:")
+}
+
+// FSM whose Snapshot() method takes a while.
+type slowSnapshotFSM struct {
+	raft.FSM
+	delay time.Duration
+}
+
+func (f *slowSnapshotFSM) Snapshot() (raft.FSMSnapshot, error) {
+	time.Sleep(f.delay)
+	return f.FSM.Snapshot()
+}
+
+// The cluster state can be rendered as a table.
+func TestControl_String(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	term := control.Elect("0")
+	term.Disconnect("1")
+
+	s := control.String()
+	t.Logf("cluster:\n%s", s)
+
+	lines := strings.Split(s, "\n")
+	require.Len(t, lines, 9)
+	assert.Contains(t, lines[1], "Leader")
+	assert.Contains(t, lines[2], "Follower")
+	assert.Equal(t, []string{"0", "-", "down", "up"}, strings.Fields(lines[5]))
+	assert.Equal(t, "faults: server 1 disconnected", lines[8])
+
+	term.Reconnect("1")
+	assert.Contains(t, control.String(), "faults: none")
+}
+
+// Killed servers are still rendered.
+func TestControl_String_Killed(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.Kill(rafts["2"])
+
+	lines := strings.Split(control.String(), "\n")
+	require.Len(t, lines, 9)
+	assert.Equal(t, "Down", strings.Fields(lines[3])[1])
+	assert.Equal(t, []string{"links", "0", "1", "2"}, strings.Fields(lines[4]))
+}
+
+// Wait for a server to apply a certain index.
+func TestControl_WaitIndex(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+
+	control.WaitIndex("1", future.Index(), 0)
+	assert.Equal(t, uint64(1), control.Commands("1"))
+}
+
+// If an index is not applied in time, the failure includes the state of the
+// cluster and the most recent RPCs.
+func TestControl_WaitIndex_Diagnostics(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.Trace(), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Panics(t, func() { control.WaitIndex("1", 100, 50*time.Millisecond) })
+	assert.Contains(t, buffer.String(), "wait index: server 1: index 100 not applied within")
+	assert.Contains(t, buffer.String(), "server  state")
+	assert.Contains(t, buffer.String(), "faults: none")
+	assert.Contains(t, buffer.String(), "recent RPCs:")
+	assert.Contains(t, buffer.String(), "append entries")
+}
+
+// Waiting for an index on a server that was killed fails right away.
+func TestControl_WaitIndex_Killed(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.Kill(rafts["2"])
+
+	assert.Panics(t, func() { control.WaitIndex("2", 100, 0) })
+	assert.Contains(t, buffer.String(), "wait: server 2 is shut down")
+
+	buffer.Reset()
+	assert.Panics(t, func() { control.WaitIndexCtx(context.Background(), "2", 100) })
+	assert.Contains(t, buffer.String(), "wait index: server 2 is shut down")
+}
+
+// Wait for a leader acknowledged by all other servers.
+func TestControl_WaitStableLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("1")
+
+	assert.Equal(t, rafts["1"], control.WaitStableLeader(0))
+	for _, id := range []raft.ServerID{"0", "2"} {
+		assert.Equal(t, raft.ServerAddress("1"), rafts[id].Leader())
+	}
+}
+
+// If there's no leader, waiting for a stable one fails.
+func TestControl_WaitStableLeader_Timeout(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	assert.Panics(t, func() { control.WaitStableLeader(50 * time.Millisecond) })
+	assert.Contains(t, buffer.String(), "wait stable leader: no stable leader within")
+	assert.Contains(t, buffer.String(), "recent RPCs: not traced")
+}
+
+// Waits can be bound to a context and canceled.
+func TestControl_WaitIndexCtx(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	future := rafts["0"].Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, control.WaitIndexCtx(ctx, "1", future.Index()))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, control.WaitIndexCtx(ctx, "1", 100))
+}
+
+// If the context deadline expires, waiting fails.
+func TestControl_WaitStableLeaderCtx_Deadline(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Panics(t, func() { control.WaitStableLeaderCtx(ctx) })
+	assert.Contains(t, buffer.String(), "wait stable leader: no stable leader within")
+}
+
+// The default timeout of waits is scaled according to GO_RAFT_TEST_LATENCY,
+// while explicit timeouts are used as given.
+func TestControl_WaitStableLeader_LatencyEnv(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	restore := setEnv("GO_RAFT_TEST_LATENCY", "0.1")
+	start := time.Now()
+	assert.Panics(t, func() { control.WaitStableLeader(0) })
+	elapsed := time.Since(start)
+	restore()
+	assert.True(t, elapsed >= 500*time.Millisecond)
+	assert.True(t, elapsed < 5*time.Second)
+
+	restore = setEnv("GO_RAFT_TEST_LATENCY", "10.0")
+	start = time.Now()
+	assert.Panics(t, func() { control.WaitStableLeader(20 * time.Millisecond) })
+	elapsed = time.Since(start)
+	restore()
+	assert.True(t, elapsed < 200*time.Millisecond)
+}
+
+// Set the given environment variable, returning a function that restores its
+// previous value.
+func setEnv(name, value string) func() {
+	env, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, env)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// Wait for a server to restore a snapshot shipped by the leader.
+func TestControl_WaitRestore(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	term := control.Elect("0")
+	term.When().Command(4).Committed().Snapshot()
+
+	r := rafts["0"]
+	for i := 0; i < 6; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		if i == 0 {
+			term.Disconnect("1")
+		}
+		if i == 4 {
+			term.Reconnect("1")
+		}
+	}
+
+	restore := control.WaitRestore("1", 0)
+	assert.Equal(t, rafttest.RestoreInstall, restore.Source)
+	assert.Equal(t, raft.ServerID("0"), restore.Leader)
+	assert.True(t, restore.Index >= 5)
+	assert.Equal(t, r.Stats()["term"], strconv.FormatUint(restore.Term, 10))
+}
+
+// Progress-aware waits keep waiting as long as the server makes progress.
+func TestControl_SetStallTimeout(t *testing.T) {
+	// Only the FSM of the server being waited for is slow.
+	fsms := rafttest.FSMs(3)
+	fsms[1] = rafttest.NewFSMs(1).WithApplyDelay(2 * time.Millisecond).Build()[0]
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.SetStallTimeout(100 * time.Millisecond)
+
+	r := rafts["0"]
+	var future raft.ApplyFuture
+	for i := 0; i < 10; i++ {
+		future = r.Apply([]byte{}, time.Second)
+	}
+	require.NoError(t, future.Error())
+
+	control.WaitIndex("1", future.Index(), 10*time.Millisecond)
+	assert.True(t, rafts["1"].AppliedIndex() >= future.Index())
+}
+
+// Configuration entries are applied in the same order on all servers.
+func TestControl_AssertConfigOrdering(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	require.NoError(t, r.RemoveServer("2", 0, time.Second).Error())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	control.AssertConfigOrdering()
+}
+
+// Uptime and partition times of each server are tracked.
+func TestControl_DowntimeReport(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	term := control.Elect("0")
+
+	term.Disconnect("1")
+	time.Sleep(20 * time.Millisecond)
+	term.Reconnect("1")
+
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+
+	assert.True(t, control.Uptime("0") > 0)
+
+	report := control.DowntimeReport()
+	require.Len(t, report, 3)
+	assert.Equal(t, 1, report["1"].Starts)
+	assert.True(t, report["1"].Partitioned >= 20*time.Millisecond)
+	assert.Equal(t, time.Duration(0), report["2"].Partitioned)
+	assert.True(t, report["0"].Stopped.IsZero())
+}
+
+// Handing committed command logs to an FSM can be throttled.
+func TestControl_SlowApplyChannel(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.SlowApplyChannel("1", 20*time.Millisecond)
+
+	r := rafts["0"]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	assert.True(t, control.Commands("1") < 3)
+
+	control.Barrier()
+	assert.Equal(t, uint64(3), control.Commands("1"))
+
+	control.SlowApplyChannel("1", 0)
+}
+
+// Calls to the FSM of a server can be intercepted to inject faults.
+func TestControl_SetFSMHooks(t *testing.T) {
+	fsms := rafttest.KVFSMs(3)
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	errApply := errors.New("apply failed")
+	errSnapshot := errors.New("snapshot failed")
+	control.SetFSMHooks("0", rafttest.FSMHooks{
+		Apply: func(n uint64, log *raft.Log) interface{} {
+			if n == 2 {
+				return errApply
+			}
+			return nil
+		},
+		Snapshot: func() error { return errSnapshot },
+	})
+
+	r := rafts["0"]
+	future := r.Apply(rafttest.KVSet("a", "1"), time.Second)
+	require.NoError(t, future.Error())
+	assert.Nil(t, future.Response())
+
+	future = r.Apply(rafttest.KVSet("b", "2"), time.Second)
+	require.NoError(t, future.Error())
+	assert.Equal(t, errApply, future.Response())
+
+	control.Barrier()
+
+	_, ok := fsms[0].(*rafttest.KVFSM).Get("b")
+	assert.False(t, ok)
+	_, ok = fsms[1].(*rafttest.KVFSM).Get("b")
+	assert.True(t, ok)
+
+	// Skipped logs are not counted.
+	assert.Equal(t, uint64(1), control.Commands("0"))
+	assert.Equal(t, uint64(2), control.Commands("1"))
+
+	assert.Error(t, control.Snapshot(r))
+
+	control.SetFSMHooks("0", rafttest.FSMHooks{})
+	assert.NoError(t, control.Snapshot(r))
+}
+
+// Panics in FSM hooks are recovered and reported when the cluster is closed.
+func TestControl_SetFSMHooks_Panic(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.KVFSMs(3), rafttest.DiscardLogger())
+
+	control.Elect("0")
+
+	control.SetFSMHooks("0", rafttest.FSMHooks{
+		Apply: func(n uint64, log *raft.Log) interface{} {
+			panic("boom")
+		},
+	})
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply(rafttest.KVSet("a", "1"), time.Second).Error())
+	assert.Equal(t, uint64(1), control.Commands("0"))
+
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: fsm: server 0: apply hook for log ")
+	assert.Contains(t, buffer.String(), "panicked: boom")
+}
+
+// A follower whose log store fails to write doesn't apply new commands, until
+// the fault is removed.
+func TestControl_LogStoreErrors(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.LogStoreErrors("2", 0)
+
+	r := rafts["0"]
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(0), control.Commands("2"))
+
+	control.LogStoreErrors("2", -1)
+	control.Barrier()
+	assert.Equal(t, uint64(2), control.Commands("2"))
+}
+
+// A server whose stable store fails to persist votes can't vote, not even for
+// itself.
+func TestControl_StableStoreErrors(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.StableStoreErrors("1", 0, "LastVoteTerm", "LastVoteCand")
+	control.Depose()
+
+	// Without a leader, server 1 eventually tries to vote for itself.
+	re := regexp.MustCompile("Failed to persist vote.*" + rafttest.ErrStableStore.Error())
+	persistFailed := func() bool { return len(control.LogsMatching("1", re)) > 0 }
+	assert.Eventually(t, persistFailed, time.Second, time.Millisecond)
+
+	// Once the fault is removed, elections work again.
+	assert.NotEqual(t, rafttest.ErrLogStore, rafttest.ErrStableStore)
+
+	control.StableStoreErrors("1", -1)
+	control.Elect("2")
+	require.NoError(t, rafts["2"].Apply([]byte{}, time.Second).Error())
+}
+
+// A leader whose log store fails to write steps down, and gets elected again
+// once the fault is removed.
+func TestControl_LogStoreErrors_Leader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	control.LogStoreErrors("0", 0)
+	assert.Error(t, r.Apply([]byte{}, time.Second).Error())
+
+	stepped := func() bool { return r.State() != raft.Leader }
+	assert.Eventually(t, stepped, time.Second, time.Millisecond)
+
+	control.LogStoreErrors("0", -1)
+	assert.Equal(t, raft.Leader, r.State())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(2), control.Commands("1"))
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/raft-test/internal/network"
+	"github.com/hashicorp/raft"
+)
+
+// RPCType identifies a type of raft RPC, see DropRPCs() and Trace().
+type RPCType int
+
+// Available RPC types.
+const (
+	// AppendEntries RPCs, including heartbeats.
+	RPCAppendEntries RPCType = iota
+
+	// RequestVote RPCs sent by candidates.
+	RPCRequestVote
+
+	// InstallSnapshot RPCs sent to followers lagging behind.
+	RPCInstallSnapshot
+)
+
+func (r RPCType) String() string {
+	switch r {
+	case RPCAppendEntries:
+		return "append entries"
+	case RPCRequestVote:
+		return "request vote"
+	case RPCInstallSnapshot:
+		return "install snapshot"
+	default:
+		return fmt.Sprintf("rpc %d", int(r))
+	}
+}
+
+// DropRPCs makes all links between servers drop the given fraction of RPCs,
+// which fail as if the target server was unreachable. If one or more RPC
+// types are given, only RPCs of those types are dropped, e.g. to reproduce
+// snapshot installs that keep failing while heartbeats succeed. A zero
+// fraction stops dropping RPCs.
+//
+// Dropping a large fraction of AppendEntries RPCs starves followers of
+// heartbeats, and is typically combined with the Latency option to keep them
+// from starting elections.
+//
+// RPCs are picked using a pseudo-random source whose seed can be set with the
+// FaultSeed option, and gets reported by Close() if the test fails.
+func (c *Control) DropRPCs(fraction float64, types ...RPCType) {
+	c.t.Helper()
+
+	c.checkFraction("drop rpcs", fraction)
+
+	if len(types) == 0 {
+		types = []RPCType{RPCAppendEntries, RPCRequestVote, RPCInstallSnapshot}
+	}
+
+	rpcs := network.RPC(0)
+	for _, t := range types {
+		switch t {
+		case RPCAppendEntries:
+			rpcs |= network.AppendEntries
+		case RPCRequestVote:
+			rpcs |= network.RequestVote
+		case RPCInstallSnapshot:
+			rpcs |= network.InstallSnapshot
+		default:
+			c.t.Fatalf("raft-test: drop rpcs: unknown %s", t)
+		}
+	}
+
+	c.usingRandomFaults(fraction > 0)
+	c.network.DropRPCs(fraction, rpcs)
+}
+
+// LimitRPCSize makes all links between servers reject RPCs carrying more than
+// the given number of bytes, as a network with a small MTU or a proxy with a
+// message size limit would. A zero size removes the limit.
+//
+// The size of an AppendEntries RPC is the total size of the data of its log
+// entries, so heartbeats always get through, and the one of an
+// InstallSnapshot RPC is the size of the snapshot. Raft doesn't shrink
+// batches that fail to be sent, so this is meant to check that settings such
+// as MaxAppendEntries keep messages small enough for the network.
+func (c *Control) LimitRPCSize(size int64) {
+	c.t.Helper()
+
+	if size < 0 {
+		c.t.Fatalf("raft-test: limit rpc size: invalid size %d", size)
+	}
+
+	c.network.SetMaxSize(size)
+}
+
+// DuplicateRPCs makes the link from the server with ID from to the server with
+// ID to deliver the given fraction of AppendEntries RPCs twice. The response
+// to the duplicate is discarded. A zero fraction stops duplicating RPCs.
+//
+// Raft is expected to tolerate duplicated messages: this is meant to check
+// that FSMs and transport-level assumptions do as well.
+func (c *Control) DuplicateRPCs(from, to raft.ServerID, fraction float64) {
+	c.t.Helper()
+	c.checkLink("duplicate rpcs", from, to)
+	c.checkFraction("duplicate rpcs", fraction)
+	c.usingRandomFaults(fraction > 0)
+	c.network.SetDuplicateRate(from, to, fraction)
+}
+
+// ReorderRPCs makes the link from the server with ID from to the server with
+// ID to hold back a copy of the given fraction of AppendEntries RPCs, and
+// deliver it again right after the next RPC, so the receiver sees an older
+// message after a newer one. The response to the stale copy is discarded. A
+// zero fraction stops reordering RPCs.
+func (c *Control) ReorderRPCs(from, to raft.ServerID, fraction float64) {
+	c.t.Helper()
+	c.checkLink("reorder rpcs", from, to)
+	c.checkFraction("reorder rpcs", fraction)
+	c.usingRandomFaults(fraction > 0)
+	c.network.SetReorderRate(from, to, fraction)
+}
+
+// Record that a probabilistic RPC fault is being injected, if enabled is true,
+// so the seed deciding which RPCs it affects gets reported on failure.
+func (c *Control) usingRandomFaults(enabled bool) {
+	if !enabled || c.randomFaults {
+		return
+	}
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: control: random faults seed %d", c.network.Seed()))
+	c.randomFaults = true
+}
+
+// Report the seed of the probabilistic RPC faults injected during a failed
+// test, so it can be reproduced with the FaultSeed option.
+func (c *Control) reportFaultSeed() {
+	if !c.randomFaults || !c.failed() {
+		return
+	}
+	c.t.Logf("raft-test: close: random RPC faults used seed %d (see the FaultSeed option)", c.network.Seed())
+}
+
+// Check that the given servers exist and are distinct.
+func (c *Control) checkLink(what string, from, to raft.ServerID) {
+	c.t.Helper()
+
+	for _, id := range []raft.ServerID{from, to} {
+		if _, ok := c.servers[id]; !ok {
+			c.t.Fatalf("raft-test: %s: unknown server %s", what, id)
+		}
+	}
+	if from == to {
+		c.t.Fatalf("raft-test: %s: server %s can't be linked to itself", what, from)
+	}
+}
+
+// Check that the given fraction is between 0 and 1.
+func (c *Control) checkFraction(what string, fraction float64) {
+	c.t.Helper()
+
+	if fraction < 0 || fraction > 1 {
+		c.t.Fatalf("raft-test: %s: fraction %g is not between 0 and 1", what, fraction)
+	}
+}
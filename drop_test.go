@@ -0,0 +1,134 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Snapshot installs keep failing while heartbeats succeed.
+func TestControl_DropRPCs(t *testing.T) {
+	rafts, control := rafttest.Cluster(
+		t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.Trace(), rafttest.DiscardLogger())
+	defer control.Close()
+
+	term := control.Elect("0")
+	term.When().Command(4).Committed().Snapshot()
+
+	r := rafts["0"]
+	for i := 0; i < 6; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		if i == 0 {
+			term.Disconnect("1")
+		}
+		if i == 4 {
+			control.DropRPCs(1.0, rafttest.RPCInstallSnapshot)
+			term.Reconnect("1")
+		}
+	}
+
+	// Wait for the leader to attempt a snapshot install.
+	installs := func() rafttest.RPCTrace {
+		return control.Trace().From("0").To("1").OfType(rafttest.RPCInstallSnapshot)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(installs()) == 0 {
+		require.True(t, time.Now().Before(deadline), "no snapshot install attempted")
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Error(t, installs()[0].Err)
+
+	assert.Equal(t, uint64(0), control.Restores("1"))
+	assert.Equal(t, raft.Leader, rafts["0"].State())
+	assert.Equal(t, raft.Follower, rafts["1"].State())
+	assert.Contains(t, control.String(), "drop=snapshot:1")
+}
+
+// If the test fails, the seed deciding which RPCs got dropped is reported.
+func TestControl_DropRPCs_Seed(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+	_, control := rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.FaultSeed(42), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	control.DropRPCs(0.1, rafttest.RPCInstallSnapshot)
+
+	reporter.Errorf("boom")
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: random RPC faults used seed 42")
+}
+
+// The dropped fraction must be valid.
+func TestControl_DropRPCs_InvalidFraction(t *testing.T) {
+	_, control := rafttest.Cluster(rafttest.NewReporter(ioutil.Discard), rafttest.FSMs(3))
+	defer control.Close()
+
+	assert.Panics(t, func() { control.DropRPCs(1.5) })
+}
+
+// Duplicated and reordered AppendEntries RPCs don't cause command logs to be
+// applied more than once.
+func TestControl_DuplicateAndReorderRPCs(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.DuplicateRPCs("0", "1", 1.0)
+	control.ReorderRPCs("0", "2", 1.0)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+
+	s := control.String()
+	assert.Contains(t, s, "up,duplicate=1")
+	assert.Contains(t, s, "up,reorder=1")
+	for _, id := range []raft.ServerID{"0", "1", "2"} {
+		assert.Equal(t, uint64(10), control.Commands(id), "server %s", id)
+	}
+}
+
+// RPCs larger than the limit are rejected until the limit is removed.
+func TestLimitRPCSize(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.LimitRPCSize(100)
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply(make([]byte, 50), time.Second).Error())
+	control.Barrier()
+
+	future := r.Apply(make([]byte, 200), time.Second)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(1), control.Commands("1"))
+	assert.Contains(t, control.String(), "max-size=100")
+
+	control.LimitRPCSize(0)
+	require.NoError(t, future.Error())
+	control.Barrier()
+	assert.Equal(t, uint64(2), control.Commands("1"))
+}
@@ -15,6 +15,7 @@
 package rafttest
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
@@ -24,6 +25,12 @@ import (
 
 // Duration is a convenience to scale the given duration according to the
 // GO_RAFT_TEST_LATENCY environment variable.
+//
+// The default timeout used by WaitLeader and by the timeout-taking methods of
+// Control when they are passed a zero timeout, 5 seconds, is scaled the same
+// way, so tests relying on it still pass on slow or loaded CI runners. It's
+// capped by the test deadline, if any, but never extended by it. Non-zero
+// timeouts are used as given: wrap them with Duration() to scale them too.
 func Duration(duration time.Duration) time.Duration {
 	factor := 1.0
 	if env := os.Getenv("GO_RAFT_TEST_LATENCY"); env != "" {
@@ -43,3 +50,57 @@ func scaleDuration(duration time.Duration, factor float64) time.Duration {
 
 	return time.Duration((math.Ceil(float64(duration) * factor)))
 }
+
+// Return the timeout for a wait performed on behalf of the given test: if the
+// given timeout is zero it's a default scaled according to
+// GO_RAFT_TEST_LATENCY, capped by the test deadline, otherwise it's returned
+// as is.
+func waitTimeout(t Reporter, timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return timeoutBudget(t, Duration(5*time.Second))
+	}
+	return timeout
+}
+
+// Return a context derived from the given one, bound to the default wait
+// timeout if the given one has no deadline.
+func waitContext(ctx context.Context, t Reporter) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, waitTimeout(t, 0))
+}
+
+// Return the given timeout for waits performed on behalf of the given test,
+// capped by the test deadline.
+//
+// If the test has a deadline (e.g. go test's -timeout flag) and 90% of the
+// time remaining before it is less than the given timeout, that is returned
+// instead, so that the harness gets a chance to fail with its own diagnostics
+// before go test panics. The deadline never extends the given timeout.
+func timeoutBudget(t Reporter, fallback time.Duration) (timeout time.Duration) {
+	deadliner, ok := t.(interface {
+		Deadline() (time.Time, bool)
+	})
+	if !ok {
+		return fallback
+	}
+
+	// A zero testing.T value (as used by examples) panics when asked for
+	// its deadline.
+	defer func() {
+		if recover() != nil {
+			timeout = fallback
+		}
+	}()
+
+	deadline, ok := deadliner.Deadline()
+	if !ok {
+		return fallback
+	}
+	remaining := time.Until(deadline)
+	if budget := remaining - remaining/10; budget < fallback {
+		return budget
+	}
+	return fallback
+}
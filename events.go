@@ -0,0 +1,207 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ClusterEventKind identifies a kind of ClusterEvent.
+type ClusterEventKind int
+
+// Available kinds of cluster events.
+const (
+	// A server observed a change of leader, see ClusterEvent.Leader.
+	EventLeaderChange ClusterEventKind = iota
+
+	// A server changed state, see ClusterEvent.State.
+	EventStateChange
+
+	// A leader started or stopped replicating to a peer, see
+	// ClusterEvent.Peer and ClusterEvent.Removed.
+	EventPeerChange
+
+	// A heartbeat sent by a server failed, see ClusterEvent.Target and
+	// ClusterEvent.Err.
+	EventFailedHeartbeat
+
+	// A server received a RequestVote RPC, see ClusterEvent.Candidate and
+	// ClusterEvent.Term.
+	EventRequestVote
+)
+
+func (k ClusterEventKind) String() string {
+	switch k {
+	case EventLeaderChange:
+		return "leader change"
+	case EventStateChange:
+		return "state change"
+	case EventPeerChange:
+		return "peer change"
+	case EventFailedHeartbeat:
+		return "failed heartbeat"
+	case EventRequestVote:
+		return "request vote"
+	default:
+		return fmt.Sprintf("event %d", int(k))
+	}
+}
+
+// ClusterEvent is an event that happened on a server of the cluster, as
+// delivered by Control.Events(). Only the fields relevant to its kind are set.
+type ClusterEvent struct {
+	Kind   ClusterEventKind
+	Node   int           // Index of the server the event happened on
+	Server raft.ServerID // ID of the server the event happened on
+	Time   time.Time     // When the harness got the event
+
+	Leader    raft.ServerAddress // New leader, empty if none
+	State     raft.RaftState     // New state
+	Peer      raft.Server        // Peer added or removed
+	Removed   bool               // Whether the peer was removed
+	Target    raft.ServerID      // Server the heartbeat was sent to
+	Err       error              // Error of the heartbeat
+	Candidate raft.ServerAddress // Server that requested the vote
+	Term      uint64             // Term of the vote request
+}
+
+// Size of the buffer of the channel returned by Events().
+const eventsBuffer = 1024
+
+// Events returns a channel delivering the events happening on all servers of
+// the cluster, including the ones restarted or added later: leadership and
+// state changes, peer changes, failed heartbeats and vote requests.
+//
+// Events are collected only after the first call. The channel is buffered,
+// and events are dropped if the application doesn't keep up with them.
+func (c *Control) Events() <-chan ClusterEvent {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+
+	if c.events.ch != nil {
+		return c.events.ch
+	}
+
+	c.events.ch = make(chan ClusterEvent, eventsBuffer)
+	c.events.indexes = make(map[raft.ServerID]int, len(c.nodes))
+	for id, r := range c.servers {
+		i := c.nodeIndex(id)
+		c.events.indexes[id] = i
+		c.events.observe(i, id, r, c.network.Underlying(id), c.stopCh)
+	}
+	c.network.OnHeartbeatFailure(c.events.heartbeatFailed)
+
+	return c.events.ch
+}
+
+// Start collecting events from the given server, if Events() was called.
+// Must be called whenever a new raft instance gets created.
+func (c *Control) observeEvents(id raft.ServerID, r *raft.Raft) {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+
+	if c.events.ch == nil {
+		return
+	}
+	i := c.nodeIndex(id)
+	c.events.indexes[id] = i
+	c.events.observe(i, id, r, c.network.Underlying(id), c.stopCh)
+}
+
+// Return the index of the server with the given ID.
+func (c *Control) nodeIndex(id raft.ServerID) int {
+	for i, node := range c.nodes {
+		if node.Conf.LocalID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Deliver the events of all servers to a single channel.
+type eventBus struct {
+	mu      sync.Mutex
+	ch      chan ClusterEvent
+	indexes map[raft.ServerID]int
+}
+
+// Register an observer on the given raft instance, forwarding its
+// observations until the given stop channel gets closed. The given transport
+// is used to decode the addresses of vote candidates.
+func (b *eventBus) observe(i int, id raft.ServerID, r *raft.Raft, trans raft.Transport, stop chan struct{}) {
+	observations := make(chan raft.Observation, eventsBuffer)
+	r.RegisterObserver(raft.NewObserver(observations, false, nil))
+
+	go func() {
+		for {
+			select {
+			case observation := <-observations:
+				event := ClusterEvent{Node: i, Server: id, Time: time.Now()}
+				switch data := observation.Data.(type) {
+				case raft.LeaderObservation:
+					event.Kind = EventLeaderChange
+					event.Leader = r.Leader()
+				case raft.RaftState:
+					event.Kind = EventStateChange
+					event.State = data
+				case raft.PeerObservation:
+					event.Kind = EventPeerChange
+					event.Peer = data.Peer
+					event.Removed = data.Removed
+				case raft.RequestVoteRequest:
+					event.Kind = EventRequestVote
+					event.Candidate = trans.DecodePeer(data.Candidate)
+					event.Term = data.Term
+				default:
+					continue
+				}
+				b.send(event)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Deliver an event for a failed heartbeat.
+func (b *eventBus) heartbeatFailed(source, target raft.ServerID, err error) {
+	b.mu.Lock()
+	i, ok := b.indexes[source]
+	b.mu.Unlock()
+	if !ok {
+		i = -1
+	}
+
+	b.send(ClusterEvent{
+		Kind:   EventFailedHeartbeat,
+		Node:   i,
+		Server: source,
+		Time:   time.Now(),
+		Target: target,
+		Err:    err,
+	})
+}
+
+// Deliver the given event, dropping it if the channel is full.
+func (b *eventBus) send(event ClusterEvent) {
+	select {
+	case b.ch <- event:
+	default:
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Events from all servers are delivered on a single channel.
+func TestControl_Events(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	events := control.Events()
+
+	control.Elect("0")
+
+	// Wait for server 0 to become leader and for the others to vote for
+	// it and follow it.
+	leader := false
+	votes := map[raft.ServerID]bool{}
+	follows := map[raft.ServerID]bool{}
+	timeout := time.After(time.Second)
+	for !leader || len(votes) < 2 || len(follows) < 2 {
+		select {
+		case event := <-events:
+			switch event.Kind {
+			case rafttest.EventStateChange:
+				if event.Server == "0" && event.State == raft.Leader {
+					assert.Equal(t, 0, event.Node)
+					leader = true
+				}
+			case rafttest.EventRequestVote:
+				assert.Equal(t, raft.ServerAddress("0"), event.Candidate)
+				votes[event.Server] = true
+			case rafttest.EventLeaderChange:
+				if event.Server != "0" && event.Leader == "0" {
+					follows[event.Server] = true
+				}
+			}
+		case <-timeout:
+			t.Fatalf("events not delivered: leader %v, votes %v, follows %v", leader, votes, follows)
+		}
+	}
+
+	// Killing a follower makes heartbeats to it fail.
+	control.Kill(rafts["2"])
+	timeout = time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Kind != rafttest.EventFailedHeartbeat {
+				continue
+			}
+			assert.Equal(t, raft.ServerID("0"), event.Server)
+			assert.Equal(t, raft.ServerID("2"), event.Target)
+			assert.Error(t, event.Err)
+			return
+		case <-timeout:
+			t.Fatal("no failed heartbeat event delivered")
+		}
+	}
+}
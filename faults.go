@@ -0,0 +1,86 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// OnFaultInjected registers a callback that gets invoked right after a fault
+// has been injected into the cluster, either by a Nemesis or directly with
+// methods like Depose(), Term.Disconnect() and SlowLeaderDisk().
+//
+// It's meant to synchronize application-level actions with harness faults,
+// for example triggering a client failover routine when a server gets
+// disconnected. The Dwell field of the given action is always zero, since the
+// fault might be healed at any time.
+//
+// Callbacks are invoked synchronously, in registration order, from the
+// goroutine that injected the fault.
+func (c *Control) OnFaultInjected(f func(FaultAction)) {
+	c.hooks.mu.Lock()
+	defer c.hooks.mu.Unlock()
+	c.hooks.injected = append(c.hooks.injected, f)
+}
+
+// OnFaultHealed registers a callback that gets invoked right after a fault
+// previously injected into the cluster has been healed, for example when a
+// disconnected server is reconnected or when a new leader gets elected after
+// the previous one was deposed.
+//
+// Callbacks are invoked synchronously, in registration order, from the
+// goroutine that healed the fault.
+func (c *Control) OnFaultHealed(f func(FaultAction)) {
+	c.hooks.mu.Lock()
+	defer c.hooks.mu.Unlock()
+	c.hooks.healed = append(c.hooks.healed, f)
+}
+
+// Callbacks registered with OnFaultInjected() and OnFaultHealed().
+type faultHooks struct {
+	mu       sync.Mutex
+	injected []func(FaultAction)
+	healed   []func(FaultAction)
+}
+
+// Invoke the callbacks registered for injected faults.
+func (c *Control) faultInjected(action FaultAction) {
+	c.recorder.Injected(action)
+	for _, f := range c.hooks.callbacks(&c.hooks.injected) {
+		f(action)
+	}
+}
+
+// Invoke the callbacks registered for healed faults.
+func (c *Control) faultHealed(action FaultAction) {
+	leader := raft.ServerID("")
+	if c.term != nil {
+		leader = c.term.id
+	}
+	c.recorder.Healed(action, leader)
+	for _, f := range c.hooks.callbacks(&c.hooks.healed) {
+		f(action)
+	}
+}
+
+// Return a copy of the given list of callbacks, so they can be invoked without
+// holding the lock.
+func (h *faultHooks) callbacks(list *[]func(FaultAction)) []func(FaultAction) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]func(FaultAction){}, *list...)
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+)
+
+// Callbacks are invoked when faults are injected and healed.
+func TestControl_OnFault(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	events := []string{}
+	control.OnFaultInjected(func(action rafttest.FaultAction) {
+		events = append(events, "injected "+action.String())
+	})
+	control.OnFaultHealed(func(action rafttest.FaultAction) {
+		events = append(events, "healed "+action.String())
+	})
+
+	term := control.Elect("0")
+	term.Disconnect("1")
+	term.Reconnect("1")
+
+	control.Depose()
+	control.Elect("2")
+
+	control.SlowLeaderDisk(time.Millisecond)
+	control.SlowLeaderDisk(0)
+
+	assert.Equal(t, []string{
+		"injected disconnect server 1",
+		"healed disconnect server 1",
+		"injected depose server 0",
+		"healed depose server 0",
+		"injected slow disk server 2",
+		"healed slow disk server 2",
+	}, events)
+}
@@ -15,7 +15,13 @@
 package rafttest
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"io"
+	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -34,6 +40,124 @@ func FSMs(n int) []raft.FSM {
 	return fsms
 }
 
+// FSMsBuilder can be used to create dummy FSMs with common behaviors, which can
+// be combined. Use NewFSMs to create one.
+type FSMsBuilder struct {
+	n         int
+	snapshots bool
+	delay     time.Duration
+}
+
+// NewFSMs returns a builder for the given number of dummy FSMs. Without any
+// further option, the built FSMs behave like the ones returned by FSMs.
+func NewFSMs(n int) *FSMsBuilder {
+	return &FSMsBuilder{n: n}
+}
+
+// WithSnapshots makes the FSMs keep track of the number of applied command
+// logs and of a digest of their data, and include them in their snapshots,
+// so restores can be verified.
+func (b *FSMsBuilder) WithSnapshots() *FSMsBuilder {
+	b.snapshots = true
+	return b
+}
+
+// WithApplyDelay makes the FSMs sleep for the given amount of time each time
+// a command log is applied, to simulate slow FSMs.
+func (b *FSMsBuilder) WithApplyDelay(delay time.Duration) *FSMsBuilder {
+	b.delay = delay
+	return b
+}
+
+// Build creates the FSMs.
+func (b *FSMsBuilder) Build() []raft.FSM {
+	fsms := make([]raft.FSM, b.n)
+	for i := range fsms {
+		if !b.snapshots && b.delay == 0 {
+			fsms[i] = FSM()
+			continue
+		}
+		fsms[i] = &stateFSM{snapshots: b.snapshots, delay: b.delay}
+	}
+	return fsms
+}
+
+// RoundTripFSM checks that the state of an FSM survives a snapshot/restore
+// round-trip, without the need of a full cluster.
+//
+// The given factory is used to create a first FSM, to which the given logs get
+// applied. A snapshot of it is then persisted to an in-memory snapshot store
+// and restored into a second FSM created with the factory. The test fails
+// unless a snapshot of the second FSM has the same digest as the first one.
+func RoundTripFSM(t Reporter, factory func() raft.FSM, logs []*raft.Log) {
+	t.Helper()
+
+	fsm := factory()
+	for i, log := range logs {
+		if result := fsm.Apply(log); result != nil {
+			if err, ok := result.(error); ok {
+				t.Fatalf("raft-test: round-trip: apply log %d: %v", i, err)
+			}
+		}
+	}
+
+	store := raft.NewInmemSnapshotStore()
+	id := persistFSM(t, store, fsm, uint64(len(logs)))
+
+	_, reader, err := store.Open(id)
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: open snapshot: %v", err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: read snapshot: %v", err)
+	}
+
+	restored := factory()
+	if err := restored.Restore(ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatalf("raft-test: round-trip: restore: %v", err)
+	}
+
+	_, reader, err = store.Open(persistFSM(t, store, restored, uint64(len(logs))))
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: open snapshot: %v", err)
+	}
+	restoredData, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: read snapshot: %v", err)
+	}
+
+	if sha256.Sum256(data) != sha256.Sum256(restoredData) {
+		t.Fatalf("raft-test: round-trip: restored FSM state differs from the original one")
+	}
+}
+
+// Take a snapshot of the given FSM and persist it into the given store,
+// returning the snapshot ID.
+func persistFSM(t Reporter, store raft.SnapshotStore, fsm raft.FSM, index uint64) string {
+	t.Helper()
+
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: snapshot: %v", err)
+	}
+	defer snapshot.Release()
+
+	sink, err := store.Create(1, index, 1, raft.Configuration{}, 0, nil)
+	if err != nil {
+		t.Fatalf("raft-test: round-trip: create snapshot sink: %v", err)
+	}
+	if err := snapshot.Persist(sink); err != nil {
+		sink.Cancel()
+		t.Fatalf("raft-test: round-trip: persist snapshot: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("raft-test: round-trip: close snapshot sink: %v", err)
+	}
+
+	return sink.ID()
+}
+
 // fsm is a dummy raft finite state machine that does nothing and
 // always no-ops.
 type fsm struct{}
@@ -41,6 +165,9 @@ type fsm struct{}
 // Apply always return a nil error without doing anything.
 func (f *fsm) Apply(*raft.Log) interface{} { return nil }
 
+// State always returns nil, since the FSM has no state.
+func (f *fsm) State() interface{} { return nil }
+
 // Snapshot always return a dummy snapshot and no error without doing
 // anything.
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) { return &fsmSnapshot{}, nil }
@@ -58,3 +185,94 @@ func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error { return nil }
 
 // Release is a no-op.
 func (s *fsmSnapshot) Release() {}
+
+// stateFSM is a dummy FSM created by FSMsBuilder.
+type stateFSM struct {
+	snapshots bool          // Whether state is included in snapshots
+	delay     time.Duration // How long each apply takes
+
+	mu       sync.Mutex
+	commands uint64   // Number of applied command logs
+	digest   [32]byte // Chained digest of the applied data
+}
+
+// Apply updates the command count and the digest.
+func (f *stateFSM) Apply(log *raft.Log) interface{} {
+	if f.delay != 0 {
+		time.Sleep(f.delay)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands++
+	f.digest = sha256.Sum256(append(f.digest[:], log.Data...))
+
+	return nil
+}
+
+// State returns the command count and the digest.
+func (f *stateFSM) State() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return struct {
+		Commands uint64
+		Digest   [32]byte
+	}{f.commands, f.digest}
+}
+
+// Snapshot returns a snapshot of the command count and digest, if snapshots
+// are enabled, or a dummy snapshot otherwise.
+func (f *stateFSM) Snapshot() (raft.FSMSnapshot, error) {
+	if !f.snapshots {
+		return &fsmSnapshot{}, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data := make([]byte, 8, 8+len(f.digest))
+	binary.LittleEndian.PutUint64(data, f.commands)
+	data = append(data, f.digest[:]...)
+
+	return &stateFSMSnapshot{data: data}, nil
+}
+
+// Restore reads back the command count and digest, if snapshots are enabled.
+func (f *stateFSM) Restore(reader io.ReadCloser) error {
+	if !f.snapshots {
+		return nil
+	}
+	defer reader.Close()
+
+	data := make([]byte, 8+32)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands = binary.LittleEndian.Uint64(data[:8])
+	copy(f.digest[:], data[8:])
+
+	return nil
+}
+
+// stateFSMSnapshot holds the encoded state of a stateFSM.
+type stateFSMSnapshot struct {
+	data []byte
+}
+
+// Persist writes the encoded state to the sink.
+func (s *stateFSMSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release is a no-op.
+func (s *stateFSMSnapshot) Release() {}
@@ -15,9 +15,15 @@
 package rafttest_test
 
 import (
+	"bytes"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFSM_Restore(t *testing.T) {
@@ -26,3 +32,57 @@ func TestFSM_Restore(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRoundTripFSM(t *testing.T) {
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: rafttest.KVSet("a", "1")},
+		{Index: 2, Term: 1, Type: raft.LogCommand, Data: rafttest.KVSet("b", "2")},
+	}
+	factory := func() raft.FSM { return rafttest.NewKVFSM() }
+	rafttest.RoundTripFSM(t, factory, logs)
+}
+
+// An FSM that loses its state when restored fails the round trip.
+func TestRoundTripFSM_Lossy(t *testing.T) {
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: rafttest.KVSet("a", "1")},
+	}
+	factory := func() raft.FSM { return &lossyFSM{KVFSM: rafttest.NewKVFSM()} }
+
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+	assert.Panics(t, func() { rafttest.RoundTripFSM(reporter, factory, logs) })
+	assert.Contains(t, buffer.String(), "restored FSM state differs from the original one")
+}
+
+// A KVFSM that ignores the snapshot it's asked to restore.
+type lossyFSM struct {
+	*rafttest.KVFSM
+}
+
+func (f *lossyFSM) Restore(reader io.ReadCloser) error {
+	return reader.Close()
+}
+
+func TestNewFSMs_WithSnapshots(t *testing.T) {
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("hello")},
+		{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("world")},
+	}
+	factory := func() raft.FSM {
+		return rafttest.NewFSMs(1).WithSnapshots().Build()[0]
+	}
+	rafttest.RoundTripFSM(t, factory, logs)
+}
+
+func TestNewFSMs_WithApplyDelay(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithApplyDelay(10 * time.Millisecond).Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	start := time.Now()
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+}
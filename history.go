@@ -0,0 +1,107 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// HistoryEntry is a command log applied by the FSM of a server.
+type HistoryEntry struct {
+	Node      raft.ServerID // Server whose FSM applied the log
+	Index     uint64        // Index of the log
+	Term      uint64        // Term of the log
+	Command   []byte        // Data of the log, see CommandHistory()
+	Timestamp time.Time     // When the FSM started applying the log
+}
+
+// History is a sequence of command logs applied by the FSMs of a cluster, as
+// returned by Control.History().
+type History []HistoryEntry
+
+// History returns all command logs applied so far by the FSMs of all servers,
+// including the ones that are currently killed or crashed, ordered by time.
+//
+// Logs re-applied by a restarted server show up again, while logs whose
+// effects were installed through a snapshot restore don't show up at all. The
+// Command field of the entries is nil unless the CommandHistory option was
+// used.
+func (c *Control) History() History {
+	history := History{}
+	for _, d := range c.nodes {
+		id := d.Conf.LocalID
+		for _, applied := range c.watcher.History(id) {
+			history = append(history, HistoryEntry{
+				Node:      id,
+				Index:     applied.Index,
+				Term:      applied.Term,
+				Command:   applied.Data,
+				Timestamp: applied.Time,
+			})
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(history[j].Timestamp)
+	})
+	return history
+}
+
+// ByNode returns the entries of the server with the given ID.
+func (h History) ByNode(id raft.ServerID) History {
+	return h.filter(func(entry HistoryEntry) bool { return entry.Node == id })
+}
+
+// Range returns the entries whose index is between from and to, inclusive.
+func (h History) Range(from, to uint64) History {
+	return h.filter(func(entry HistoryEntry) bool {
+		return entry.Index >= from && entry.Index <= to
+	})
+}
+
+// Diff returns the entries of this history that have no match in the other
+// one, i.e. entries whose index is not in the other history at all, or is
+// there with a different term or command.
+//
+// It's typically used to compare the histories of two servers, as in
+// h.ByNode("0").Diff(h.ByNode("1")).
+func (h History) Diff(other History) History {
+	byIndex := make(map[uint64][]HistoryEntry, len(other))
+	for _, entry := range other {
+		byIndex[entry.Index] = append(byIndex[entry.Index], entry)
+	}
+	return h.filter(func(entry HistoryEntry) bool {
+		for _, o := range byIndex[entry.Index] {
+			if o.Term == entry.Term && bytes.Equal(o.Command, entry.Command) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Return the entries for which the given function returns true.
+func (h History) filter(f func(HistoryEntry) bool) History {
+	filtered := History{}
+	for _, entry := range h {
+		if f(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The history holds the command logs applied by all servers.
+func TestControl_History(t *testing.T) {
+	rafts, control := rafttest.Cluster(
+		t, rafttest.FSMs(3), rafttest.CommandHistory(), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	var first uint64
+	for i := 0; i < 3; i++ {
+		future := r.Apply([]byte{byte(i)}, time.Second)
+		require.NoError(t, future.Error())
+		if i == 0 {
+			first = future.Index()
+		}
+	}
+	control.Barrier()
+
+	history := control.History()
+	assert.Len(t, history, 9)
+
+	leader := history.ByNode("0")
+	require.Len(t, leader, 3)
+	for i, entry := range leader {
+		assert.Equal(t, first+uint64(i), entry.Index)
+		assert.Equal(t, []byte{byte(i)}, entry.Command)
+		assert.False(t, entry.Timestamp.IsZero())
+	}
+
+	assert.Len(t, history.Range(first+1, first+2), 6)
+	assert.Len(t, leader.Diff(history.ByNode("1")), 0)
+
+	// Kill a follower and apply one more command: the leader's history
+	// now has one entry that the follower's doesn't.
+	control.Kill(rafts["2"])
+	require.NoError(t, r.Apply([]byte{3}, time.Second).Error())
+	control.Barrier()
+
+	history = control.History()
+	diff := history.ByNode("0").Diff(history.ByNode("2"))
+	require.Len(t, diff, 1)
+	assert.Equal(t, []byte{3}, diff[0].Command)
+}
+
+// Without the CommandHistory option, entries hold no command data.
+func TestControl_History_NoCommands(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{1}, time.Second).Error())
+	control.Barrier()
+
+	history := control.History().ByNode("0")
+	require.Len(t, history, 1)
+	assert.Nil(t, history[0].Command)
+	assert.NotZero(t, history[0].Index)
+}
@@ -16,6 +16,7 @@ package election
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -33,6 +34,9 @@ type notifier struct {
 	// Reference to the Config.NotifyCh object set in this server's Config.
 	notifyCh chan bool
 
+	// If not nil, incremented every time the server acquires leadership.
+	acquisitions *uint64
+
 	// Channel used to tell the notification loop to expect the server to
 	// acquire leadership. The leadership future sent to this channel will
 	// be used both for notifying that leadership was acquired.
@@ -47,14 +51,15 @@ type notifier struct {
 }
 
 // Create a new notifier.
-func newNotifier(logger hclog.Logger, id raft.ServerID, notifyCh chan bool) *notifier {
+func newNotifier(logger hclog.Logger, id raft.ServerID, notifyCh chan bool, acquisitions *uint64) *notifier {
 	observer := &notifier{
-		logger:     logger,
-		id:         id,
-		notifyCh:   notifyCh,
-		futureCh:   make(chan *Future),
-		ignoreCh:   make(chan struct{}),
-		shutdownCh: make(chan struct{}),
+		logger:       logger,
+		id:           id,
+		notifyCh:     notifyCh,
+		acquisitions: acquisitions,
+		futureCh:     make(chan *Future),
+		ignoreCh:     make(chan struct{}),
+		shutdownCh:   make(chan struct{}),
 	}
 	go observer.start()
 	return observer
@@ -133,6 +138,9 @@ func (n *notifier) start() {
 				panic(fmt.Sprintf("server %s %s leadership twice in a row", n.id, verb))
 			}
 			last = acquired
+			if acquired && n.acquisitions != nil {
+				atomic.AddUint64(n.acquisitions, 1)
+			}
 			n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: leadership: %s", n.id, verb))
 			select {
 			case <-ch:
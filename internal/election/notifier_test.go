@@ -207,6 +207,6 @@ func newTestNotifier(t testing.TB) (*notifier, chan bool) {
 	id := raft.ServerID("0")
 	notifyCh := make(chan bool)
 
-	notifier := newNotifier(logger, id, notifyCh)
+	notifier := newNotifier(logger, id, notifyCh, new(uint64))
 	return notifier, notifyCh
 }
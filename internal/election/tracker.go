@@ -17,6 +17,7 @@ package election
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -26,20 +27,17 @@ import (
 // Tracker consumes the raft.Config.NotifyCh set on each server of a cluster,
 // tracking when elections occur.
 type Tracker struct {
+	// Number of times any server acquired leadership. It's updated
+	// atomically by the notifiers, so it's kept as first word of the
+	// struct to guarantee 64-bit alignment on 32-bit platforms.
+	acquisitions uint64
+
 	// For debugging raft-test itself or its consumers.
 	logger hclog.Logger
 
 	// Watchers for individual servers.
-	//
-	// Note that this map is not protected by a mutex, since it should be
-	// written once when the cluster is created, and never written again.
 	observers map[raft.ServerID]*notifier
 
-	// Flag indicating if Acquired() has been called on this Observer. It's
-	// used to as sanity check that Add() is not called after the first
-	// call to Acquired().
-	observing bool
-
 	// Current leadership future, if any. It's used as sanity check to
 	// prevent further leadership requests.
 	future *Future
@@ -60,6 +58,8 @@ func NewTracker(logger hclog.Logger) *Tracker {
 // Ignore stops propagating leadership change notifications, which will be
 // simply dropped on the floor. Should be called before the final Close().
 func (t *Tracker) Ignore() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	for _, observer := range t.observers {
 		observer.Ignore()
 	}
@@ -67,6 +67,8 @@ func (t *Tracker) Ignore() {
 
 // Close stops watching for leadership changes in the cluster.
 func (t *Tracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	for _, observer := range t.observers {
 		observer.Close()
 	}
@@ -74,14 +76,22 @@ func (t *Tracker) Close() {
 
 // Track leadership changes on the server with the given ID using the given
 // Config.NotifyCh.
+//
+// Servers can be tracked at any time, for example when they are added to a
+// running cluster.
 func (t *Tracker) Track(id raft.ServerID, notifyCh chan bool) {
-	if t.observing {
-		panic("can't track new server while observing")
-	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if _, ok := t.observers[id]; ok {
 		panic(fmt.Sprintf("an observer for server %s is already registered", id))
 	}
-	t.observers[id] = newNotifier(t.logger, id, notifyCh)
+	t.observers[id] = newNotifier(t.logger, id, notifyCh, &t.acquisitions)
+}
+
+// Acquisitions returns the number of times any tracked server acquired
+// leadership.
+func (t *Tracker) Acquisitions() uint64 {
+	return atomic.LoadUint64(&t.acquisitions)
 }
 
 // Expect returns an election Future object whose Done() method will return
@@ -95,7 +105,6 @@ func (t *Tracker) Track(id raft.ServerID, notifyCh chan bool) {
 func (t *Tracker) Expect(id raft.ServerID, timeout time.Duration) *Future {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.observing = true
 
 	if t.future != nil {
 		select {
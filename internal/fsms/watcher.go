@@ -15,6 +15,8 @@
 package fsms
 
 import (
+	"time"
+
 	"github.com/CanonicalLtd/raft-test/internal/event"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
@@ -26,6 +28,9 @@ type Watcher struct {
 
 	// FSM wrappers.
 	fsms map[raft.ServerID]*fsmWrapper
+
+	// Whether FSMs keep a copy of the data of the logs they apply.
+	recordData bool
 }
 
 // New create a new FSMs watcher for watching the underlying FSMs.
@@ -40,9 +45,17 @@ func New(logger hclog.Logger) *Watcher {
 // instrumentation for firing events.
 func (w *Watcher) Add(id raft.ServerID, fsm raft.FSM) raft.FSM {
 	w.fsms[id] = newFSMWrapper(w.logger, id, fsm)
+	w.fsms[id].recordData = w.recordData
 	return w.fsms[id]
 }
 
+// RecordData makes the FSMs added from now on keep a copy of the data of the
+// command logs they apply, as returned by History(). By default only their
+// index, term and apply time are kept.
+func (w *Watcher) RecordData() {
+	w.recordData = true
+}
+
 // WhenApplied returns an event that will fire when the n'th command log for
 // the term is applied on the FSM associated with the server with the given
 // ID. It's that such server is currently the leader.
@@ -50,12 +63,62 @@ func (w *Watcher) WhenApplied(id raft.ServerID, n uint64) *event.Event {
 	return w.fsms[id].whenApplied(n)
 }
 
+// SetHandoffDelay sets the delay to wait before handing each command log to
+// the FSM of the server with the given ID.
+func (w *Watcher) SetHandoffDelay(id raft.ServerID, delay time.Duration) {
+	w.fsms[id].SetHandoffDelay(delay)
+}
+
+// SetHooks sets the hooks intercepting calls to the FSM of the server with
+// the given ID.
+func (w *Watcher) SetHooks(id raft.ServerID, hooks Hooks) {
+	w.fsms[id].SetHooks(hooks)
+}
+
+// SnapshotEvery returns a channel that gets notified every time the FSM of the
+// server with the given ID has applied n more command logs. It must be called
+// before the server starts.
+func (w *Watcher) SnapshotEvery(id raft.ServerID, n uint64) <-chan struct{} {
+	return w.fsms[id].SnapshotEvery(n)
+}
+
+// FSM returns the user FSM of the server with the given ID, as wrapped by
+// Add().
+func (w *Watcher) FSM(id raft.ServerID) raft.FSM {
+	return w.fsms[id].fsm
+}
+
 // Commands returns the total number of command logs applied by the FSM of
 // the server with the given ID.
 func (w *Watcher) Commands(id raft.ServerID) uint64 {
 	return w.fsms[id].Commands()
 }
 
+// LastIndex returns the index of the last command log applied by the FSM of
+// the server with the given ID.
+func (w *Watcher) LastIndex(id raft.ServerID) uint64 {
+	return w.fsms[id].Index()
+}
+
+// WhenIndex returns a channel that gets closed once the FSM of the server with
+// the given ID applies the command log with the given index, or a later one.
+func (w *Watcher) WhenIndex(id raft.ServerID, index uint64) <-chan struct{} {
+	return w.fsms[id].WhenIndex(index)
+}
+
+// ApplyTimes returns the start and end time of the last apply of the command
+// log with the given index by the FSM of the server with the given ID. Only
+// the latest MaxTrackedApplies indexes are tracked.
+func (w *Watcher) ApplyTimes(id raft.ServerID, index uint64) (time.Time, time.Time, bool) {
+	return w.fsms[id].ApplyTimes(index)
+}
+
+// History returns all command logs applied so far by the FSM of the server
+// with the given ID, in order.
+func (w *Watcher) History(id raft.ServerID) []Applied {
+	return w.fsms[id].History()
+}
+
 // Snapshots returns the total number of snapshots performed by the FSM of the
 // server with the given ID.
 func (w *Watcher) Snapshots(id raft.ServerID) uint64 {
@@ -68,6 +131,24 @@ func (w *Watcher) Restores(id raft.ServerID) uint64 {
 	return w.fsms[id].Restores()
 }
 
+// SnapshotStall returns the longest time for which applies on the FSM of the
+// server with the given ID were stalled by a snapshot.
+func (w *Watcher) SnapshotStall(id raft.ServerID) time.Duration {
+	return w.fsms[id].SnapshotStall()
+}
+
+// Failures returns the problems found so far while raft used the FSM of the
+// server with the given ID, such as concurrent applies or panicking hooks.
+func (w *Watcher) Failures(id raft.ServerID) []string {
+	return w.fsms[id].Failures()
+}
+
+// Restarting must be called before the given server gets restarted, to reset
+// the internal state of its FSM.
+func (w *Watcher) Restarting(id raft.ServerID) {
+	w.fsms[id].restart()
+}
+
 // Electing must be called whenever the given server is about to transition to
 // the leader state, and before any new command log is applied.
 //
@@ -18,7 +18,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/CanonicalLtd/raft-test/internal/event"
 	"github.com/hashicorp/go-hclog"
@@ -39,41 +44,156 @@ type fsmWrapper struct {
 	// Total number of commands applied by this FSM.
 	commands uint64
 
+	// Index of the last command log applied by this FSM.
+	index uint64
+
+	// Start and end time of the last apply of each command log index.
+	applies map[uint64][2]time.Time
+
+	// All command logs applied by this FSM, in order.
+	history []Applied
+
+	// Whether to keep a copy of the data of applied command logs in
+	// history.
+	recordData bool
+
 	// Total number of snapshots performed on this FSM.
 	snapshots uint64
 
 	// Total number of restores performed on this FSM.
 	restores uint64
 
+	// Number of snapshots currently being persisted.
+	persisting int32
+
+	// Longest time for which applies were stalled by a snapshot, either
+	// because the FSM's Snapshot() method was running, or because a single
+	// apply took that long while a snapshot was being persisted.
+	snapshotStall time.Duration
+
 	// Events that should be fired when a certain command log is events.
 	events map[uint64][]*event.Event
 
+	// ID of the goroutine that applied the first command log. Raft is
+	// expected to apply all logs from the same goroutine.
+	goroutine uint64
+
+	// Non-zero if a command log is currently being applied, used to detect
+	// concurrent applies.
+	applying int32
+
+	// Problems found so far while raft used this FSM, such as concurrent
+	// applies or panicking hooks.
+	failures []string
+
+	// Delay to wait before handing each command log to the wrapped FSM, in
+	// nanoseconds.
+	handoff int64
+
+	// Hooks intercepting calls to the wrapped FSM.
+	hooks Hooks
+
+	// Channels to close once a certain log index is applied.
+	waiters []indexWaiter
+
+	// If non-zero, notify snapshotCh every time this many command logs
+	// have been applied.
+	snapshotEvery uint64
+	snapshotCh    chan struct{}
+
 	mu sync.RWMutex
 }
 
 func newFSMWrapper(logger hclog.Logger, id raft.ServerID, fsm raft.FSM) *fsmWrapper {
 	return &fsmWrapper{
-		logger: logger,
-		id:     id,
-		fsm:    fsm,
-		events: make(map[uint64][]*event.Event),
+		logger:  logger,
+		id:      id,
+		fsm:     fsm,
+		events:  make(map[uint64][]*event.Event),
+		applies: make(map[uint64][2]time.Time),
 	}
 }
 
 func (f *fsmWrapper) Apply(log *raft.Log) interface{} {
-	result := f.fsm.Apply(log)
+	// User FSMs are typically written assuming that applies are serialized,
+	// so record it if that's not the case. Panicking would crash the whole
+	// test binary, since nothing recovers raft's FSM goroutine.
+	if atomic.CompareAndSwapInt32(&f.applying, 0, 1) {
+		defer atomic.StoreInt32(&f.applying, 0)
+	} else {
+		f.fail("concurrent FSM apply of log %d", log.Index)
+	}
+
+	goroutine := goroutineID()
+	f.mu.Lock()
+	expected := f.goroutine
+	if expected == 0 {
+		f.goroutine = goroutine
+	}
+	f.mu.Unlock()
+	if expected != 0 && expected != goroutine {
+		f.fail("FSM apply of log %d from goroutine %d instead of %d", log.Index, goroutine, expected)
+	}
+
+	if delay := atomic.LoadInt64(&f.handoff); delay != 0 {
+		time.Sleep(time.Duration(delay))
+	}
+
+	f.mu.RLock()
+	hook := f.hooks.Apply
+	n := f.commands + 1
+	f.mu.RUnlock()
+
+	start := time.Now()
+	var result interface{}
+	if hook != nil {
+		f.runHook(fmt.Sprintf("apply hook for log %d", log.Index), func() { result = hook(n, log) })
+	}
+	skipped := result != nil
+	if !skipped {
+		result = f.fsm.Apply(log)
+	}
+	end := time.Now()
 
 	f.mu.Lock()
-	f.commands++
+	if atomic.LoadInt32(&f.persisting) > 0 && end.Sub(start) > f.snapshotStall {
+		f.snapshotStall = end.Sub(start)
+	}
+	f.index = log.Index
+	f.notifyWaiters()
+	f.applies[log.Index] = [2]time.Time{start, end}
+	if log.Index > MaxTrackedApplies {
+		delete(f.applies, log.Index-MaxTrackedApplies)
+	}
+	if !skipped {
+		f.commands++
+		applied := Applied{Index: log.Index, Term: log.Term, Time: start}
+		if f.recordData {
+			applied.Data = append([]byte(nil), log.Data...)
+		}
+		f.history = append(f.history, applied)
+	}
+	commands := f.commands
+	events := append([]*event.Event(nil), f.events[commands]...)
 	f.mu.Unlock()
 
-	f.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: fsm %s: applied %d", f.id, f.commands))
-	if events, ok := f.events[f.commands]; ok {
-		for _, event := range events {
-			event.Fire()
-			event.Block()
+	if skipped {
+		f.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: fsm %s: skipped log %d", f.id, log.Index))
+		return result
+	}
+
+	f.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: fsm %s: applied %d", f.id, commands))
+	if f.snapshotEvery != 0 && commands%f.snapshotEvery == 0 {
+		// Don't block if a snapshot is already pending.
+		select {
+		case f.snapshotCh <- struct{}{}:
+		default:
 		}
 	}
+	for _, event := range events {
+		event.Fire()
+		event.Block()
+	}
 
 	return result
 }
@@ -81,12 +201,36 @@ func (f *fsmWrapper) Apply(log *raft.Log) interface{} {
 // Snapshot always return a dummy snapshot and no error without doing
 // anything.
 func (f *fsmWrapper) Snapshot() (raft.FSMSnapshot, error) {
-	snapshot, err := f.fsm.Snapshot()
+	f.mu.RLock()
+	hook := f.hooks.Snapshot
+	f.mu.RUnlock()
+
+	// Raft doesn't apply any log while this method runs.
+	start := time.Now()
+	var snapshot raft.FSMSnapshot
+	var err error
+	if hook != nil {
+		if !f.runHook("snapshot hook", func() { err = hook() }) {
+			err = fmt.Errorf("snapshot hook panicked")
+		}
+	}
+	if err == nil {
+		snapshot, err = f.fsm.Snapshot()
+	}
+	stall := time.Since(start)
+
+	f.mu.Lock()
+	if stall > f.snapshotStall {
+		f.snapshotStall = stall
+	}
+	f.mu.Unlock()
 
 	if snapshot != nil {
 		f.mu.Lock()
 		f.snapshots++
+		atomic.AddInt32(&f.persisting, 1)
 		snapshot = &fsmSnapshotWrapper{
+			fsm:      f,
 			commands: f.commands,
 			snapshot: snapshot,
 		}
@@ -99,24 +243,43 @@ func (f *fsmWrapper) Snapshot() (raft.FSMSnapshot, error) {
 // Restore always return a nil error without reading anything from
 // the reader.
 func (f *fsmWrapper) Restore(reader io.ReadCloser) error {
-	if err := binary.Read(reader, binary.LittleEndian, &f.commands); err != nil {
-		return errors.Wrap(err, "failed to restore commands count")
+	f.mu.RLock()
+	hook := f.hooks.Restore
+	f.mu.RUnlock()
+
+	if hook != nil {
+		var err error
+		if !f.runHook("restore hook", func() { err = hook() }) {
+			err = fmt.Errorf("restore hook panicked")
+		}
+		if err != nil {
+			reader.Close()
+			return err
+		}
 	}
-	if err := f.fsm.Restore(reader); err != nil {
-		return errors.Wrap(err, "failed to perform restore on user's FSM")
+
+	commands, err := ReadSnapshotHeader(reader)
+	if err != nil {
+		return err
 	}
+	f.mu.Lock()
+	f.commands = commands
+	f.mu.Unlock()
 
-	if events, ok := f.events[f.commands]; ok {
-		for _, event := range events {
-			event.Fire()
-			event.Block()
-		}
+	if err := f.fsm.Restore(reader); err != nil {
+		return errors.Wrap(err, "failed to perform restore on user's FSM")
 	}
 
 	f.mu.Lock()
 	f.restores++
+	events := append([]*event.Event(nil), f.events[commands]...)
 	f.mu.Unlock()
 
+	for _, event := range events {
+		event.Fire()
+		event.Block()
+	}
+
 	return nil
 }
 
@@ -139,8 +302,8 @@ func (f *fsmWrapper) electing() {
 // current leader.
 func (f *fsmWrapper) whenApplied(n uint64) *event.Event {
 	e := event.New()
-	f.mu.RLock()
-	defer f.mu.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.commands >= n {
 		// Fire immediately.
 		go e.Fire()
@@ -154,30 +317,216 @@ func (f *fsmWrapper) whenApplied(n uint64) *event.Event {
 	return e
 }
 
+// Hooks intercept calls made by raft to an FSM.
+type Hooks struct {
+	// Called before handing the n'th command log to the FSM. If it
+	// returns a non-nil value, the log is not handed to the FSM, the value
+	// is used as apply response and the log is not counted.
+	Apply func(n uint64, log *raft.Log) interface{}
+
+	// Called before taking a snapshot of the FSM. If it returns an error,
+	// the snapshot fails with it.
+	Snapshot func() error
+
+	// Called before restoring the FSM from a snapshot. If it returns an
+	// error, the restore fails with it.
+	Restore func() error
+}
+
+// Run the given hook, recording a failure and returning false if it panics,
+// since nothing would recover the panic in raft's goroutines.
+func (f *fsmWrapper) runHook(what string, hook func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.fail("%s panicked: %v", what, r)
+			ok = false
+		}
+	}()
+	hook()
+	return true
+}
+
+// Set the hooks intercepting calls to the wrapped FSM.
+func (f *fsmWrapper) SetHooks(hooks Hooks) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hooks = hooks
+}
+
+// Set the delay to wait before handing each command log to the wrapped FSM.
+func (f *fsmWrapper) SetHandoffDelay(delay time.Duration) {
+	atomic.StoreInt64(&f.handoff, int64(delay))
+}
+
+// Return a channel notified every time n command logs have been applied. It
+// must be called before any command log gets applied.
+func (f *fsmWrapper) SnapshotEvery(n uint64) <-chan struct{} {
+	f.snapshotEvery = n
+	f.snapshotCh = make(chan struct{}, 1)
+	return f.snapshotCh
+}
+
+// Reset the state of this FSM before its raft server gets restarted, since
+// logs will be re-applied from the latest snapshot, if any, or from the
+// beginning.
+func (f *fsmWrapper) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = 0
+	f.index = 0
+	f.goroutine = 0
+	for n := range f.events {
+		delete(f.events, n)
+	}
+}
+
 // Return the total number of command logs applied by this FSM.
 func (f *fsmWrapper) Commands() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.commands
 }
 
+// Return the index of the last command log applied by this FSM.
+func (f *fsmWrapper) Index() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.index
+}
+
+// Return a channel that gets closed once this FSM applies the command log with
+// the given index, or a later one.
+func (f *fsmWrapper) WhenIndex(index uint64) <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan struct{})
+	if f.index >= index {
+		close(ch)
+	} else {
+		f.waiters = append(f.waiters, indexWaiter{index: index, ch: ch})
+	}
+	return ch
+}
+
+// Close the channels of the waiters whose index was reached. Must be called
+// with the lock held.
+func (f *fsmWrapper) notifyWaiters() {
+	waiters := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if f.index >= waiter.index {
+			close(waiter.ch)
+			continue
+		}
+		waiters = append(waiters, waiter)
+	}
+	f.waiters = waiters
+}
+
+// A channel to close once a certain log index is applied.
+type indexWaiter struct {
+	index uint64
+	ch    chan struct{}
+}
+
+// MaxTrackedApplies is the number of most recent log indexes whose apply
+// times are tracked.
+const MaxTrackedApplies = 4096
+
+// Return the start and end time of the last apply of the command log with the
+// given index.
+func (f *fsmWrapper) ApplyTimes(index uint64) (time.Time, time.Time, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	times, ok := f.applies[index]
+	return times[0], times[1], ok
+}
+
+// Return all command logs applied by this FSM so far.
+func (f *fsmWrapper) History() []Applied {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]Applied(nil), f.history...)
+}
+
 // Return the total number of snapshots performed by this FSM.
 func (f *fsmWrapper) Snapshots() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.snapshots
 }
 
 // Return the total number of restores performed by this FSM.
 func (f *fsmWrapper) Restores() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.restores
 }
 
+// Return the longest time for which applies were stalled by a snapshot.
+func (f *fsmWrapper) SnapshotStall() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.snapshotStall
+}
+
+// Return the problems found so far while raft used this FSM.
+func (f *fsmWrapper) Failures() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	failures := make([]string, len(f.failures))
+	copy(failures, f.failures)
+	return failures
+}
+
+// Record a problem found while raft used this FSM.
+func (f *fsmWrapper) fail(format string, args ...interface{}) {
+	failure := fmt.Sprintf("server %s: ", f.id) + fmt.Sprintf(format, args...)
+	f.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: fsm %s: failure: %s", f.id, failure))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, failure)
+}
+
+// Applied holds information about a command log applied by an FSM.
+type Applied struct {
+	Index uint64    // Index of the log
+	Term  uint64    // Term of the log
+	Data  []byte    // Copy of the log data, if recorded
+	Time  time.Time // When the FSM started applying the log
+}
+
+// ReadSnapshotHeader reads the header that the harness prepends to the data
+// of user FSM snapshots, returning the command count it holds. The rest of the
+// data can then be passed to the user FSM.
+func ReadSnapshotHeader(reader io.Reader) (uint64, error) {
+	var commands uint64
+	if err := binary.Read(reader, binary.LittleEndian, &commands); err != nil {
+		return 0, errors.Wrap(err, "failed to restore commands count")
+	}
+	return commands, nil
+}
+
+// WriteSnapshotHeader writes the header that the harness prepends to the data
+// of user FSM snapshots, holding the given command count.
+func WriteSnapshotHeader(writer io.Writer, commands uint64) error {
+	if err := binary.Write(writer, binary.LittleEndian, commands); err != nil {
+		return errors.Wrap(err, "failed to augment snapshot with commands count")
+	}
+	return nil
+}
+
 type fsmSnapshotWrapper struct {
+	fsm      *fsmWrapper
 	commands uint64
 	snapshot raft.FSMSnapshot
 }
 
 func (s *fsmSnapshotWrapper) Persist(sink raft.SnapshotSink) error {
 	// Augment the snapshot with the current command count.
-	if err := binary.Write(sink, binary.LittleEndian, s.commands); err != nil {
-		return errors.Wrap(err, "failed to augment snapshot with commands count")
+	if err := WriteSnapshotHeader(sink, s.commands); err != nil {
+		return err
 	}
 	if err := s.snapshot.Persist(sink); err != nil {
 		return errors.Wrap(err, "failed to perform snapshot on user's FSM")
@@ -185,4 +534,19 @@ func (s *fsmSnapshotWrapper) Persist(sink raft.SnapshotSink) error {
 	return nil
 }
 
-func (s *fsmSnapshotWrapper) Release() {}
+func (s *fsmSnapshotWrapper) Release() {
+	atomic.AddInt32(&s.fsm.persisting, -1)
+}
+
+// Return the ID of the current goroutine, as reported by the first line of its
+// stack trace (e.g. "goroutine 123 [running]:").
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := strings.Fields(strings.TrimPrefix(string(buf), "goroutine "))
+	id, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("can't parse goroutine ID: %v", err))
+	}
+	return id
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsms_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/CanonicalLtd/raft-test/internal/fsms"
+	"github.com/CanonicalLtd/raft-test/internal/logging"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Applying logs from different goroutines is recorded as a failure.
+func TestWrapper_ApplyFromDifferentGoroutines(t *testing.T) {
+	watcher := fsms.New(logging.New(t, "DEBUG"))
+	fsm := watcher.Add("0", &dummyFSM{})
+
+	fsm.Apply(&raft.Log{Index: 1})
+	assert.Empty(t, watcher.Failures("0"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fsm.Apply(&raft.Log{Index: 2})
+	}()
+	<-done
+
+	failures := watcher.Failures("0")
+	assert.Len(t, failures, 1)
+	assert.Contains(t, failures[0], "server 0: FSM apply of log 2 from goroutine ")
+}
+
+type dummyFSM struct{}
+
+func (f *dummyFSM) Apply(*raft.Log) interface{}         { return nil }
+func (f *dummyFSM) Snapshot() (raft.FSMSnapshot, error) { return nil, nil }
+func (f *dummyFSM) Restore(io.ReadCloser) error         { return nil }
@@ -15,7 +15,12 @@
 package logging
 
 import (
-	"testing"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/logutils"
 	"github.com/hashicorp/go-hclog"
@@ -23,7 +28,7 @@ import (
 
 // New returns a standard hclog.Logger that will write entries at or above the
 // specified level to the testing log.
-func New(t testing.TB, level logutils.LogLevel) hclog.Logger {
+func New(t Output, level logutils.LogLevel) hclog.Logger {
 	filter := &logutils.LevelFilter{
 		Levels:   []logutils.LogLevel{"DEBUG", "WARN", "ERROR", "INFO"},
 		MinLevel: level,
@@ -36,10 +41,86 @@ func New(t testing.TB, level logutils.LogLevel) hclog.Logger {
 	})
 }
 
+// NewNode returns a logger for the raft server with the given ID, which writes
+// all entries to the given capture writer, and entries at or above the
+// specified level to the testing log. If t is nil, entries are only captured.
+func NewNode(t Output, level logutils.LogLevel, id string, capture io.Writer) hclog.Logger {
+	var output io.Writer = ioutil.Discard
+	if t != nil {
+		output = &logutils.LevelFilter{
+			Levels:   []logutils.LogLevel{"DEBUG", "WARN", "ERROR", "INFO"},
+			MinLevel: level,
+			Writer:   &testingWriter{t},
+		}
+	}
+
+	return hclog.New(&hclog.LoggerOptions{
+		Name:   fmt.Sprintf("raft-test.%s", id),
+		Output: io.MultiWriter(output, capture),
+	})
+}
+
+// Buffer captures log entries in memory, one line per entry. It's safe for
+// concurrent use.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Entry is a single captured log line, along with the time it was written.
+type Entry struct {
+	Time time.Time
+	Line string
+}
+
+// NewBuffer returns a new empty buffer.
+func NewBuffer() *Buffer {
+	return &Buffer{entries: make([]Entry, 0)}
+}
+
+// Write one or more \n-terminated log entries.
+func (b *Buffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.entries = append(b.entries, Entry{Time: now, Line: line})
+	}
+	return len(p), nil
+}
+
+// Lines returns a copy of all captured entries.
+func (b *Buffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := make([]string, len(b.entries))
+	for i, entry := range b.entries {
+		lines[i] = entry.Line
+	}
+	return lines
+}
+
+// Entries returns a copy of all captured entries, with their time.
+func (b *Buffer) Entries() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := make([]Entry, len(b.entries))
+	copy(entries, b.entries)
+	return entries
+}
+
+// Output is where log entries are forwarded to, typically a testing.TB.
+type Output interface {
+	Logf(format string, args ...interface{})
+}
+
 // Implement io.Writer and forward what it receives to a
 // testing logger.
 type testingWriter struct {
-	t testing.TB
+	t Output
 }
 
 // Write a single log entry. It's assumed that p is always a \n-terminated UTF
@@ -48,3 +129,54 @@ func (w *testingWriter) Write(p []byte) (n int, err error) {
 	w.t.Logf(string(p))
 	return len(p), nil
 }
+
+// Tee returns a logger that forwards entries both to the given logger and to
+// a logger writing them to the given capture writer, like the ones returned by
+// NewNode.
+func Tee(logger hclog.Logger, id string, capture io.Writer) hclog.Logger {
+	return &teeLogger{Logger: logger, capture: NewNode(nil, "", id, capture)}
+}
+
+// Logger forwarding entries to two loggers. Methods that don't emit entries
+// are served by the first one only.
+type teeLogger struct {
+	hclog.Logger
+	capture hclog.Logger
+}
+
+func (l *teeLogger) Trace(msg string, args ...interface{}) {
+	l.Logger.Trace(msg, args...)
+	l.capture.Trace(msg, args...)
+}
+
+func (l *teeLogger) Debug(msg string, args ...interface{}) {
+	l.Logger.Debug(msg, args...)
+	l.capture.Debug(msg, args...)
+}
+
+func (l *teeLogger) Info(msg string, args ...interface{}) {
+	l.Logger.Info(msg, args...)
+	l.capture.Info(msg, args...)
+}
+
+func (l *teeLogger) Warn(msg string, args ...interface{}) {
+	l.Logger.Warn(msg, args...)
+	l.capture.Warn(msg, args...)
+}
+
+func (l *teeLogger) Error(msg string, args ...interface{}) {
+	l.Logger.Error(msg, args...)
+	l.capture.Error(msg, args...)
+}
+
+func (l *teeLogger) With(args ...interface{}) hclog.Logger {
+	return &teeLogger{Logger: l.Logger.With(args...), capture: l.capture.With(args...)}
+}
+
+func (l *teeLogger) Named(name string) hclog.Logger {
+	return &teeLogger{Logger: l.Logger.Named(name), capture: l.capture.Named(name)}
+}
+
+func (l *teeLogger) ResetNamed(name string) hclog.Logger {
+	return &teeLogger{Logger: l.Logger.ResetNamed(name), capture: l.capture.ResetNamed(name)}
+}
@@ -16,6 +16,9 @@ package network
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/CanonicalLtd/raft-test/internal/event"
 	"github.com/hashicorp/go-hclog"
@@ -27,22 +30,44 @@ import (
 type Network struct {
 	logger hclog.Logger
 
-	// Transport wrappers.
+	// Transport wrappers, protected by mu since Add() can be called while
+	// other goroutines look them up.
+	mu         sync.RWMutex
 	transports map[raft.ServerID]*eventTransport
+
+	// Notified of failed heartbeats sent by any transport.
+	heartbeats *heartbeatHook
+
+	// Shared by all transports to inject probabilistic faults.
+	random *Random
 }
 
-// New create a new network for controlling the underlying transports.
-func New(logger hclog.Logger) *Network {
+// New create a new network for controlling the underlying transports,
+// injecting probabilistic faults using a random source with the given seed.
+func New(logger hclog.Logger, seed int64) *Network {
 	return &Network{
 		logger:     logger,
 		transports: make(map[raft.ServerID]*eventTransport),
+		heartbeats: &heartbeatHook{},
+		random:     NewRandom(seed),
 	}
 }
 
+// Seed returns the seed of the random source used to inject probabilistic
+// faults.
+func (n *Network) Seed() int64 {
+	return n.random.Seed()
+}
+
 // Add a new transport to the network. Returns a transport that wraps the given
 // transport with instrumentation to inject disconnections and failures.
 func (n *Network) Add(id raft.ServerID, trans raft.Transport) raft.Transport {
 	transport := newEventTransport(n.logger, id, trans)
+	transport.heartbeats = n.heartbeats
+	transport.peers.random = n.random
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
 	for _, other := range n.transports {
 		transport.AddPeer(other)
@@ -53,6 +78,21 @@ func (n *Network) Add(id raft.ServerID, trans raft.Transport) raft.Transport {
 	return transport
 }
 
+// OnHeartbeatFailure sets a callback invoked whenever a heartbeat sent by any
+// transport fails, either because of an injected fault or because the
+// underlying transport returned an error.
+func (n *Network) OnHeartbeatFailure(f func(source, target raft.ServerID, err error)) {
+	n.heartbeats.Set(f)
+}
+
+// Underlying returns the transport wrapped by the instrumented transport of
+// the server with the given ID.
+func (n *Network) Underlying(id raft.ServerID) raft.Transport {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.transports[id].trans
+}
+
 // Electing resets any leader-related state in the transport associated with
 // given server ID (such as the track of logs appended by the peers), and it
 // connects the transport to all its peers, enabling it to send them RPCs. It
@@ -107,12 +147,236 @@ func (n *Network) Reconnect(id, follower raft.ServerID) {
 	n.transports[id].Reconnect(follower)
 }
 
+// Block drops all RPCs that the transport of the server with the given ID
+// sends to the given peer, regardless of connectivity, until Unblock() is
+// called.
+func (n *Network) Block(id, peer raft.ServerID) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: block RPCs to %s", id, peer))
+	n.transports[id].peers.Get(peer).SetBlocked(true)
+}
+
+// Unblock re-enables RPCs sent by the transport of the server with the given
+// ID to the given peer.
+func (n *Network) Unblock(id, peer raft.ServerID) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: unblock RPCs to %s", id, peer))
+	n.transports[id].peers.Get(peer).SetBlocked(false)
+}
+
+// BlockResponses drops the responses that the given peer sends back to the
+// transport of the server with the given ID, after having handled its RPCs,
+// until UnblockResponses() is called.
+func (n *Network) BlockResponses(id, peer raft.ServerID) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: block responses from %s", id, peer))
+	n.transports[id].peers.Get(peer).SetResponsesBlocked(true)
+}
+
+// UnblockResponses re-enables the responses that the given peer sends back to
+// the transport of the server with the given ID.
+func (n *Network) UnblockResponses(id, peer raft.ServerID) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: unblock responses from %s", id, peer))
+	n.transports[id].peers.Get(peer).SetResponsesBlocked(false)
+}
+
+// UnblockAll re-enables all RPCs and responses blocked with Block() and
+// BlockResponses().
+func (n *Network) UnblockAll() {
+	n.logger.Debug("[DEBUG] raft-test: unblock all RPCs")
+	for _, transport := range n.transports {
+		for _, peer := range transport.peers.All() {
+			peer.SetBlocked(false)
+			peer.SetResponsesBlocked(false)
+		}
+	}
+}
+
+// SetLatency adds the given delay to every RPC that the transport of the
+// server with the given ID sends to the given peer. If jitter is non-zero,
+// each delay is randomized by up to jitter in either direction.
+func (n *Network) SetLatency(id, peer raft.ServerID, latency, jitter time.Duration) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: latency to %s: %s (jitter %s)", id, peer, latency, jitter))
+	n.transports[id].peers.Get(peer).SetLatency(latency, jitter)
+}
+
+// RPC identifies one or more types of RPC sent by a transport.
+type RPC uint8
+
+// Available RPC types.
+const (
+	AppendEntries RPC = 1 << iota
+	RequestVote
+	InstallSnapshot
+)
+
+func (r RPC) String() string {
+	names := []string{}
+	if r&AppendEntries != 0 {
+		names = append(names, "append")
+	}
+	if r&RequestVote != 0 {
+		names = append(names, "vote")
+	}
+	if r&InstallSnapshot != 0 {
+		names = append(names, "snapshot")
+	}
+	return strings.Join(names, "+")
+}
+
+// DropRPCs makes all transports drop the given fraction of the RPCs of the
+// given types. A zero fraction stops dropping RPCs.
+func (n *Network) DropRPCs(rate float64, rpcs RPC) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: drop %g of %s RPCs", rate, rpcs))
+	for _, transport := range n.transports {
+		for _, peer := range transport.peers.All() {
+			peer.SetDropRate(rate, rpcs)
+		}
+	}
+}
+
+// SetMaxSize makes all transports reject RPCs carrying more than the given
+// number of bytes. Zero removes the limit.
+func (n *Network) SetMaxSize(size int64) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: reject RPCs larger than %d bytes", size))
+	for _, transport := range n.transports {
+		for _, peer := range transport.peers.All() {
+			peer.SetMaxSize(size)
+		}
+	}
+}
+
+// SetDuplicateRate makes the transport of the server with the given ID deliver
+// the given fraction of the append entries RPCs sent to the given peer twice.
+func (n *Network) SetDuplicateRate(id, peer raft.ServerID, rate float64) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: duplicate %g of RPCs to %s", id, rate, peer))
+	n.transports[id].peers.Get(peer).SetDuplicateRate(rate)
+}
+
+// SetReorderRate makes the transport of the server with the given ID deliver
+// again the given fraction of the append entries RPCs sent to the given peer,
+// after a newer one.
+func (n *Network) SetReorderRate(id, peer raft.ServerID, rate float64) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: reorder %g of RPCs to %s", id, rate, peer))
+	n.transports[id].peers.Get(peer).SetReorderRate(rate)
+}
+
+// CommandsAppended returns the highest number of command logs that the
+// transport of the server with the given ID has appended to any of its peers
+// since it was last elected.
+func (n *Network) CommandsAppended(id raft.ServerID) uint64 {
+	count := uint64(0)
+	for _, peer := range n.transports[id].peers.All() {
+		if n := peer.CommandLogsCount(); n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+// LinkStatus returns a short description of the link from the transport of
+// the server with the given ID to the given peer, or "?" if there's no such
+// link.
+func (n *Network) LinkStatus(id, peer raft.ServerID) string {
+	n.mu.RLock()
+	transport, ok := n.transports[id]
+	n.mu.RUnlock()
+	if !ok {
+		return "?"
+	}
+	p := transport.peers.Get(peer)
+	if p == nil {
+		return "?"
+	}
+	return p.Status()
+}
+
 // PeerConnected returns whether the peer with the given server ID is connected
 // with the transport of the server with the given ID.
 func (n *Network) PeerConnected(id, peer raft.ServerID) bool {
 	return n.transports[id].PeerConnected(peer)
 }
 
+// LastCommandSentTo returns the highest index of all the command logs that
+// any transport has sent to the server with the given ID.
+func (n *Network) LastCommandSentTo(id raft.ServerID) uint64 {
+	index := uint64(0)
+	for other, transport := range n.transports {
+		if other == id {
+			continue
+		}
+		if last := transport.peers.Get(id).LastCommandIndex(); last > index {
+			index = last
+		}
+	}
+	return index
+}
+
+// HeartbeatOnly sets whether all transports should deliver only heartbeats,
+// dropping any RPC carrying entries or snapshots.
+func (n *Network) HeartbeatOnly(enabled bool) {
+	for _, transport := range n.transports {
+		for _, peer := range transport.peers.All() {
+			peer.SetHeartbeatOnly(enabled)
+		}
+	}
+}
+
+// SetHeartbeatOnly sets whether the transport of the server with the given ID
+// should deliver only heartbeats to the given peer, dropping any RPC carrying
+// entries or snapshots.
+func (n *Network) SetHeartbeatOnly(id, peer raft.ServerID, enabled bool) {
+	n.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: heartbeat only to %s: %v", id, peer, enabled))
+	n.transports[id].peers.Get(peer).SetHeartbeatOnly(enabled)
+}
+
+// Snapshot holds information about a snapshot sent with an install snapshot
+// RPC.
+type Snapshot struct {
+	Source raft.ServerID // Server that sent the snapshot
+	Index  uint64        // Last log index included in the snapshot
+	Term   uint64        // Term of the last log included in the snapshot
+	Time   time.Time     // When the snapshot was sent
+}
+
+// LastSnapshotSentTo returns the last snapshot that any transport has sent to
+// the server with the given ID. It returns false if no snapshot was sent.
+func (n *Network) LastSnapshotSentTo(id raft.ServerID) (Snapshot, bool) {
+	last := Snapshot{}
+	for other, transport := range n.transports {
+		if other == id {
+			continue
+		}
+		if snapshot := transport.peers.Get(id).LastSnapshot(); snapshot.Time.After(last.Time) {
+			last = snapshot
+		}
+	}
+	return last, !last.Time.IsZero()
+}
+
+// SnapshotBytesSentTo returns the total number of snapshot bytes that all
+// transports have sent to the server with the given ID.
+func (n *Network) SnapshotBytesSentTo(id raft.ServerID) uint64 {
+	total := uint64(0)
+	for other, transport := range n.transports {
+		if other == id {
+			continue
+		}
+		total += transport.peers.Get(id).SnapshotBytes()
+	}
+	return total
+}
+
+// AckTimes returns the times at which the followers of the server with the
+// given ID first acknowledged having appended the log with the given index (or
+// a higher one). Followers that never did are not included.
+func (n *Network) AckTimes(id raft.ServerID, index uint64) []time.Time {
+	times := make([]time.Time, 0)
+	for _, peer := range n.transports[id].peers.All() {
+		if t, ok := peer.AckTime(index); ok {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
 // Address returns the address of the server with the given id.
 func (n *Network) Address(id raft.ServerID) raft.ServerAddress {
 	return n.transports[id].LocalAddr()
@@ -27,7 +27,7 @@ import (
 
 func TestNetwork_FaultyEnqueue(t *testing.T) {
 	transports := newTransports(2)
-	network := network.New(logging.New(t, "DEBUG"))
+	network := network.New(logging.New(t, "DEBUG"), 0)
 	for i, transport := range transports {
 		network.Add(itoID(i), transport)
 	}
@@ -16,7 +16,10 @@ package network
 
 import (
 	"fmt"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -25,36 +28,45 @@ import (
 // safety. This bit of information is not on faultyTransport directly, since it
 // needs to be shared between faultyTransport and faultyPipeline.
 type peers struct {
-	peers map[raft.ServerID]*peer
-	mu    sync.RWMutex
+	peers  map[raft.ServerID]*peer
+	random *Random // Given to new peers
+	mu     sync.RWMutex
 }
 
 // Create a new empty peers map.
 func newPeers() *peers {
 	return &peers{
-		peers: make(map[raft.ServerID]*peer),
+		peers:  make(map[raft.ServerID]*peer),
+		random: NewRandom(time.Now().UnixNano()),
 	}
 }
 
 // Add a new peer for the given source and target server IDs.
 func (p *peers) Add(source, target raft.ServerID) {
-	p.peers[target] = newPeer(source, target)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peer := newPeer(source, target)
+	peer.random = p.random
+	p.peers[target] = peer
 }
 
 // Get the peer with the given ID.
 func (p *peers) Get(id raft.ServerID) *peer {
-	// Sinces peers entries are inserted at initialization time by the
-	// Cluster() function, and currently they never change afterwise,
-	// there's no need to protect this method with the mutex.
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.peers[id]
 }
 
 // Return all the peers
 func (p *peers) All() map[raft.ServerID]*peer {
-	// Sinces peers entries are inserted at initialization time by the
-	// Cluster() function, and currently they never change afterwise,
-	// there's no need to protect this method with the mutex.
-	return p.peers
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	peers := make(map[raft.ServerID]*peer, len(p.peers))
+	for id, peer := range p.peers {
+		peers[id] = peer
+	}
+	return peers
 }
 
 // Enable connectivity to all the peers in this map.
@@ -156,6 +168,57 @@ type peer struct {
 	// only logs tagged with the same term the leader was elected at.
 	logs []*raft.Log
 
+	// Index of the last command log entry sent to this peer, regardless of
+	// whether it was actually appended.
+	lastCommandIndex uint64
+
+	// Times at which the peer acknowledged entries, in increasing index
+	// order, and highest index of the acks dropped to keep only the most
+	// recent maxTrackedAcks ones.
+	acks       []ack
+	acksPruned uint64
+
+	// Whether only heartbeats (i.e. append entries RPCs without entries)
+	// should be delivered to the peer.
+	heartbeatOnly bool
+
+	// Number of snapshot bytes sent to the peer.
+	snapshotBytes uint64
+
+	// Last snapshot sent to the peer with an install snapshot RPC, if any.
+	snapshot Snapshot
+
+	// Whether all RPCs to the peer are dropped, regardless of connectivity.
+	blocked bool
+
+	// Whether the responses of the peer to RPCs are dropped, after it has
+	// handled them.
+	responsesBlocked bool
+
+	// Delay added to every RPC sent to the peer, randomized by up to
+	// jitter in either direction.
+	latency time.Duration
+	jitter  time.Duration
+
+	// Fraction of RPCs of the given types that get dropped.
+	dropRate float64
+	dropRPCs RPC
+
+	// Fraction of append entries RPCs that get delivered twice.
+	duplicateRate float64
+
+	// Fraction of append entries RPCs that get delivered again after a
+	// newer one, and the RPC currently held back for that, if any.
+	reorderRate float64
+	held        *raft.AppendEntriesRequest
+
+	// If non-zero, RPCs carrying more than this many bytes get rejected.
+	maxSize int64
+
+	// Decides which RPCs get dropped, duplicated or reordered, and by how
+	// much they get delayed.
+	random *Random
+
 	// Serialize access to internal state.
 	mu sync.RWMutex
 }
@@ -163,8 +226,10 @@ type peer struct {
 // Create a new peer for the given server.
 func newPeer(source, target raft.ServerID) *peer {
 	return &peer{
+		source: source,
 		target: target,
 		logs:   make([]*raft.Log, 0),
+		random: NewRandom(time.Now().UnixNano()),
 	}
 }
 
@@ -284,6 +349,26 @@ func (p *peer) UpdateLogs(logs []*raft.Log) {
 	}
 }
 
+// Record the given entries as sent to this peer.
+func (p *peer) Sent(logs []*raft.Log) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, log := range logs {
+		if log.Type == raft.LogCommand && log.Index > p.lastCommandIndex {
+			p.lastCommandIndex = log.Index
+		}
+	}
+}
+
+// Return the index of the last command log sent to this peer.
+func (p *peer) LastCommandIndex() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.lastCommandIndex
+}
+
 // Return then number of all logs appended so far to this peer.
 func (p *peer) LogsCount() int {
 	p.mu.RLock()
@@ -305,3 +390,288 @@ func (p *peer) CommandLogsCount() uint64 {
 	}
 	return n
 }
+
+// Record that the peer has successfully appended the given entries.
+func (p *peer) Acked(logs []*raft.Log) {
+	if len(logs) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	index := logs[len(logs)-1].Index
+	if n := len(p.acks); n > 0 && p.acks[n-1].index >= index {
+		return
+	}
+	p.acks = append(p.acks, ack{index: index, time: time.Now()})
+
+	// Copy the most recent acks over once in a while, so the backing
+	// array doesn't grow forever.
+	if n := len(p.acks); n >= 2*maxTrackedAcks {
+		p.acksPruned = p.acks[n-maxTrackedAcks-1].index
+		p.acks = append([]ack(nil), p.acks[n-maxTrackedAcks:]...)
+	}
+}
+
+// Number of most recent acks whose time is tracked.
+const maxTrackedAcks = 4096
+
+// Return the time at which the peer first acknowledged an entry with the
+// given index or higher.
+func (p *peer) AckTime(index uint64) (time.Time, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if index <= p.acksPruned {
+		return time.Time{}, false
+	}
+	i := sort.Search(len(p.acks), func(i int) bool { return p.acks[i].index >= index })
+	if i == len(p.acks) {
+		return time.Time{}, false
+	}
+	return p.acks[i].time, true
+}
+
+// Acknowledgement of appended entries up to a certain index.
+type ack struct {
+	index uint64
+	time  time.Time
+}
+
+// Set whether only heartbeats should be delivered to this peer.
+func (p *peer) SetHeartbeatOnly(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.heartbeatOnly = enabled
+}
+
+// Return true if an RPC carrying the given entries should be dropped because
+// only heartbeats are allowed.
+func (p *peer) DropEntries(logs []*raft.Log) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.heartbeatOnly && len(logs) > 0
+}
+
+// Return true if only heartbeats should be delivered to this peer.
+func (p *peer) HeartbeatOnly() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.heartbeatOnly
+}
+
+// Set whether all RPCs to the peer should be dropped, regardless of
+// connectivity.
+func (p *peer) SetBlocked(blocked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocked = blocked
+}
+
+// Return whether all RPCs to the peer are dropped.
+func (p *peer) Blocked() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.blocked
+}
+
+// Set whether the responses of the peer to RPCs should be dropped, after it
+// has handled them.
+func (p *peer) SetResponsesBlocked(blocked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responsesBlocked = blocked
+}
+
+// Return whether the responses of the peer to RPCs are dropped.
+func (p *peer) ResponsesBlocked() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.responsesBlocked
+}
+
+// Set the delay added to every RPC sent to the peer.
+func (p *peer) SetLatency(latency, jitter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency = latency
+	p.jitter = jitter
+}
+
+// Sleep for the configured latency of the link to the peer, if any.
+func (p *peer) Delay() {
+	p.mu.RLock()
+	delay := p.latency
+	if p.jitter > 0 {
+		delay += time.Duration(p.random.Int63n(int64(2*p.jitter)+1)) - p.jitter
+	}
+	p.mu.RUnlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Set the fraction of RPCs of the given types sent to the peer that should be
+// dropped.
+func (p *peer) SetDropRate(rate float64, rpcs RPC) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropRate = rate
+	p.dropRPCs = rpcs
+}
+
+// Return true if the given RPC should be dropped.
+func (p *peer) Drop(rpc RPC) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.dropRPCs&rpc == 0 || p.dropRate == 0 {
+		return false
+	}
+	return p.random.Float64() < p.dropRate
+}
+
+// Set the maximum number of bytes that an RPC sent to the peer can carry. Zero
+// means no limit.
+func (p *peer) SetMaxSize(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxSize = size
+}
+
+// Return true if an RPC carrying the given number of bytes is too large to be
+// sent to the peer.
+func (p *peer) TooLarge(size int64) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.maxSize > 0 && size > p.maxSize
+}
+
+// Set the fraction of append entries RPCs sent to the peer that should be
+// duplicated.
+func (p *peer) SetDuplicateRate(rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.duplicateRate = rate
+}
+
+// Return true if an append entries RPC should be delivered twice.
+func (p *peer) Duplicate() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.duplicateRate > 0 && p.random.Float64() < p.duplicateRate
+}
+
+// Set the fraction of append entries RPCs sent to the peer that should be
+// delivered again after a newer one.
+func (p *peer) SetReorderRate(rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reorderRate = rate
+	if rate == 0 {
+		p.held = nil
+	}
+}
+
+// Possibly hold back a copy of the given append entries RPC, to deliver it
+// after the next one. Return the copy that was previously held back, if any.
+func (p *peer) Reorder(args *raft.AppendEntriesRequest) *raft.AppendEntriesRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	held := p.held
+	p.held = nil
+	if p.reorderRate > 0 && p.random.Float64() < p.reorderRate {
+		request := *args
+		p.held = &request
+	}
+	return held
+}
+
+// Return a short description of the link to the peer, e.g. "up", "down",
+// "syncing" or "blocked", followed by any fault affecting it.
+func (p *peer) Status() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	status := "down"
+	switch {
+	case p.blocked:
+		status = "blocked"
+	case p.connected:
+		status = "up"
+	case p.allowSyncing:
+		status = "syncing"
+	}
+	if p.responsesBlocked {
+		status += ",responses-blocked"
+	}
+	if p.heartbeatOnly {
+		status += ",heartbeat-only"
+	}
+	if p.dropRate > 0 && p.dropRPCs != 0 {
+		status += fmt.Sprintf(",drop=%s:%g", p.dropRPCs, p.dropRate)
+	}
+	if p.duplicateRate > 0 {
+		status += fmt.Sprintf(",duplicate=%g", p.duplicateRate)
+	}
+	if p.reorderRate > 0 {
+		status += fmt.Sprintf(",reorder=%g", p.reorderRate)
+	}
+	if p.maxSize > 0 {
+		status += fmt.Sprintf(",max-size=%d", p.maxSize)
+	}
+	if p.latency > 0 || p.jitter > 0 {
+		status += fmt.Sprintf(",delay=%s", p.latency)
+		if p.jitter > 0 {
+			status += fmt.Sprintf("±%s", p.jitter)
+		}
+	}
+	return status
+}
+
+// Record that a snapshot with the given metadata is being sent to the peer.
+func (p *peer) SendingSnapshot(args *raft.InstallSnapshotRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshot = Snapshot{
+		Source: p.source,
+		Index:  args.LastLogIndex,
+		Term:   args.LastLogTerm,
+		Time:   time.Now(),
+	}
+}
+
+// Return the last snapshot sent to the peer.
+func (p *peer) LastSnapshot() Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.snapshot
+}
+
+// Record that the given number of snapshot bytes was sent to the peer.
+func (p *peer) SentSnapshotBytes(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshotBytes += uint64(n)
+}
+
+// Return the number of snapshot bytes sent to the peer.
+func (p *peer) SnapshotBytes() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.snapshotBytes
+}
+
+// Wrap a snapshot data reader, counting the bytes sent to a peer.
+type snapshotReader struct {
+	reader io.Reader
+	peer   *peer
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.peer.SentSnapshotBytes(n)
+	return n, err
+}
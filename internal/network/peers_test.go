@@ -114,3 +114,18 @@ func TestPeer_CommandLogsCount(t *testing.T) {
 	})
 	assert.Equal(t, uint64(1), peer.CommandLogsCount())
 }
+
+// Only the most recent acks are tracked.
+func TestPeer_AckTime_Pruned(t *testing.T) {
+	peer := newPeer("0", "1")
+	for i := 1; i <= 2*maxTrackedAcks; i++ {
+		peer.Acked([]*raft.Log{{Type: raft.LogCommand, Term: 1, Index: uint64(i)}})
+	}
+
+	_, ok := peer.AckTime(1)
+	assert.False(t, ok)
+	_, ok = peer.AckTime(maxTrackedAcks + 1)
+	assert.True(t, ok)
+	_, ok = peer.AckTime(2 * maxTrackedAcks)
+	assert.True(t, ok)
+}
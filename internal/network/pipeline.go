@@ -33,6 +33,11 @@ type eventPipeline struct {
 	// Server ID this pipeline is sending RPCs to.
 	target raft.ServerID
 
+	// Address of the target server and transport for sending it RPCs
+	// outside of the pipeline, for duplicating or reordering them.
+	address raft.ServerAddress
+	trans   raft.Transport
+
 	// Regular pipeline that we are wrapping.
 	pipeline raft.AppendPipeline
 
@@ -77,16 +82,48 @@ func (p *eventPipeline) AppendEntries(
 		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: not connected", p.source, p.target))
 		return nil, fmt.Errorf("cannot reach server %s", p.target)
 	}
+	if peer.Blocked() {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: blocked", p.source, p.target))
+		return nil, fmt.Errorf("cannot reach server %s", p.target)
+	}
 
 	if faulty && p.schedule.IsAppendFault() {
 		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: append fault: command %d", p.source, p.target, p.schedule.n))
 		p.failure = args.Entries[0].Index
 	}
 
+	if peer.DropEntries(args.Entries) {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: heartbeat only", p.source, p.target))
+		return nil, fmt.Errorf("cannot reach server %s", p.target)
+	}
+
+	if peer.Drop(AppendEntries) {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: dropped", p.source, p.target))
+		return nil, fmt.Errorf("cannot reach server %s", p.target)
+	}
+
+	if size := entriesSize(args.Entries); peer.TooLarge(size) {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: too large (%d bytes)", p.source, p.target, size))
+		return nil, fmt.Errorf("message to server %s too large (%d bytes)", p.target, size)
+	}
+
+	peer.Delay()
+	peer.Sent(args.Entries)
+	held := peer.Reorder(args)
+
 	future, err := p.pipeline.AppendEntries(args, resp)
 	if err != nil {
 		return nil, err
 	}
+
+	if held != nil {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: reorder", p.source, p.target))
+		redeliver(p.logger, p.source, p.trans, p.target, p.address, held)
+	}
+	if peer.Duplicate() {
+		p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: duplicate", p.source, p.target))
+		redeliver(p.logger, p.source, p.trans, p.target, p.address, args)
+	}
 	peer.UpdateLogs(args.Entries)
 
 	if faulty && p.schedule.IsEnqueueFault() {
@@ -119,6 +156,11 @@ func (p *eventPipeline) Consumer() <-chan raft.AppendFuture {
 					p.schedule.OccurredOn(p.target)
 					p.schedule.event.Block()
 					future = &appendFutureWrapper{id: p.target, future: future, failing: true}
+				} else if p.peers.Get(p.target).ResponsesBlocked() {
+					p.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: pipeline: append to %s: response blocked", p.source, p.target))
+					future = &appendFutureWrapper{id: p.target, future: future, failing: true}
+				} else if future.Error() == nil && future.Response().Success {
+					p.peers.Get(p.target).Acked(entries)
 				}
 				ch <- future
 			case <-p.shutdownCh:
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Random is a seeded source of pseudo-random numbers, safe for concurrent use,
+// which decides which RPCs get affected by probabilistic faults, so a failing
+// run can be reproduced using the same seed.
+type Random struct {
+	seed int64
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewRandom creates a new source of pseudo-random numbers with the given seed.
+func NewRandom(seed int64) *Random {
+	return &Random{
+		seed: seed,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Seed returns the seed of the source.
+func (r *Random) Seed() int64 {
+	return r.seed
+}
+
+// Float64 returns a pseudo-random number in [0.0,1.0).
+func (r *Random) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64()
+}
+
+// Int63n returns a non-negative pseudo-random number in [0,n).
+func (r *Random) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Int63n(n)
+}
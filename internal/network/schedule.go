@@ -52,6 +52,8 @@ func newSchedule() *schedule {
 
 // Add a server to the list of peers where the event should occurr.
 func (s *schedule) AddPeer(id raft.ServerID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.peers = append(s.peers, id)
 	s.occurred = append(s.occurred, false)
 }
@@ -17,6 +17,7 @@ package network
 import (
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/CanonicalLtd/raft-test/internal/event"
 	"github.com/hashicorp/go-hclog"
@@ -40,6 +41,9 @@ type eventTransport struct {
 	// Schedule and event that should happen in this transport during a
 	// term.
 	schedule *schedule
+
+	// Notified of failed heartbeats, if set.
+	heartbeats *heartbeatHook
 }
 
 // Create a new transport wrapper..
@@ -73,6 +77,9 @@ func (t *eventTransport) AppendEntriesPipeline(
 		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: not connected", t.id, id))
 		return nil, fmt.Errorf("cannot reach server %s", id)
 	}
+	if t.peers.Get(id).Blocked() {
+		return nil, fmt.Errorf("cannot reach server %s", id)
+	}
 	if !t.peers.Get(id).Connected() {
 		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: syncing logs", t.id, id))
 	}
@@ -86,6 +93,8 @@ func (t *eventTransport) AppendEntriesPipeline(
 		logger:     t.logger,
 		source:     t.id,
 		target:     id,
+		address:    target,
+		trans:      t.trans,
 		pipeline:   pipeline,
 		peers:      t.peers,
 		schedule:   t.schedule,
@@ -100,6 +109,18 @@ func (t *eventTransport) AppendEntries(
 	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
 	resp *raft.AppendEntriesResponse) error {
 
+	err := t.appendEntries(id, target, args, resp)
+	if err != nil && len(args.Entries) == 0 && t.heartbeats != nil {
+		t.heartbeats.Fire(t.id, id, err)
+	}
+	return err
+}
+
+// Send an AppendEntries RPC, injecting faults as configured.
+func (t *eventTransport) appendEntries(
+	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+
 	peer := t.peers.Get(id)
 	t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: %s", t.id, id, stringifyLogs(args.Entries)))
 
@@ -119,20 +140,59 @@ func (t *eventTransport) AppendEntries(
 		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: not connected", t.id, id))
 		return fmt.Errorf("cannot reach server %s", id)
 	}
+	if peer.Blocked() {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: blocked", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
 	if !t.peers.Get(id).Connected() {
 		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: syncing logs", t.id, id))
 	}
 
+	if peer.DropEntries(args.Entries) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: heartbeat only", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+
+	if peer.Drop(AppendEntries) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: dropped", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+
+	if size := entriesSize(args.Entries); peer.TooLarge(size) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: too large (%d bytes)", t.id, id, size))
+		return fmt.Errorf("message to server %s too large (%d bytes)", id, size)
+	}
+
+	peer.Delay()
+	peer.Sent(args.Entries)
+	held := peer.Reorder(args)
+
 	if err := t.trans.AppendEntries(id, target, args, resp); err != nil {
 		return err
 	}
 
+	if held != nil {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: reorder", t.id, id))
+		redeliver(t.logger, t.id, t.trans, id, target, held)
+	}
+	if peer.Duplicate() {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: duplicate", t.id, id))
+		redeliver(t.logger, t.id, t.trans, id, target, args)
+	}
+
 	// Check for a newer term, stop running
 	if resp.Term > args.Term {
 		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: newer term", t.id, id))
 	}
 
 	peer.UpdateLogs(args.Entries)
+	if peer.ResponsesBlocked() {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: response blocked", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+	if resp.Success {
+		peer.Acked(args.Entries)
+	}
 
 	if faulty && t.schedule.IsEnqueueFault() {
 		t.schedule.OccurredOn(id)
@@ -151,8 +211,24 @@ func (t *eventTransport) RequestVote(
 	if !t.peers.Get(id).Connected() {
 		return fmt.Errorf("connectivity to server %s is down", id)
 	}
+	if t.peers.Get(id).Blocked() {
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+	if t.peers.Get(id).Drop(RequestVote) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: request vote to %s: dropped", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+	t.peers.Get(id).Delay()
+
+	if err := t.trans.RequestVote(id, target, args, resp); err != nil {
+		return err
+	}
+	if t.peers.Get(id).ResponsesBlocked() {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: request vote to %s: response blocked", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
 
-	return t.trans.RequestVote(id, target, args, resp)
+	return nil
 }
 
 // InstallSnapshot is used to push a snapshot down to a follower. The data is read from
@@ -164,7 +240,29 @@ func (t *eventTransport) InstallSnapshot(
 	if !t.peers.Get(id).Connected() {
 		return fmt.Errorf("connectivity to server %s is down", id)
 	}
-	return t.trans.InstallSnapshot(id, target, args, resp, data)
+	if t.peers.Get(id).HeartbeatOnly() || t.peers.Get(id).Blocked() {
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+	if t.peers.Get(id).Drop(InstallSnapshot) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: install snapshot to %s: dropped", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+	if t.peers.Get(id).TooLarge(args.Size) {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: install snapshot to %s: too large (%d bytes)", t.id, id, args.Size))
+		return fmt.Errorf("message to server %s too large (%d bytes)", id, args.Size)
+	}
+	t.peers.Get(id).Delay()
+	t.peers.Get(id).SendingSnapshot(args)
+	data = &snapshotReader{reader: data, peer: t.peers.Get(id)}
+	if err := t.trans.InstallSnapshot(id, target, args, resp, data); err != nil {
+		return err
+	}
+	if t.peers.Get(id).ResponsesBlocked() {
+		t.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: install snapshot to %s: response blocked", t.id, id))
+		return fmt.Errorf("cannot reach server %s", id)
+	}
+
+	return nil
 }
 
 // EncodePeer is used to serialize a peer's address.
@@ -255,6 +353,29 @@ func (t *eventTransport) HasAppendedLogsTo(id raft.ServerID) bool {
 	return peer.LogsCount() > 0
 }
 
+// Return the number of bytes of log data carried by the given entries.
+func entriesSize(entries []*raft.Log) int64 {
+	size := int64(0)
+	for _, entry := range entries {
+		size += int64(len(entry.Data) + len(entry.Extensions))
+	}
+	return size
+}
+
+// Deliver again the given append entries RPC sent by the server with the given
+// source ID, discarding the response, as if the network had duplicated it or
+// delayed it past newer RPCs. A failure is only logged, since the original RPC
+// got delivered anyway.
+func redeliver(
+	logger hclog.Logger, source raft.ServerID, trans raft.Transport,
+	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest) {
+
+	request := *args
+	if err := trans.AppendEntries(id, target, &request, &raft.AppendEntriesResponse{}); err != nil {
+		logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: transport: append to %s: redeliver failed: %v", source, id, err))
+	}
+}
+
 // Schedule the n'th command log to fail to be appended to the
 // followers. Return an event that will fire when all followers have reached
 // this failure.
@@ -272,3 +393,28 @@ func (t *eventTransport) ScheduleAppendFailure(n uint64) *event.Event {
 	t.schedule.AppendFailure(n, event)
 	return event
 }
+
+// Callback invoked whenever a heartbeat, i.e. an AppendEntries RPC without
+// entries, fails.
+type heartbeatHook struct {
+	mu sync.RWMutex
+	f  func(source, target raft.ServerID, err error)
+}
+
+// Set the callback.
+func (h *heartbeatHook) Set(f func(source, target raft.ServerID, err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.f = f
+}
+
+// Invoke the callback, if set.
+func (h *heartbeatHook) Fire(source, target raft.ServerID, err error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.f != nil {
+		h.f(source, target, err)
+	}
+}
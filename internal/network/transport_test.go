@@ -51,6 +51,44 @@ func TestFaultyTransport_AppendEntries_Connected(t *testing.T) {
 	assert.True(t, transport0.HasAppendedLogsTo("1"))
 }
 
+// If the responses of the target node are blocked, the append entries RPC
+// gets handled but fails.
+func TestFaultyTransport_AppendEntries_ResponsesBlocked(t *testing.T) {
+	transports, cleanup := newTransports(t, 2)
+	defer cleanup()
+
+	transport0 := transports["0"]
+	transport0.Electing()
+	transport0.peers.Get("1").SetResponsesBlocked(true)
+
+	args, resp := newAppendEntries(1, raft.LogNoop)
+	err := transport0.AppendEntries("1", "1", args, resp)
+	require.EqualError(t, err, "cannot reach server 1")
+	assert.True(t, transport0.HasAppendedLogsTo("1"))
+}
+
+// If the responses of the target node are blocked, the futures of pipeline
+// append entries RPCs fail.
+func TestFaultyTransport_PipelineAppendEntries_ResponsesBlocked(t *testing.T) {
+	transports, cleanup := newTransports(t, 2)
+	defer cleanup()
+
+	transport0 := transports["0"]
+	transport0.Electing()
+	transport0.peers.Get("1").SetResponsesBlocked(true)
+
+	pipeline0, err := transport0.AppendEntriesPipeline("1", "1")
+	require.NoError(t, err)
+	defer pipeline0.Close()
+
+	args, resp := newAppendEntries(0)
+	_, err = pipeline0.AppendEntries(args, resp)
+	require.NoError(t, err)
+
+	future := <-pipeline0.Consumer()
+	assert.EqualError(t, future.Error(), "cannot reach server 1")
+}
+
 // By a pipeline append entries RPC to target server fails if the peer is not
 // connected.
 func TestFaultyTransport_PipelineAppendEntries_Disconnected(t *testing.T) {
@@ -0,0 +1,155 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Wrap a regular raft.LogStore, injecting faults on writes.
+type logStoreWrapper struct {
+	id     raft.ServerID
+	store  raft.LogStore
+	stores *Stores
+
+	// Start and end time of the last write of each log index, for the
+	// latest MaxTrackedWrites indexes written in the latest term.
+	writes map[uint64]span
+	term   uint64
+	mu     sync.Mutex
+
+	// If true, the server has crashed and writes fail.
+	crashed bool
+
+	// If non-negative, number of writes that will succeed before writes
+	// start failing.
+	failAfter int
+}
+
+// ErrCrashed is returned by writes performed by a server that has crashed.
+var ErrCrashed = errors.New("server crashed")
+
+// ErrFault is returned by writes failing because of an injected fault.
+var ErrFault = errors.New("injected log store fault")
+
+func (s *logStoreWrapper) FirstIndex() (uint64, error) {
+	return s.store.FirstIndex()
+}
+
+func (s *logStoreWrapper) LastIndex() (uint64, error) {
+	return s.store.LastIndex()
+}
+
+func (s *logStoreWrapper) GetLog(index uint64, log *raft.Log) error {
+	return s.store.GetLog(index, log)
+}
+
+func (s *logStoreWrapper) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *logStoreWrapper) StoreLogs(logs []*raft.Log) error {
+	if err := s.fault(); err != nil {
+		return err
+	}
+	start := time.Now()
+	s.delay()
+	if err := s.store.StoreLogs(logs); err != nil {
+		return err
+	}
+	end := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, log := range logs {
+		// Writes performed in previous terms are not reported, so
+		// forget about them.
+		if log.Term > s.term {
+			s.term = log.Term
+			s.writes = make(map[uint64]span)
+		}
+		s.writes[log.Index] = span{start: start, end: end, term: log.Term}
+		if log.Index > MaxTrackedWrites {
+			delete(s.writes, log.Index-MaxTrackedWrites)
+		}
+	}
+
+	return nil
+}
+
+// MaxTrackedWrites is the number of most recent log indexes whose write times
+// are tracked.
+const MaxTrackedWrites = 4096
+
+// Return the start and end time and the term of the last write of the given
+// log index.
+func (s *logStoreWrapper) writeSpan(index uint64) (span, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span, ok := s.writes[index]
+	return span, ok
+}
+
+// A time interval spent writing a log of the given term.
+type span struct {
+	start time.Time
+	end   time.Time
+	term  uint64
+}
+
+func (s *logStoreWrapper) DeleteRange(min, max uint64) error {
+	if err := s.fault(); err != nil {
+		return err
+	}
+	return s.store.DeleteRange(min, max)
+}
+
+// Close the wrapped store, if it implements io.Closer.
+func (s *logStoreWrapper) Close() error {
+	if closer, ok := s.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Sleep for the amount of time that writes should currently take.
+func (s *logStoreWrapper) delay() {
+	if delay := s.stores.writeDelay(s.id); delay != 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Return the error that a write should fail with, if any.
+func (s *logStoreWrapper) fault() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return ErrCrashed
+	}
+	switch {
+	case s.failAfter == 0:
+		return ErrFault
+	case s.failAfter > 0:
+		s.failAfter--
+	}
+	return nil
+}
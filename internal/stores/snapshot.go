@@ -0,0 +1,172 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// Corruption is a way of damaging the content of a snapshot.
+type Corruption int
+
+// Available corruptions.
+const (
+	// Keep only the first half of the snapshot data.
+	Truncate Corruption = iota + 1
+
+	// Flip a bit in the middle of the snapshot data.
+	BitFlip
+)
+
+// Apply the corruption to the given data.
+func (c Corruption) apply(data []byte) []byte {
+	switch c {
+	case Truncate:
+		return data[:len(data)/2]
+	case BitFlip:
+		if len(data) > 0 {
+			data[len(data)/2] ^= 0x01
+		}
+	}
+	return data
+}
+
+// Wrap a regular raft.SnapshotStore, injecting faults on snapshot data.
+type snapshotStoreWrapper struct {
+	store raft.SnapshotStore
+
+	mu sync.Mutex
+
+	// If true, the server has crashed and no snapshot can be created.
+	crashed bool
+
+	// If non-zero, corruption to apply to the next snapshot created.
+	create Corruption
+
+	// If non-zero, corruption to apply to the next snapshot opened.
+	open Corruption
+}
+
+func (s *snapshotStoreWrapper) Create(
+	version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return nil, ErrCrashed
+	}
+
+	sink, err := s.store.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	sink = &crashingSink{SnapshotSink: sink, store: s}
+	if s.create == 0 {
+		return sink, nil
+	}
+
+	corruption := s.create
+	s.create = 0
+
+	return &corruptingSink{SnapshotSink: sink, corruption: corruption}, nil
+}
+
+func (s *snapshotStoreWrapper) List() ([]*raft.SnapshotMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store.List()
+}
+
+func (s *snapshotStoreWrapper) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, reader, err := s.store.Open(id)
+	if err != nil || s.open == 0 {
+		return meta, reader, err
+	}
+
+	corruption := s.open
+	s.open = 0
+
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return meta, ioutil.NopCloser(bytes.NewReader(corruption.apply(data))), nil
+}
+
+// Discard a snapshot sink that gets closed after its server has crashed, so
+// snapshots in progress at the time of the crash are not persisted.
+//
+// Raft closes sinks after FSMSnapshot.Persist() returns, while Persist() is
+// supposed to close them too, so Close() is a no-op after the first call.
+type crashingSink struct {
+	raft.SnapshotSink
+	store  *snapshotStoreWrapper
+	closed bool
+}
+
+func (s *crashingSink) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.store.mu.Lock()
+	crashed := s.store.crashed
+	s.store.mu.Unlock()
+
+	if crashed {
+		s.SnapshotSink.Cancel()
+		return ErrCrashed
+	}
+	return s.SnapshotSink.Close()
+}
+
+// Buffer the data written to a snapshot sink, corrupting it before it gets
+// written to the wrapped sink upon the first Close().
+type corruptingSink struct {
+	raft.SnapshotSink
+	corruption Corruption
+	buffer     bytes.Buffer
+	closed     bool
+}
+
+func (s *corruptingSink) Write(p []byte) (int, error) {
+	return s.buffer.Write(p)
+}
+
+func (s *corruptingSink) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if _, err := s.SnapshotSink.Write(s.corruption.apply(s.buffer.Bytes())); err != nil {
+		s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores_test
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/raft-test/internal/logging"
+	"github.com/CanonicalLtd/raft-test/internal/stores"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Raft closes snapshot sinks after FSM snapshots have closed them already, so
+// the data of a corrupted snapshot must be written only once.
+func TestSnapshotStore_CorruptClosedTwice(t *testing.T) {
+	s := stores.New(logging.New(t, "DEBUG"))
+	store := &countingStore{SnapshotStore: raft.NewInmemSnapshotStore()}
+	snaps := s.AddSnapshots("0", store)
+	s.CorruptSnapshot("0", stores.Truncate, false)
+
+	sink, err := snaps.Create(1, 1, 1, raft.Configuration{}, 0, nil)
+	require.NoError(t, err)
+	_, err = sink.Write([]byte("hello world"))
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Close())
+	require.NoError(t, sink.Close())
+
+	assert.Equal(t, 1, store.sink.writes)
+	assert.Equal(t, 1, store.sink.closes)
+}
+
+// Snapshot store counting the calls made to the sinks it creates.
+type countingStore struct {
+	raft.SnapshotStore
+	sink *countingSink
+}
+
+func (s *countingStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := s.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	s.sink = &countingSink{SnapshotSink: sink}
+	return s.sink, nil
+}
+
+type countingSink struct {
+	raft.SnapshotSink
+	writes int
+	closes int
+}
+
+func (s *countingSink) Write(p []byte) (int, error) {
+	s.writes++
+	return s.SnapshotSink.Write(p)
+}
+
+func (s *countingSink) Close() error {
+	s.closes++
+	return s.SnapshotSink.Close()
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// SnapshotStore is an in-memory raft.SnapshotStore that retains the content
+// of its snapshots, so it can be inspected after the fact.
+//
+// Like raft.FileSnapshotStore, only the given number of most recent snapshots
+// is retained, unless KeepAll() is called.
+type SnapshotStore struct {
+	mu        sync.Mutex
+	snapshots []*snapshot
+	seq       uint64
+	retain    int // Number of snapshots to retain, or zero for all
+}
+
+// A snapshot whose sink was closed successfully.
+type snapshot struct {
+	meta raft.SnapshotMeta
+	data []byte
+	seq  uint64
+}
+
+// NewSnapshotStore creates a new in-memory snapshot store retaining the given
+// number of most recent snapshots, which must be at least one.
+func NewSnapshotStore(retain int) *SnapshotStore {
+	return &SnapshotStore{retain: retain}
+}
+
+// KeepAll makes the store retain every snapshot ever taken, instead of only
+// the most recent ones.
+func (s *SnapshotStore) KeepAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retain = 0
+}
+
+// Create implements raft.SnapshotStore.
+func (s *SnapshotStore) Create(
+	version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	sink := &snapshotSink{
+		store: s,
+		snapshot: &snapshot{
+			meta: raft.SnapshotMeta{
+				Version:            version,
+				ID:                 fmt.Sprintf("%d-%d-%d", term, index, s.seq),
+				Index:              index,
+				Term:               term,
+				Configuration:      configuration,
+				ConfigurationIndex: configurationIndex,
+			},
+			seq: s.seq,
+		},
+	}
+
+	return sink, nil
+}
+
+// List implements raft.SnapshotStore, returning all snapshots newest first.
+func (s *SnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metas := make([]*raft.SnapshotMeta, len(s.snapshots))
+	for i, snapshot := range s.snapshots {
+		meta := snapshot.meta
+		metas[i] = &meta
+	}
+
+	return metas, nil
+}
+
+// Open implements raft.SnapshotStore.
+func (s *SnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, snapshot := range s.snapshots {
+		if snapshot.meta.ID == id {
+			meta := snapshot.meta
+			return &meta, ioutil.NopCloser(bytes.NewReader(snapshot.data)), nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("snapshot %s not found", id)
+}
+
+// Add the given snapshot, keeping the newest first and reaping the ones that
+// exceed the retain count.
+func (s *SnapshotStore) add(snapshot *snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots = append(s.snapshots, snapshot)
+	sort.Slice(s.snapshots, func(i, j int) bool {
+		a, b := s.snapshots[i], s.snapshots[j]
+		if a.meta.Term != b.meta.Term {
+			return a.meta.Term > b.meta.Term
+		}
+		if a.meta.Index != b.meta.Index {
+			return a.meta.Index > b.meta.Index
+		}
+		return a.seq > b.seq
+	})
+	if s.retain > 0 && len(s.snapshots) > s.retain {
+		s.snapshots = s.snapshots[:s.retain]
+	}
+}
+
+// Buffer the content of a snapshot, adding it to its store upon Close().
+type snapshotSink struct {
+	store    *SnapshotStore
+	snapshot *snapshot
+	buffer   bytes.Buffer
+	done     bool
+}
+
+func (s *snapshotSink) Write(p []byte) (int, error) {
+	return s.buffer.Write(p)
+}
+
+func (s *snapshotSink) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+
+	s.snapshot.data = s.buffer.Bytes()
+	s.snapshot.meta.Size = int64(len(s.snapshot.data))
+	s.store.add(s.snapshot)
+
+	return nil
+}
+
+func (s *snapshotSink) ID() string {
+	return s.snapshot.meta.ID
+}
+
+func (s *snapshotSink) Cancel() error {
+	s.done = true
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrStableFault is returned by stable store writes failing because of an
+// injected fault.
+var ErrStableFault = errors.New("injected stable store fault")
+
+// Wrap a regular raft.StableStore, injecting faults on writes.
+type stableStoreWrapper struct {
+	store raft.StableStore
+
+	mu sync.Mutex
+
+	// If true, the server has crashed and writes are silently dropped.
+	crashed bool
+
+	// If non-negative, number of writes that will succeed before writes
+	// start failing.
+	failAfter int
+
+	// If not empty, only writes of these keys are subject to failures.
+	keys map[string]bool
+}
+
+func (s *stableStoreWrapper) Set(key []byte, val []byte) error {
+	dropped, err := s.fault(key)
+	if err != nil || dropped {
+		return err
+	}
+	return s.store.Set(key, val)
+}
+
+func (s *stableStoreWrapper) Get(key []byte) ([]byte, error) {
+	return s.store.Get(key)
+}
+
+func (s *stableStoreWrapper) SetUint64(key []byte, val uint64) error {
+	dropped, err := s.fault(key)
+	if err != nil || dropped {
+		return err
+	}
+	return s.store.SetUint64(key, val)
+}
+
+func (s *stableStoreWrapper) GetUint64(key []byte) (uint64, error) {
+	return s.store.GetUint64(key)
+}
+
+// Return whether a write of the given key should be dropped or, if not, the
+// error it should fail with, if any.
+//
+// Writes performed after a crash are dropped rather than failed, since raft
+// panics when it can't persist its term or vote, while the process it
+// approximates would simply be gone.
+func (s *stableStoreWrapper) fault(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return true, nil
+	}
+	if len(s.keys) > 0 && !s.keys[string(key)] {
+		return false, nil
+	}
+	switch {
+	case s.failAfter == 0:
+		return false, ErrStableFault
+	case s.failAfter > 0:
+		s.failAfter--
+	}
+	return false, nil
+}
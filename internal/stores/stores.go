@@ -0,0 +1,215 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stores
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// Stores instruments the log stores of all servers of a cluster, injecting
+// faults into them.
+type Stores struct {
+	logger hclog.Logger
+
+	// Log store wrappers.
+	logs map[raft.ServerID]*logStoreWrapper
+
+	// Stable store wrappers.
+	stables map[raft.ServerID]*stableStoreWrapper
+
+	// Snapshot store wrappers.
+	snapshots map[raft.ServerID]*snapshotStoreWrapper
+
+	mu sync.RWMutex
+
+	// Delay applied to writes performed by the leader, if any.
+	leaderDelay time.Duration
+
+	// Return true if the server with the given ID is the leader.
+	isLeader func(raft.ServerID) bool
+}
+
+// New creates a new Stores object for instrumenting log stores.
+func New(logger hclog.Logger) *Stores {
+	return &Stores{
+		logger:    logger,
+		logs:      make(map[raft.ServerID]*logStoreWrapper),
+		stables:   make(map[raft.ServerID]*stableStoreWrapper),
+		snapshots: make(map[raft.ServerID]*snapshotStoreWrapper),
+	}
+}
+
+// Add a log store to be instrumented. Returns a LogStore that wraps the given
+// one.
+func (s *Stores) Add(id raft.ServerID, store raft.LogStore) raft.LogStore {
+	s.logs[id] = &logStoreWrapper{
+		id:        id,
+		store:     store,
+		stores:    s,
+		writes:    make(map[uint64]span),
+		failAfter: -1,
+	}
+	return s.logs[id]
+}
+
+// AddStable adds a stable store to be instrumented. Returns a StableStore that
+// wraps the given one.
+func (s *Stores) AddStable(id raft.ServerID, store raft.StableStore) raft.StableStore {
+	s.stables[id] = &stableStoreWrapper{
+		store:     store,
+		failAfter: -1,
+	}
+	return s.stables[id]
+}
+
+// AddSnapshots adds a snapshot store to be instrumented. Returns a
+// SnapshotStore that wraps the given one.
+func (s *Stores) AddSnapshots(id raft.ServerID, store raft.SnapshotStore) raft.SnapshotStore {
+	s.snapshots[id] = &snapshotStoreWrapper{store: store}
+	return s.snapshots[id]
+}
+
+// Replace swaps the log, stable and snapshot stores wrapped for the server
+// with the given ID, for instance because they were reopened from disk. The
+// server must not be running.
+func (s *Stores) Replace(id raft.ServerID, logs raft.LogStore, stable raft.StableStore, snaps raft.SnapshotStore) {
+	store := s.logs[id]
+	store.mu.Lock()
+	store.store = logs
+	store.mu.Unlock()
+
+	s.stables[id].mu.Lock()
+	s.stables[id].store = stable
+	s.stables[id].mu.Unlock()
+
+	s.snapshots[id].mu.Lock()
+	s.snapshots[id].store = snaps
+	s.snapshots[id].mu.Unlock()
+}
+
+// Get returns the instrumented log store of the server with the given ID.
+func (s *Stores) Get(id raft.ServerID) raft.LogStore {
+	return s.logs[id]
+}
+
+// CurrentTerm returns the current term persisted in the stable store of the
+// server with the given ID, or zero if none was persisted yet.
+func (s *Stores) CurrentTerm(id raft.ServerID) uint64 {
+	term, err := s.stables[id].GetUint64([]byte("CurrentTerm"))
+	if err != nil {
+		return 0
+	}
+	return term
+}
+
+// WriteTimes returns the start and end time of the last write of the log with
+// the given index to the log store of the server with the given ID, along
+// with the term of the log written. Only the latest MaxTrackedWrites indexes
+// written in the latest term are tracked.
+func (s *Stores) WriteTimes(id raft.ServerID, index uint64) (time.Time, time.Time, uint64, bool) {
+	span, ok := s.logs[id].writeSpan(index)
+	return span.start, span.end, span.term, ok
+}
+
+// Crash freezes the log, stable and snapshot stores of the server with the
+// given ID as they were at the time of the crash: log writes and snapshot
+// creations fail with ErrCrashed, and stable store writes are dropped. If
+// crashed is false, writes are allowed again.
+func (s *Stores) Crash(id raft.ServerID, crashed bool) {
+	logs := s.logs[id]
+	logs.mu.Lock()
+	logs.crashed = crashed
+	logs.mu.Unlock()
+
+	stable := s.stables[id]
+	stable.mu.Lock()
+	stable.crashed = crashed
+	stable.mu.Unlock()
+
+	snaps := s.snapshots[id]
+	snaps.mu.Lock()
+	snaps.crashed = crashed
+	snaps.mu.Unlock()
+}
+
+// FailAfter makes writes to the log store of the server with the given ID
+// fail with ErrFault after n more successful writes. A negative n disables
+// the fault.
+func (s *Stores) FailAfter(id raft.ServerID, n int) {
+	store := s.logs[id]
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.failAfter = n
+}
+
+// FailStableAfter makes writes to the stable store of the server with the
+// given ID fail with ErrStableFault after n more successful writes. If any key
+// is given, only writes of those keys are affected. A negative n disables the
+// fault.
+func (s *Stores) FailStableAfter(id raft.ServerID, n int, keys ...string) {
+	store := s.stables[id]
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.failAfter = n
+	store.keys = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		store.keys[key] = true
+	}
+}
+
+// CorruptSnapshot applies the given corruption to the data of the next
+// snapshot created in the snapshot store of the server with the given ID or,
+// if shipped is true, to the data of the next snapshot opened from it, such as
+// the one sent by a leader to a follower lagging behind.
+func (s *Stores) CorruptSnapshot(id raft.ServerID, corruption Corruption, shipped bool) {
+	store := s.snapshots[id]
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if shipped {
+		store.open = corruption
+	} else {
+		store.create = corruption
+	}
+}
+
+// SlowLeader makes log store writes performed by the current leader, as
+// determined by the given function, take at least the given amount of
+// time. A zero delay disables the fault.
+func (s *Stores) SlowLeader(delay time.Duration, isLeader func(raft.ServerID) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.leaderDelay = delay
+	s.isLeader = isLeader
+}
+
+// Return the delay that should be applied to a write performed by the server
+// with the given ID.
+func (s *Stores) writeDelay(id raft.ServerID) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.leaderDelay == 0 || !s.isLeader(id) {
+		return 0
+	}
+	return s.leaderDelay
+}
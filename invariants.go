@@ -0,0 +1,217 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Interval at which the invariants checker observes the cluster.
+const invariantsInterval = 5 * time.Millisecond
+
+// Continuously observes all servers of a cluster, recording violations of the
+// core raft invariants, see the Invariants option.
+type invariantsChecker struct {
+	control *Control
+	done    chan struct{}
+
+	mu sync.Mutex
+
+	// Leader observed in each term.
+	leaders map[uint64]raft.ServerID
+
+	// Highest term observed for each server.
+	terms map[raft.ServerID]uint64
+
+	// Entries applied by any FSM, and thus committed, in index order.
+	commits []committedEntry
+
+	// Number of history entries already observed for each server.
+	cursors map[raft.ServerID]int
+
+	// Violations found so far, without duplicates.
+	violations []string
+	seen       map[string]bool
+}
+
+// An entry known to be committed.
+type committedEntry struct {
+	index uint64
+	term  uint64
+}
+
+func newInvariantsChecker(control *Control) *invariantsChecker {
+	return &invariantsChecker{
+		control: control,
+		done:    make(chan struct{}),
+		leaders: make(map[uint64]raft.ServerID),
+		terms:   make(map[raft.ServerID]uint64),
+		cursors: make(map[raft.ServerID]int),
+		seen:    make(map[string]bool),
+	}
+}
+
+// Observe the cluster until it gets closed.
+func (i *invariantsChecker) run() {
+	defer close(i.done)
+
+	ticker := time.NewTicker(invariantsInterval)
+	defer ticker.Stop()
+
+	for {
+		i.observe()
+		select {
+		case <-i.control.stopCh:
+			i.observe()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Take a single observation of all servers.
+func (i *invariantsChecker) observe() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	c := i.control
+
+	// Committed entries must never be replaced by different ones.
+	for _, d := range c.allNodes() {
+		id := d.Conf.LocalID
+		history := c.watcher.History(id)
+		for _, applied := range history[i.cursors[id]:] {
+			i.commit(id, applied.Index, applied.Term)
+		}
+		i.cursors[id] = len(history)
+	}
+
+	for id, r := range c.running() {
+		// Terms are persisted before servers act on them, and a leader
+		// steps down before changing term, so if the stored term is the
+		// same before and after seeing the leader state, the server was
+		// the leader of that term.
+		term := c.stores.CurrentTerm(id)
+		state := r.State()
+		if c.stores.CurrentTerm(id) != term {
+			continue
+		}
+
+		if term < i.terms[id] {
+			i.violate("server %s: term went back from %d to %d", id, i.terms[id], term)
+		} else {
+			i.terms[id] = term
+		}
+
+		if state != raft.Leader {
+			continue
+		}
+
+		if leader, ok := i.leaders[term]; ok && leader != id {
+			i.violate("term %d: both server %s and server %s were leaders", term, leader, id)
+		} else {
+			i.leaders[term] = id
+		}
+
+		i.checkLeaderLog(id, term)
+	}
+}
+
+// Record that the given server applied the entry with the given index and
+// term.
+func (i *invariantsChecker) commit(id raft.ServerID, index, term uint64) {
+	n := sort.Search(len(i.commits), func(j int) bool { return i.commits[j].index >= index })
+	if n < len(i.commits) && i.commits[n].index == index {
+		if i.commits[n].term != term {
+			i.violate(
+				"server %s: applied entry %d from term %d, but term %d was committed",
+				id, index, term, i.commits[n].term)
+		}
+		return
+	}
+	i.commits = append(i.commits, committedEntry{})
+	copy(i.commits[n+1:], i.commits[n:])
+	i.commits[n] = committedEntry{index: index, term: term}
+}
+
+// Check that the log of the given leader of the given term holds all entries
+// committed in that term or earlier ones. It's enough to check the last of
+// them, since logs that agree on an entry agree on all preceding ones.
+func (i *invariantsChecker) checkLeaderLog(id raft.ServerID, term uint64) {
+	n := sort.Search(len(i.commits), func(j int) bool { return i.commits[j].term > term })
+	if n == 0 {
+		return
+	}
+	entry := i.commits[n-1]
+
+	store := i.control.stores.Get(id)
+	log := raft.Log{}
+	err := store.GetLog(entry.index, &log)
+	if err == nil && log.Term == entry.term {
+		return
+	}
+	if err != nil {
+		if err != raft.ErrLogNotFound {
+			return
+		}
+		// The entry might have been compacted after a snapshot.
+		first, err := store.FirstIndex()
+		if err != nil || first > entry.index {
+			return
+		}
+		i.violate("term %d: leader %s lost committed entry %d", term, id, entry.index)
+		return
+	}
+	i.violate(
+		"term %d: leader %s has entry %d from term %d, but term %d was committed",
+		term, id, entry.index, log.Term, entry.term)
+}
+
+// Record a violation, unless it was already recorded.
+func (i *invariantsChecker) violate(format string, args ...interface{}) {
+	violation := fmt.Sprintf(format, args...)
+	if i.seen[violation] {
+		return
+	}
+	i.seen[violation] = true
+	i.violations = append(i.violations, violation)
+	i.control.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: invariants: violation: %s", violation))
+}
+
+// Wait for the checker to stop and return all violations found.
+func (i *invariantsChecker) Stop() []string {
+	<-i.done
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.violations
+}
+
+// Fail the test if any raft invariant was violated, when using the Invariants
+// option.
+func (c *Control) checkInvariants() {
+	if c.invariants == nil {
+		return
+	}
+	for _, violation := range c.invariants.Stop() {
+		c.t.Errorf("raft-test: close: invariant violated: %s", violation)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// No invariant is violated by regular leadership changes and restarts.
+func TestInvariants(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Invariants())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{1}, time.Second).Error())
+	control.Barrier()
+	control.Depose()
+
+	control.Elect("1")
+	require.NoError(t, rafts["1"].Apply([]byte{2}, time.Second).Error())
+	control.Barrier()
+
+	control.Kill(rafts["2"])
+	control.Restart(2)
+	require.NoError(t, rafts["1"].Apply([]byte{3}, time.Second).Error())
+	control.Barrier()
+}
+
+// If the leader loses committed entries, the test fails.
+func TestInvariants_LostEntry(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	stores := make([]*raft.InmemStore, 3)
+	factory := func(i int) raft.LogStore {
+		stores[i] = raft.NewInmemStore()
+		return stores[i]
+	}
+	rafts, control := rafttest.Cluster(
+		rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LogStore(factory),
+		rafttest.Invariants(), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	future := rafts["0"].Apply([]byte{1}, time.Second)
+	require.NoError(t, future.Error())
+	control.Barrier()
+
+	require.NoError(t, stores[0].DeleteRange(1, future.Index()+1))
+	time.Sleep(50 * time.Millisecond)
+
+	control.Close()
+	assert.Regexp(t, `invariant violated: term \d+: leader 0 lost committed entry`, buffer.String())
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// KVFSM is a simple key-value store FSM, mapping string keys to string
+// values, with working snapshots and restores.
+//
+// Command logs are built with KVSet(), KVDelete() and KVGet(). Logs with
+// empty data, such as the ones applied by the harness itself, are ignored.
+type KVFSM struct {
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// NewKVFSM creates a new, empty, key-value store FSM.
+func NewKVFSM() *KVFSM {
+	return &KVFSM{data: make(map[string]string)}
+}
+
+// KVFSMs creates the given number of key-value store FSMs. Use a type
+// assertion to *KVFSM to read their state.
+func KVFSMs(n int) []raft.FSM {
+	fsms := make([]raft.FSM, n)
+	for i := range fsms {
+		fsms[i] = NewKVFSM()
+	}
+	return fsms
+}
+
+// KVSet returns the data of a command log setting the given key to the given
+// value.
+func KVSet(key, value string) []byte {
+	return encodeKVCommand(kvCommand{Op: "set", Key: key, Value: value})
+}
+
+// KVDelete returns the data of a command log deleting the given key.
+func KVDelete(key string) []byte {
+	return encodeKVCommand(kvCommand{Op: "delete", Key: key})
+}
+
+// KVGet returns the data of a command log reading the value of the given key,
+// which is returned as the response of the log, or the empty string if the
+// key is not set. Reading through the log makes the read linearizable.
+func KVGet(key string) []byte {
+	return encodeKVCommand(kvCommand{Op: "get", Key: key})
+}
+
+// Get returns the value of the given key, and whether it's set.
+func (f *KVFSM) Get(key string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	value, ok := f.data[key]
+	return value, ok
+}
+
+// Len returns the number of keys currently set.
+func (f *KVFSM) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.data)
+}
+
+// State returns a copy of all keys and values, as a map[string]string.
+func (f *KVFSM) State() interface{} {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.copy()
+}
+
+// Apply sets, deletes or reads a key, returning an error if the command log
+// data is invalid.
+func (f *KVFSM) Apply(log *raft.Log) interface{} {
+	if len(log.Data) == 0 {
+		return nil
+	}
+
+	command := kvCommand{}
+	if err := json.Unmarshal(log.Data, &command); err != nil {
+		return fmt.Errorf("invalid kv command at index %d: %v", log.Index, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch command.Op {
+	case "set":
+		f.data[command.Key] = command.Value
+	case "delete":
+		delete(f.data, command.Key)
+	case "get":
+		return f.data[command.Key]
+	default:
+		return fmt.Errorf("unknown kv operation %q at index %d", command.Op, log.Index)
+	}
+
+	return nil
+}
+
+// Snapshot returns a snapshot of all keys and values.
+func (f *KVFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return &kvSnapshot{data: f.copy()}, nil
+}
+
+// Restore replaces all keys and values with the ones in the snapshot.
+func (f *KVFSM) Restore(reader io.ReadCloser) error {
+	defer reader.Close()
+
+	data := make(map[string]string)
+	if err := json.NewDecoder(reader).Decode(&data); err != nil {
+		return fmt.Errorf("invalid kv snapshot: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.data = data
+
+	return nil
+}
+
+// Return a copy of the data. Must be called with the lock held.
+func (f *KVFSM) copy() map[string]string {
+	data := make(map[string]string, len(f.data))
+	for key, value := range f.data {
+		data[key] = value
+	}
+	return data
+}
+
+// A command log of KVFSM.
+type kvCommand struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+func encodeKVCommand(command kvCommand) []byte {
+	data, err := json.Marshal(command)
+	if err != nil {
+		panic(fmt.Sprintf("can't encode kv command: %v", err))
+	}
+	return data
+}
+
+// KVModel returns a Model of KVFSM, for checking histories of commands built
+// with KVSet(), KVDelete() and KVGet() with CheckLinearizable().
+func KVModel() Model {
+	return kvModel{}
+}
+
+// Sequential specification of KVFSM. Its state is a map[string]string that is
+// copied on write.
+type kvModel struct{}
+
+func (kvModel) Init() interface{} {
+	return map[string]string{}
+}
+
+func (kvModel) Step(state interface{}, command []byte) (interface{}, interface{}) {
+	data := state.(map[string]string)
+
+	decoded := kvCommand{}
+	if err := json.Unmarshal(command, &decoded); err != nil {
+		return data, nil
+	}
+
+	switch decoded.Op {
+	case "get":
+		return data, data[decoded.Key]
+	case "set", "delete":
+	default:
+		return data, nil
+	}
+
+	next := make(map[string]string, len(data))
+	for key, value := range data {
+		next[key] = value
+	}
+	if decoded.Op == "set" {
+		next[decoded.Key] = decoded.Value
+	} else {
+		delete(next, decoded.Key)
+	}
+
+	return next, nil
+}
+
+// kvSnapshot holds a copy of the data of a KVFSM.
+type kvSnapshot struct {
+	data map[string]string
+}
+
+// Persist writes the data to the sink, encoded as JSON.
+func (s *kvSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release is a no-op.
+func (s *kvSnapshot) Release() {}
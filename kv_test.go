@@ -0,0 +1,65 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Key-value FSMs apply set and delete commands.
+func TestKVFSMs(t *testing.T) {
+	fsms := rafttest.KVFSMs(3)
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	for _, data := range [][]byte{rafttest.KVSet("a", "1"), rafttest.KVSet("b", "2"), rafttest.KVDelete("a")} {
+		future := r.Apply(data, time.Second)
+		require.NoError(t, future.Error())
+		assert.Nil(t, future.Response())
+	}
+
+	future := r.Apply([]byte("garbage"), time.Second)
+	require.NoError(t, future.Error())
+	assert.Error(t, future.Response().(error))
+
+	control.Barrier()
+
+	kv := fsms[2].(*rafttest.KVFSM)
+	_, ok := kv.Get("a")
+	assert.False(t, ok)
+	value, ok := kv.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, "2", value)
+	assert.Equal(t, 1, kv.Len())
+	assert.Equal(t, map[string]string{"b": "2"}, kv.State())
+}
+
+// The state of key-value FSMs survives snapshots and restores.
+func TestKVFSM_RoundTrip(t *testing.T) {
+	logs := []*raft.Log{
+		{Index: 1, Data: rafttest.KVSet("a", "1")},
+		{Index: 2, Data: rafttest.KVSet("b", "2")},
+	}
+	rafttest.RoundTripFSM(t, func() raft.FSM { return rafttest.NewKVFSM() }, logs)
+}
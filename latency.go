@@ -0,0 +1,152 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test/internal/fsms"
+	"github.com/hashicorp/raft"
+)
+
+// LatencyBreakdown holds statistics about the time spent by command logs in
+// each phase of their lifecycle on the leader.
+type LatencyBreakdown struct {
+	Append      PhaseLatency // Writing the log to the leader's log store
+	Replication PhaseLatency // From the end of the leader write to a quorum of acks
+	Commit      PhaseLatency // From the quorum of acks to the start of the FSM apply
+	Apply       PhaseLatency // Applying the log to the leader's FSM
+}
+
+func (b LatencyBreakdown) String() string {
+	return fmt.Sprintf(
+		"append: %s\nreplication: %s\ncommit: %s\napply: %s",
+		b.Append, b.Replication, b.Commit, b.Apply)
+}
+
+// PhaseLatency holds statistics about the duration of a single phase.
+type PhaseLatency struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func (p PhaseLatency) String() string {
+	return fmt.Sprintf("count=%d mean=%s p50=%s p99=%s max=%s", p.Count, p.Mean, p.P50, p.P99, p.Max)
+}
+
+// LatencyBreakdown breaks down the latency of the command logs applied by
+// the leader's FSM during the current term into the append, replication,
+// commit and apply phases, using the instrumentation of log stores,
+// transports and FSMs.
+//
+// Only the most recent 4096 command logs are considered. Command logs whose
+// phases can't be fully reconstructed (e.g. because they were appended during
+// a previous term) are not included.
+func (c *Control) LatencyBreakdown() LatencyBreakdown {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: latency breakdown: no leader was elected")
+	}
+	leader := c.term.id
+
+	// Number of follower acks needed to reach a quorum.
+	voters := 0
+	for _, server := range c.configuration(leader).Servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	needed := voters / 2
+
+	term := c.stores.CurrentTerm(leader)
+
+	var appends, replications, commits, applies []time.Duration
+	first := uint64(1)
+	last := c.watcher.LastIndex(leader)
+	if last > fsms.MaxTrackedApplies {
+		first = last - fsms.MaxTrackedApplies + 1
+	}
+	for index := first; index <= last; index++ {
+		applyStart, applyEnd, ok := c.watcher.ApplyTimes(leader, index)
+		if !ok {
+			continue
+		}
+		writeStart, writeEnd, writeTerm, ok := c.stores.WriteTimes(leader, index)
+		if !ok || writeTerm != term || writeEnd.After(applyStart) {
+			continue
+		}
+
+		quorum := writeEnd
+		if needed > 0 {
+			acks := make([]time.Time, 0)
+			for _, ack := range c.network.AckTimes(leader, index) {
+				if !ack.Before(writeEnd) {
+					acks = append(acks, ack)
+				}
+			}
+			if len(acks) < needed {
+				continue
+			}
+			sort.Slice(acks, func(i, j int) bool { return acks[i].Before(acks[j]) })
+			quorum = acks[needed-1]
+		}
+
+		commit := applyStart.Sub(quorum)
+		if commit < 0 {
+			commit = 0
+		}
+
+		appends = append(appends, writeEnd.Sub(writeStart))
+		replications = append(replications, quorum.Sub(writeEnd))
+		commits = append(commits, commit)
+		applies = append(applies, applyEnd.Sub(applyStart))
+	}
+
+	return LatencyBreakdown{
+		Append:      newPhaseLatency(appends),
+		Replication: newPhaseLatency(replications),
+		Commit:      newPhaseLatency(commits),
+		Apply:       newPhaseLatency(applies),
+	}
+}
+
+// Compute statistics about the given durations.
+func newPhaseLatency(durations []time.Duration) PhaseLatency {
+	n := len(durations)
+	if n == 0 {
+		return PhaseLatency{}
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	total := time.Duration(0)
+	for _, duration := range durations {
+		total += duration
+	}
+
+	return PhaseLatency{
+		Count: n,
+		Mean:  total / time.Duration(n),
+		P50:   durations[percentileIndex(n, 50)],
+		P99:   durations[percentileIndex(n, 99)],
+		Max:   durations[n-1],
+	}
+}
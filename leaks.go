@@ -0,0 +1,89 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"strings"
+	"time"
+)
+
+// Return the IDs of all running goroutines, mapped to their stack traces, and
+// the ID of the calling goroutine.
+func goroutines() (map[string]string, string) {
+	stacks := make(map[string]string)
+	self := ""
+	for _, stack := range strings.Split(allStacks(), "\n\n") {
+		// The first line is something like "goroutine 42 [running]:",
+		// and the calling goroutine comes first.
+		fields := strings.Fields(stack)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		if self == "" {
+			self = fields[1]
+		}
+		stacks[fields[1]] = stack
+	}
+	return stacks, self
+}
+
+// Return the stacks of the goroutines running raft or harness code that were
+// not in the given baseline, excluding the calling goroutine and the ones
+// running tests.
+func leakedGoroutines(baseline map[string]string) []string {
+	stacks, self := goroutines()
+
+	leaked := []string{}
+	for id, stack := range stacks {
+		if _, ok := baseline[id]; ok || id == self {
+			continue
+		}
+		if !strings.Contains(stack, "github.com/hashicorp/raft") &&
+			!strings.Contains(stack, "github.com/CanonicalLtd/raft-test") {
+			continue
+		}
+		if strings.Contains(stack, "testing.tRunner") {
+			continue
+		}
+		leaked = append(leaked, stack)
+	}
+	return leaked
+}
+
+// Check that all goroutines spawned by the cluster have exited, if requested
+// with the LeakCheck option.
+func (c *Control) checkLeaks() {
+	if c.goroutines == nil {
+		return
+	}
+
+	// Some goroutines might take a little while to notice the shutdown. Use
+	// wall time rather than c.clock, since a manual clock might never be
+	// advanced again at this point.
+	var leaked []string
+	deadline := time.Now().Add(Duration(2 * time.Second))
+	for {
+		leaked = leakedGoroutines(c.goroutines)
+		if len(leaked) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.t.Errorf("raft-test: close: %d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+}
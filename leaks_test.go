@@ -0,0 +1,88 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A cluster that was closed cleanly leaks no goroutine.
+func TestLeakCheck(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LeakCheck(), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Close()
+
+	assert.NotContains(t, buffer.String(), "leaked")
+}
+
+// Deposing the leader leaves no goroutine behind.
+func TestLeakCheck_Depose(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LeakCheck(), rafttest.DiscardLogger())
+
+	control.Elect("0")
+	control.Depose()
+	control.Close()
+
+	assert.NotContains(t, buffer.String(), "leaked")
+}
+
+// Goroutines still running after the cluster got closed are reported.
+func TestLeakCheck_Leak(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LeakCheck(), rafttest.DiscardLogger())
+
+	control.Elect("0")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		<-done
+	}()
+
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: 1 goroutines leaked")
+	assert.Contains(t, buffer.String(), "TestLeakCheck_Leak")
+}
+
+// Leaks are reported even if the cluster uses a manual clock that nobody
+// advances.
+func TestLeakCheck_ManualClock(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	clock := rafttest.NewManualClock(time.Now())
+	_, control := rafttest.Cluster(
+		rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.LeakCheck(),
+		rafttest.TimeSource(clock), rafttest.DiscardLogger())
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		<-done
+	}()
+
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: 1 goroutines leaked")
+}
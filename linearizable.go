@@ -0,0 +1,287 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Model is a sequential specification of an FSM, against which the history of
+// commands applied with Control.Apply() is checked by CheckLinearizable().
+type Model interface {
+	// Init returns the initial state of the FSM.
+	Init() interface{}
+
+	// Step applies the given command to the given state, returning the new
+	// state and the response that the FSM is expected to return. It must
+	// not modify the given state in place.
+	//
+	// States are compared by their Go-syntax representation as printed by
+	// the fmt package, so they should be values such as maps, slices or
+	// structs, not pointers.
+	Step(state interface{}, command []byte) (interface{}, interface{})
+}
+
+// Operation is a command applied with Control.Apply(), as recorded in the
+// history checked by CheckLinearizable().
+type Operation struct {
+	Server   raft.ServerID // Server the command was applied to
+	Command  []byte        // Data of the command log
+	Index    uint64        // Index of the command log, zero if not dispatched
+	Response interface{}   // Response returned by the FSM, if any
+	Err      error         // Error returned by the future, if any
+	Call     time.Time     // When the command was applied
+	Return   time.Time     // When the application got the result, if it did
+}
+
+// Apply applies the given command to the given server, like raft.Apply()
+// does, recording the invocation in the history of operations checked by
+// CheckLinearizable().
+//
+// The operation completes when the application calls Error() on the returned
+// future. Operations never completed, or completed with an error after their
+// command log was dispatched, such as raft.ErrLeadershipLost, are treated as
+// indeterminate: their command may or may not have been applied.
+func (c *Control) Apply(r *raft.Raft, command []byte, timeout time.Duration) raft.ApplyFuture {
+	c.t.Helper()
+
+	id := c.serverID(r, "apply")
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: apply recorded command", id))
+
+	recorded := &recordedApply{
+		op: &Operation{
+			Server:  id,
+			Command: command,
+			Call:    time.Now(),
+		},
+		operations: c.operations,
+	}
+	c.operations.Add(recorded.op)
+
+	recorded.ApplyFuture = r.Apply(command, timeout)
+
+	return recorded
+}
+
+// Operations returns all operations recorded so far by Apply(), in order of
+// invocation.
+func (c *Control) Operations() []Operation {
+	return c.operations.List()
+}
+
+// CheckLinearizable fails the test if the history of the operations recorded
+// so far by Apply() is not linearizable with respect to the given model, that
+// is if there's no way to order the operations sequentially such that:
+//
+// - each operation takes effect at some point between its invocation and the
+// moment the application got its result;
+//
+// - the responses returned by the FSM match the ones of the model when
+// applying the commands in that order.
+//
+// Indeterminate operations may take effect at any point after their
+// invocation, or not at all, and their responses are not checked.
+func (c *Control) CheckLinearizable(model Model) {
+	c.t.Helper()
+
+	operations := c.Operations()
+	if linearizable(model, operations) {
+		return
+	}
+
+	lines := make([]string, len(operations))
+	for i, op := range operations {
+		lines[i] = op.String()
+	}
+	c.t.Fatalf(
+		"raft-test: linearizability: history of %d operations is not linearizable:\n%s",
+		len(operations), strings.Join(lines, "\n"))
+}
+
+// String returns a human-readable description of the operation.
+func (o Operation) String() string {
+	result := "indeterminate"
+	if o.determinate() {
+		result = fmt.Sprintf("-> %v", o.Response)
+	} else if o.Err != nil {
+		result = fmt.Sprintf("indeterminate (%v)", o.Err)
+	}
+	returned := "-"
+	if !o.Return.IsZero() {
+		returned = o.Return.Format(time.StampMicro)
+	}
+	return fmt.Sprintf(
+		"server %s: index %d: %q %s [%s, %s]", o.Server, o.Index, o.Command, result,
+		o.Call.Format(time.StampMicro), returned)
+}
+
+// Return true if the operation has completed successfully.
+func (o Operation) determinate() bool {
+	return !o.Return.IsZero() && o.Err == nil
+}
+
+// Return true if the operation has failed before its command log was
+// dispatched, in which case it certainly had no effect.
+func (o Operation) failed() bool {
+	return !o.Return.IsZero() && o.Err != nil && o.Index == 0
+}
+
+// Check whether the given operations are linearizable with respect to the
+// given model, by searching depth-first for a valid order, memoizing the
+// combinations of linearized operations and model states already explored.
+func linearizable(model Model, operations []Operation) bool {
+	ops := make([]Operation, 0, len(operations))
+	pending := 0
+	for _, op := range operations {
+		if op.failed() {
+			continue
+		}
+		if op.determinate() {
+			pending++
+		}
+		ops = append(ops, op)
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Call.Before(ops[j].Call) })
+
+	done := make([]bool, len(ops))
+	visited := map[string]bool{}
+
+	var search func(state interface{}, pending int) bool
+	search = func(state interface{}, pending int) bool {
+		if pending == 0 {
+			return true
+		}
+
+		key := linearizationKey(done, state)
+		if visited[key] {
+			return false
+		}
+		visited[key] = true
+
+		// Only operations invoked before the earliest return of the
+		// pending determinate ones can take effect next.
+		var deadline time.Time
+		for i, op := range ops {
+			if done[i] || !op.determinate() {
+				continue
+			}
+			if deadline.IsZero() || op.Return.Before(deadline) {
+				deadline = op.Return
+			}
+		}
+
+		for i, op := range ops {
+			if done[i] {
+				continue
+			}
+			if op.Call.After(deadline) {
+				break
+			}
+			next, response := model.Step(state, op.Command)
+			left := pending
+			if op.determinate() {
+				if !reflect.DeepEqual(response, op.Response) {
+					continue
+				}
+				left--
+			}
+			done[i] = true
+			if search(next, left) {
+				return true
+			}
+			done[i] = false
+		}
+
+		return false
+	}
+
+	return search(model.Init(), pending)
+}
+
+// Return a key identifying the given set of linearized operations and model
+// state.
+func linearizationKey(done []bool, state interface{}) string {
+	key := make([]byte, len(done), len(done)+64)
+	for i, d := range done {
+		if d {
+			key[i] = '1'
+		} else {
+			key[i] = '0'
+		}
+	}
+	return string(key) + fmt.Sprintf("%#v", state)
+}
+
+// Apply future returned by Control.Apply().
+type recordedApply struct {
+	raft.ApplyFuture
+	op         *Operation
+	operations *operationRecorder
+	once       sync.Once
+}
+
+// Error waits for the wrapped future and records the result of the
+// operation.
+func (f *recordedApply) Error() error {
+	err := f.ApplyFuture.Error()
+	f.once.Do(func() {
+		f.operations.Complete(f.op, f.ApplyFuture.Index(), f.ApplyFuture.Response(), err)
+	})
+	return err
+}
+
+// Keep track of the operations performed with Control.Apply().
+type operationRecorder struct {
+	mu         sync.Mutex
+	operations []*Operation
+}
+
+// Add an operation that was just invoked.
+func (r *operationRecorder) Add(op *Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.operations = append(r.operations, op)
+}
+
+// Record the result of the given operation.
+func (r *operationRecorder) Complete(op *Operation, index uint64, response interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op.Index = index
+	op.Response = response
+	op.Err = err
+	op.Return = time.Now()
+}
+
+// Return a copy of all operations recorded so far.
+func (r *operationRecorder) List() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	operations := make([]Operation, len(r.operations))
+	for i, op := range r.operations {
+		operations[i] = *op
+	}
+	return operations
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Commands applied concurrently by several clients form a linearizable
+// history.
+func TestControl_CheckLinearizable(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.KVFSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				command := rafttest.KVGet("x")
+				if j%2 == 0 {
+					command = rafttest.KVSet("x", fmt.Sprintf("%d-%d", i, j))
+				}
+				assert.NoError(t, control.Apply(r, command, time.Second).Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	operations := control.Operations()
+	require.Len(t, operations, 15)
+	for _, op := range operations {
+		assert.Equal(t, "0", string(op.Server))
+		assert.NotZero(t, op.Index)
+		assert.False(t, op.Return.Before(op.Call))
+	}
+
+	control.CheckLinearizable(rafttest.KVModel())
+}
+
+// An operation whose result was never observed may or may not have taken
+// effect.
+func TestControl_CheckLinearizable_Indeterminate(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.KVFSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	control.Apply(r, rafttest.KVSet("x", "1"), time.Second)
+	control.Barrier()
+
+	future := control.Apply(r, rafttest.KVGet("x"), time.Second)
+	require.NoError(t, future.Error())
+	assert.Equal(t, "1", future.Response())
+
+	control.CheckLinearizable(rafttest.KVModel())
+}
+
+// If the responses of the FSM don't match the model, the test fails.
+func TestControl_CheckLinearizable_Violation(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.KVFSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	require.NoError(t, control.Apply(r, rafttest.KVSet("x", "1"), time.Second).Error())
+	require.NoError(t, control.Apply(r, rafttest.KVGet("x"), time.Second).Error())
+
+	assert.Panics(t, func() { control.CheckLinearizable(forgetfulModel{}) })
+	assert.Contains(t, buffer.String(), "history of 2 operations is not linearizable")
+	assert.Contains(t, buffer.String(), `"{\"op\":\"get\",\"key\":\"x\"}" -> 1`)
+}
+
+// Model of a key-value store that never remembers anything.
+type forgetfulModel struct{}
+
+func (forgetfulModel) Init() interface{} {
+	return nil
+}
+
+func (forgetfulModel) Step(state interface{}, command []byte) (interface{}, interface{}) {
+	return nil, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test/internal/logging"
+	"github.com/hashicorp/raft"
+)
+
+// LogEntry is a single entry logged by the raft instance of a server, as
+// returned by Control.LogEntries().
+type LogEntry struct {
+	Server  raft.ServerID
+	Time    time.Time // When the entry was logged
+	Level   string    // Level of the entry, such as "INFO" or "WARN"
+	Message string    // Text of the entry, without timestamp, level and logger name
+}
+
+// LogEntries is a sequence of entries logged by raft.
+type LogEntries []LogEntry
+
+// LogEntries returns all entries logged so far by the raft instance of the
+// server with the given ID, in order. Like Logs(), entries are captured even
+// if the DiscardLogger option is used.
+func (c *Control) LogEntries(id raft.ServerID) LogEntries {
+	c.t.Helper()
+
+	captured := c.node(id).Capture.Entries()
+	entries := make(LogEntries, len(captured))
+	for i, entry := range captured {
+		entries[i] = parseLogEntry(id, entry.Time, entry.Line)
+	}
+	return entries
+}
+
+// Level returns the entries logged at the given level, such as "WARN".
+func (e LogEntries) Level(level string) LogEntries {
+	level = strings.ToUpper(level)
+	return e.filter(func(entry LogEntry) bool { return entry.Level == level })
+}
+
+// Matching returns the entries whose message matches the given regular
+// expression.
+func (e LogEntries) Matching(re *regexp.Regexp) LogEntries {
+	return e.filter(func(entry LogEntry) bool { return re.MatchString(entry.Message) })
+}
+
+// Since returns the entries logged at or after the given time.
+func (e LogEntries) Since(t time.Time) LogEntries {
+	return e.filter(func(entry LogEntry) bool { return !entry.Time.Before(t) })
+}
+
+// Elections returns the entries related to elections: a server starting one,
+// becoming a candidate or a leader, and requesting, granting or rejecting
+// votes.
+func (e LogEntries) Elections() LogEntries {
+	return e.Matching(electionLogs)
+}
+
+// Messages returns the messages of the entries.
+func (e LogEntries) Messages() []string {
+	messages := make([]string, len(e))
+	for i, entry := range e {
+		messages[i] = entry.Message
+	}
+	return messages
+}
+
+// Return the entries for which the given function returns true.
+func (e LogEntries) filter(f func(LogEntry) bool) LogEntries {
+	filtered := LogEntries{}
+	for _, entry := range e {
+		if f(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// Match messages of election-related log entries.
+var electionLogs = regexp.MustCompile(`(?i)election|candidate|vote|entering leader state`)
+
+// Match a line written by raft's hclog logger, such as:
+//
+//	2019-01-01T00:00:00.000Z [INFO]  raft-test.0: entering Leader state
+var logLine = regexp.MustCompile(`^\S+ \[([A-Z]+)\]\s+\S+: (.*)$`)
+
+// Convert a captured line into a LogEntry. Lines in an unexpected format are
+// kept whole as message, with no level.
+func parseLogEntry(id raft.ServerID, t time.Time, line string) LogEntry {
+	entry := LogEntry{Server: id, Time: t, Message: line}
+	if match := logLine.FindStringSubmatch(line); match != nil {
+		entry.Level = match[1]
+		entry.Message = match[2]
+	}
+	return entry
+}
+
+// Write the raft log output of all servers to the testing log, in the order it
+// was emitted, if the test failed and the LogOnFailure mode is used.
+func (c *Control) writeLogsOnFailure() {
+	if !c.logOnFailure || !c.failed() {
+		return
+	}
+
+	entries := make([]logging.Entry, 0)
+	for _, d := range c.nodes {
+		entries = append(entries, d.Capture.Entries()...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	for _, entry := range entries {
+		c.t.Logf("%s", entry.Line)
+	}
+}
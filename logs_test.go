@@ -0,0 +1,139 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Captured log entries are parsed and can be filtered.
+func TestControl_LogEntries(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	start := time.Now()
+	control.Elect("0")
+
+	entries := control.LogEntries("0")
+	assert.NotEmpty(t, entries)
+	for _, entry := range entries {
+		assert.Equal(t, raft.ServerID("0"), entry.Server)
+		assert.NotEmpty(t, entry.Level)
+	}
+
+	won := entries.Elections().Matching(regexp.MustCompile("entering Leader state"))
+	if assert.Len(t, won, 1) {
+		assert.Equal(t, "INFO", won[0].Level)
+		assert.Contains(t, won[0].Message, "[Leader] entering Leader state")
+		assert.False(t, won[0].Time.Before(start))
+	}
+	assert.Len(t, entries.Since(time.Now()), 0)
+
+	// Followers never become leaders.
+	for _, id := range []raft.ServerID{"1", "2"} {
+		assert.Empty(t, control.LogEntries(id).Matching(regexp.MustCompile("entering Leader state")))
+	}
+}
+
+// Only entries at the given level are returned.
+func TestLogEntries_Level(t *testing.T) {
+	entries := rafttest.LogEntries{
+		{Level: "INFO", Message: "a"},
+		{Level: "WARN", Message: "b"},
+		{Level: "INFO", Message: "c"},
+	}
+
+	assert.Equal(t, []string{"a", "c"}, entries.Level("info").Messages())
+	assert.Equal(t, []string{"b"}, entries.Level("WARN").Messages())
+}
+
+// With LogOnFailure, raft logs are written only if the test fails.
+func TestLogging_OnFailure(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.Logging(rafttest.LogOnFailure))
+	control.Elect("0")
+	assert.NotContains(t, buffer.String(), "entering Leader state")
+
+	assert.Panics(t, func() { control.WaitIndex("1", 100, 50*time.Millisecond) })
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "entering Leader state")
+}
+
+// With LogOnFailure, nothing is written if the test passes.
+func TestLogging_OnFailure_Passed(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.Logging(rafttest.LogOnFailure))
+	control.Elect("0")
+	control.Close()
+
+	assert.NotContains(t, buffer.String(), "entering Leader state")
+	assert.NotEmpty(t, control.Logs("0"))
+}
+
+// With LogToTest, raft logs are written as they are emitted.
+func TestLogging_ToTest(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger(), rafttest.Logging(rafttest.LogToTest))
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Contains(t, buffer.String(), "entering Leader state")
+}
+
+// Custom loggers receive raft's output, which is still captured. They take
+// precedence over the Logging option.
+func TestLoggers(t *testing.T) {
+	writers := make([]*lockedWriter, 3)
+	factory := func(i int) hclog.Logger {
+		writers[i] = &lockedWriter{}
+		return hclog.New(&hclog.LoggerOptions{Name: "custom", Output: writers[i], JSONFormat: true})
+	}
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Loggers(factory), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Contains(t, writers[0].String(), `"@message":"Node at 0 [Leader] entering Leader state"`)
+	assert.Len(t, control.LogsMatching("0", regexp.MustCompile("entering Leader state")), 1)
+}
+
+// Buffer safe for concurrent use, as raft logs from several goroutines.
+type lockedWriter struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.Write(p)
+}
+
+func (w *lockedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.String()
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// ClusterSpec describes the shape of a cluster created by Matrix().
+type ClusterSpec struct {
+	Name    string               // Name of the subtest, derived from the spec if empty
+	Servers int                  // Number of servers, 3 if zero
+	Disk    bool                 // Whether all servers use on-disk stores
+	FSMs    func(int) []raft.FSM // Create the FSMs of the servers, FSMs() if nil
+	Options []Option             // Additional options, such as Transport()
+}
+
+// Return the subtest name of the spec.
+func (s ClusterSpec) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	name := fmt.Sprintf("%d-servers", s.servers())
+	if s.Disk {
+		name += "-disk"
+	}
+	return name
+}
+
+// Return the number of servers of the spec.
+func (s ClusterSpec) servers() int {
+	if s.Servers == 0 {
+		return 3
+	}
+	return s.Servers
+}
+
+// Matrix runs the given test body against clusters of different shapes, one
+// subtest for each of the given specs, so the same scenario can be covered
+// across configurations without copy-pasted loops.
+//
+// Each subtest creates its cluster with Cluster() and closes it once the body
+// returns. No leader is elected beforehand.
+func Matrix(t *testing.T, specs []ClusterSpec, f func(*testing.T, map[raft.ServerID]*raft.Raft, *Control)) {
+	t.Helper()
+
+	for _, spec := range specs {
+		spec := spec
+		t.Run(spec.name(), func(t *testing.T) {
+			factory := spec.FSMs
+			if factory == nil {
+				factory = FSMs
+			}
+			options := spec.Options
+			if spec.Disk {
+				options = append([]Option{Disk()}, options...)
+			}
+			rafts, control := Cluster(t, factory(spec.servers()), options...)
+			defer control.Close()
+
+			f(t, rafts, control)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The same test body runs against all given cluster shapes.
+func TestMatrix(t *testing.T) {
+	specs := []rafttest.ClusterSpec{
+		{},
+		{Servers: 5},
+		{Disk: true, Options: []rafttest.Option{rafttest.Latency(10.0)}},
+	}
+
+	sizes := make([]int, 0)
+	rafttest.Matrix(t, specs, func(t *testing.T, rafts map[raft.ServerID]*raft.Raft, control *rafttest.Control) {
+		sizes = append(sizes, len(rafts))
+
+		r := control.Elect("0").Leader()
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		control.Barrier()
+		assert.Equal(t, uint64(1), control.Commands("1"))
+	})
+
+	assert.Equal(t, []int{3, 5, 3}, sizes)
+}
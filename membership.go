@@ -0,0 +1,195 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Add creates a brand new server backed by the given FSM, wires it into the
+// cluster network and adds it as voter using the current leader.
+//
+// The new server gets the default test dependencies (in-memory transport and
+// stores), with the next unused index as server ID and address. Options
+// passed to Cluster() are not applied to it, except for Transports. It runs
+// the same raft protocol version as the leader.
+//
+// A leader must have been elected with Elect() beforehand. Add must not be
+// called concurrently with other Control methods.
+func (c *Control) Add(fsm raft.FSM) *raft.Raft {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: add: no leader was elected")
+	}
+	leader := c.term.id
+
+	index := c.nextIndex
+	d := newDefaultDependencies(c.t, c.logger, index, fsm)
+	d.Voter = false
+	d.Conf.ProtocolVersion = c.confs[leader].ProtocolVersion
+	id := d.Conf.LocalID
+	if _, ok := c.servers[id]; ok {
+		c.t.Fatalf("raft-test: add: server %s already exists", id)
+	}
+	if factory := c.nodes[0].NewTransport; factory != nil {
+		trans, err := factory(index)
+		if err != nil {
+			c.t.Fatalf("raft-test: add: server %s: failed to create transport: %v", id, err)
+		}
+		d.Trans = trans
+		d.NewTransport = factory
+	}
+	d.SnapshotAfter = c.nodes[0].SnapshotAfter
+	d.SnapshotArchive = c.nodes[0].SnapshotArchive
+	archiveSnapshots([]*dependencies{d})
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: add: server %s: start", id))
+
+	setTimeouts([]*dependencies{d})
+
+	notifyCh := make(chan bool)
+	d.Conf.NotifyCh = notifyCh
+	c.election.Track(id, notifyCh)
+
+	// Connect the new loopback transport to the ones of all other
+	// servers, in both directions.
+	if loopback, ok := d.Trans.(raft.LoopbackTransport); ok {
+		c.connectLoopback(loopback)
+	}
+
+	d.Trans = c.network.Add(id, d.Trans)
+	d.FSM = c.watcher.Add(id, d.FSM)
+	var snapshotCh <-chan struct{}
+	if d.SnapshotAfter != 0 {
+		snapshotCh = c.watcher.SnapshotEvery(id, d.SnapshotAfter)
+	}
+	d.Logs = c.stores.Add(id, d.Logs)
+	d.Stable = c.stores.AddStable(id, d.Stable)
+	d.Snaps = c.stores.AddSnapshots(id, d.Snaps)
+
+	r, err := newRaft(d)
+	if err != nil {
+		c.t.Fatalf("raft-test: add: server %s failed to start: %v", id, err)
+	}
+	c.addServer(id, r, d.Conf)
+	c.mu.Lock()
+	c.nodes = append(c.nodes, d)
+	c.nextIndex++
+	c.mu.Unlock()
+	c.uptime.Start(id)
+	c.observeEvents(id, r)
+	if snapshotCh != nil {
+		go c.snapshotEvery(id, snapshotCh)
+	}
+
+	// Let the leader replicate to the new server.
+	c.network.Reconnect(leader, id)
+
+	timeout := Duration(time.Second)
+	future := c.addVoter(leader, id, timeout)
+	if err := c.await(future, "server %s: add voter %s", leader, id); err != nil {
+		c.t.Fatalf("raft-test: add: server %s: add voter failed: %v", id, err)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: add: server %s: done", id))
+
+	return r
+}
+
+// Remove removes the given server from the cluster configuration using the
+// current leader, and then shuts it down.
+//
+// The server is also forgotten by the harness, which releases its transport
+// and data: methods taking a server index, such as Restart(), only count the
+// servers that are left.
+//
+// A leader must have been elected with Elect() beforehand, and the server
+// being removed must not be the leader. Remove must not be called
+// concurrently with other Control methods.
+func (c *Control) Remove(r *raft.Raft) {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: remove: no leader was elected")
+	}
+	leader := c.term.id
+
+	id := c.serverID(r, "remove")
+	if id == leader {
+		c.t.Fatalf("raft-test: remove: server %s is the leader", id)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: remove: server %s: start", id))
+
+	timeout := Duration(time.Second)
+	future := c.removeServer(leader, id, timeout)
+	if err := c.await(future, "server %s: remove server %s", leader, id); err != nil {
+		c.t.Fatalf("raft-test: remove: server %s: remove server failed: %v", id, err)
+	}
+
+	c.shutdownServer(id)
+	c.dropServer(id)
+
+	// The server is not part of the cluster anymore, so forget about it
+	// and release its transport and data right away.
+	for i, node := range c.nodes {
+		if node.Conf.LocalID != id {
+			continue
+		}
+		c.mu.Lock()
+		c.nodes = append(c.nodes[:i:i], c.nodes[i+1:]...)
+		c.mu.Unlock()
+		c.closeTransport(node)
+		c.removeNodeData(node)
+		break
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: remove: server %s: done", id))
+}
+
+// Add the server with the given ID as voter using the given leader, with the
+// API supported by the leader's protocol version.
+func (c *Control) addVoter(leader, id raft.ServerID, timeout time.Duration) raft.Future {
+	r := c.servers[leader]
+	if c.confs[leader].ProtocolVersion < 2 {
+		return r.AddPeer(c.network.Address(id))
+	}
+	return r.AddVoter(id, c.network.Address(id), 0, timeout)
+}
+
+// Remove the server with the given ID using the given leader, with the API
+// supported by the leader's protocol version.
+func (c *Control) removeServer(leader, id raft.ServerID, timeout time.Duration) raft.Future {
+	r := c.servers[leader]
+	if c.confs[leader].ProtocolVersion < 2 {
+		return r.RemovePeer(c.network.Address(id))
+	}
+	return r.RemoveServer(id, 0, timeout)
+}
+
+// Connect the given loopback transport to the ones of all other running
+// servers, in both directions.
+func (c *Control) connectLoopback(loopback raft.LoopbackTransport) {
+	for other := range c.servers {
+		if peer, ok := c.network.Underlying(other).(raft.LoopbackTransport); ok {
+			loopback.Connect(peer.LocalAddr(), peer)
+			peer.Connect(loopback.LocalAddr(), loopback)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Servers can be added to and removed from a running cluster.
+func TestControl_AddRemove(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	added := control.Add(rafttest.FSM())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(2), control.Commands("3"))
+
+	control.Remove(added)
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	future := r.GetConfiguration()
+	require.NoError(t, future.Error())
+	servers := future.Configuration().Servers
+	require.Len(t, servers, 3)
+	for _, server := range servers {
+		assert.NotEqual(t, raft.ServerID("3"), server.ID)
+	}
+
+	// The index of the removed server is not reused.
+	control.Add(rafttest.FSM())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(4), control.Commands("4"))
+}
+
+// Servers can run different protocol versions, and membership changes work
+// with leaders running the oldest supported one.
+func TestProtocolVersions(t *testing.T) {
+	cases := [][]raft.ProtocolVersion{
+		{1, 2, 2},
+		{2, 3, 3},
+		{3, 2},
+	}
+	for _, versions := range cases {
+		rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.ProtocolVersions(versions...), rafttest.DiscardLogger())
+
+		control.Elect("0")
+
+		r := rafts["0"]
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		assert.Equal(t, fmt.Sprintf("%d", versions[0]), r.Stats()["protocol_version"])
+
+		added := control.Add(rafttest.FSM())
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		control.Barrier()
+		assert.Equal(t, uint64(2), control.Commands("3"))
+		assert.Equal(t, uint64(2), control.Commands("2"))
+
+		control.Remove(added)
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		control.Barrier()
+
+		future := r.GetConfiguration()
+		require.NoError(t, future.Error())
+		assert.Len(t, future.Configuration().Servers, 3)
+
+		control.Close()
+	}
+}
+
+// Protocol versions can be set only for existing nodes.
+func TestProtocolVersions_OutOfRange(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+
+	f := func() {
+		rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(2), rafttest.ProtocolVersions(3, 3, 3))
+	}
+	assert.Panics(t, f)
+	assert.Contains(t, buffer.String(), "ProtocolVersions option: node index 2 out of range (2 nodes)")
+}
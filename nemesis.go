@@ -0,0 +1,410 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Nemesis is a fault-selection policy, driven by RunNemesis().
+//
+// At each step the driver passes the current state of the cluster to Next()
+// and injects the returned fault, keeping it in place for the requested dwell
+// time before healing it.
+type Nemesis interface {
+	Next(view ClusterView) FaultAction
+}
+
+// ClusterView is a snapshot of the cluster state, as seen by a Nemesis.
+type ClusterView struct {
+	Step    int             // Index of the current step, starting from 0
+	Leader  raft.ServerID   // Current leader
+	Servers []raft.ServerID // All servers, sorted by ID
+	Voters  []raft.ServerID // Voters in the leader's configuration, sorted
+}
+
+// Followers returns the IDs of all servers except the leader, sorted.
+func (v ClusterView) Followers() []raft.ServerID {
+	followers := make([]raft.ServerID, 0, len(v.Servers))
+	for _, id := range v.Servers {
+		if id != v.Leader {
+			followers = append(followers, id)
+		}
+	}
+	return followers
+}
+
+// FaultKind identifies a type of fault that a Nemesis can inject.
+type FaultKind int
+
+// Available fault kinds.
+const (
+	// Do nothing for the dwell time.
+	FaultNone FaultKind = iota
+
+	// Depose the target, which must be the leader, leaving the cluster
+	// without leader for the dwell time. A new leader is then elected.
+	FaultDepose
+
+	// Disconnect the target, which must be a follower, for the dwell
+	// time.
+	FaultDisconnect
+
+	// Slow down log store writes of the target, which must be the leader,
+	// by the action's delay for the dwell time.
+	FaultSlowDisk
+
+	// Isolate the target from all other servers for the dwell time. If
+	// the target is the leader, it gets elected again afterwards.
+	FaultPartition
+
+	// Kill the target, which must be a follower, and restart it after the
+	// dwell time.
+	FaultRestart
+
+	// Crash the target and restart it after the dwell time. If the target
+	// is the leader, it gets deposed first, leaving the cluster without
+	// leader for the dwell time, and a new leader is elected once it's
+	// back.
+	FaultCrash
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultNone:
+		return "none"
+	case FaultDepose:
+		return "depose"
+	case FaultDisconnect:
+		return "disconnect"
+	case FaultSlowDisk:
+		return "slow disk"
+	case FaultPartition:
+		return "partition"
+	case FaultRestart:
+		return "restart"
+	case FaultCrash:
+		return "crash"
+	default:
+		return fmt.Sprintf("fault %d", int(k))
+	}
+}
+
+// FaultAction is a fault selected by a Nemesis.
+type FaultAction struct {
+	Kind   FaultKind     // Type of fault to inject
+	Target raft.ServerID // Server to inject the fault into
+	Dwell  time.Duration // How long the fault stays in place
+	Delay  time.Duration // Additional write latency, for FaultSlowDisk
+}
+
+func (a FaultAction) String() string {
+	s := fmt.Sprintf("%s server %s", a.Kind, a.Target)
+	if a.Kind == FaultNone {
+		s = "none"
+	}
+	if a.Dwell != 0 {
+		s += fmt.Sprintf(" for %s", a.Dwell)
+	}
+	return s
+}
+
+// RandomNemesis returns a Nemesis that at each step picks uniformly at random
+// between doing nothing, deposing the leader and disconnecting a follower,
+// keeping the fault in place for the given dwell time.
+func RandomNemesis(seed int64, dwell time.Duration) Nemesis {
+	return &randomNemesis{rand: rand.New(rand.NewSource(seed)), dwell: dwell}
+}
+
+type randomNemesis struct {
+	rand  *rand.Rand
+	dwell time.Duration
+}
+
+func (n *randomNemesis) Next(view ClusterView) FaultAction {
+	action := FaultAction{Dwell: n.dwell}
+	switch n.rand.Intn(3) {
+	case 1:
+		action.Kind = FaultDepose
+		action.Target = view.Leader
+	case 2:
+		followers := view.Followers()
+		action.Kind = FaultDisconnect
+		action.Target = followers[n.rand.Intn(len(followers))]
+	}
+	return action
+}
+
+// LeaderNemesis is a Nemesis that disrupts whichever server is currently the
+// leader at every step, cycling through the configured fault kinds.
+type LeaderNemesis struct {
+	Dwell time.Duration // How long each fault stays in place
+	Kinds []FaultKind   // Faults to cycle through, FaultDepose if empty
+	Delay time.Duration // Additional write latency, for FaultSlowDisk
+}
+
+// Next implements Nemesis.
+func (n *LeaderNemesis) Next(view ClusterView) FaultAction {
+	kind := FaultDepose
+	if len(n.Kinds) > 0 {
+		kind = n.Kinds[view.Step%len(n.Kinds)]
+	}
+	return FaultAction{
+		Kind:   kind,
+		Target: view.Leader,
+		Dwell:  n.Dwell,
+		Delay:  n.Delay,
+	}
+}
+
+// MinorityNemesis is a Nemesis that disconnects followers in round-robin,
+// never taking down a majority of the voters at the same time. The leader is
+// left alone, so the cluster keeps making progress while it runs.
+//
+// If the cluster is too small to tolerate any fault, it does nothing.
+type MinorityNemesis struct {
+	Dwell time.Duration // How long each fault stays in place
+}
+
+// Next implements Nemesis.
+func (n *MinorityNemesis) Next(view ClusterView) FaultAction {
+	action := FaultAction{Dwell: n.Dwell}
+
+	if len(view.Voters)-1 < quorum(len(view.Voters)) {
+		return action
+	}
+
+	followers := view.Followers()
+	if len(followers) == 0 {
+		return action
+	}
+	action.Kind = FaultDisconnect
+	action.Target = followers[view.Step%len(followers)]
+
+	return action
+}
+
+// MinorityOnly implements MinorityOnly.
+func (n *MinorityNemesis) MinorityOnly() {}
+
+// MinorityOnly is implemented by a Nemesis that promises to never take down a
+// majority of the voters at the same time. RunNemesis fails the test if such
+// a Nemesis returns a fault that would break the promise.
+type MinorityOnly interface {
+	Nemesis
+	MinorityOnly()
+}
+
+// RunNemesis runs the given Nemesis for the given number of steps, injecting
+// one fault at a time. It's typically run while other goroutines apply
+// command logs to the cluster.
+//
+// A leader must have been elected with Elect() beforehand, and there's still
+// a leader when RunNemesis returns.
+//
+// The outcome of each step is recorded, see Results() and WriteResults().
+//
+// Faults are injected into a live cluster, so dwell times should be
+// comparable to the election timeout: with the default tight timeouts a
+// follower that was disconnected briefly might start an election right after
+// being reconnected, while the leader is still backing off. Using the Latency
+// option makes the nemesis more reliable.
+func (c *Control) RunNemesis(nemesis Nemesis, steps int) {
+	c.t.Helper()
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: nemesis: no leader was elected")
+	}
+
+	_, safe := nemesis.(MinorityOnly)
+
+	// Result of the step currently running, recorded as failed if the
+	// test fails before it completes.
+	var result *StepResult
+	var start time.Time
+	defer func() {
+		if result != nil {
+			result.Duration = time.Since(start)
+			result.Failed = true
+			c.results = append(c.results, *result)
+		}
+	}()
+
+	for step := 0; step < steps; step++ {
+		view := c.clusterView(step)
+		action := nemesis.Next(view)
+
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: nemesis: step %d: %s", step, action))
+
+		result = &StepResult{Name: fmt.Sprintf("nemesis step %d: %s", step, action)}
+		start = time.Now()
+
+		takesDown := action.Kind != FaultNone && action.Kind != FaultSlowDisk
+		// Faults are injected one at a time, so only the target is
+		// down while this one is in place.
+		if safe && takesDown {
+			if len(view.Voters)-1 < quorum(len(view.Voters)) {
+				c.t.Fatalf("raft-test: nemesis: %s: would take down a majority of %d voters", action, len(view.Voters))
+			}
+			result.Invariants = append(result.Invariants, "majority of voters up")
+		}
+
+		heal := c.injectFault(action)
+		time.Sleep(action.Dwell)
+		heal()
+
+		if c.term == nil {
+			c.t.Fatalf("raft-test: nemesis: %s: no leader after healing", action)
+		}
+		result.Invariants = append(result.Invariants, "leader elected")
+
+		result.Duration = time.Since(start)
+		c.results = append(c.results, *result)
+		result = nil
+	}
+}
+
+// Return the current view of the cluster.
+func (c *Control) clusterView(step int) ClusterView {
+	voters := make([]raft.ServerID, 0, len(c.servers))
+	for _, server := range c.configuration(c.term.id).Servers {
+		if server.Suffrage == raft.Voter {
+			voters = append(voters, server.ID)
+		}
+	}
+	sort.Slice(voters, func(i, j int) bool { return voters[i] < voters[j] })
+
+	return ClusterView{
+		Step:    step,
+		Leader:  c.term.id,
+		Servers: c.serverIDs(),
+		Voters:  voters,
+	}
+}
+
+// Inject the given fault, returning a function that heals it.
+func (c *Control) injectFault(action FaultAction) func() {
+	c.t.Helper()
+
+	if action.Kind != FaultNone {
+		if _, ok := c.servers[action.Target]; !ok {
+			c.t.Fatalf("raft-test: nemesis: %s: unknown server", action)
+		}
+	}
+
+	switch action.Kind {
+	case FaultNone:
+		return func() {}
+	case FaultDepose:
+		if action.Target != c.term.id {
+			c.t.Fatalf("raft-test: nemesis: %s: server is not the leader", action)
+		}
+		c.Depose()
+		return func() {
+			c.Elect(c.successor(action.Target))
+		}
+	case FaultDisconnect:
+		if action.Target == c.term.id {
+			c.t.Fatalf("raft-test: nemesis: %s: server is the leader", action)
+		}
+		term := c.term
+		term.Disconnect(action.Target)
+		return func() {
+			term.Reconnect(action.Target)
+		}
+	case FaultSlowDisk:
+		if action.Target != c.term.id {
+			c.t.Fatalf("raft-test: nemesis: %s: server is not the leader", action)
+		}
+		c.SlowLeaderDisk(action.Delay)
+		return func() {
+			c.SlowLeaderDisk(0)
+		}
+	case FaultPartition:
+		rest := make([]*raft.Raft, 0, len(c.servers))
+		for id, r := range c.servers {
+			if id != action.Target {
+				rest = append(rest, r)
+			}
+		}
+		c.Partition([]*raft.Raft{c.servers[action.Target]}, rest)
+		return func() {
+			c.Heal()
+		}
+	case FaultRestart:
+		if action.Target == c.term.id {
+			c.t.Fatalf("raft-test: nemesis: %s: server is the leader", action)
+		}
+		i := c.nodeIndex(action.Target)
+		c.Kill(c.servers[action.Target])
+		c.faultInjected(FaultAction{Kind: FaultRestart, Target: action.Target})
+		return func() {
+			c.Restart(i)
+			c.faultHealed(FaultAction{Kind: FaultRestart, Target: action.Target})
+		}
+	case FaultCrash:
+		leader := action.Target == c.term.id
+		if leader {
+			c.Depose()
+		}
+		i := c.nodeIndex(action.Target)
+		c.Crash(c.servers[action.Target])
+		c.faultInjected(FaultAction{Kind: FaultCrash, Target: action.Target})
+		return func() {
+			c.Restart(i)
+			c.faultHealed(FaultAction{Kind: FaultCrash, Target: action.Target})
+			if leader {
+				c.Elect(c.successor(action.Target))
+			}
+		}
+	default:
+		c.t.Fatalf("raft-test: nemesis: %s: unsupported fault", action)
+	}
+
+	return nil
+}
+
+// Return the IDs of all servers, sorted.
+func (c *Control) serverIDs() []raft.ServerID {
+	ids := make([]raft.ServerID, 0, len(c.servers))
+	for id := range c.servers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Return the number of votes needed for a majority of the given number of
+// voters.
+func quorum(voters int) int {
+	return voters/2 + 1
+}
+
+// Return the server that should be elected after the one with the given ID
+// gets deposed, i.e. the next one in ID order.
+func (c *Control) successor(id raft.ServerID) raft.ServerID {
+	servers := c.serverIDs()
+	for i, other := range servers {
+		if other == id {
+			return servers[(i+1)%len(servers)]
+		}
+	}
+	return servers[0]
+}
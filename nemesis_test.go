@@ -0,0 +1,219 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A custom policy disconnecting followers in round-robin.
+type roundRobinNemesis struct {
+	targets []raft.ServerID
+}
+
+func (n *roundRobinNemesis) Next(view rafttest.ClusterView) rafttest.FaultAction {
+	followers := view.Followers()
+	target := followers[view.Step%len(followers)]
+	n.targets = append(n.targets, target)
+	return rafttest.FaultAction{
+		Kind:   rafttest.FaultDisconnect,
+		Target: target,
+		Dwell:  50 * time.Millisecond,
+	}
+}
+
+func TestControl_RunNemesis(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	nemesis := &roundRobinNemesis{}
+	control.RunNemesis(nemesis, 4)
+
+	assert.Equal(t, []raft.ServerID{"1", "2", "1", "2"}, nemesis.targets)
+
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("1"))
+	assert.Equal(t, uint64(1), control.Commands("2"))
+}
+
+func TestControl_RunNemesis_Random(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.RunNemesis(rafttest.RandomNemesis(1, 50*time.Millisecond), 6)
+
+	var leader *raft.Raft
+	for _, r := range rafts {
+		if r.State() == raft.Leader {
+			leader = r
+		}
+	}
+	require.NotNil(t, leader)
+	require.NoError(t, leader.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+}
+
+// The leader nemesis disrupts whichever server is the leader.
+func TestControl_RunNemesis_Leader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	nemesis := &rafttest.LeaderNemesis{
+		Dwell: 50 * time.Millisecond,
+		Kinds: []rafttest.FaultKind{rafttest.FaultDepose, rafttest.FaultSlowDisk},
+		Delay: 5 * time.Millisecond,
+	}
+	control.RunNemesis(nemesis, 3)
+
+	// Leadership moved from 0 to 1 at the first step and from 1 to 2 at
+	// the third one.
+	assert.Equal(t, raft.Leader, rafts["2"].State())
+
+	require.NoError(t, rafts["2"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("0"))
+}
+
+// The minority nemesis never stops the cluster from making progress.
+func TestControl_RunNemesis_Minority(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	// Apply commands in the background, counting them until stopped or
+	// until an apply fails.
+	type outcome struct {
+		n   uint64
+		err error
+	}
+	stop := make(chan struct{})
+	done := make(chan outcome, 1)
+	go func() {
+		r := rafts["0"]
+		n := uint64(0)
+		for {
+			select {
+			case <-stop:
+				done <- outcome{n: n}
+				return
+			default:
+			}
+			if err := r.Apply([]byte{}, time.Second).Error(); err != nil {
+				done <- outcome{n: n, err: err}
+				return
+			}
+			n++
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	control.RunNemesis(&rafttest.MinorityNemesis{Dwell: 50 * time.Millisecond}, 4)
+	close(stop)
+
+	result := <-done
+	require.NoError(t, result.err)
+	control.Barrier()
+	assert.Equal(t, result.n, control.Commands("0"))
+}
+
+// The minority nemesis does nothing if the cluster can't tolerate any fault.
+func TestControl_RunNemesis_MinorityTooSmall(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(2), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.RunNemesis(&rafttest.MinorityNemesis{Dwell: time.Millisecond}, 2)
+
+	report := control.DowntimeReport()
+	assert.Equal(t, time.Duration(0), report["1"].Partitioned)
+}
+
+// A partitioned leader gets elected again once healed.
+func TestControl_RunNemesis_PartitionLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	nemesis := &rafttest.LeaderNemesis{
+		Dwell: 300 * time.Millisecond,
+		Kinds: []rafttest.FaultKind{rafttest.FaultPartition},
+	}
+	control.RunNemesis(nemesis, 2)
+
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("2"))
+}
+
+// A crashed leader gets restarted once healed, and a new leader is elected.
+func TestControl_RunNemesis_CrashLeader(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	nemesis := &rafttest.LeaderNemesis{
+		Dwell: 50 * time.Millisecond,
+		Kinds: []rafttest.FaultKind{rafttest.FaultCrash},
+	}
+	control.RunNemesis(nemesis, 2)
+
+	// Leadership moved from 0 to 1 at the first step and from 1 to 2 at
+	// the second one.
+	assert.Equal(t, 1, control.DowntimeReport()["0"].Crashes)
+	assert.Equal(t, 1, control.DowntimeReport()["1"].Crashes)
+
+	require.NoError(t, rafts["2"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("0"))
+}
+
+// A user nemesis opting into the MinorityOnly guarantee gets stopped if it
+// would take down a majority of the voters.
+func TestControl_RunNemesis_MinorityOnly(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(2), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	assert.Panics(t, func() { control.RunNemesis(disconnectNemesis{}, 1) })
+	assert.Contains(t, buffer.String(), "would take down a majority of 2 voters")
+}
+
+// Nemesis that always disconnects the first follower, claiming it never takes
+// down a majority.
+type disconnectNemesis struct{}
+
+func (disconnectNemesis) Next(view rafttest.ClusterView) rafttest.FaultAction {
+	return rafttest.FaultAction{Kind: rafttest.FaultDisconnect, Target: view.Followers()[0]}
+}
+
+func (disconnectNemesis) MinorityOnly() {}
@@ -15,34 +15,189 @@
 package rafttest
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/CanonicalLtd/raft-test/internal/logging"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
+	"github.com/hashicorp/raft-boltdb"
 )
 
 // Config sets a hook for tweaking the raft configuration of individual nodes.
 func Config(f func(int, *raft.Config)) Option {
-	return func(nodes []*dependencies) {
+	return func(t Reporter, nodes []*dependencies) {
 		for i, node := range nodes {
 			f(i, node.Conf)
 		}
 	}
 }
 
+// NodeConfig sets a hook for tweaking the raft configuration of the node with
+// the given index only, for example to give it a much longer election timeout
+// so it never becomes leader on its own.
+//
+// Options are applied in order, so NodeConfig can be stacked with Config and
+// with other NodeConfig options: later hooks see the changes of earlier ones.
+func NodeConfig(i int, f func(*raft.Config)) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		f(checkIndex(t, nodes, i, "NodeConfig").Conf)
+	}
+}
+
+// ServerIDs sets the server IDs of the nodes: the i'th ID is used by the node
+// with index i, and nodes without an ID keep the default one, which is their
+// index. Control methods and the maps returned by Cluster() are keyed by these
+// IDs, while transport addresses stay the node indexes.
+//
+// Raft requires the ID to match the address for protocol versions older than
+// 3, so this option can't be combined with ProtocolVersions to run them.
+func ServerIDs(ids ...raft.ServerID) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for i, id := range ids {
+			checkIndex(t, nodes, i, "ServerIDs").Conf.LocalID = id
+		}
+	}
+}
+
+// ProtocolVersions sets the raft protocol version of each node: the i'th
+// version is used by the node with index i, and nodes without a version keep
+// the latest one. Running nodes with different versions in the same cluster
+// lets tests check that an application keeps working across a rolling
+// upgrade or downgrade.
+//
+// Each node bootstraps its log with the configuration entry format of its own
+// version, and Control.Add() and Control.Remove() fall back to the deprecated
+// AddPeer() and RemovePeer() APIs when the leader runs version 1. Raft
+// servers reject RPCs from servers more than one version behind them.
+func ProtocolVersions(versions ...raft.ProtocolVersion) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for i, version := range versions {
+			checkIndex(t, nodes, i, "ProtocolVersions").Conf.ProtocolVersion = version
+		}
+	}
+}
+
+// Return the node with the given index, failing the test if it's out of range.
+func checkIndex(t Reporter, nodes []*dependencies, index int, option string) *dependencies {
+	if index < 0 || index >= len(nodes) {
+		t.Helper()
+		t.Fatalf("raft-test: setup: error: %s option: node index %d out of range (%d nodes)", option, index, len(nodes))
+	}
+	return nodes[index]
+}
+
 // LogStore can be used to create custom log stores.
 //
 // The given function takes a node index as argument and returns the LogStore
 // that the node should use.
 func LogStore(factory func(int) raft.LogStore) Option {
-	return func(nodes []*dependencies) {
+	return func(t Reporter, nodes []*dependencies) {
 		for i, node := range nodes {
 			node.Logs = factory(i)
 		}
 	}
 }
 
+// Stores can be used to create custom log, stable and snapshot stores, for
+// instance to test a store implementation while getting all the facilities of
+// Control.
+//
+// The given function takes a node index as argument and returns the stores
+// that the node should use. A nil store keeps the default in-memory one.
+//
+// Stores are not reopened when a server is restarted, so their content must
+// survive a shutdown of the raft instance using them.
+func Stores(factory func(int) (raft.LogStore, raft.StableStore, raft.SnapshotStore)) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for i, node := range nodes {
+			logs, stable, snaps := factory(i)
+			if logs != nil {
+				node.Logs = logs
+			}
+			if stable != nil {
+				node.Stable = stable
+			}
+			if snaps != nil {
+				node.Snaps = snaps
+			}
+		}
+	}
+}
+
+// Bootstrap makes the node with the given index start with the given state
+// written into its log and stable stores, on top of the initial
+// configuration, for example to exercise recovery from existing state or the
+// truncation of conflicting logs when a leader gets elected. A seeded snapshot
+// gets restored into the FSM of the node when it starts, which
+// Control.WaitRestore() reports as a RestoreBoot restore.
+func Bootstrap(i int, seed Seed) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		checkIndex(t, nodes, i, "Bootstrap").Seed = &seed
+	}
+}
+
+// Disk makes the nodes with the given indexes store their logs, stable data
+// and snapshots on disk, using a raft-boltdb store and a raft.FileSnapshotStore
+// backed by a temporary directory. The directory is removed when the cluster
+// is closed. Restart() reopens the stores from the directory, so restarted
+// servers see only what was persisted.
+//
+// All other nodes keep their in-memory stores, so tests can focus disk-related
+// behavior on a few nodes while keeping the rest of the cluster fast. If no
+// index is given, all nodes will use on-disk stores.
+//
+// Since disk writes are much slower than in-memory ones, this option is
+// typically combined with the Latency option. The data directory of a server
+// is returned by Control.DataDir().
+//
+// The boltdb/bolt version that raft-boltdb depends on fails the checkptr
+// instrumentation enabled by the race detector, so tests using this option
+// can't run with -race.
+func Disk(indexes ...int) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		if len(indexes) == 0 {
+			for i := range nodes {
+				indexes = append(indexes, i)
+			}
+		}
+		for _, index := range indexes {
+			node := checkIndex(t, nodes, index, "Disk")
+			dir, err := ioutil.TempDir("", "raft-test-")
+			if err != nil {
+				t.Fatalf("raft-test: setup: error: disk: failed to create data dir for node %d: %v", index, err)
+			}
+			store, snaps, err := openDiskStores(dir, node.Conf.Logger)
+			if err != nil {
+				t.Fatalf("raft-test: setup: error: disk: node %d: %v", index, err)
+			}
+			node.Logs = store
+			node.Stable = store
+			node.Snaps = snaps
+			node.Dir = dir
+		}
+	}
+}
+
+// Open the raft-boltdb store and the raft.FileSnapshotStore backed by the
+// given directory, creating them if they don't exist.
+func openDiskStores(dir string, logger hclog.Logger) (*raftboltdb.BoltStore, *raft.FileSnapshotStore, error) {
+	store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create bolt store: %v", err)
+	}
+	output := logger.StandardWriter(&hclog.StandardLoggerOptions{})
+	snaps, err := raft.NewFileSnapshotStore(dir, 2, output)
+	if err != nil {
+		store.Close()
+		return nil, nil, fmt.Errorf("failed to create snapshot store: %v", err)
+	}
+	return store, snaps, nil
+}
+
 // Transport can be used to create custom transports.
 //
 // The given function takes a node index as argument and returns the Transport
@@ -51,13 +206,70 @@ func LogStore(factory func(int) raft.LogStore) Option {
 // If the transports returned by the factory do not implement
 // LoopbackTransport, the Disconnect API won't work.
 func Transport(factory func(int) raft.Transport) Option {
-	return func(nodes []*dependencies) {
+	return func(t Reporter, nodes []*dependencies) {
 		for i, node := range nodes {
 			node.Trans = factory(i)
 		}
 	}
 }
 
+// Transports can be used to create real transports, such as TCP
+// NetworkTransports listening on 127.0.0.1, instead of the in-memory loopback
+// ones, in order to exercise RPC encoding, timeouts and connection pooling.
+//
+// The given function takes a node index as argument and returns the transport
+// that the node should use. It's also used to create the transports of
+// servers added with Control.Add(). The initial configuration is built using
+// the local addresses of the returned transports, and the transports are
+// closed when the cluster is closed.
+func Transports(factory func(int) (raft.Transport, error)) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for i, node := range nodes {
+			trans, err := factory(i)
+			if err != nil {
+				t.Fatalf("raft-test: setup: error: transports: node %d: %v", i, err)
+			}
+			node.Trans = trans
+			node.NewTransport = factory
+		}
+	}
+}
+
+// Trace makes the harness record every RPC sent by the servers created by
+// Cluster(), see Control.Trace().
+//
+// RPCs are recorded by wrapping the transport instrumented by the harness,
+// like WrapTransport decorators do, so RPCs failed because of injected faults
+// are recorded too, along with their error.
+func Trace() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		tracer := &rpcTracer{}
+		for _, node := range nodes {
+			node := node
+			node.Tracer = tracer
+			node.Decorators = append(node.Decorators, func(trans raft.Transport) raft.Transport {
+				return &tracingTransport{Transport: trans, id: node.Conf.LocalID, tracer: tracer}
+			})
+		}
+	}
+}
+
+// WrapTransport can be used to wrap the transport of each node with a
+// user-defined decorator, e.g. to insert protocol shims such as auth headers
+// or tracing.
+//
+// The decorator is applied on top of the transport instrumented by the
+// harness, so fault injection keeps working underneath it. The decorated
+// transport must keep the same local address. If the option is used more than
+// once, decorators are applied in order.
+func WrapTransport(decorator func(raft.Transport) raft.Transport) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.Decorators = append(node.Decorators, decorator)
+		}
+	}
+}
+
 // Latency is a convenience around Config that scales the values of the various
 // raft timeouts that would be set by default by Cluster.
 //
@@ -80,14 +292,242 @@ func Latency(factor float64) Option {
 	})
 }
 
-// DiscardLogger is a convenience around Config that sets the output stream of
-// raft's logger to ioutil.Discard.
+// LinkLatency adds the given delay to every RPC sent between servers, to
+// simulate a real network. If jitter is non-zero, each delay is randomized by
+// up to jitter in either direction.
+//
+// Unlike Latency, which only scales raft timeouts, this option actually slows
+// down the in-memory transports. The two are typically combined, so that
+// timeouts stay well above the round trip time. Per-link delays can be
+// changed at runtime with Control.SetLinkLatency.
+func LinkLatency(delay, jitter time.Duration) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.LinkLatency = delay
+			node.LinkJitter = jitter
+		}
+	}
+}
+
+// ForceSnapshotAfter makes every server take a snapshot each time its FSM has
+// applied n more command logs, regardless of the SnapshotThreshold and
+// SnapshotInterval settings.
+//
+// Tuning those settings to get a snapshot at the right time is delicate,
+// since raft checks them only periodically: this option makes the intent
+// explicit and reliable. Combined with the default TrailingLogs of 1, it's
+// the simplest way to make lagging followers restore from a snapshot.
+//
+// Servers restarted with Restart() or added with Add() are covered too.
+func ForceSnapshotAfter(n uint64) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.SnapshotAfter = n
+		}
+	}
+}
+
+// SnapshotArchive makes the default in-memory snapshot stores retain every
+// snapshot ever taken by or installed on a server, instead of only the latest
+// one, so all of them can be inspected with SnapshotsOf() and OpenSnapshot().
+//
+// Memory usage grows with each snapshot, so this option is best reserved to
+// tests taking a bounded number of them. Snapshot stores provided with the
+// Stores or Disk options are not affected.
+func SnapshotArchive() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.SnapshotArchive = true
+		}
+	}
+}
+
+// Strict makes the harness fail the test whenever it detects an anomaly that
+// it would otherwise only log as a warning, for teams that want maximum signal
+// from their test suite. The only such warning is currently about snapshot
+// settings that can't take effect.
+//
+// It also enables the LeakCheck and Invariants options, so goroutines still
+// running after Close() and violations of core raft invariants fail the test
+// too. Unexpected leadership changes always do, with or without this option.
+func Strict() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.Strict = true
+			node.LeakCheck = true
+			node.Invariants = true
+		}
+	}
+}
+
+// Invariants makes the harness continuously observe all servers and fail the
+// test when the cluster gets closed if any core raft invariant was violated:
+//
+// - there's at most one leader per term;
+//
+// - the term of each server never goes back;
+//
+// - committed entries are never lost, i.e. all servers apply the same entry at
+// a given index, and the leader of a term holds all entries committed up to
+// that term.
+func Invariants() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.Invariants = true
+		}
+	}
+}
+
+// CommandHistory makes the FSM of every server keep a copy of the data of each
+// command log it applies, so that Control.History() can return it in the
+// Command field of its entries.
+//
+// Without this option only the index, term and time of each applied log are
+// kept, since copying all commands makes memory grow with the length of the
+// test, which matters for long chaos runs and benchmarks.
+func CommandHistory() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.CommandHistory = true
+		}
+	}
+}
+
+// FaultSeed sets the seed of the pseudo-random source deciding which RPCs get
+// dropped, duplicated or reordered by DropRPCs(), DuplicateRPCs() and
+// ReorderRPCs(), and by how much link latency gets randomized by jitter.
+//
+// By default the seed is based on the current time, and Close() reports it
+// if the test failed while any such fault was in place, so the run can be
+// reproduced with this option.
+func FaultSeed(seed int64) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.FaultSeed = &seed
+		}
+	}
+}
+
+// LeakCheck makes Close() fail the test if any goroutine running raft or
+// harness code that was spawned after the cluster got created is still
+// running once the cluster is closed, dumping their stacks. Leaks from
+// half-shutdown clusters otherwise go unnoticed and slow down or break later
+// tests.
+//
+// Other clusters created in the meantime must be closed first, and the check
+// is not reliable if tests using the harness run in parallel.
+func LeakCheck() Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.LeakCheck = true
+		}
+	}
+}
+
+// Artifacts makes Close() write post-mortem artifacts to a new sub-directory of
+// the given directory if the test has failed: the state of the cluster, and for
+// each server its raft log output, the entries of its log store and its
+// snapshots. If the directory is empty, the RAFT_TEST_ARTIFACTS environment
+// variable is used, falling back to the system temporary directory.
+//
+// The test is considered failed if the Reporter has a Failed() method
+// returning true, as testing.T does.
+func Artifacts(dir string) Option {
+	if dir == "" {
+		dir = os.Getenv(artifactsEnv)
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.ArtifactsDir = dir
+		}
+	}
+}
+
+// TimeSource makes Control measure the timeouts of its wait and poll loops,
+// such as WaitIndex() or AssertFSMsEqual(), with the given clock instead of the
+// system one. Backed by a ManualClock, it lets tests decide exactly when a
+// wait times out. Loops still poll at wall-clock intervals, and raft itself
+// keeps using the system time.
+//
+// Waits bound to a context, such as WaitIndexCtx(), are not affected.
+func TimeSource(clock Clock) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.Clock = clock
+		}
+	}
+}
+
+// DiscardLogger makes raft's logger stop writing to the testing log. The output
+// is still captured, see Control.Logs. It's the same as Logging(LogDiscard).
 func DiscardLogger() Option {
-	return Config(func(i int, config *raft.Config) {
-		config.Logger = hclog.New(&hclog.LoggerOptions{
-			Name: "raft-test",
-			Output: ioutil.Discard})
-	})
+	return Logging(LogDiscard)
+}
+
+// Loggers sets custom raft loggers, for example to send raft's output to the
+// structured logging pipeline of a project during tests.
+//
+// The given function takes a node index as argument and returns the logger
+// that the node should use, or nil to keep the default one. Entries are still
+// captured, see Control.Logs, but not written to the testing log. Custom
+// loggers take precedence over the Logging option, whatever the order in which
+// the two are given, although with LogOnFailure captured entries are still
+// written to the testing log if the test fails.
+func Loggers(factory func(int) hclog.Logger) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for i, node := range nodes {
+			logger := factory(i)
+			if logger == nil {
+				continue
+			}
+			id := string(node.Conf.LocalID)
+			node.Conf.Logger = logging.Tee(logger, id, node.Capture)
+			node.CustomLogger = true
+		}
+	}
+}
+
+// LoggingMode controls where the output of raft's logger goes, see Logging().
+type LoggingMode int
+
+// Available logging modes.
+const (
+	LogToTest    LoggingMode = iota // Write entries to the testing log as they are emitted (the default)
+	LogDiscard                      // Don't write entries to the testing log
+	LogOnFailure                    // Write entries to the testing log on Close(), only if the test failed
+)
+
+// Logging sets where the output of raft's logger goes. In all modes the output
+// is captured, see Control.Logs. Nodes with a custom logger set with the
+// Loggers option keep it.
+//
+// With LogOnFailure, entries of all servers are written to the testing log in
+// the order they were emitted when the cluster is closed, only if the test
+// failed, which keeps large test suites quiet without losing information when
+// a test breaks. The test is considered failed if the Reporter has a Failed()
+// method returning true, as testing.T does.
+func Logging(mode LoggingMode) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			id := string(node.Conf.LocalID)
+			switch mode {
+			case LogToTest:
+				if !node.CustomLogger {
+					node.Conf.Logger = logging.NewNode(node.t, "DEBUG", id, node.Capture)
+				}
+			case LogDiscard, LogOnFailure:
+				if !node.CustomLogger {
+					node.Conf.Logger = logging.NewNode(nil, "", id, node.Capture)
+				}
+			default:
+				t.Fatalf("raft-test: option Logging: unknown mode %d", mode)
+			}
+			node.LogOnFailure = mode == LogOnFailure
+		}
+	}
 }
 
 // Servers can be used to indicate which nodes should be initially part of the
@@ -96,12 +536,65 @@ func DiscardLogger() Option {
 // If this option is not used, the default is to have all nodes be part of the
 // cluster.
 func Servers(indexes ...int) Option {
-	return func(nodes []*dependencies) {
+	return func(t Reporter, nodes []*dependencies) {
 		for _, node := range nodes {
 			node.Voter = false
 		}
 		for _, index := range indexes {
-			nodes[index].Voter = true
+			checkIndex(t, nodes, index, "Servers").Voter = true
+		}
+	}
+}
+
+// NonVoters can be used to indicate which nodes should be initially part of
+// the created cluster as non-voting servers, for example to test read
+// replicas or promotion flows.
+//
+// Non-voters receive log entries from the leader but don't take part in
+// elections nor count towards the commit quorum.
+func NonVoters(indexes ...int) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, index := range indexes {
+			node := checkIndex(t, nodes, index, "NonVoters")
+			node.Voter = false
+			node.NonVoter = true
+			node.Staging = false
+		}
+	}
+}
+
+// Staging can be used to indicate which nodes should be initially part of the
+// created cluster as staging servers, for example to test promotion flows.
+//
+// Staging servers receive log entries from the leader but, like non-voters,
+// don't take part in elections nor count towards the commit quorum. The
+// version of raft in use never promotes them automatically: promote them
+// explicitly with AddVoter().
+func Staging(indexes ...int) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, index := range indexes {
+			node := checkIndex(t, nodes, index, "Staging")
+			node.Voter = false
+			node.NonVoter = false
+			node.Staging = true
+		}
+	}
+}
+
+// ResultsArchive makes Close() save a summary of the run, such as its
+// duration, outcome, leader changes, election retries, scenario step results
+// and violations found by the Invariants option, to the given directory, as
+// <dir>/<test name>/<revision>-<timestamp>.json. The revision is taken from
+// the RAFT_TEST_REVISION environment variable, or from git.
+//
+// Before saving it, the summary is compared with the latest one archived for
+// the same test at a different revision and significant regressions are
+// logged, see CompareRuns().
+// Archives can be loaded with LoadRuns().
+func ResultsArchive(dir string) Option {
+	return func(t Reporter, nodes []*dependencies) {
+		for _, node := range nodes {
+			node.ArchiveDir = dir
 		}
 	}
 }
@@ -14,6 +14,20 @@
 
 package rafttest_test
 
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
 /*
 // The Latency options tweak the default raft timeouts.
 func TestCluster_Latency(t *testing.T) {
@@ -84,3 +98,382 @@ func TestServers(t *testing.T) {
 	assert.Len(t, future.Configuration().Servers, 1)
 }
 */
+
+// The NodeConfig option tweaks only the node with the given index, and it can
+// be stacked with other configuration hooks.
+func TestNodeConfig(t *testing.T) {
+	timeouts := make([]time.Duration, 3)
+	options := []rafttest.Option{
+		rafttest.Config(func(i int, conf *raft.Config) {
+			conf.ElectionTimeout = 100 * time.Millisecond
+		}),
+		rafttest.NodeConfig(2, func(conf *raft.Config) {
+			conf.ElectionTimeout *= 10
+		}),
+		rafttest.NodeConfig(2, func(conf *raft.Config) {
+			conf.ElectionTimeout += time.Second
+		}),
+		rafttest.Config(func(i int, conf *raft.Config) {
+			timeouts[i] = conf.ElectionTimeout
+		}),
+		rafttest.DiscardLogger(),
+	}
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), options...)
+	defer control.Close()
+
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		2 * time.Second,
+	}, timeouts)
+}
+
+// The NodeConfig option fails if the node index is out of range.
+func TestNodeConfig_OutOfRange(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	option := rafttest.NodeConfig(3, func(conf *raft.Config) {})
+
+	assert.Panics(t, func() { rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), option) })
+	assert.Contains(t, buffer.String(), "NodeConfig option: node index 3 out of range (3 nodes)")
+}
+
+// Index options fail cleanly on a cluster with no nodes.
+func TestServers_NoNodes(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+
+	assert.Panics(t, func() { rafttest.Cluster(rafttest.NewReporter(buffer), nil, rafttest.Servers(0)) })
+	assert.Contains(t, buffer.String(), "Servers option: node index 0 out of range (0 nodes)")
+}
+
+// Nodes can be given custom server IDs, which Control methods accept.
+func TestServerIDs(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.ServerIDs("alpha", "beta"), rafttest.DiscardLogger())
+	defer control.Close()
+
+	require.Len(t, rafts, 3)
+	for _, id := range []raft.ServerID{"alpha", "beta", "2"} {
+		require.Contains(t, rafts, id)
+	}
+
+	term := control.Elect("beta")
+	term.Disconnect("alpha")
+
+	r := rafts["beta"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	term.Reconnect("alpha")
+	control.WaitIndex("alpha", r.LastIndex(), 0)
+
+	assert.Equal(t, uint64(1), control.Commands("alpha"))
+}
+
+// Server IDs must be unique.
+func TestServerIDs_Duplicate(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	option := rafttest.ServerIDs("a", "b", "a")
+
+	assert.Panics(t, func() { rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), option) })
+	assert.Contains(t, buffer.String(), "servers 0 and 2 have the same ID a")
+}
+
+// The Disk option makes only the given nodes use on-disk stores.
+func TestDisk(t *testing.T) {
+	skipDiskUnderRace(t)
+
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Disk(0), rafttest.Latency(10.0), rafttest.DiscardLogger())
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	require.NoError(t, r.Snapshot().Error())
+
+	control.Barrier()
+
+	assert.Equal(t, uint64(1), control.Commands("1"))
+	assert.Equal(t, uint64(1), control.Commands("2"))
+
+	dir := control.DataDir("0")
+	require.NotEmpty(t, dir)
+	assert.FileExists(t, filepath.Join(dir, "raft.db"))
+	snapshots, err := ioutil.ReadDir(filepath.Join(dir, "snapshots"))
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	assert.Empty(t, control.DataDir("1"))
+	assert.Empty(t, control.DataDir("2"))
+
+	control.Close()
+
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// Skip a test using on-disk stores if the race detector is enabled, since the
+// boltdb/bolt version that raft-boltdb depends on fails its checkptr
+// instrumentation.
+func skipDiskUnderRace(t *testing.T) {
+	if raceEnabled {
+		t.Skip("boltdb/bolt fails checkptr under the race detector")
+	}
+}
+
+// Custom stores can be plugged in, with nil ones keeping the default.
+func TestStores(t *testing.T) {
+	logs := make([]*raft.InmemStore, 3)
+	snaps := make([]*raft.InmemSnapshotStore, 3)
+	factory := func(i int) (raft.LogStore, raft.StableStore, raft.SnapshotStore) {
+		logs[i] = raft.NewInmemStore()
+		snaps[i] = raft.NewInmemSnapshotStore()
+		return logs[i], nil, snaps[i]
+	}
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Stores(factory), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+	require.NoError(t, r.Snapshot().Error())
+	control.Barrier()
+
+	for i := range logs {
+		last, err := logs[i].LastIndex()
+		require.NoError(t, err)
+		assert.True(t, last >= future.Index())
+	}
+
+	snapshots, err := snaps[0].List()
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+}
+
+// Real TCP transports can be used in place of the loopback ones.
+func TestTransports(t *testing.T) {
+	factory := func(int) (raft.Transport, error) {
+		return raft.NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, ioutil.Discard)
+	}
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Transports(factory), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(1), control.Commands("1"))
+	assert.Equal(t, uint64(1), control.Commands("2"))
+
+	// Servers added later get a transport from the factory too.
+	control.Add(rafttest.FSM())
+
+	future := r.GetConfiguration()
+	require.NoError(t, future.Error())
+	servers := future.Configuration().Servers
+	require.Len(t, servers, 4)
+	for _, server := range servers {
+		assert.Contains(t, string(server.Address), "127.0.0.1:")
+	}
+}
+
+// Transports can be wrapped with user decorators.
+func TestWrapTransport(t *testing.T) {
+	requests := make(chan struct{}, 64)
+	decorator := func(trans raft.Transport) raft.Transport {
+		return &countingTransport{Transport: trans, requests: requests}
+	}
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.WrapTransport(decorator), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	assert.NotEqual(t, 0, len(requests))
+}
+
+// Transport decorator counting append entries RPCs with entries.
+type countingTransport struct {
+	raft.Transport
+	requests chan struct{}
+}
+
+func (t *countingTransport) AppendEntries(
+	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+	if len(args.Entries) > 0 {
+		select {
+		case t.requests <- struct{}{}:
+		default:
+		}
+	}
+	return t.Transport.AppendEntries(id, target, args, resp)
+}
+
+// The NonVoters option bootstraps some nodes as non-voting servers.
+func TestNonVoters(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.NonVoters(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	future := r.GetConfiguration()
+	require.NoError(t, future.Error())
+	servers := future.Configuration().Servers
+	require.Len(t, servers, 3)
+	assert.Equal(t, raft.Voter, servers[1].Suffrage)
+	assert.Equal(t, raft.Nonvoter, servers[2].Suffrage)
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("2"))
+
+	// Promote the non-voter.
+	require.NoError(t, r.AddVoter("2", "2", 0, time.Second).Error())
+}
+
+// The Staging option bootstraps some nodes as staging servers, which can be
+// promoted to voters.
+func TestStaging(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Staging(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	future := r.GetConfiguration()
+	require.NoError(t, future.Error())
+	servers := future.Configuration().Servers
+	require.Len(t, servers, 3)
+	assert.Equal(t, raft.Staging, servers[2].Suffrage)
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Commands("2"))
+
+	require.NoError(t, r.AddVoter("2", "2", 0, time.Second).Error())
+	future = r.GetConfiguration()
+	require.NoError(t, future.Error())
+	assert.Equal(t, raft.Voter, future.Configuration().Servers[2].Suffrage)
+}
+
+// The LinkLatency option delays the RPCs sent between servers.
+func TestLinkLatency(t *testing.T) {
+	rafts, control := rafttest.Cluster(
+		t, rafttest.FSMs(3), rafttest.LinkLatency(20*time.Millisecond, 5*time.Millisecond),
+		rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	start := time.Now()
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+
+	// Remove the latency from the leader's outbound links.
+	control.SetLinkLatency("0", "1", 0, 0)
+	control.SetLinkLatency("0", "2", 0, 0)
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(2), control.Commands("1"))
+}
+
+// The ForceSnapshotAfter option makes servers take snapshots at regular
+// intervals.
+func TestForceSnapshotAfter(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.ForceSnapshotAfter(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	for n := uint64(1); n <= 2; n++ {
+		for i := 0; i < 2; i++ {
+			require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+		}
+		control.Barrier()
+
+		for _, id := range []raft.ServerID{"0", "1", "2"} {
+			snapshots := func() bool { return control.Snapshots(id) == n }
+			assert.Eventually(t, snapshots, time.Second, time.Millisecond, "server %s", id)
+		}
+	}
+}
+
+// Servers restarted or added after the cluster started keep taking forced
+// snapshots.
+func TestForceSnapshotAfter_RestartAndAdd(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.ForceSnapshotAfter(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	control.Kill(rafts["1"])
+	control.Restart(1)
+	control.Add(rafttest.FSM())
+
+	before := control.Snapshots("1")
+	for i := 0; i < 4; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+
+	restarted := func() bool { return control.Snapshots("1") > before }
+	assert.Eventually(t, restarted, time.Second, time.Millisecond)
+	adds := func() bool { return control.Snapshots("3") > 0 }
+	assert.Eventually(t, adds, time.Second, time.Millisecond)
+}
+
+// A warning is emitted if snapshot settings can't take effect.
+func TestCluster_SnapshotConfigWarning(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	config := rafttest.Config(func(i int, config *raft.Config) {
+		config.SnapshotThreshold = 10
+	})
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), config, rafttest.DiscardLogger())
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "server 0: SnapshotThreshold is set to 10 but SnapshotInterval is 24h0m0s")
+}
+
+// With the Strict option, warnings are reported as errors.
+func TestStrict(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := &failureReporter{Reporter: rafttest.NewReporter(buffer)}
+	config := rafttest.Config(func(i int, config *raft.Config) {
+		config.SnapshotThreshold = 10
+	})
+	_, control := rafttest.Cluster(reporter, rafttest.FSMs(3), config, rafttest.Strict(), rafttest.DiscardLogger())
+	control.Close()
+
+	assert.True(t, reporter.failed)
+	assert.Contains(t, buffer.String(), "SnapshotThreshold is set to 10")
+}
+
+// Reporter recording whether Errorf was called.
+type failureReporter struct {
+	rafttest.Reporter
+	failed bool
+}
+
+func (r *failureReporter) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.Reporter.Errorf(format, args...)
+}
+
+// The Strict option also checks for leaked goroutines.
+func TestStrict_LeakCheck(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.Strict(), rafttest.DiscardLogger())
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		<-done
+	}()
+
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "raft-test: close: 1 goroutines leaked")
+}
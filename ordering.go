@@ -0,0 +1,99 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/raft"
+)
+
+// AssertConfigOrdering fails the test if configuration entries were not
+// applied in the same relative order with respect to command entries on all
+// servers.
+//
+// For each log index that at least two servers have applied and still hold in
+// their log stores, the type and term of the entry must match. Applications
+// whose FSMs react to configuration changes depend on this ordering.
+func (c *Control) AssertConfigOrdering() {
+	c.t.Helper()
+
+	ids := make([]string, 0, len(c.servers))
+	for id := range c.servers {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	// For each applied index, the first server seen holding it and its
+	// entry.
+	type seen struct {
+		id  raft.ServerID
+		log raft.Log
+	}
+	entries := make(map[uint64]seen)
+
+	for _, s := range ids {
+		id := raft.ServerID(s)
+		store := c.stores.Get(id)
+
+		first, err := store.FirstIndex()
+		if err != nil {
+			c.t.Fatalf("raft-test: assert config ordering: server %s: failed to get first index: %v", id, err)
+		}
+		last, err := store.LastIndex()
+		if err != nil {
+			c.t.Fatalf("raft-test: assert config ordering: server %s: failed to get last index: %v", id, err)
+		}
+		if applied := c.servers[id].AppliedIndex(); applied < last {
+			last = applied
+		}
+		if first == 0 {
+			continue
+		}
+
+		for index := first; index <= last; index++ {
+			log := raft.Log{}
+			if err := store.GetLog(index, &log); err != nil {
+				c.t.Fatalf("raft-test: assert config ordering: server %s: failed to get log %d: %v", id, index, err)
+			}
+			if log.Type != raft.LogCommand && log.Type != raft.LogConfiguration {
+				continue
+			}
+			other, ok := entries[index]
+			if !ok {
+				entries[index] = seen{id: id, log: log}
+				continue
+			}
+			if other.log.Type != log.Type || other.log.Term != log.Term {
+				c.t.Fatalf(
+					"raft-test: assert config ordering: log %d: server %s applied a %s entry in term %d, server %s applied a %s entry in term %d",
+					index, other.id, logTypeName(other.log.Type), other.log.Term, id, logTypeName(log.Type), log.Term)
+			}
+		}
+	}
+}
+
+// Return a human readable name for the given log type.
+func logTypeName(t raft.LogType) string {
+	switch t {
+	case raft.LogCommand:
+		return "command"
+	case raft.LogConfiguration:
+		return "configuration"
+	default:
+		return fmt.Sprintf("type %d", t)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// PartitionOneWay creates an asymmetric network partition: messages from the
+// first server to the second keep flowing, while messages from the second
+// server to the first are dropped.
+//
+// That is, RPCs sent by the second server never reach the first one, while
+// RPCs sent by the first server reach the second one and get handled, but
+// their responses never come back.
+//
+// The partition stays in place until HealOneWay() is called with the same
+// servers.
+func (c *Control) PartitionOneWay(from, to *raft.Raft) {
+	c.t.Helper()
+
+	a := c.serverID(from, "partition one way")
+	b := c.serverID(to, "partition one way")
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: partition: one way from %s to %s", a, b))
+	c.network.Block(b, a)
+	c.network.BlockResponses(a, b)
+}
+
+// HealOneWay heals an asymmetric network partition created with
+// PartitionOneWay(), letting messages from the second server to the first
+// flow again.
+//
+// If the second server is the leader and it lost leadership because of the
+// partition (e.g. the first server started an election with a higher term),
+// the leader is elected again.
+func (c *Control) HealOneWay(from, to *raft.Raft) {
+	c.t.Helper()
+
+	a := c.serverID(from, "heal one way")
+	b := c.serverID(to, "heal one way")
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: partition: heal one way from %s to %s", a, b))
+	c.network.Unblock(b, a)
+	c.network.UnblockResponses(a, b)
+
+	if c.term == nil || c.term.id != b {
+		return
+	}
+	if c.waitLeadershipPropagated(b, c.term.leadership) {
+		return
+	}
+	c.Elect(b)
+}
+
+// Partition splits the cluster into the given groups of servers: RPCs between
+// servers in the same group keep flowing, while RPCs between servers in
+// different groups are dropped in both directions. Servers not listed in any
+// group are isolated from all others.
+//
+// For example, with five servers:
+//
+//	control.Partition(
+//	    []*raft.Raft{rafts["0"], rafts["1"]},
+//	    []*raft.Raft{rafts["2"], rafts["3"], rafts["4"]},
+//	)
+//
+// If the leader ends up in a minority group it will step down once its lease
+// expires. The partition stays in place until Heal() is called.
+func (c *Control) Partition(groups ...[]*raft.Raft) {
+	c.t.Helper()
+
+	group := make(map[raft.ServerID]int)
+	for i, servers := range groups {
+		for _, r := range servers {
+			id := c.serverID(r, "partition")
+			if j, ok := group[id]; ok {
+				c.t.Fatalf("raft-test: partition: server %s is in both group %d and %d", id, j, i)
+			}
+			group[id] = i
+		}
+	}
+
+	// Servers not listed in any group get a group of their own.
+	n := len(groups)
+	for _, id := range c.serverIDs() {
+		if _, ok := group[id]; !ok {
+			group[id] = n
+			n++
+		}
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: partition: %d groups", n))
+
+	for a, i := range group {
+		for b, j := range group {
+			if i != j {
+				c.network.Block(a, b)
+			}
+		}
+	}
+
+	// Track the servers cut off from the leader, if any, unless a previous
+	// partition already did.
+	if c.term == nil {
+		return
+	}
+	for _, id := range c.serverIDs() {
+		if group[id] == group[c.term.id] || c.isIsolated(id) {
+			continue
+		}
+		c.isolated = append(c.isolated, id)
+		c.uptime.Partition(id)
+		c.faultInjected(FaultAction{Kind: FaultPartition, Target: id})
+	}
+}
+
+// Heal undoes all partitions created with Partition() and PartitionOneWay(),
+// letting all RPCs flow again.
+//
+// If the leader lost leadership because of the partitions, it's elected
+// again.
+func (c *Control) Heal() {
+	c.t.Helper()
+
+	c.logger.Debug("[DEBUG] raft-test: partition: heal")
+
+	if c.term == nil {
+		c.network.UnblockAll()
+		c.healed()
+		return
+	}
+
+	leader := c.term.id
+	lost := false
+	select {
+	case <-c.term.leadership.Lost():
+		lost = true
+	default:
+	}
+
+	if lost {
+		// Prevent the former leader from winning an election on its
+		// own as soon as RPCs flow again.
+		c.network.Deposing(leader)
+		c.network.UnblockAll()
+		c.healed()
+		c.Elect(leader)
+		return
+	}
+
+	c.network.UnblockAll()
+	c.healed()
+	if !c.waitLeadershipPropagated(leader, c.term.leadership) {
+		c.Elect(leader)
+	}
+}
+
+// Return true if the server with the given ID was isolated from the leader by
+// Partition().
+func (c *Control) isIsolated(id raft.ServerID) bool {
+	for _, other := range c.isolated {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Record that all servers isolated by Partition() have been healed.
+func (c *Control) healed() {
+	for _, id := range c.isolated {
+		c.uptime.Heal(id)
+		c.faultHealed(FaultAction{Kind: FaultPartition, Target: id})
+	}
+	c.isolated = nil
+}
+
+// Return the ID of the given server, failing the test if it's not part of the
+// cluster.
+func (c *Control) serverID(r *raft.Raft, what string) raft.ServerID {
+	c.t.Helper()
+
+	for id, server := range c.servers {
+		if server == r {
+			return id
+		}
+	}
+	c.t.Fatalf("raft-test: %s: unknown server", what)
+
+	return ""
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RPCs can be dropped in one direction only.
+func TestControl_PartitionOneWay(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	// The leader can't reach server 1, but server 1 could reach the
+	// leader.
+	control.PartitionOneWay(rafts["1"], rafts["0"])
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	assert.Equal(t, uint64(0), control.Commands("1"))
+
+	control.HealOneWay(rafts["1"], rafts["0"])
+	control.Barrier()
+
+	assert.Equal(t, uint64(1), control.Commands("1"))
+}
+
+// RPCs sent across a one way partition in the allowed direction get handled,
+// but their responses are dropped.
+func TestControl_PartitionOneWay_Responses(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	// The leader can reach server 1, but server 1 can't reach the leader.
+	control.PartitionOneWay(rafts["0"], rafts["1"])
+	assert.Contains(t, control.String(), "up,responses-blocked")
+
+	r := rafts["0"]
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+	control.WaitIndex("1", future.Index(), 0)
+
+	control.HealOneWay(rafts["0"], rafts["1"])
+	assert.NotContains(t, control.String(), "responses-blocked")
+}
+
+// The cluster can be split into groups and healed.
+func TestControl_Partition(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(5), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	control.Partition(
+		[]*raft.Raft{rafts["0"], rafts["1"], rafts["2"]},
+		[]*raft.Raft{rafts["3"], rafts["4"]},
+	)
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	assert.Equal(t, uint64(0), control.Commands("3"))
+	assert.Equal(t, uint64(0), control.Commands("4"))
+
+	control.Heal()
+	control.Barrier()
+
+	for id := range rafts {
+		assert.Equal(t, uint64(1), control.Commands(id))
+	}
+}
+
+// If the leader ends up in a minority, it steps down and gets elected again
+// after healing.
+func TestControl_Partition_LeaderInMinority(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	control.Partition([]*raft.Raft{rafts["0"]}, []*raft.Raft{rafts["1"], rafts["2"]})
+
+	r := rafts["0"]
+	assert.Equal(t, raft.ErrLeadershipLost, r.Apply([]byte{}, time.Second).Error())
+
+	control.Heal()
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+}
+
+// Partitioning the cluster again doesn't isolate the same servers twice.
+func TestControl_Partition_Twice(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(5), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	healed := 0
+	control.OnFaultHealed(func(action rafttest.FaultAction) {
+		if action.Kind == rafttest.FaultPartition {
+			healed++
+		}
+	})
+
+	leader := []*raft.Raft{rafts["0"], rafts["1"], rafts["2"]}
+	control.Partition(leader, []*raft.Raft{rafts["3"], rafts["4"]})
+	control.Partition(leader, []*raft.Raft{rafts["3"]}, []*raft.Raft{rafts["4"]})
+	assert.Contains(t, control.String(), "faults: servers 3,4 partitioned")
+
+	control.Heal()
+	assert.Equal(t, 2, healed)
+}
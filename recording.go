@@ -0,0 +1,188 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Recording is the sequence of faults injected into a cluster, either by
+// Chaos(), by a Nemesis or directly with methods like Depose(), Partition()
+// and Term.Disconnect(), as returned by Control.Recording().
+//
+// Its fields are exported, so it can be serialized, for example as JSON, by a
+// failing CI job and fed to Replay() locally.
+type Recording struct {
+	Leader raft.ServerID   // Leader elected before the first fault
+	Faults []RecordedFault // Injected faults, in order
+}
+
+// RecordedFault is a fault injected into a cluster.
+type RecordedFault struct {
+	Action FaultAction   // Fault injected, with Dwell set to how long it stayed in place
+	Offset time.Duration // When it was injected, since the first leader got elected
+	Leader raft.ServerID // Leader after the fault was healed, if any
+	Healed bool          // Whether the fault was healed
+}
+
+func (r Recording) String() string {
+	lines := []string{fmt.Sprintf("leader %s", r.Leader)}
+	for _, fault := range r.Faults {
+		line := fmt.Sprintf("%s: %s", fault.Offset, fault.Action)
+		if fault.Healed && fault.Leader != "" {
+			line += fmt.Sprintf(" (then leader %s)", fault.Leader)
+		}
+		if !fault.Healed {
+			line += " (not healed)"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Recording returns all faults injected so far into the cluster, with their
+// timing, so the same sequence can be replayed with Replay().
+func (c *Control) Recording() Recording {
+	return c.recorder.Recording()
+}
+
+// Replay creates a fresh cluster with the given FSMs and options, elects the
+// same leader as the recorded cluster and injects the recorded faults into
+// it, at the same offsets and for the same dwell times. It returns when the
+// last fault has been healed, and the test can then run its own assertions.
+//
+// This is meant to reproduce locally flaky failures found in CI. Faults
+// injected with Partition() are replayed by isolating each cut off server,
+// and deposed leaders are replaced by the same new leader as in the
+// recording. Faults that were never healed are left in place.
+func Replay(t Reporter, recording Recording, fsms []raft.FSM, options ...Option) (map[raft.ServerID]*raft.Raft, *Control) {
+	t.Helper()
+
+	rafts, control := Cluster(t, fsms, options...)
+	if recording.Leader == "" {
+		return rafts, control
+	}
+	control.Elect(recording.Leader)
+	control.replay(recording.Faults)
+
+	return rafts, control
+}
+
+// Inject and heal the given faults following their recorded timing.
+func (c *Control) replay(faults []RecordedFault) {
+	c.t.Helper()
+
+	// Timeline of injections and heals.
+	type step struct {
+		at    time.Duration
+		fault int
+		heal  bool
+	}
+	steps := make([]step, 0, 2*len(faults))
+	for i, fault := range faults {
+		steps = append(steps, step{at: fault.Offset, fault: i})
+		if fault.Healed {
+			steps = append(steps, step{at: fault.Offset + fault.Action.Dwell, fault: i, heal: true})
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at < steps[j].at })
+
+	heals := make([]func(), len(faults))
+	start := time.Now()
+	for _, step := range steps {
+		if wait := step.at - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+		fault := faults[step.fault]
+		action := fault.Action
+
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: replay: %s: %s (heal %v)", step.at, action, step.heal))
+
+		if !step.heal {
+			heals[step.fault] = c.injectFault(action)
+			continue
+		}
+		if action.Kind == FaultDepose && fault.Leader != "" {
+			c.Elect(fault.Leader)
+			continue
+		}
+		heals[step.fault]()
+	}
+}
+
+// Record the faults injected into a cluster.
+type faultRecorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	recording Recording
+	injected  []time.Time // Injection time of each fault
+}
+
+// Record that the given server was elected.
+func (r *faultRecorder) Elected(id raft.ServerID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recording.Leader == "" {
+		r.recording.Leader = id
+		r.start = time.Now()
+	}
+}
+
+// Record that the given fault was injected.
+func (r *faultRecorder) Injected(action FaultAction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recording.Leader == "" {
+		return
+	}
+	now := time.Now()
+	r.recording.Faults = append(r.recording.Faults, RecordedFault{
+		Action: action,
+		Offset: now.Sub(r.start),
+	})
+	r.injected = append(r.injected, now)
+}
+
+// Record that all pending faults of the same kind and target as the given
+// one were healed, with the given server being the leader.
+func (r *faultRecorder) Healed(action FaultAction, leader raft.ServerID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.recording.Faults {
+		fault := &r.recording.Faults[i]
+		if fault.Healed || fault.Action.Kind != action.Kind || fault.Action.Target != action.Target {
+			continue
+		}
+		fault.Healed = true
+		fault.Action.Dwell = time.Since(r.injected[i])
+		fault.Leader = leader
+	}
+}
+
+// Return a copy of the recording.
+func (r *faultRecorder) Recording() Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Recording{
+		Leader: r.recording.Leader,
+		Faults: append([]RecordedFault{}, r.recording.Faults...),
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Faults injected into a cluster are recorded, and can be replayed against a
+// fresh cluster.
+func TestReplay(t *testing.T) {
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.Depose()
+	term := control.Elect("1")
+	term.Disconnect("2")
+	time.Sleep(50 * time.Millisecond)
+	term.Reconnect("2")
+
+	recording := control.Recording()
+	assert.Equal(t, raft.ServerID("0"), recording.Leader)
+	require.Len(t, recording.Faults, 2)
+
+	depose := recording.Faults[0]
+	assert.Equal(t, rafttest.FaultDepose, depose.Action.Kind)
+	assert.Equal(t, raft.ServerID("0"), depose.Action.Target)
+	assert.Equal(t, raft.ServerID("1"), depose.Leader)
+	assert.True(t, depose.Healed)
+
+	disconnect := recording.Faults[1]
+	assert.Equal(t, rafttest.FaultDisconnect, disconnect.Action.Kind)
+	assert.Equal(t, raft.ServerID("2"), disconnect.Action.Target)
+	assert.True(t, disconnect.Action.Dwell >= 50*time.Millisecond)
+	assert.True(t, disconnect.Offset >= depose.Offset)
+
+	// The recording survives a round trip through JSON, as it would when
+	// shipped from a CI job.
+	data, err := json.Marshal(recording)
+	require.NoError(t, err)
+	recording = rafttest.Recording{}
+	require.NoError(t, json.Unmarshal(data, &recording))
+
+	rafts, replayed := rafttest.Replay(t, recording, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer replayed.Close()
+
+	assert.Equal(t, raft.Leader, rafts["1"].State())
+
+	faults := replayed.Recording().Faults
+	require.Len(t, faults, 2)
+	for i, fault := range faults {
+		assert.Equal(t, recording.Faults[i].Action.Kind, fault.Action.Kind)
+		assert.Equal(t, recording.Faults[i].Action.Target, fault.Action.Target)
+		assert.Equal(t, recording.Faults[i].Leader, fault.Leader)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/raft-test/internal/fsms"
+	"github.com/hashicorp/raft"
+)
+
+// ReplayInto rebuilds, in the given FSM, the state that the server with the
+// given index should have had after applying the log with the given index,
+// using only what it has persisted in its stores. This is meant to answer
+// "what state should this server have had at index N" while debugging complex
+// scenarios, without touching the cluster.
+//
+// The most recent snapshot not past upToIndex, if any, is restored into the
+// FSM and the command logs that follow it are then applied, up to and
+// including upToIndex. A zero upToIndex means the last stored log.
+//
+// The server may be running, killed or crashed. The test fails if the needed
+// logs have already been compacted away, for instance because only the latest
+// snapshot was retained (see the SnapshotArchive option).
+func (c *Control) ReplayInto(i int, fsm raft.FSM, upToIndex uint64) {
+	c.t.Helper()
+
+	if i < 0 || i >= len(c.nodes) {
+		c.t.Fatalf("raft-test: replay: server index %d out of range (%d servers)", i, len(c.nodes))
+	}
+	d := c.nodes[i]
+	id := d.Conf.LocalID
+
+	first, err := d.Logs.FirstIndex()
+	if err != nil {
+		c.t.Fatalf("raft-test: replay: server %s: can't get first index: %v", id, err)
+	}
+	last, err := d.Logs.LastIndex()
+	if err != nil {
+		c.t.Fatalf("raft-test: replay: server %s: can't get last index: %v", id, err)
+	}
+	if upToIndex == 0 {
+		upToIndex = last
+	}
+	if upToIndex > last {
+		c.t.Fatalf("raft-test: replay: server %s: index %d is past the last stored log (%d)", id, upToIndex, last)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: replay: server %s: up to index %d", id, upToIndex))
+
+	index := c.replaySnapshot(id, d.Snaps, fsm, upToIndex)
+
+	if index < upToIndex && index+1 < first {
+		c.t.Fatalf("raft-test: replay: server %s: logs from %d to %d were compacted", id, index+1, first-1)
+	}
+
+	for index < upToIndex {
+		index++
+		log := &raft.Log{}
+		if err := d.Logs.GetLog(index, log); err != nil {
+			c.t.Fatalf("raft-test: replay: server %s: can't get log %d: %v", id, index, err)
+		}
+		if log.Type != raft.LogCommand {
+			continue
+		}
+		if result := fsm.Apply(log); result != nil {
+			if err, ok := result.(error); ok {
+				c.t.Fatalf("raft-test: replay: server %s: apply log %d: %v", id, index, err)
+			}
+		}
+	}
+}
+
+// Restore into the given FSM the most recent snapshot of the given store whose
+// index is not greater than the given one, returning the snapshot index, or
+// zero if there's no such snapshot.
+func (c *Control) replaySnapshot(id raft.ServerID, store raft.SnapshotStore, fsm raft.FSM, upToIndex uint64) uint64 {
+	c.t.Helper()
+
+	snapshots, err := store.List()
+	if err != nil {
+		c.t.Fatalf("raft-test: replay: server %s: can't list snapshots: %v", id, err)
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Index > upToIndex {
+			continue
+		}
+		_, reader, err := store.Open(snapshot.ID)
+		if err != nil {
+			c.t.Fatalf("raft-test: replay: server %s: can't open snapshot %s: %v", id, snapshot.ID, err)
+		}
+		defer reader.Close()
+
+		// Skip the header added by the harness.
+		if _, err := fsms.ReadSnapshotHeader(reader); err != nil {
+			c.t.Fatalf("raft-test: replay: server %s: read snapshot %s: %v", id, snapshot.ID, err)
+		}
+		if err := fsm.Restore(reader); err != nil {
+			c.t.Fatalf("raft-test: replay: server %s: restore snapshot %s: %v", id, snapshot.ID, err)
+		}
+		return snapshot.Index
+	}
+
+	return 0
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The stored logs of a server can be replayed into a fresh FSM, up to a given
+// index.
+func TestControl_ReplayInto(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+
+	var index uint64
+	for i := 0; i < 5; i++ {
+		future := r.Apply([]byte{}, time.Second)
+		require.NoError(t, future.Error())
+		if i == 2 {
+			index = future.Index()
+		}
+	}
+	control.Barrier()
+
+	fsm := &counterFSM{}
+	control.ReplayInto(1, fsm, 0)
+	assert.Equal(t, uint64(5), fsm.n)
+
+	fsm = &counterFSM{}
+	control.ReplayInto(1, fsm, index)
+	assert.Equal(t, uint64(3), fsm.n)
+}
+
+// If the server has a snapshot, it gets restored before replaying the logs
+// that follow it.
+func TestControl_ReplayInto_Snapshot(t *testing.T) {
+	fsms := []raft.FSM{&counterFSM{}, &counterFSM{}, &counterFSM{}}
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+	require.NoError(t, rafts["1"].Snapshot().Error())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+
+	fsm := &counterFSM{}
+	control.ReplayInto(1, fsm, 0)
+	assert.True(t, fsm.restored)
+	assert.Equal(t, uint64(5), fsm.n)
+}
+
+// FSM counting applied commands, and including the count in snapshots.
+type counterFSM struct {
+	mu       sync.Mutex
+	n        uint64
+	restored bool
+}
+
+func (f *counterFSM) Apply(*raft.Log) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+	return nil
+}
+
+func (f *counterFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, f.n)
+	return &counterSnapshot{data: data}, nil
+}
+
+func (f *counterFSM) Restore(reader io.ReadCloser) error {
+	defer reader.Close()
+	data := make([]byte, 8)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n = binary.BigEndian.Uint64(data)
+	f.restored = true
+	return nil
+}
+
+type counterSnapshot struct {
+	data []byte
+}
+
+func (s *counterSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *counterSnapshot) Release() {}
@@ -0,0 +1,95 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Reporter is used by the harness to report failures and log entries.
+//
+// It's implemented by testing.T and testing.B, which are what you typically
+// pass to Cluster() and other helpers. Programs that embed the harness outside
+// of go test, such as long-running QA services or demos, can use NewReporter()
+// or provide their own implementation.
+//
+// Fatalf must not return: the testing package stops the calling goroutine,
+// other implementations typically panic.
+type Reporter interface {
+	Helper()
+	Logf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// NewReporter returns a Reporter suitable for use outside of go test, which
+// writes log entries and failures to the given writer, one per line.
+//
+// Its Fatalf method panics with the formatted message after writing it, and its
+// Failed method tells whether Errorf or Fatalf were called.
+func NewReporter(w io.Writer) Reporter {
+	return &writerReporter{w: w}
+}
+
+// Reporter writing to an io.Writer.
+type writerReporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+func (r *writerReporter) Helper() {}
+
+func (r *writerReporter) Logf(format string, args ...interface{}) {
+	r.write(format, args...)
+}
+
+func (r *writerReporter) Errorf(format string, args ...interface{}) {
+	r.fail()
+	r.write(format, args...)
+}
+
+func (r *writerReporter) Fatalf(format string, args ...interface{}) {
+	r.fail()
+	panic(r.write(format, args...))
+}
+
+func (r *writerReporter) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+func (r *writerReporter) fail() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = true
+}
+
+// Write a single formatted line and return it.
+func (r *writerReporter) write(format string, args ...interface{}) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	line := fmt.Sprintf(format, args...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line += "\n"
+	}
+	fmt.Fprint(r.w, line)
+
+	return line
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A cluster can be driven without a testing.T.
+func TestNewReporter(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+
+	rafts, control := rafttest.Cluster(reporter, rafttest.FSMs(3))
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Close()
+
+	assert.Contains(t, buffer.String(), "entering Leader state")
+}
+
+// Fatal failures panic.
+func TestNewReporter_Fatal(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+
+	f := func() {
+		rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.Servers(5))
+	}
+	assert.Panics(t, f)
+	assert.Contains(t, buffer.String(), "index 5")
+}
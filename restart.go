@@ -0,0 +1,247 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Kill shuts down the given server abruptly, without removing it from the
+// cluster configuration. Its log store, stable store and snapshot store are
+// kept, so it can be brought back with Restart() to exercise crash-recovery
+// paths, such as log replay and snapshot restore on boot.
+//
+// The server is removed from the map returned by Cluster() until it gets
+// restarted. It must not be the leader: use Depose() first to kill a leader.
+func (c *Control) Kill(r *raft.Raft) {
+	c.t.Helper()
+
+	id := c.serverID(r, "kill")
+	if c.term != nil && c.term.id == id && r.State() == raft.Leader {
+		c.t.Fatalf("raft-test: kill: server %s is the leader", id)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: kill: server %s", id))
+
+	c.shutdownServer(id)
+	c.recordCancellations(id, "kill")
+	c.dropServer(id)
+
+	// Make RPCs sent to the killed server fail right away, instead of
+	// timing out.
+	address := c.network.Address(id)
+	for other := range c.servers {
+		if peer, ok := c.network.Underlying(other).(raft.LoopbackTransport); ok {
+			peer.Disconnect(address)
+		}
+	}
+	c.disconnectTCP(id)
+}
+
+// Crash tears down the given server abruptly, to approximate a process being
+// killed with SIGKILL. Unlike Kill(), the server doesn't get a chance to shut
+// down cleanly:
+//
+//   - its log, stable and snapshot stores are frozen first, so nothing
+//     written from then on is persisted;
+//   - its transport is severed in both directions, so no RPC gets in or out;
+//   - its raft instance is left running in isolation, and only shut down
+//     when the server gets restarted or the cluster closed, so pending
+//     futures are abandoned rather than cleanly cancelled.
+//
+// As with Kill(), the server is removed from the map returned by Cluster(),
+// can be brought back with Restart(), and must not be the leader.
+func (c *Control) Crash(r *raft.Raft) {
+	c.t.Helper()
+
+	id := c.serverID(r, "crash")
+	if c.term != nil && c.term.id == id && r.State() == raft.Leader {
+		c.t.Fatalf("raft-test: crash: server %s is the leader", id)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: crash: server %s", id))
+
+	c.stores.Crash(id, true)
+
+	address := c.network.Address(id)
+	if loopback, ok := c.network.Underlying(id).(raft.LoopbackTransport); ok {
+		loopback.DisconnectAll()
+	}
+	for other := range c.servers {
+		if peer, ok := c.network.Underlying(other).(raft.LoopbackTransport); ok {
+			peer.Disconnect(address)
+		}
+	}
+	c.disconnectTCP(id)
+
+	c.crashed[id] = r
+	c.uptime.Stop(id, true)
+	c.dropServer(id)
+}
+
+// Shut down the raft instance of a server crashed with Crash() and wait for it
+// to be fully gone, so its stores can be reused.
+func (c *Control) reapServer(id raft.ServerID) {
+	c.t.Helper()
+
+	r, ok := c.crashed[id]
+	if !ok {
+		return
+	}
+	delete(c.crashed, id)
+
+	timeout := Duration(2 * time.Second)
+	ch := make(chan error, 1)
+	go func() {
+		ch <- r.Shutdown().Error()
+	}()
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+		c.t.Errorf("\n\t%s", c.stacks())
+		c.t.Fatalf("raft-test: crash: server %s: shutdown timeout (%s)", id, timeout)
+	}
+	c.recordCancellations(id, "crash")
+
+	c.stores.Crash(id, false)
+}
+
+// Shut down all servers crashed with Crash() and never restarted, and wait
+// for them to be fully gone.
+func (c *Control) reapCrashed() {
+	c.t.Helper()
+
+	for id := range c.crashed {
+		c.reapServer(id)
+	}
+}
+
+// Restart brings back the server with the given index, previously killed
+// with Kill() or crashed with Crash(), using the same stores, transport and
+// FSM it had before. The new raft instance is also put back in the map
+// returned by Cluster().
+//
+// If the server was set up with the Disk option, its stores are closed and
+// opened again from its data directory, so the restarted server only sees
+// what was actually persisted to disk.
+//
+// As with a real process restart, raft restores the FSM from the latest
+// snapshot, if any, and then re-applies the committed logs that follow it, so
+// the FSM must be able to reset its state when that happens. Commands()
+// reflects only the logs applied since the restart.
+//
+// If a leader is currently elected, it gets deposed while the server restarts
+// and then elected again, so the restarted server can't disrupt it by
+// starting an election of its own.
+func (c *Control) Restart(i int) *raft.Raft {
+	c.t.Helper()
+
+	if i < 0 || i >= len(c.nodes) {
+		c.t.Fatalf("raft-test: restart: server index %d out of range (%d servers)", i, len(c.nodes))
+	}
+	d := c.nodes[i]
+	id := d.Conf.LocalID
+	if _, ok := c.servers[id]; ok {
+		c.t.Fatalf("raft-test: restart: server %s is running", id)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: restart: server %s: start", id))
+
+	c.reapServer(id)
+
+	if d.Dir != "" {
+		c.reopenData(d)
+	}
+
+	var leader raft.ServerID
+	if c.term != nil && c.servers[c.term.id].State() == raft.Leader {
+		leader = c.term.id
+		c.depose()
+	}
+
+	if loopback, ok := c.network.Underlying(id).(raft.LoopbackTransport); ok {
+		c.connectLoopback(loopback)
+	}
+	c.reconnectTCP(id)
+	c.watcher.Restarting(id)
+
+	restores := c.Restores(id)
+	r, err := newRaft(d)
+	if err != nil {
+		c.t.Fatalf("raft-test: restart: server %s failed to start: %v", id, err)
+	}
+
+	// Raft restores the latest snapshot synchronously at startup, if any.
+	if n := c.Restores(id); n > restores {
+		c.recordBootRestore(id, n, d.Snaps)
+	}
+	c.addServer(id, r, d.Conf)
+	c.uptime.Start(id)
+	c.observeEvents(id, r)
+
+	if leader != "" {
+		c.Elect(leader)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: restart: server %s: done", id))
+
+	return r
+}
+
+// Record that the n'th restore of the server with the given ID was performed
+// at startup, using the latest snapshot in the given store.
+func (c *Control) recordBootRestore(id raft.ServerID, n uint64, store raft.SnapshotStore) {
+	c.t.Helper()
+
+	snapshots, err := store.List()
+	if err != nil || len(snapshots) == 0 {
+		c.t.Fatalf("raft-test: restart: server %s: can't list restored snapshot: %v", id, err)
+	}
+
+	if c.bootRestores[id] == nil {
+		c.bootRestores[id] = make(map[uint64]Restore)
+	}
+	c.bootRestores[id][n] = Restore{
+		Source: RestoreBoot,
+		Index:  snapshots[0].Index,
+		Term:   snapshots[0].Term,
+	}
+}
+
+// Close the on-disk stores of the given server and open them again from its
+// data directory.
+func (c *Control) reopenData(d *dependencies) {
+	c.t.Helper()
+
+	id := d.Conf.LocalID
+
+	if closer, ok := d.Logs.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.t.Fatalf("raft-test: restart: server %s: failed to close store: %v", id, err)
+		}
+	}
+
+	store, snaps, err := openDiskStores(d.Dir, d.Conf.Logger)
+	if err != nil {
+		c.t.Fatalf("raft-test: restart: server %s: %v", id, err)
+	}
+	c.stores.Replace(id, store, store, snaps)
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: restart: server %s: reopened data dir %s", id, d.Dir))
+}
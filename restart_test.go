@@ -0,0 +1,155 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A killed follower replays its logs when restarted.
+func TestControl_KillAndRestart(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	killed := rafts["1"]
+	control.Kill(killed)
+	assert.Equal(t, raft.ErrRaftShutdown, killed.Apply([]byte{}, time.Second).Error())
+	assert.NotContains(t, control.Running(), raft.ServerID("1"))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+
+	restarted := control.Restart(1)
+	assert.Equal(t, control.Running()["1"], restarted)
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, raft.Follower, restarted.State())
+	assert.Equal(t, uint64(5), control.Commands("1"))
+	assert.Equal(t, uint64(0), control.Restores("1"))
+}
+
+// A killed follower restores its latest snapshot when restarted.
+func TestControl_KillAndRestart_Snapshot(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithSnapshots().Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+	future := rafts["1"].Snapshot()
+	require.NoError(t, future.Error())
+	meta, reader, err := future.Open()
+	require.NoError(t, err)
+	reader.Close()
+	index := meta.Index
+
+	control.Kill(rafts["1"])
+	control.Restart(1)
+
+	restore := control.WaitRestore("1", 0)
+	assert.Equal(t, rafttest.RestoreBoot, restore.Source)
+	assert.Equal(t, index, restore.Index)
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(1), control.Restores("1"))
+	assert.Equal(t, uint64(3), control.Commands("1"))
+}
+
+// Servers using on-disk stores get them reopened from their data directory
+// upon restart.
+func TestControl_KillAndRestart_Disk(t *testing.T) {
+	skipDiskUnderRace(t)
+
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Disk(1), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	control.Barrier()
+
+	control.Kill(rafts["1"])
+	restarted := control.Restart(1)
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	// The leader's barrier log might not have been replicated yet.
+	control.WaitIndex("1", r.LastIndex(), 0)
+
+	assert.Equal(t, r.LastIndex(), restarted.LastIndex())
+	assert.Equal(t, uint64(3), control.Commands("1"))
+}
+
+// A crashed follower gets its stores frozen, and catches up when restarted.
+func TestControl_CrashAndRestart(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	crashed := rafts["1"]
+	control.Crash(crashed)
+	assert.NotContains(t, control.Running(), raft.ServerID("1"))
+	assert.Equal(t, 1, control.DowntimeReport()["1"].Crashes)
+	assert.NotEqual(t, raft.Shutdown, crashed.State())
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+
+	restarted := control.Restart(1)
+	assert.Equal(t, raft.Shutdown, crashed.State())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, raft.Follower, restarted.State())
+	assert.Equal(t, uint64(5), control.Commands("1"))
+	assert.Equal(t, 2, control.DowntimeReport()["1"].Starts)
+}
+
+// A crashed follower that is never restarted gets reaped on close.
+func TestControl_Crash_NoRestart(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	control.Crash(rafts["2"])
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+}
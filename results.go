@@ -0,0 +1,170 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StepResult holds the outcome of a single step of a long-running scenario,
+// such as a step of RunNemesis().
+type StepResult struct {
+	Name       string        // Human-readable name of the step
+	Duration   time.Duration // How long the step took
+	Failed     bool          // Whether the test failed during the step
+	Invariants []string      // Invariants checked during the step
+}
+
+// Results returns the outcome of all scenario steps run so far, in order.
+//
+// A step during which the test failed is included, but all following ones
+// are not, since they never ran.
+func (c *Control) Results() []StepResult {
+	return append([]StepResult(nil), c.results...)
+}
+
+// WriteResults makes the cluster write the outcome of all scenario steps to
+// the file at the given path when it's closed, so CI systems can display
+// each step as an individual test case.
+//
+// If the path has a .xml extension the results are written as a JUnit XML
+// test suite, otherwise they are written as JSON.
+func (c *Control) WriteResults(path string) {
+	c.resultsPath = path
+}
+
+// Write the results to the file set with WriteResults(), if any.
+func (c *Control) writeResults() {
+	if c.resultsPath == "" {
+		return
+	}
+
+	f, err := os.Create(c.resultsPath)
+	if err != nil {
+		c.t.Errorf("raft-test: close: failed to create results file: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if filepath.Ext(c.resultsPath) == ".xml" {
+		err = WriteJUnit(f, c.testName(), c.results)
+	} else {
+		err = WriteJSON(f, c.results)
+	}
+	if err != nil {
+		c.t.Errorf("raft-test: close: failed to write results file: %v", err)
+	}
+}
+
+// WriteJUnit writes the given results to the given writer as a JUnit XML test
+// suite with the given name, with one test case per step.
+func WriteJUnit(w io.Writer, name string, results []StepResult) error {
+	suite := junitSuite{Name: name, Tests: len(results)}
+	for _, result := range results {
+		testcase := junitCase{
+			Name:      result.Name,
+			Classname: name,
+			Time:      result.Duration.Seconds(),
+		}
+		if len(result.Invariants) > 0 {
+			testcase.Output = fmt.Sprintf("invariants checked: %v", result.Invariants)
+		}
+		if result.Failed {
+			suite.Failures++
+			testcase.Failure = &junitFailure{Message: "test failed during step"}
+		}
+		suite.Time += testcase.Time
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON writes the given results to the given writer as a JSON array,
+// with one object per step.
+func WriteJSON(w io.Writer, results []StepResult) error {
+	steps := make([]jsonStep, len(results))
+	for i, result := range results {
+		steps[i] = jsonStep{
+			Name:       result.Name,
+			Duration:   result.Duration.Seconds(),
+			Status:     "passed",
+			Invariants: result.Invariants,
+		}
+		if result.Failed {
+			steps[i].Status = "failed"
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(steps)
+}
+
+// JUnit XML test suite.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// JUnit XML test case.
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+// JUnit XML test case failure.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// JSON step result.
+type jsonStep struct {
+	Name       string   `json:"name"`
+	Duration   float64  `json:"duration"`
+	Status     string   `json:"status"`
+	Invariants []string `json:"invariants,omitempty"`
+}
+
+// Return the name of the running test, if the Reporter has one (as
+// *testing.T does), or "raft-test" otherwise.
+func (c *Control) testName() string {
+	if named, ok := c.t.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "raft-test"
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The outcome of each nemesis step can be written to a JUnit XML file.
+func TestControl_WriteResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.xml")
+
+	_, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	control.WriteResults(path)
+
+	control.Elect("0")
+	control.RunNemesis(&rafttest.MinorityNemesis{Dwell: 10 * time.Millisecond}, 2)
+
+	results := control.Results()
+	require.Len(t, results, 2)
+	assert.Equal(t, "nemesis step 0: disconnect server 1 for 10ms", results[0].Name)
+	assert.Equal(t, []string{"majority of voters up", "leader elected"}, results[0].Invariants)
+	assert.False(t, results[1].Failed)
+
+	control.Close()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `<testsuite name="TestControl_WriteResults" tests="2" failures="0"`)
+	assert.Contains(t, string(data), `<testcase name="nemesis step 1: disconnect server 2 for 10ms"`)
+}
+
+// Results can be written as JSON.
+func TestWriteJSON(t *testing.T) {
+	results := []rafttest.StepResult{
+		{Name: "a", Duration: time.Second, Invariants: []string{"x"}},
+		{Name: "b", Failed: true},
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	require.NoError(t, rafttest.WriteJSON(buffer, results))
+
+	steps := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &steps))
+	require.Len(t, steps, 2)
+	assert.Equal(t, "a", steps[0]["name"])
+	assert.Equal(t, 1.0, steps[0]["duration"])
+	assert.Equal(t, "passed", steps[0]["status"])
+	assert.Equal(t, "failed", steps[1]["status"])
+}
@@ -0,0 +1,319 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/binary"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// The functions below reproduce classic raft scenarios against the given FSMs,
+// failing the test if the guarantees they exercise don't hold. They serve both
+// as regression tests for FSM implementations and as executable documentation
+// of what raft guarantees.
+//
+// Each scenario creates its own cluster with the given FSMs and options, and
+// closes it before returning.
+
+// ScenarioFigure8 reproduces the situation described in Figure 8 of the raft
+// paper, where a leader gets deposed after replicating a command log to all
+// followers but before committing it.
+//
+// The client of the deposed leader gets ErrLeadershipLost, yet the command log
+// is not lost: the next leader must not commit it by counting replicas, but
+// only indirectly, as soon as a command log from its own term is committed.
+// The scenario checks that in the end all FSMs applied the command log
+// exactly once.
+//
+// At least three FSMs are needed.
+func ScenarioFigure8(t Reporter, fsms []raft.FSM, options ...Option) {
+	t.Helper()
+
+	checkScenarioFSMs(t, "figure 8", fsms, 3)
+
+	rafts, control := Cluster(t, fsms, options...)
+	defer control.Close()
+
+	timeout := Duration(time.Second)
+
+	control.Elect("0").When().Command(2).Appended().Depose()
+
+	r := rafts["0"]
+	if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+		t.Fatalf("raft-test: scenario: figure 8: first apply failed: %v", err)
+	}
+	if err := r.Apply([]byte{}, timeout).Error(); err != raft.ErrLeadershipLost {
+		t.Fatalf("raft-test: scenario: figure 8: expected leadership lost on second apply, got: %v", err)
+	}
+
+	control.Elect("1")
+
+	r = rafts["1"]
+	if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+		t.Fatalf("raft-test: scenario: figure 8: apply on new leader failed: %v", err)
+	}
+
+	control.Barrier()
+
+	for id := range rafts {
+		if n := control.Commands(id); n != 3 {
+			t.Fatalf("raft-test: scenario: figure 8: server %s: applied %d commands instead of 3", id, n)
+		}
+	}
+}
+
+// ScenarioLeaderCompleteness checks the leader completeness property of raft:
+// a command log committed in a term is present in the logs of the leaders of
+// all higher terms.
+//
+// Leadership is moved across all servers in turn, each leader applying a
+// command log and checking that all command logs committed by previous leaders
+// have been applied to its FSM.
+//
+// At least three FSMs are needed.
+func ScenarioLeaderCompleteness(t Reporter, fsms []raft.FSM, options ...Option) {
+	t.Helper()
+
+	checkScenarioFSMs(t, "leader completeness", fsms, 3)
+
+	rafts, control := Cluster(t, fsms, options...)
+	defer control.Close()
+
+	timeout := Duration(time.Second)
+
+	for i, d := range control.nodes {
+		id := d.Conf.LocalID
+		control.Elect(id)
+
+		r := rafts[id]
+		if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+			t.Fatalf("raft-test: scenario: leader completeness: server %s: apply failed: %v", id, err)
+		}
+		control.Barrier()
+
+		if n := control.Commands(id); n != uint64(i+1) {
+			t.Fatalf("raft-test: scenario: leader completeness: server %s: applied %d commands instead of %d", id, n, i+1)
+		}
+
+		if i < len(fsms)-1 {
+			control.Depose()
+		}
+	}
+}
+
+// ScenarioSnapshotCatchUp checks that a follower lagging behind the leader
+// catches up by installing a snapshot, once the leader has compacted the
+// command logs the follower is missing.
+//
+// The given FSMs must support snapshots and restores. At least three FSMs are
+// needed.
+func ScenarioSnapshotCatchUp(t Reporter, fsms []raft.FSM, options ...Option) {
+	t.Helper()
+
+	checkScenarioFSMs(t, "snapshot catch-up", fsms, 3)
+
+	rafts, control := Cluster(t, fsms, options...)
+	defer control.Close()
+
+	timeout := Duration(time.Second)
+
+	term := control.Elect("0")
+
+	r := rafts["0"]
+	if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+		t.Fatalf("raft-test: scenario: snapshot catch-up: apply failed: %v", err)
+	}
+
+	term.Disconnect("1")
+
+	for i := 0; i < 4; i++ {
+		if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+			t.Fatalf("raft-test: scenario: snapshot catch-up: apply failed: %v", err)
+		}
+	}
+
+	// With the default TrailingLogs of 1, this compacts the logs that the
+	// disconnected follower is missing.
+	term.Snapshot("0")
+
+	if err := r.Apply([]byte{}, timeout).Error(); err != nil {
+		t.Fatalf("raft-test: scenario: snapshot catch-up: apply failed: %v", err)
+	}
+
+	term.Reconnect("1")
+
+	control.Barrier()
+
+	if n := control.Restores("1"); n == 0 {
+		t.Fatalf("raft-test: scenario: snapshot catch-up: server 1: no snapshot was restored")
+	}
+	for id := range rafts {
+		if n := control.Commands(id); n != 6 {
+			t.Fatalf("raft-test: scenario: snapshot catch-up: server %s: applied %d commands instead of 6", id, n)
+		}
+	}
+}
+
+// ScenarioApplyPipelining issues m Apply() calls, with at most the given number
+// of them in flight at any time, moving leadership from server 0 to server 1
+// halfway through. This is the pattern typically implemented by real clients.
+//
+// Once done it checks exactly-once-or-error semantics: every command log whose
+// Apply() succeeded was applied exactly once by all FSMs, and every command
+// log whose Apply() failed was applied at most once. The data of each command
+// log is its 8-byte big endian sequence number, so the given FSMs must accept
+// arbitrary data.
+//
+// At least three FSMs are needed.
+func ScenarioApplyPipelining(t Reporter, fsms []raft.FSM, m, inflight int, options ...Option) {
+	t.Helper()
+
+	checkScenarioFSMs(t, "apply pipelining", fsms, 3)
+
+	recorders := make([]raft.FSM, len(fsms))
+	for i, fsm := range fsms {
+		recorders[i] = &recordingFSM{fsm: fsm}
+	}
+
+	rafts, control := Cluster(t, recorders, options...)
+	defer control.Close()
+
+	timeout := Duration(time.Second)
+
+	control.Elect("0")
+	leader := rafts["0"]
+
+	errors := make([]error, m)
+	semaphore := make(chan struct{}, inflight)
+	current := int32(0) // Number of Apply() calls currently in flight
+	peak := int32(0)    // Maximum number of Apply() calls observed in flight
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < m; i++ {
+		if i == m/2 {
+			control.Depose()
+			control.Elect("1")
+			leader = rafts["1"]
+		}
+
+		semaphore <- struct{}{}
+		wg.Add(1)
+
+		go func(i int, r *raft.Raft) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			n := atomic.AddInt32(&current, 1)
+			defer atomic.AddInt32(&current, -1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+
+			data := make([]byte, 8)
+			binary.BigEndian.PutUint64(data, uint64(i))
+			errors[i] = r.Apply(data, timeout).Error()
+		}(i, leader)
+	}
+
+	wg.Wait()
+
+	if int(peak) > inflight {
+		t.Fatalf("raft-test: scenario: apply pipelining: %d applies in flight, more than the cap of %d", peak, inflight)
+	}
+
+	control.Barrier()
+
+	// Check the history of each server.
+	var expected [][]byte
+	for i, recorder := range recorders {
+		history := recorder.(*recordingFSM).History()
+		counts := make([]int, m)
+		for _, data := range history {
+			if len(data) != 8 {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(data)
+			if seq >= uint64(m) {
+				t.Fatalf("raft-test: scenario: apply pipelining: server %d: unexpected command %d", i, seq)
+			}
+			counts[seq]++
+		}
+		for seq, count := range counts {
+			if errors[seq] == nil && count != 1 {
+				t.Fatalf("raft-test: scenario: apply pipelining: server %d: successful command %d applied %d times", i, seq, count)
+			}
+			if count > 1 {
+				t.Fatalf("raft-test: scenario: apply pipelining: server %d: failed command %d applied %d times", i, seq, count)
+			}
+		}
+		if expected == nil {
+			expected = history
+			continue
+		}
+		if !reflect.DeepEqual(history, expected) {
+			t.Fatalf("raft-test: scenario: apply pipelining: server %d: history differs from server 0", i)
+		}
+	}
+}
+
+// Fail the test if fewer than n FSMs are given to the scenario with the given
+// name.
+func checkScenarioFSMs(t Reporter, name string, fsms []raft.FSM, n int) {
+	t.Helper()
+
+	if len(fsms) < n {
+		t.Fatalf("raft-test: scenario: %s: need at least %d FSMs, got %d", name, n, len(fsms))
+	}
+}
+
+// recordingFSM wraps an FSM and records the data of all applied command logs.
+type recordingFSM struct {
+	fsm     raft.FSM
+	mu      sync.Mutex
+	history [][]byte
+}
+
+func (f *recordingFSM) Apply(log *raft.Log) interface{} {
+	f.mu.Lock()
+	f.history = append(f.history, log.Data)
+	f.mu.Unlock()
+
+	return f.fsm.Apply(log)
+}
+
+func (f *recordingFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return f.fsm.Snapshot()
+}
+
+func (f *recordingFSM) Restore(reader io.ReadCloser) error {
+	return f.fsm.Restore(reader)
+}
+
+// History returns the data of all command logs applied so far.
+func (f *recordingFSM) History() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.history
+}
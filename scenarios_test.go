@@ -0,0 +1,38 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/raft-test"
+)
+
+func TestScenarioFigure8(t *testing.T) {
+	rafttest.ScenarioFigure8(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+}
+
+func TestScenarioLeaderCompleteness(t *testing.T) {
+	rafttest.ScenarioLeaderCompleteness(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+}
+
+func TestScenarioSnapshotCatchUp(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithSnapshots().Build()
+	rafttest.ScenarioSnapshotCatchUp(t, fsms, rafttest.DiscardLogger())
+}
+
+func TestScenarioApplyPipelining(t *testing.T) {
+	rafttest.ScenarioApplyPipelining(t, rafttest.FSMs(3), 50, 8, rafttest.DiscardLogger())
+}
@@ -0,0 +1,191 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/raft-test/internal/fsms"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// Seed is persisted raft state that the Bootstrap option writes into the
+// stores of a server before it starts.
+type Seed struct {
+	// Log entries appended after the entry holding the initial
+	// configuration, if any. Entries with a zero index get the one
+	// following the previous entry, and entries with a zero term get term 1.
+	// Terms must never decrease from one entry to the next.
+	Logs []raft.Log
+
+	// Values saved by raft in the stable store. The current term defaults
+	// to the highest term of the seeded entries.
+	CurrentTerm  uint64
+	LastVoteTerm uint64
+	LastVoteCand raft.ServerID
+
+	// Snapshot installed into the snapshot store, if any, which raft
+	// restores into the FSM at startup.
+	Snapshot *SeedSnapshot
+}
+
+// SeedSnapshot is a snapshot written by the Bootstrap option. Its
+// configuration is the initial one of the cluster.
+type SeedSnapshot struct {
+	Index uint64 // Index of the last log included, must be greater than 1
+	Term  uint64 // Term of the last log included, 1 if zero
+	Data  []byte // Payload to be passed to the Restore method of the FSM
+
+	// Number of command logs included, as reported by Control.Commands()
+	// once the snapshot is restored.
+	Commands uint64
+}
+
+// Write the state set with the Bootstrap option into the stores of each
+// server, after the initial configuration has been bootstrapped.
+func seedStores(t Reporter, logger hclog.Logger, dependencies []*dependencies) {
+	t.Helper()
+
+	configuration := initialConfiguration(dependencies)
+	for _, d := range dependencies {
+		if d.Seed == nil {
+			continue
+		}
+		id := d.Conf.LocalID
+		logger.Debug(fmt.Sprintf("[DEBUG] raft-test: setup: server %s: seed %d logs", id, len(d.Seed.Logs)))
+		if err := seedStore(d, configuration); err != nil {
+			t.Fatalf("raft-test: setup: error: server %s: failed to seed stores: %v", id, err)
+		}
+	}
+}
+
+// Write the seed of the server with the given dependencies into its stores.
+func seedStore(d *dependencies, configuration raft.Configuration) error {
+	seed := d.Seed
+
+	last, err := d.Logs.LastIndex()
+	if err != nil {
+		return fmt.Errorf("get last index: %v", err)
+	}
+
+	// The key is not there if the server was not bootstrapped.
+	term, _ := d.Stable.GetUint64([]byte("CurrentTerm"))
+
+	// Term of the last entry, which seeded entries must not go below.
+	previous := uint64(0)
+	if last > 0 {
+		log := &raft.Log{}
+		if err := d.Logs.GetLog(last, log); err != nil {
+			return fmt.Errorf("get log %d: %v", last, err)
+		}
+		previous = log.Term
+	}
+
+	if seed.Snapshot != nil {
+		snapshot := *seed.Snapshot
+		if snapshot.Term == 0 {
+			snapshot.Term = 1
+		}
+		if snapshot.Index > last && snapshot.Term < previous {
+			return fmt.Errorf("snapshot: term %d is lower than previous term %d", snapshot.Term, previous)
+		}
+		if err := seedSnapshot(d, configuration, snapshot); err != nil {
+			return fmt.Errorf("snapshot: %v", err)
+		}
+		if snapshot.Index > last {
+			last = snapshot.Index
+			previous = snapshot.Term
+		}
+		if snapshot.Term > term {
+			term = snapshot.Term
+		}
+	}
+
+	logs := make([]*raft.Log, len(seed.Logs))
+	for i := range seed.Logs {
+		log := seed.Logs[i]
+		if log.Index == 0 {
+			log.Index = last + 1
+		}
+		if log.Index != last+1 {
+			return fmt.Errorf("log %d: expected index %d", log.Index, last+1)
+		}
+		if log.Term == 0 {
+			log.Term = 1
+		}
+		if log.Term < previous {
+			return fmt.Errorf("log %d: term %d is lower than previous term %d", log.Index, log.Term, previous)
+		}
+		previous = log.Term
+		if log.Term > term {
+			term = log.Term
+		}
+		logs[i] = &log
+		last = log.Index
+	}
+	if len(logs) > 0 {
+		if err := d.Logs.StoreLogs(logs); err != nil {
+			return fmt.Errorf("store logs: %v", err)
+		}
+	}
+
+	if seed.CurrentTerm > term {
+		term = seed.CurrentTerm
+	}
+	if term > 0 {
+		if err := d.Stable.SetUint64([]byte("CurrentTerm"), term); err != nil {
+			return fmt.Errorf("set current term: %v", err)
+		}
+	}
+	if seed.LastVoteTerm != 0 {
+		if err := d.Stable.SetUint64([]byte("LastVoteTerm"), seed.LastVoteTerm); err != nil {
+			return fmt.Errorf("set last vote term: %v", err)
+		}
+	}
+	if seed.LastVoteCand != "" {
+		if err := d.Stable.Set([]byte("LastVoteCand"), []byte(seed.LastVoteCand)); err != nil {
+			return fmt.Errorf("set last vote candidate: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Write the given snapshot into the snapshot store of the server with the
+// given dependencies.
+func seedSnapshot(d *dependencies, configuration raft.Configuration, snapshot SeedSnapshot) error {
+	if snapshot.Index <= 1 {
+		return fmt.Errorf("index %d is not greater than 1", snapshot.Index)
+	}
+
+	sink, err := d.Snaps.Create(raft.SnapshotVersionMax, snapshot.Index, snapshot.Term, configuration, 1, d.Trans)
+	if err != nil {
+		return fmt.Errorf("create: %v", err)
+	}
+	if err := fsms.WriteSnapshotHeader(sink, snapshot.Commands); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("write: %v", err)
+	}
+	if _, err := sink.Write(snapshot.Data); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("write: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		return fmt.Errorf("close: %v", err)
+	}
+
+	return nil
+}
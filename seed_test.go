@@ -0,0 +1,120 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Entries seeded into the log of the leader get replicated and applied.
+func TestBootstrap(t *testing.T) {
+	seed := rafttest.Seed{
+		Logs: []raft.Log{
+			{Type: raft.LogCommand, Term: 2, Data: []byte("a")},
+			{Type: raft.LogCommand, Term: 2, Data: []byte("b")},
+		},
+		CurrentTerm: 3,
+	}
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Bootstrap(0, seed), rafttest.DiscardLogger())
+	defer control.Close()
+
+	r := rafts["0"]
+	assert.Equal(t, uint64(3), r.LastIndex())
+
+	control.Elect("0")
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(3), control.Commands("1"))
+	term, err := strconv.ParseUint(r.Stats()["term"], 10, 64)
+	require.NoError(t, err)
+	assert.True(t, term > 3, "term %d not above the seeded one", term)
+}
+
+// Entries seeded into the log of a follower that conflict with the ones of the
+// leader get truncated.
+func TestBootstrap_Conflict(t *testing.T) {
+	seed := rafttest.Seed{
+		Logs: []raft.Log{
+			{Type: raft.LogCommand, Data: []byte("x")},
+			{Type: raft.LogCommand, Data: []byte("y")},
+		},
+	}
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Bootstrap(2, seed), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	control.WaitIndex("2", r.LastIndex(), 0)
+
+	assert.Equal(t, uint64(1), control.Commands("2"))
+	assert.Equal(t, r.LastIndex(), rafts["2"].LastIndex())
+}
+
+// Seeded entries whose term goes backwards are rejected.
+func TestBootstrap_TermBackwards(t *testing.T) {
+	seed := rafttest.Seed{
+		Logs: []raft.Log{
+			{Type: raft.LogCommand, Term: 3},
+			{Type: raft.LogCommand, Term: 2},
+		},
+	}
+	buffer := bytes.NewBuffer(nil)
+	reporter := rafttest.NewReporter(buffer)
+	assert.Panics(t, func() {
+		rafttest.Cluster(reporter, rafttest.FSMs(3), rafttest.Bootstrap(0, seed), rafttest.DiscardLogger())
+	})
+	assert.Contains(t, buffer.String(), "log 3: term 2 is lower than previous term 3")
+}
+
+// A seeded snapshot is restored into the FSM at startup, and shipped to the
+// other servers once the server is elected.
+func TestBootstrap_Snapshot(t *testing.T) {
+	seed := rafttest.Seed{
+		Snapshot: &rafttest.SeedSnapshot{Index: 5, Term: 2, Data: []byte(`{"a":"1"}`), Commands: 3},
+	}
+	fsms := rafttest.KVFSMs(3)
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.Bootstrap(0, seed), rafttest.DiscardLogger())
+	defer control.Close()
+
+	restore := control.WaitRestore("0", 0)
+	assert.Equal(t, rafttest.RestoreBoot, restore.Source)
+	assert.Equal(t, uint64(5), restore.Index)
+	assert.Equal(t, uint64(2), restore.Term)
+
+	value, ok := fsms[0].(*rafttest.KVFSM).Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	assert.Equal(t, uint64(3), control.Commands("0"))
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply(rafttest.KVSet("b", "2"), time.Second).Error())
+	control.Barrier()
+
+	restore = control.WaitRestore("1", 0)
+	assert.Equal(t, rafttest.RestoreInstall, restore.Source)
+	control.AssertFSMsEqual(time.Second)
+}
@@ -15,8 +15,6 @@
 package rafttest
 
 import (
-	"testing"
-
 	"github.com/hashicorp/raft"
 )
 
@@ -26,7 +24,9 @@ import (
 // The default network address of a test node is "0".
 //
 // Dependencies can be replaced or mutated using the various options.
-func Server(t *testing.T, fsm raft.FSM, options ...Option) (*raft.Raft, func()) {
+//
+// As with Cluster(), t can be a *testing.B, for writing benchmarks.
+func Server(t Reporter, fsm raft.FSM, options ...Option) (*raft.Raft, func()) {
 	fsms := []raft.FSM{fsm}
 
 	rafts, control := Cluster(t, fsms, options...)
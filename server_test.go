@@ -31,3 +31,16 @@ func TestServer_StartAndShutdown(t *testing.T) {
 	assert.Equal(t, raft.ServerAddress("0"), r.Leader())
 	assert.NoError(t, r.Apply([]byte{}, time.Second).Error())
 }
+
+// A server can be created by benchmarks too.
+func BenchmarkServer_Apply(b *testing.B) {
+	r, cleanup := rafttest.Server(b, rafttest.FSM(), rafttest.DiscardLogger())
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.Apply([]byte{}, time.Second).Error(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
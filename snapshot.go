@@ -0,0 +1,261 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CanonicalLtd/raft-test/internal/stores"
+	"github.com/hashicorp/raft"
+)
+
+// Snapshot makes the given server take a snapshot right away and waits for it
+// to complete, returning the error reported by raft, if any, for example
+// raft.ErrNothingNewToSnapshot if no log was applied yet.
+//
+// It's an alternative to tuning the SnapshotInterval and SnapshotThreshold
+// config values and waiting for raft to decide to take a snapshot.
+func (c *Control) Snapshot(r *raft.Raft) error {
+	c.t.Helper()
+
+	id := c.serverID(r, "snapshot")
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: snapshot", id))
+
+	return c.await(r.Snapshot(), "server %s: snapshot", id)
+}
+
+// RetainedSnapshots returns the metadata of the snapshots currently retained
+// by the snapshot store of the server with the given ID, newest first.
+//
+// The default in-memory snapshot store retains only the latest snapshot, or
+// every snapshot ever taken by or installed on a server if the
+// SnapshotArchive option is used. Their content can be inspected with
+// OpenSnapshot().
+//
+// For servers using on-disk stores (see the Disk option) the snapshot
+// directory is scanned directly, so snapshots beyond the store's retention
+// count are reported too, should raft fail to reap them.
+func (c *Control) RetainedSnapshots(id raft.ServerID) []*raft.SnapshotMeta {
+	c.t.Helper()
+
+	node := c.node(id)
+	if node.Dir == "" {
+		snapshots, err := node.Snaps.List()
+		if err != nil {
+			c.t.Fatalf("raft-test: server %s: failed to list snapshots: %v", id, err)
+		}
+		return snapshots
+	}
+
+	snapshots, err := listSnapshotsDir(filepath.Join(node.Dir, "snapshots"))
+	if err != nil {
+		c.t.Fatalf("raft-test: server %s: failed to list snapshots: %v", id, err)
+	}
+	return snapshots
+}
+
+// SnapshotsOf returns the metadata of the snapshots currently retained by the
+// snapshot store of the given server, newest first. See RetainedSnapshots().
+func (c *Control) SnapshotsOf(r *raft.Raft) []raft.SnapshotMeta {
+	c.t.Helper()
+
+	snapshots := c.RetainedSnapshots(c.serverID(r, "snapshots"))
+	metas := make([]raft.SnapshotMeta, len(snapshots))
+	for i, snapshot := range snapshots {
+		metas[i] = *snapshot
+	}
+	return metas
+}
+
+// OpenSnapshot returns the metadata and the content of the snapshot with the
+// given ID, retained by the snapshot store of the server with the given ID.
+func (c *Control) OpenSnapshot(id raft.ServerID, snapshotID string) (*raft.SnapshotMeta, []byte) {
+	c.t.Helper()
+
+	node := c.node(id)
+	meta, reader, err := node.Snaps.Open(snapshotID)
+	if err != nil {
+		c.t.Fatalf("raft-test: server %s: failed to open snapshot %s: %v", id, snapshotID, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		c.t.Fatalf("raft-test: server %s: failed to read snapshot %s: %v", id, snapshotID, err)
+	}
+
+	return meta, data
+}
+
+// SnapshotCorruption is a way of damaging the content of a snapshot, see
+// CorruptSnapshot().
+type SnapshotCorruption int
+
+// Available snapshot corruptions.
+const (
+	// Keep only the first half of the snapshot data.
+	SnapshotTruncate = SnapshotCorruption(stores.Truncate)
+
+	// Flip a bit in the middle of the snapshot data.
+	SnapshotBitFlip = SnapshotCorruption(stores.BitFlip)
+)
+
+// CorruptSnapshot damages the data of the next snapshot written to the
+// snapshot store of the server with the given ID, either because the server
+// takes a snapshot or because it receives one from the leader.
+//
+// A follower that receives a snapshot gets it written to its store first, and
+// then restores its FSM from it: if the FSM detects the corruption and fails
+// to restore, the follower rejects the snapshot and the leader retries
+// sending it.
+func (c *Control) CorruptSnapshot(id raft.ServerID, corruption SnapshotCorruption) {
+	c.t.Helper()
+
+	c.node(id)
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: corrupt next written snapshot", id))
+	c.stores.CorruptSnapshot(id, stores.Corruption(corruption), false)
+}
+
+// CorruptShippedSnapshot damages the data of the next snapshot read from the
+// snapshot store of the server with the given ID, typically by the server
+// itself, as leader, to send it to a follower lagging behind.
+//
+// The snapshot metadata is left untouched, so a follower receiving a
+// truncated snapshot rejects it because of its size, and the leader retries
+// sending it.
+func (c *Control) CorruptShippedSnapshot(id raft.ServerID, corruption SnapshotCorruption) {
+	c.t.Helper()
+
+	c.node(id)
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: corrupt next read snapshot", id))
+	c.stores.CorruptSnapshot(id, stores.Corruption(corruption), true)
+}
+
+// AssertRetainedSnapshots fails the test if the server with the given ID does
+// not retain exactly n snapshots.
+func (c *Control) AssertRetainedSnapshots(id raft.ServerID, n int) {
+	c.t.Helper()
+
+	snapshots := c.RetainedSnapshots(id)
+	if len(snapshots) != n {
+		c.t.Fatalf("raft-test: server %s: expected %d retained snapshots, found %d", id, n, len(snapshots))
+	}
+}
+
+// DeleteNewestSnapshot removes the most recent snapshot of the server with the
+// given ID behind raft's back, to simulate a missing snapshot.
+//
+// The server must be using on-disk stores (see the Disk option).
+func (c *Control) DeleteNewestSnapshot(id raft.ServerID) {
+	c.t.Helper()
+	c.deleteSnapshot(id, true)
+}
+
+// DeleteOldestSnapshot removes the least recent snapshot of the server with the
+// given ID behind raft's back, to simulate a missing snapshot.
+//
+// The server must be using on-disk stores (see the Disk option).
+func (c *Control) DeleteOldestSnapshot(id raft.ServerID) {
+	c.t.Helper()
+	c.deleteSnapshot(id, false)
+}
+
+// Remove either the newest or oldest snapshot of the given server.
+func (c *Control) deleteSnapshot(id raft.ServerID, newest bool) {
+	c.t.Helper()
+
+	node := c.node(id)
+	if node.Dir == "" {
+		c.t.Fatalf("raft-test: server %s: can't delete snapshots: not using on-disk stores", id)
+	}
+
+	snapshots := c.RetainedSnapshots(id)
+	if len(snapshots) == 0 {
+		c.t.Fatalf("raft-test: server %s: can't delete snapshots: no snapshot found", id)
+	}
+
+	snapshot := snapshots[len(snapshots)-1]
+	if newest {
+		snapshot = snapshots[0]
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: delete snapshot %s", id, snapshot.ID))
+
+	if err := os.RemoveAll(filepath.Join(node.Dir, "snapshots", snapshot.ID)); err != nil {
+		c.t.Fatalf("raft-test: server %s: failed to delete snapshot %s: %v", id, snapshot.ID, err)
+	}
+}
+
+// Return the dependencies of the server with the given ID.
+func (c *Control) node(id raft.ServerID) *dependencies {
+	c.t.Helper()
+
+	for _, node := range c.nodes {
+		if node.Conf.LocalID == id {
+			return node
+		}
+	}
+	c.t.Fatalf("raft-test: unknown server %s", id)
+	return nil
+}
+
+// Read the metadata of all complete snapshots in the given directory, as
+// written by raft.FileSnapshotStore, sorted from newest to oldest.
+func listSnapshotsDir(dir string) ([]*raft.SnapshotMeta, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	snapshots := make([]*raft.SnapshotMeta, 0)
+	for _, entry := range entries {
+		// Skip anything which is not a complete snapshot.
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), "meta.json"))
+		if err != nil {
+			return nil, err
+		}
+		meta := &raft.SnapshotMeta{}
+		if err := json.Unmarshal(data, meta); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, meta)
+	}
+
+	// Same ordering as raft.FileSnapshotStore.
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		return a.ID > b.ID
+	})
+
+	return snapshots, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"encoding/binary"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// On-disk snapshot stores retain at most two snapshots, and they can be
+// deleted behind raft's back.
+func TestControl_RetainedSnapshots(t *testing.T) {
+	skipDiskUnderRace(t)
+
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Disk(0), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		require.NoError(t, r.Snapshot().Error())
+	}
+
+	control.AssertRetainedSnapshots("0", 2)
+	snapshots := control.RetainedSnapshots("0")
+
+	control.DeleteNewestSnapshot("0")
+	control.AssertRetainedSnapshots("0", 1)
+	assert.Equal(t, snapshots[1].ID, control.RetainedSnapshots("0")[0].ID)
+}
+
+// In-memory snapshot stores retain only the latest snapshot by default.
+func TestControl_SnapshotsOf(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		require.NoError(t, r.Snapshot().Error())
+	}
+
+	snapshots := control.SnapshotsOf(r)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, r.LastIndex(), snapshots[0].Index)
+}
+
+// With the SnapshotArchive option, in-memory snapshot stores retain all
+// snapshots, whose content can be inspected.
+func TestControl_OpenSnapshot(t *testing.T) {
+	fsms := rafttest.NewFSMs(3).WithSnapshots().Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.SnapshotArchive(), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+		require.NoError(t, r.Snapshot().Error())
+	}
+
+	snapshots := control.RetainedSnapshots("0")
+	require.Len(t, snapshots, 2)
+	assert.True(t, snapshots[0].Index > snapshots[1].Index)
+
+	for i, snapshot := range snapshots {
+		meta, data := control.OpenSnapshot("0", snapshot.ID)
+		assert.Equal(t, snapshot.Index, meta.Index)
+		assert.Equal(t, int64(len(data)), meta.Size)
+		assert.Equal(t, uint64(2-i), binary.LittleEndian.Uint64(data[:8]))
+	}
+}
+
+// A follower rejects a snapshot that got truncated while being shipped, and
+// the leader retries sending it.
+func TestControl_CorruptShippedSnapshot(t *testing.T) {
+	testCorruptSnapshot(t, "Failed to receive whole snapshot", func(control *rafttest.Control) {
+		control.CorruptShippedSnapshot("0", rafttest.SnapshotTruncate)
+	})
+}
+
+// A follower rejects a snapshot that got truncated while being written to its
+// store, and the leader retries sending it.
+func TestControl_CorruptSnapshot(t *testing.T) {
+	testCorruptSnapshot(t, "Failed to restore snapshot", func(control *rafttest.Control) {
+		control.CorruptSnapshot("2", rafttest.SnapshotTruncate)
+	})
+}
+
+// Make server 2 lag behind, so the leader has to send it a snapshot, which
+// gets corrupted by the given function. Check that server 2 rejects it with
+// the given message and that it eventually catches up.
+func testCorruptSnapshot(t *testing.T, message string, corrupt func(*rafttest.Control)) {
+	fsms := rafttest.NewFSMs(3).WithSnapshots().Build()
+	rafts, control := rafttest.Cluster(t, fsms, rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	term := control.Elect("0")
+	term.Disconnect("2")
+
+	r := rafts["0"]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	}
+	require.NoError(t, r.Snapshot().Error())
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	corrupt(control)
+	term.Reconnect("2")
+
+	re := regexp.MustCompile(message)
+	rejected := func() bool { return len(control.LogsMatching("2", re)) > 0 }
+	assert.Eventually(t, rejected, time.Second, time.Millisecond)
+
+	control.Barrier()
+	assert.Equal(t, uint64(1), control.Restores("2"))
+	assert.Equal(t, uint64(4), control.Commands("2"))
+}
+
+// A snapshot can be taken on demand.
+func TestControl_Snapshot(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	assert.Equal(t, raft.ErrNothingNewToSnapshot, control.Snapshot(rafts["1"]))
+
+	control.Elect("0")
+
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	require.NoError(t, control.Snapshot(rafts["1"]))
+	assert.Equal(t, uint64(1), control.Snapshots("1"))
+	assert.Len(t, control.RetainedSnapshots("1"), 1)
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// Return the stacks of all goroutines running raft or harness code, grouped
+// by the raft instance they belong to. The given map associates a label to
+// each raft instance.
+//
+// A goroutine is attributed to a raft instance if one of its frames is a
+// method of that instance (as identified by the receiver address printed in
+// the stack trace). Goroutines that can't be attributed are grouped last.
+func goroutineStacks(rafts map[string]*raft.Raft) string {
+	addresses := make(map[string]string)
+	for label, r := range rafts {
+		addresses[fmt.Sprintf("%p", r)] = label
+	}
+
+	groups := make(map[string][]string)
+	for _, stack := range strings.Split(allStacks(), "\n\n") {
+		if !strings.Contains(stack, "github.com/hashicorp/raft") &&
+			!strings.Contains(stack, "github.com/CanonicalLtd/raft-test") {
+			continue
+		}
+		label := "other"
+		if address := raftReceiver(stack); address != "" {
+			if l, ok := addresses[address]; ok {
+				label = l
+			}
+		}
+		groups[label] = append(groups[label], stack)
+	}
+
+	labels := make([]string, 0, len(groups))
+	for label := range groups {
+		if label != "other" {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	if _, ok := groups["other"]; ok {
+		labels = append(labels, "other")
+	}
+
+	var b strings.Builder
+	for _, label := range labels {
+		fmt.Fprintf(&b, "--- %s (%d goroutines)\n\n", label, len(groups[label]))
+		for _, stack := range groups[label] {
+			fmt.Fprintf(&b, "%s\n\n", stack)
+		}
+	}
+
+	return b.String()
+}
+
+// Return the stack traces of all goroutines.
+func allStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Return the receiver address of the first raft.Raft method found in the
+// given goroutine stack trace, or an empty string if there's none.
+func raftReceiver(stack string) string {
+	const prefix = "github.com/hashicorp/raft.(*Raft)."
+	for _, line := range strings.Split(stack, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		i := strings.Index(line, "(0x")
+		if i == -1 {
+			continue
+		}
+		address := line[i+1:]
+		if j := strings.IndexAny(address, ",)?"); j != -1 {
+			address = address[:j]
+		}
+		return address
+	}
+	return ""
+}
+
+// Return the stacks of the goroutines of the servers in this cluster.
+func (c *Control) stacks() string {
+	rafts := make(map[string]*raft.Raft)
+	for id, r := range c.servers {
+		rafts[fmt.Sprintf("server %s", id)] = r
+	}
+	return goroutineStacks(rafts)
+}
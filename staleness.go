@@ -0,0 +1,190 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// StalenessSampler periodically samples how far the applied state of each
+// follower lags behind the one of the leader. Use Control.SampleStaleness to
+// create one.
+type StalenessSampler struct {
+	control  *Control
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu sync.Mutex
+
+	// Times at which the leader applied new logs, in increasing index order.
+	progress []stalenessProgress
+
+	// Samples collected for each follower.
+	entries map[raft.ServerID][]uint64
+	times   map[raft.ServerID][]time.Duration
+}
+
+// StalenessReport summarizes the staleness samples collected for a single
+// follower. Entries values are the number of logs the follower had yet to
+// apply, time values are for how long the oldest of those logs had already
+// been applied by the leader.
+type StalenessReport struct {
+	Samples    int
+	EntriesP50 uint64
+	EntriesP99 uint64
+	EntriesMax uint64
+	TimeP50    time.Duration
+	TimeP99    time.Duration
+	TimeMax    time.Duration
+}
+
+func (r StalenessReport) String() string {
+	return fmt.Sprintf(
+		"samples=%d entries(p50=%d p99=%d max=%d) time(p50=%s p99=%s max=%s)",
+		r.Samples, r.EntriesP50, r.EntriesP99, r.EntriesMax, r.TimeP50, r.TimeP99, r.TimeMax)
+}
+
+// A point in time at which the leader had applied logs up to a certain index.
+type stalenessProgress struct {
+	index uint64
+	time  time.Time
+}
+
+// SampleStaleness starts sampling, at the given interval, the staleness of
+// the applied state of every follower with respect to the current leader. A
+// sample is also taken when sampling starts and when it stops.
+//
+// The returned sampler must be stopped before closing the cluster.
+func (c *Control) SampleStaleness(interval time.Duration) *StalenessSampler {
+	s := &StalenessSampler{
+		control:  c,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		entries:  make(map[raft.ServerID][]uint64),
+		times:    make(map[raft.ServerID][]time.Duration),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// Stop sampling and return a report for each follower that was sampled at
+// least once.
+func (s *StalenessSampler) Stop() map[raft.ServerID]StalenessReport {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make(map[raft.ServerID]StalenessReport)
+	for id, entries := range s.entries {
+		times := s.times[id]
+		sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		reports[id] = StalenessReport{
+			Samples:    len(entries),
+			EntriesP50: entries[percentileIndex(len(entries), 50)],
+			EntriesP99: entries[percentileIndex(len(entries), 99)],
+			EntriesMax: entries[len(entries)-1],
+			TimeP50:    times[percentileIndex(len(times), 50)],
+			TimeP99:    times[percentileIndex(len(times), 99)],
+			TimeMax:    times[len(times)-1],
+		}
+	}
+
+	return reports
+}
+
+func (s *StalenessSampler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Always take a sample at start and one at stop, so even short runs
+	// produce some data.
+	for {
+		s.sample()
+		select {
+		case <-s.stop:
+			s.sample()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Take a single sample of all followers.
+func (s *StalenessSampler) sample() {
+	servers := s.control.running()
+	leader := raft.ServerID("")
+	for id, r := range servers {
+		if r.State() == raft.Leader {
+			leader = id
+		}
+	}
+	if leader == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	index := servers[leader].AppliedIndex()
+	n := len(s.progress)
+	if n == 0 || s.progress[n-1].index < index {
+		s.progress = append(s.progress, stalenessProgress{index: index, time: now})
+	}
+
+	for id, r := range servers {
+		if id == leader {
+			continue
+		}
+		applied := r.AppliedIndex()
+		lag := uint64(0)
+		age := time.Duration(0)
+		if applied < index {
+			lag = index - applied
+			// Find the first time the leader applied a log that the
+			// follower is still missing.
+			i := sort.Search(len(s.progress), func(i int) bool {
+				return s.progress[i].index > applied
+			})
+			age = now.Sub(s.progress[i].time)
+		}
+		s.entries[id] = append(s.entries[id], lag)
+		s.times[id] = append(s.times[id], age)
+	}
+}
+
+// Return the index of the element at the given percentile of a sorted slice
+// of length n.
+func percentileIndex(n int, percentile int) int {
+	i := (n*percentile + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Standby turns the server with the given index into a warm standby, i.e. a
+// non-voter that continuously catches up with the leader, and waits for it to
+// apply all the logs of the leader.
+//
+// A voter or staging server is demoted, and a server that is not part of the
+// configuration is added as non-voter. The leader itself can't be turned into a
+// standby. The test fails if a step takes longer than the given timeout (the
+// default one if zero, see Duration()).
+func (c *Control) Standby(i int, timeout time.Duration) *raft.Raft {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: standby: no leader was elected")
+	}
+	if i < 0 || i >= len(c.nodes) {
+		c.t.Fatalf("raft-test: standby: server index %d out of range (%d servers)", i, len(c.nodes))
+	}
+
+	leader := c.term.id
+	id := c.nodes[i].Conf.LocalID
+	r, ok := c.servers[id]
+	if !ok {
+		c.t.Fatalf("raft-test: standby: server %s is not running", id)
+	}
+	if id == leader {
+		c.t.Fatalf("raft-test: standby: server %s is the leader", id)
+	}
+
+	var future raft.IndexFuture
+	suffrage := raft.ServerSuffrage(-1)
+	for _, server := range c.configuration(leader).Servers {
+		if server.ID == id {
+			suffrage = server.Suffrage
+		}
+	}
+	switch suffrage {
+	case raft.Nonvoter:
+	case raft.Voter, raft.Staging:
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: standby: demote server %s", id))
+		future = c.servers[leader].DemoteVoter(id, 0, timeout)
+	default:
+		c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: standby: add server %s", id))
+		future = c.servers[leader].AddNonvoter(id, c.network.Address(id), 0, timeout)
+	}
+	if future != nil {
+		if err := c.await(future, "server %s: standby membership change of server %s", leader, id); err != nil {
+			c.t.Fatalf("raft-test: standby: server %s: membership change failed: %v", id, err)
+		}
+	}
+
+	index := c.servers[leader].LastIndex()
+	if !c.poll(func() bool { return r.AppliedIndex() >= index }, timeout) {
+		c.t.Fatalf("raft-test: standby: server %s did not catch up with index %d within %s", id, index, timeout)
+	}
+
+	return r
+}
+
+// Failover performs a planned failover to a warm standby, i.e. a non-voter
+// that continuously catches up with the leader (see Standby() and the
+// NonVoters option), and returns the new Term along with the downtime of the
+// cluster.
+//
+// The standby is first promoted to voter, then leadership is transferred to
+// it as with TransferLeadership() and finally the old leader is demoted to
+// non-voter, so it becomes the new standby.
+//
+// The downtime runs from the moment the old leader gets deposed until the new
+// leader commits its first entry (a barrier), so it includes the time needed by
+// the new leader to apply all pending logs. The test fails if any step takes
+// longer than the given timeout (the default one if zero, see Duration()), if
+// the downtime is longer than that, or if once done any server of the
+// configuration hasn't applied the same command logs as the new leader.
+func (c *Control) Failover(standby *raft.Raft, timeout time.Duration) (*Term, time.Duration) {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	if c.term == nil {
+		c.t.Fatalf("raft-test: failover: no leader was elected")
+	}
+
+	leader := c.term.id
+	id := c.serverID(standby, "failover")
+	if c.suffrage(leader, id) != raft.Nonvoter {
+		c.t.Fatalf("raft-test: failover: server %s is not a non-voter", id)
+	}
+
+	r := c.servers[leader]
+	commands := c.Commands(leader)
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: failover: promote server %s", id))
+	future := r.AddVoter(id, c.network.Address(id), 0, timeout)
+	if err := c.await(future, "server %s: failover promotion of server %s", leader, id); err != nil {
+		c.t.Fatalf("raft-test: failover: server %s: promotion failed: %v", id, err)
+	}
+
+	term, downtime := c.transferLeadership(r, standby, timeout)
+	start := time.Now()
+	if err := c.await(standby.Barrier(timeout), "server %s: failover barrier", id); err != nil {
+		c.t.Fatalf("raft-test: failover: server %s: barrier failed: %v", id, err)
+	}
+	downtime += time.Since(start)
+	if downtime > timeout {
+		c.t.Fatalf("raft-test: failover: cluster was down for %s (more than %s)", downtime, timeout)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: failover: demote server %s", leader))
+	future = standby.DemoteVoter(leader, 0, timeout)
+	if err := c.await(future, "server %s: failover demotion of server %s", id, leader); err != nil {
+		c.t.Fatalf("raft-test: failover: server %s: demotion failed: %v", leader, err)
+	}
+
+	c.Barrier()
+
+	// Check that no data was lost and that all data was replicated.
+	n := c.Commands(id)
+	if n < commands {
+		c.t.Fatalf("raft-test: failover: server %s: applied %d commands, but old leader had %d", id, n, commands)
+	}
+	for _, server := range c.configuration(id).Servers {
+		check := func() bool { return c.Commands(server.ID) == n }
+		if !c.poll(check, timeout) {
+			c.t.Fatalf("raft-test: failover: server %s: applied %d commands instead of %d", server.ID, c.Commands(server.ID), n)
+		}
+	}
+
+	return term, downtime
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A warm standby can take over leadership, and the old leader becomes the
+// new standby.
+func TestControl_Failover(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.NonVoters(2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	}
+
+	term, downtime := control.Failover(rafts["2"], 5*time.Second)
+	assert.Equal(t, rafts["2"], term.Leader())
+	assert.True(t, downtime > 0)
+
+	future := rafts["2"].GetConfiguration()
+	require.NoError(t, future.Error())
+	for _, server := range future.Configuration().Servers {
+		if server.ID == "0" {
+			assert.Equal(t, raft.Nonvoter, server.Suffrage)
+		} else {
+			assert.Equal(t, raft.Voter, server.Suffrage)
+		}
+	}
+
+	require.NoError(t, rafts["2"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+	assert.Equal(t, uint64(4), control.Commands("0"))
+}
+
+// A server outside of the configuration can be turned into a warm standby,
+// and so can a voter.
+func TestControl_Standby(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(4), rafttest.Servers(0, 1, 2), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	}
+
+	standby := control.Standby(3, time.Second)
+	assert.Equal(t, uint64(3), control.Commands("3"))
+	control.Standby(2, time.Second)
+
+	future := rafts["0"].GetConfiguration()
+	require.NoError(t, future.Error())
+	servers := future.Configuration().Servers
+	require.Len(t, servers, 4)
+	assert.Equal(t, raft.Nonvoter, servers[2].Suffrage)
+	assert.Equal(t, raft.Nonvoter, servers[3].Suffrage)
+
+	term, _ := control.Failover(standby, 5*time.Second)
+	assert.Equal(t, standby, term.Leader())
+}
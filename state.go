@@ -0,0 +1,149 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// StatefulFSM is implemented by FSMs that can expose their state, so it can be
+// compared across servers with AssertFSMsEqual().
+//
+// The FSMs returned by FSMs(), NewFSMs() and KVFSMs() all implement it.
+type StatefulFSM interface {
+	raft.FSM
+	State() interface{}
+}
+
+// AssertFSMsEqual waits until all running servers connected to the leader, or
+// all running servers if there's no leader, have applied the same log index
+// and their FSMs have the same state, as returned by State() and compared
+// with reflect.DeepEqual().
+//
+// If that doesn't happen within the given timeout (the default one if zero, see
+// Duration()) the test fails, showing how the state of each server differs from
+// the first one. All FSMs must implement StatefulFSM.
+func (c *Control) AssertFSMsEqual(timeout time.Duration) {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	if failures := c.fsmFailures(); len(failures) > 0 {
+		c.t.Fatalf("raft-test: fsms equal: %s", strings.Join(failures, "\n"))
+	}
+
+	ids := make([]raft.ServerID, 0, len(c.servers))
+	for _, id := range c.serverIDs() {
+		if c.term != nil && id != c.term.id && !c.network.PeerConnected(c.term.id, id) {
+			continue
+		}
+		if _, ok := c.watcher.FSM(id).(StatefulFSM); !ok {
+			c.t.Fatalf("raft-test: fsms equal: server %s: FSM does not implement State()", id)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var diffs []string
+	check := func() bool {
+		diffs = c.fsmDiffs(ids)
+		return len(diffs) == 0
+	}
+	if !c.poll(check, timeout) {
+		c.t.Fatalf("raft-test: fsms equal: state differs after %s:\n%s", timeout, strings.Join(diffs, "\n"))
+	}
+}
+
+// Return the problems found while raft used the FSMs, such as concurrent
+// applies or panicking hooks, since the last call.
+func (c *Control) fsmFailures() []string {
+	failures := []string{}
+	for _, d := range c.nodes {
+		id := d.Conf.LocalID
+		all := c.watcher.Failures(id)
+		failures = append(failures, all[c.reportedFSMFailures[id]:]...)
+		c.reportedFSMFailures[id] = len(all)
+	}
+	return failures
+}
+
+// Return a description of how the applied index and FSM state of each of the
+// given servers differ from the first one, if they do.
+func (c *Control) fsmDiffs(ids []raft.ServerID) []string {
+	first := ids[0]
+	index := c.servers[first].AppliedIndex()
+	state := c.watcher.FSM(first).(StatefulFSM).State()
+
+	diffs := make([]string, 0)
+	for _, id := range ids[1:] {
+		if other := c.servers[id].AppliedIndex(); other != index {
+			diffs = append(diffs, fmt.Sprintf("server %s: applied index %d instead of %d", id, other, index))
+			continue
+		}
+		other := c.watcher.FSM(id).(StatefulFSM).State()
+		if !reflect.DeepEqual(state, other) {
+			diffs = append(diffs, fmt.Sprintf("server %s: %s", id, stateDiff(state, other)))
+		}
+	}
+
+	return diffs
+}
+
+// Describe how the given states differ. Maps are compared key by key.
+func stateDiff(expected, actual interface{}) string {
+	a := reflect.ValueOf(expected)
+	b := reflect.ValueOf(actual)
+	if a.Kind() != reflect.Map || b.Kind() != reflect.Map || a.Type() != b.Type() {
+		return fmt.Sprintf("state %#v instead of %#v", actual, expected)
+	}
+
+	keys := make(map[string]reflect.Value)
+	for _, key := range a.MapKeys() {
+		keys[fmt.Sprint(key.Interface())] = key
+	}
+	for _, key := range b.MapKeys() {
+		keys[fmt.Sprint(key.Interface())] = key
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0)
+	for _, name := range names {
+		key := keys[name]
+		x := a.MapIndex(key)
+		y := b.MapIndex(key)
+		switch {
+		case !y.IsValid():
+			lines = append(lines, fmt.Sprintf("missing key %s", name))
+		case !x.IsValid():
+			lines = append(lines, fmt.Sprintf("extra key %s = %#v", name, y.Interface()))
+		case !reflect.DeepEqual(x.Interface(), y.Interface()):
+			lines = append(lines, fmt.Sprintf("key %s = %#v instead of %#v", name, y.Interface(), x.Interface()))
+		}
+	}
+
+	return strings.Join(lines, ", ")
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The state of all FSMs converges.
+func TestControl_AssertFSMsEqual(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.KVFSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rafts["0"].Apply(rafttest.KVSet("a", strconv.Itoa(i)), time.Second).Error())
+	}
+
+	control.AssertFSMsEqual(time.Second)
+}
+
+// If the state of an FSM differs, the test fails showing the difference.
+func TestControl_AssertFSMsEqual_Differ(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	fsms := rafttest.KVFSMs(3)
+	rafts, control := rafttest.Cluster(rafttest.NewReporter(buffer), fsms, rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	require.NoError(t, rafts["0"].Apply(rafttest.KVSet("a", "1"), time.Second).Error())
+	control.Barrier()
+
+	fsms[2].Apply(&raft.Log{Data: rafttest.KVSet("b", "2")})
+
+	assert.Panics(t, func() { control.AssertFSMsEqual(50 * time.Millisecond) })
+	assert.Contains(t, buffer.String(), `server 2: extra key b = "2"`)
+}
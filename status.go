@@ -0,0 +1,147 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// String returns a compact description of the current state of the cluster: a
+// table with the state, term and indexes of each server, including the ones
+// that are currently killed or crashed, a matrix with the status of the link
+// from each server (rows) to each other server (columns), and the faults
+// currently injected by the harness. It can be used with t.Log at any point of
+// a test, for example:
+//
+//	t.Logf("cluster:\n%s", control)
+//
+// Only accessors that don't go through raft's main loop are used, so it's safe
+// to call even if the cluster is stuck.
+func (c *Control) String() string {
+	nodes := c.allNodes()
+	servers := c.running()
+
+	buffer := bytes.NewBuffer(nil)
+	w := tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "server\tstate\tterm\tlast\tapplied\tcommands\tuptime")
+	for _, d := range nodes {
+		id := d.Conf.LocalID
+
+		term := "?"
+		if value, err := d.Stable.GetUint64([]byte("CurrentTerm")); err == nil {
+			term = fmt.Sprintf("%d", value)
+		}
+
+		// Servers that are killed or crashed have no raft instance, so
+		// only what's persisted in their log store is known.
+		state, last, applied := "Down", "?", "-"
+		if r, ok := servers[id]; ok {
+			state = r.State().String()
+			last = fmt.Sprintf("%d", r.LastIndex())
+			applied = fmt.Sprintf("%d", r.AppliedIndex())
+		} else if index, err := d.Logs.LastIndex(); err == nil {
+			last = fmt.Sprintf("%d", index)
+		}
+
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			id, state, term, last, applied, c.Commands(id), c.Uptime(id))
+	}
+	w.Flush()
+
+	w = tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "links")
+	for _, d := range nodes {
+		fmt.Fprintf(w, "\t%s", d.Conf.LocalID)
+	}
+	fmt.Fprintln(w)
+	for _, from := range nodes {
+		fmt.Fprint(w, from.Conf.LocalID)
+		for _, to := range nodes {
+			status := "-"
+			if to != from {
+				status = c.network.LinkStatus(from.Conf.LocalID, to.Conf.LocalID)
+			}
+			fmt.Fprintf(w, "\t%s", status)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	fmt.Fprintf(buffer, "faults: %s", strings.Join(c.activeFaults(), ", "))
+
+	return buffer.String()
+}
+
+// Return a description of the faults currently injected by the harness, or
+// "none".
+func (c *Control) activeFaults() []string {
+	faults := make([]string, 0)
+
+	if c.deposed != "" {
+		faults = append(faults, fmt.Sprintf("server %s deposed", c.deposed))
+	}
+	if c.term != nil && c.term.disconnected != "" {
+		faults = append(faults, fmt.Sprintf("server %s disconnected", c.term.disconnected))
+	}
+	if len(c.isolated) > 0 {
+		isolated := make([]string, len(c.isolated))
+		for i, id := range c.isolated {
+			isolated[i] = string(id)
+		}
+		faults = append(faults, fmt.Sprintf("servers %s partitioned", strings.Join(isolated, ",")))
+	}
+	if c.slowDisk != 0 {
+		faults = append(faults, fmt.Sprintf("slow leader disk by %s", c.slowDisk))
+	}
+
+	if len(faults) == 0 {
+		faults = append(faults, "none")
+	}
+
+	return faults
+}
+
+// Maximum number of RPCs included in the diagnostics of a failed wait.
+const diagnosticsRPCs = 20
+
+// Return a full description of the cluster, to be included in the failure
+// message of a helper that timed out: the state returned by String(),
+// followed by the most recent RPCs if the Trace option was used.
+func (c *Control) diagnostics() string {
+	buffer := bytes.NewBuffer(nil)
+	fmt.Fprintf(buffer, "%s\n\n", c)
+
+	if c.tracer == nil {
+		fmt.Fprintf(buffer, "recent RPCs: not traced (use the Trace option)")
+		return buffer.String()
+	}
+
+	trace := c.tracer.Trace()
+	if len(trace) == 0 {
+		fmt.Fprintf(buffer, "recent RPCs: none")
+		return buffer.String()
+	}
+	if len(trace) > diagnosticsRPCs {
+		trace = trace[len(trace)-diagnosticsRPCs:]
+	}
+	fmt.Fprintf(buffer, "recent RPCs:\n%s", trace)
+
+	return buffer.String()
+}
@@ -0,0 +1,383 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// TCPCluster is like Cluster, but servers communicate over real TCP
+// connections, using raft NetworkTransports listening on ephemeral ports of
+// 127.0.0.1. Some race conditions only reproduce with real sockets and
+// goroutine-per-connection behavior.
+//
+// Use DisconnectTCP() and ReconnectTCP() to firewall a server, and note that
+// Kill() and Crash() also kill the listener of the server's transport, which
+// Restart() brings back on the same address.
+func TCPCluster(t Reporter, fsms []raft.FSM, options ...Option) (map[raft.ServerID]*raft.Raft, *Control) {
+	factory := func(int) (raft.Transport, error) {
+		return newTCPTransport("127.0.0.1:0")
+	}
+	options = append([]Option{Transports(factory)}, options...)
+	return Cluster(t, fsms, options...)
+}
+
+// DisconnectTCP firewalls the given server of a cluster created with
+// TCPCluster(), by killing the listener of its transport along with all its
+// connections: any RPC from or to the server fails until ReconnectTCP() is
+// called. The server must not be the leader.
+func (c *Control) DisconnectTCP(r *raft.Raft) {
+	c.t.Helper()
+
+	id := c.serverID(r, "disconnect tcp")
+	trans := c.tcpTransport(id, "disconnect tcp")
+	if c.term != nil && c.term.id == id && r.State() == raft.Leader {
+		c.t.Fatalf("raft-test: disconnect tcp: server %s is the leader", id)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: disconnect tcp", id))
+	trans.Disconnect()
+}
+
+// ReconnectTCP restarts the listener of the transport of the given server,
+// which was firewalled with DisconnectTCP(), on the same address.
+//
+// Since the server has most probably bumped its term while firewalled, the
+// current leader, if any, gets deposed and elected again, as with Restart().
+func (c *Control) ReconnectTCP(r *raft.Raft) {
+	c.t.Helper()
+
+	id := c.serverID(r, "reconnect tcp")
+	trans := c.tcpTransport(id, "reconnect tcp")
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: server %s: reconnect tcp", id))
+
+	var leader raft.ServerID
+	if c.term != nil && c.servers[c.term.id].State() == raft.Leader {
+		leader = c.term.id
+		c.depose()
+	}
+
+	if err := trans.Reconnect(); err != nil {
+		c.t.Fatalf("raft-test: reconnect tcp: server %s: %v", id, err)
+	}
+
+	if leader != "" {
+		c.Elect(leader)
+	}
+}
+
+// Return the TCP transport of the server with the given ID, failing the test
+// if the cluster was not created with TCPCluster().
+func (c *Control) tcpTransport(id raft.ServerID, what string) *tcpTransport {
+	c.t.Helper()
+
+	trans, ok := c.network.Underlying(id).(*tcpTransport)
+	if !ok {
+		c.t.Fatalf("raft-test: %s: server %s: not using a TCP transport (use TCPCluster)", what, id)
+	}
+	return trans
+}
+
+// Kill the listener of the TCP transport of the given server, if it uses one.
+func (c *Control) disconnectTCP(id raft.ServerID) {
+	if trans, ok := c.network.Underlying(id).(*tcpTransport); ok {
+		trans.Disconnect()
+	}
+}
+
+// Restart the listener of the TCP transport of the given server, if it uses
+// one.
+func (c *Control) reconnectTCP(id raft.ServerID) {
+	c.t.Helper()
+
+	if trans, ok := c.network.Underlying(id).(*tcpTransport); ok {
+		if err := trans.Reconnect(); err != nil {
+			c.t.Fatalf("raft-test: restart: server %s: %v", id, err)
+		}
+	}
+}
+
+// Error returned by the RPCs of a disconnected TCP transport.
+var errTCPDisconnected = fmt.Errorf("tcp transport is disconnected")
+
+// Transport wrapping a raft NetworkTransport over TCP, which can be
+// disconnected by killing its listener and reconnected by creating a new
+// NetworkTransport listening on the same address. The consumer channel stays
+// the same, so the raft instance using the transport doesn't notice.
+type tcpTransport struct {
+	address  raft.ServerAddress
+	consumer chan raft.RPC
+
+	mu        sync.Mutex
+	trans     *raft.NetworkTransport // Nil if disconnected
+	stop      chan struct{}          // Closed when disconnecting
+	heartbeat func(raft.RPC)
+}
+
+// Create a new TCP transport listening on the given address.
+func newTCPTransport(address string) (*tcpTransport, error) {
+	t := &tcpTransport{consumer: make(chan raft.RPC)}
+	if err := t.listen(address); err != nil {
+		return nil, err
+	}
+	t.address = t.trans.LocalAddr()
+	return t, nil
+}
+
+// Create a new NetworkTransport listening on the given address, forwarding
+// its RPCs to the consumer channel. Must be called with the lock held or
+// before the transport is used.
+func (t *tcpTransport) listen(address string) error {
+	stream, err := listenTCP(address)
+	if err != nil {
+		return err
+	}
+	t.trans = raft.NewNetworkTransport(stream, 2, Duration(time.Second), ioutil.Discard)
+	if t.heartbeat != nil {
+		t.trans.SetHeartbeatHandler(t.heartbeat)
+	}
+	t.stop = make(chan struct{})
+	go t.forward(t.trans.Consumer(), t.stop)
+	return nil
+}
+
+// Forward RPCs received by a NetworkTransport to the consumer channel, until
+// the given stop channel gets closed.
+func (t *tcpTransport) forward(ch <-chan raft.RPC, stop chan struct{}) {
+	for {
+		select {
+		case rpc := <-ch:
+			select {
+			case t.consumer <- rpc:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Disconnect kills the listener and all connections of the transport.
+func (t *tcpTransport) Disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.trans == nil {
+		return
+	}
+	close(t.stop)
+	t.trans.Close()
+	t.trans = nil
+}
+
+// Reconnect listens again on the address of the transport.
+func (t *tcpTransport) Reconnect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.trans != nil {
+		return nil
+	}
+
+	// The port might still be briefly held by the old listener.
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = t.listen(string(t.address)); err == nil {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to listen on %s: %v", t.address, err)
+}
+
+// Return the current NetworkTransport, or an error if disconnected.
+func (t *tcpTransport) current() (*raft.NetworkTransport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.trans == nil {
+		return nil, errTCPDisconnected
+	}
+	return t.trans, nil
+}
+
+func (t *tcpTransport) Consumer() <-chan raft.RPC {
+	return t.consumer
+}
+
+func (t *tcpTransport) LocalAddr() raft.ServerAddress {
+	return t.address
+}
+
+func (t *tcpTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	trans, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	return trans.AppendEntriesPipeline(id, target)
+}
+
+func (t *tcpTransport) AppendEntries(
+	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+	trans, err := t.current()
+	if err != nil {
+		return err
+	}
+	return trans.AppendEntries(id, target, args, resp)
+}
+
+func (t *tcpTransport) RequestVote(
+	id raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest,
+	resp *raft.RequestVoteResponse) error {
+	trans, err := t.current()
+	if err != nil {
+		return err
+	}
+	return trans.RequestVote(id, target, args, resp)
+}
+
+func (t *tcpTransport) InstallSnapshot(
+	id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest,
+	resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	trans, err := t.current()
+	if err != nil {
+		return err
+	}
+	return trans.InstallSnapshot(id, target, args, resp, data)
+}
+
+func (t *tcpTransport) EncodePeer(id raft.ServerID, addr raft.ServerAddress) []byte {
+	return []byte(addr)
+}
+
+func (t *tcpTransport) DecodePeer(buf []byte) raft.ServerAddress {
+	return raft.ServerAddress(buf)
+}
+
+func (t *tcpTransport) SetHeartbeatHandler(cb func(rpc raft.RPC)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.heartbeat = cb
+	if t.trans != nil {
+		t.trans.SetHeartbeatHandler(cb)
+	}
+}
+
+func (t *tcpTransport) TimeoutNow(
+	id raft.ServerID, target raft.ServerAddress, args *raft.TimeoutNowRequest,
+	resp *raft.TimeoutNowResponse) error {
+	trans, err := t.current()
+	if err != nil {
+		return err
+	}
+	return trans.TimeoutNow(id, target, args, resp)
+}
+
+// Close disconnects the transport for good.
+func (t *tcpTransport) Close() error {
+	t.Disconnect()
+	return nil
+}
+
+// StreamLayer over TCP which keeps track of its connections, closing all of
+// them when the listener gets closed.
+type tcpStream struct {
+	net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// Create a new TCP stream layer listening on the given address.
+func listenTCP(address string) (*tcpStream, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpStream{Listener: listener, conns: make(map[net.Conn]bool)}, nil
+}
+
+// Accept waits for the next inbound connection and tracks it.
+func (s *tcpStream) Accept() (net.Conn, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return s.track(conn)
+}
+
+// Dial creates a new outbound connection and tracks it.
+func (s *tcpStream) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return s.track(conn)
+}
+
+// Close the listener and all connections.
+func (s *tcpStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+
+	return s.Listener.Close()
+}
+
+// Track the given connection, closing it right away if the stream was
+// closed in the meantime.
+func (s *tcpStream) track(conn net.Conn) (net.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		conn.Close()
+		return nil, errTCPDisconnected
+	}
+	s.conns[conn] = true
+	return &tcpConn{Conn: conn, stream: s}, nil
+}
+
+// Connection tracked by a tcpStream.
+type tcpConn struct {
+	net.Conn
+	stream *tcpStream
+}
+
+// Close the connection and stop tracking it.
+func (c *tcpConn) Close() error {
+	c.stream.mu.Lock()
+	delete(c.stream.conns, c.Conn)
+	c.stream.mu.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Servers of a TCP cluster replicate logs over real sockets.
+func TestTCPCluster(t *testing.T) {
+	rafts, control := rafttest.TCPCluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(1), control.Commands("1"))
+	assert.Equal(t, uint64(1), control.Commands("2"))
+}
+
+// A killed server of a TCP cluster listens again on the same address once
+// restarted.
+func TestTCPCluster_KillAndRestart(t *testing.T) {
+	rafts, control := rafttest.TCPCluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	control.Kill(rafts["2"])
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	control.Restart(2)
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+
+	control.WaitIndex("2", future.Index(), time.Second)
+}
+
+// A firewalled server of a TCP cluster doesn't get any RPC until reconnected.
+func TestTCPCluster_DisconnectTCP(t *testing.T) {
+	rafts, control := rafttest.TCPCluster(t, rafttest.FSMs(3), rafttest.Latency(10.0), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	r := rafts["0"]
+	control.DisconnectTCP(rafts["2"])
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(0), control.Commands("2"))
+
+	control.ReconnectTCP(rafts["2"])
+	control.WaitIndex("2", future.Index(), time.Second)
+	assert.Equal(t, uint64(1), control.Commands("2"))
+}
@@ -34,6 +34,12 @@ type Term struct {
 	disconnected raft.ServerID
 }
 
+// Leader returns the raft instance of the server that was elected as leader
+// for this Term.
+func (t *Term) Leader() *raft.Raft {
+	return t.control.servers[t.id]
+}
+
 // When can be used to schedule a certain action when a certain expected
 // event occurs in the cluster during this Term.
 func (t *Term) When() *Event {
@@ -64,6 +70,8 @@ func (t *Term) Disconnect(id raft.ServerID) {
 
 	t.disconnected = id
 	t.control.network.Disconnect(t.id, id)
+	t.control.uptime.Partition(id)
+	t.control.faultInjected(FaultAction{Kind: FaultDisconnect, Target: id})
 }
 
 // Reconnect a previously disconnected follower.
@@ -74,9 +82,13 @@ func (t *Term) Reconnect(id raft.ServerID) {
 		t.control.t.Fatalf("raft-test: term: reconnect error: server %s was not disconnected", id)
 	}
 
+	t.disconnected = ""
+
 	// Reconnecting a server might end up in a new election round, so we
 	// have to be prepared for that.
 	t.control.network.Reconnect(t.id, id)
+	t.control.uptime.Heal(id)
+	t.control.faultHealed(FaultAction{Kind: FaultDisconnect, Target: id})
 	if t.control.waitLeadershipPropagated(t.id, t.leadership) {
 		// Leadership was not lost and all followers are back
 		// on track.
@@ -97,7 +109,7 @@ func (t *Term) Snapshot(id raft.ServerID) {
 	t.control.t.Helper()
 
 	r := t.control.servers[id]
-	if err := r.Snapshot().Error(); err != nil {
+	if err := t.control.await(r.Snapshot(), "server %s: snapshot", id); err != nil {
 		t.control.t.Fatalf("raft-test: term: snapshot error on server %s: %v", id, err)
 	}
 }
@@ -115,7 +127,7 @@ func (e *Event) Command(n uint64) *Dispatch {
 	e.term.control.t.Helper()
 
 	if e.isScheduled {
-		e.term.control.t.Fatal("raft-test: error: term event already scheduled")
+		e.term.control.t.Fatalf("raft-test: error: term event already scheduled")
 	}
 	e.isScheduled = true
 
@@ -139,7 +151,7 @@ func (d *Dispatch) Enqueued() *Action {
 	d.term.control.t.Helper()
 
 	if d.event != nil {
-		d.term.control.t.Fatal("raft-test: error: dispatch event already defined")
+		d.term.control.t.Fatalf("raft-test: error: dispatch event already defined")
 	}
 	d.event = d.term.control.whenCommandEnqueued(d.term.id, d.n)
 
@@ -155,7 +167,7 @@ func (d *Dispatch) Appended() *Action {
 	d.term.control.t.Helper()
 
 	if d.event != nil {
-		d.term.control.t.Fatal("raft-test: error: dispatch event already defined")
+		d.term.control.t.Fatalf("raft-test: error: dispatch event already defined")
 	}
 
 	d.event = d.term.control.whenCommandAppended(d.term.id, d.n)
@@ -172,7 +184,7 @@ func (d *Dispatch) Committed() *Action {
 	d.term.control.t.Helper()
 
 	if d.event != nil {
-		d.term.control.t.Fatal("raft-test: error: dispatch event already defined")
+		d.term.control.t.Fatalf("raft-test: error: dispatch event already defined")
 	}
 
 	d.event = d.term.control.whenCommandCommitted(d.term.id, d.n)
@@ -195,12 +207,12 @@ func (a *Action) Depose() {
 	//a.control.t.Logf(
 	//"raft-test: event: schedule depose server %s when command %d gets %s", a.id, a.n, a.phase)
 
-	a.term.control.deposing = make(chan struct{})
+	c := a.term.control
+	c.checkLeader(a.term.id)
+	c.deposing = make(chan error, 1)
 
-	go func() {
-		//c.t.Logf("raft-test: node %d: fsm: wait log command %d", i, n)
-		a.term.control.deposeUponEvent(a.event, a.term.id, a.term.leadership)
-	}()
+	timeout := maximumLeaderLeaseTimeout(c.confs)
+	go c.deposeUponEvent(a.event, a.term.id, a.term.leadership, timeout, c.deposing)
 }
 
 // Snapshot makes the action trigger a snapshot on the leader.
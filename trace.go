@@ -0,0 +1,228 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// RPCRecord is an RPC sent from a server to another, as recorded by the Trace
+// option.
+//
+// For RequestVote and InstallSnapshot RPCs, Last is the index of the last log
+// of the candidate or of the snapshot.
+type RPCRecord struct {
+	Type      RPCType
+	Source    raft.ServerID // Server that sent the RPC
+	Target    raft.ServerID // Server the RPC was sent to
+	Term      uint64        // Term of the sender
+	First     uint64        // Index of the first log carried, if any
+	Last      uint64        // Index of the last log carried, or of the log preceding them
+	Timestamp time.Time     // When the RPC was sent
+	Err       error         // Error returned by the transport, if any
+}
+
+// RPCTrace is a sequence of RPCs recorded by the Trace option, as returned by
+// Control.Trace().
+type RPCTrace []RPCRecord
+
+// Trace returns all RPCs recorded so far, in the order they were sent. The
+// Trace option must have been used.
+func (c *Control) Trace() RPCTrace {
+	c.t.Helper()
+
+	if c.tracer == nil {
+		c.t.Fatalf("raft-test: trace: RPCs are not being traced (use the Trace option)")
+	}
+	return c.tracer.Trace()
+}
+
+// From returns the RPCs sent by the server with the given ID.
+func (t RPCTrace) From(id raft.ServerID) RPCTrace {
+	return t.filter(func(record RPCRecord) bool { return record.Source == id })
+}
+
+// To returns the RPCs sent to the server with the given ID.
+func (t RPCTrace) To(id raft.ServerID) RPCTrace {
+	return t.filter(func(record RPCRecord) bool { return record.Target == id })
+}
+
+// OfType returns the RPCs of the given type.
+func (t RPCTrace) OfType(rpcType RPCType) RPCTrace {
+	return t.filter(func(record RPCRecord) bool { return record.Type == rpcType })
+}
+
+// Dump writes a table with the recorded RPCs to the given writer, one per
+// line, with timestamps relative to the first one.
+func (t RPCTrace) Dump(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "time\ttype\tsource\ttarget\tterm\tindexes\terror")
+	for _, record := range t {
+		indexes := fmt.Sprintf("%d", record.Last)
+		if record.First != 0 {
+			indexes = fmt.Sprintf("%d-%d", record.First, record.Last)
+		}
+		err := ""
+		if record.Err != nil {
+			err = record.Err.Error()
+		}
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			record.Timestamp.Sub(t[0].Timestamp), record.Type, record.Source, record.Target,
+			record.Term, indexes, err)
+	}
+	tw.Flush()
+}
+
+// String returns the same table written by Dump().
+func (t RPCTrace) String() string {
+	buffer := bytes.NewBuffer(nil)
+	t.Dump(buffer)
+	return buffer.String()
+}
+
+// Return the records for which the given function returns true.
+func (t RPCTrace) filter(f func(RPCRecord) bool) RPCTrace {
+	filtered := RPCTrace{}
+	for _, record := range t {
+		if f(record) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// Collect the RPCs recorded by the tracing transports of all servers.
+type rpcTracer struct {
+	mu      sync.Mutex
+	records []RPCRecord
+}
+
+// Add a record.
+func (t *rpcTracer) Add(record RPCRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.records = append(t.records, record)
+}
+
+// Return a copy of all records.
+func (t *rpcTracer) Trace() RPCTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	trace := append(RPCTrace{}, t.records...)
+	sort.SliceStable(trace, func(i, j int) bool {
+		return trace[i].Timestamp.Before(trace[j].Timestamp)
+	})
+	return trace
+}
+
+// Transport decorator recording all RPCs sent through it.
+type tracingTransport struct {
+	raft.Transport
+	id     raft.ServerID
+	tracer *rpcTracer
+}
+
+func (t *tracingTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	pipeline, err := t.Transport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingPipeline{AppendPipeline: pipeline, transport: t, target: id}, nil
+}
+
+func (t *tracingTransport) AppendEntries(
+	id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+	record := t.appendEntriesRecord(id, args)
+	record.Err = t.Transport.AppendEntries(id, target, args, resp)
+	t.tracer.Add(record)
+	return record.Err
+}
+
+func (t *tracingTransport) RequestVote(
+	id raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest,
+	resp *raft.RequestVoteResponse) error {
+	record := RPCRecord{
+		Type:      RPCRequestVote,
+		Source:    t.id,
+		Target:    id,
+		Term:      args.Term,
+		Last:      args.LastLogIndex,
+		Timestamp: time.Now(),
+	}
+	record.Err = t.Transport.RequestVote(id, target, args, resp)
+	t.tracer.Add(record)
+	return record.Err
+}
+
+func (t *tracingTransport) InstallSnapshot(
+	id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest,
+	resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	record := RPCRecord{
+		Type:      RPCInstallSnapshot,
+		Source:    t.id,
+		Target:    id,
+		Term:      args.Term,
+		Last:      args.LastLogIndex,
+		Timestamp: time.Now(),
+	}
+	record.Err = t.Transport.InstallSnapshot(id, target, args, resp, data)
+	t.tracer.Add(record)
+	return record.Err
+}
+
+// Return a record for the given AppendEntries RPC, sent now.
+func (t *tracingTransport) appendEntriesRecord(id raft.ServerID, args *raft.AppendEntriesRequest) RPCRecord {
+	record := RPCRecord{
+		Type:      RPCAppendEntries,
+		Source:    t.id,
+		Target:    id,
+		Term:      args.Term,
+		Last:      args.PrevLogEntry,
+		Timestamp: time.Now(),
+	}
+	if n := len(args.Entries); n > 0 {
+		record.First = args.Entries[0].Index
+		record.Last = args.Entries[n-1].Index
+	}
+	return record
+}
+
+// Pipeline decorator recording all AppendEntries RPCs sent through it.
+type tracingPipeline struct {
+	raft.AppendPipeline
+	transport *tracingTransport
+	target    raft.ServerID
+}
+
+func (p *tracingPipeline) AppendEntries(
+	args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) (raft.AppendFuture, error) {
+	record := p.transport.appendEntriesRecord(p.target, args)
+	future, err := p.AppendPipeline.AppendEntries(args, resp)
+	record.Err = err
+	p.transport.tracer.Add(record)
+	return future, err
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The RPCs exchanged by servers are recorded.
+func TestControl_Trace(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.Trace(), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+
+	future := rafts["0"].Apply([]byte{1}, time.Second)
+	require.NoError(t, future.Error())
+	control.Barrier()
+
+	trace := control.Trace()
+
+	votes := trace.OfType(rafttest.RPCRequestVote).From("0")
+	require.NotEmpty(t, votes)
+	assert.NotEqual(t, uint64(0), votes[0].Term)
+
+	found := false
+	for _, record := range trace.OfType(rafttest.RPCAppendEntries).From("0").To("1") {
+		if record.Err == nil && record.First <= future.Index() && record.Last >= future.Index() {
+			found = true
+		}
+	}
+	assert.True(t, found, "append entries for index %d not found:\n%s", future.Index(), trace)
+
+	assert.Empty(t, trace.From("1").OfType(rafttest.RPCAppendEntries))
+
+	buffer := bytes.NewBuffer(nil)
+	trace.Dump(buffer)
+	assert.Contains(t, buffer.String(), "request vote")
+	assert.Contains(t, buffer.String(), "append entries")
+}
+
+// Tracing must be enabled with the Trace option.
+func TestControl_Trace_NotEnabled(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	_, control := rafttest.Cluster(rafttest.NewReporter(buffer), rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	assert.Panics(t, func() { control.Trace() })
+	assert.Contains(t, buffer.String(), "use the Trace option")
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// TransferLeadership performs a planned handoff of leadership from the given
+// leader to the given follower, blocking until the follower is established as
+// the new leader. It returns the new Term, as Elect() does.
+//
+// The follower is first given up to the given timeout (the default one if zero,
+// see Duration()) to catch up with all the logs of the leader, which is then
+// deposed, so the follower can win the following election. Raft's own
+// LeadershipTransfer() API is not used, since cluster connectivity is driven by
+// Elect().
+func (c *Control) TransferLeadership(from, to *raft.Raft, timeout time.Duration) *Term {
+	c.t.Helper()
+
+	term, _ := c.transferLeadership(from, to, waitTimeout(c.t, timeout))
+	return term
+}
+
+// Implementation of TransferLeadership, also returning for how long the
+// cluster was left without a leader.
+func (c *Control) transferLeadership(from, to *raft.Raft, timeout time.Duration) (*Term, time.Duration) {
+	c.t.Helper()
+
+	leader := c.serverID(from, "transfer leadership")
+	follower := c.serverID(to, "transfer leadership")
+
+	if c.term == nil || c.term.id != leader {
+		c.t.Fatalf("raft-test: transfer leadership: server %s is not the leader", leader)
+	}
+	if follower == leader {
+		c.t.Fatalf("raft-test: transfer leadership: server %s is already the leader", leader)
+	}
+	if c.suffrage(leader, follower) != raft.Voter {
+		c.t.Fatalf("raft-test: transfer leadership: server %s is not a voter", follower)
+	}
+
+	c.logger.Debug(fmt.Sprintf("[DEBUG] raft-test: transfer leadership: from %s to %s", leader, follower))
+
+	// Make sure that the target has all logs of the leader, otherwise the
+	// other voters would reject its candidacy.
+	if err := c.await(from.Barrier(timeout), "server %s: barrier", leader); err != nil {
+		c.t.Fatalf("raft-test: transfer leadership: leader barrier: %v", err)
+	}
+	index := from.LastIndex()
+	if !c.poll(func() bool { return to.LastIndex() >= index }, timeout) {
+		c.t.Fatalf("raft-test: transfer leadership: server %s did not catch up with index %d within %s", follower, index, timeout)
+	}
+
+	start := time.Now()
+	c.depose()
+	term := c.Elect(follower)
+
+	return term, time.Since(start)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Leadership can be handed off to a follower, and back.
+func TestControl_TransferLeadership(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+
+	term := control.TransferLeadership(rafts["0"], rafts["1"], time.Second)
+	assert.Equal(t, rafts["1"], term.Leader())
+	assert.Equal(t, raft.Follower, rafts["0"].State())
+	require.NoError(t, rafts["1"].Apply([]byte{}, time.Second).Error())
+
+	control.TransferLeadership(rafts["1"], rafts["0"], time.Second)
+	require.NoError(t, rafts["0"].Apply([]byte{}, time.Second).Error())
+	control.Barrier()
+
+	assert.Equal(t, uint64(3), control.Commands("2"))
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Availability holds the bookkeeping of a single server, as returned by
+// DowntimeReport().
+type Availability struct {
+	Started     time.Time     // When the current (or last) instance started
+	Stopped     time.Time     // When the last instance stopped, zero if running
+	Starts      int           // Number of instances started so far
+	Crashes     int           // Number of instances that crashed
+	Up          time.Duration // Total time spent running
+	Down        time.Duration // Total time spent not running
+	Partitioned time.Duration // Total time spent running but disconnected
+}
+
+// Uptime returns for how long the current instance of the server with the
+// given ID has been running, or zero if it's not running.
+func (c *Control) Uptime(id raft.ServerID) time.Duration {
+	return c.uptime.Uptime(id)
+}
+
+// DowntimeReport returns the availability bookkeeping of all servers in the
+// cluster, from the moment they were first started until now.
+func (c *Control) DowntimeReport() map[raft.ServerID]Availability {
+	return c.uptime.Report()
+}
+
+// Track start, stop and partition times of all servers.
+type uptimeTracker struct {
+	mu      sync.Mutex
+	created time.Time
+	servers map[raft.ServerID]*uptimeRecord
+}
+
+// Bookkeeping of a single server.
+type uptimeRecord struct {
+	started     time.Time
+	stopped     time.Time
+	starts      int
+	crashes     int
+	up          time.Duration // Accumulated over previous instances
+	partitioned time.Duration // Accumulated over previous partitions
+	isolated    time.Time     // Start of the current partition, if any
+}
+
+func newUptimeTracker() *uptimeTracker {
+	return &uptimeTracker{
+		created: time.Now(),
+		servers: make(map[raft.ServerID]*uptimeRecord),
+	}
+}
+
+// Record that a new instance of the server with the given ID has started.
+func (u *uptimeTracker) Start(id raft.ServerID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	record, ok := u.servers[id]
+	if !ok {
+		record = &uptimeRecord{}
+		u.servers[id] = record
+	}
+	record.started = time.Now()
+	record.stopped = time.Time{}
+	record.starts++
+}
+
+// Record that the running instance of the server with the given ID has
+// stopped, either cleanly or because it crashed.
+func (u *uptimeTracker) Stop(id raft.ServerID, crashed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	record := u.servers[id]
+	if record == nil || !record.stopped.IsZero() {
+		return
+	}
+	now := time.Now()
+	record.heal(now)
+	record.stopped = now
+	record.up += now.Sub(record.started)
+	if crashed {
+		record.crashes++
+	}
+}
+
+// Record that the server with the given ID got disconnected.
+func (u *uptimeTracker) Partition(id raft.ServerID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if record := u.servers[id]; record != nil && record.isolated.IsZero() {
+		record.isolated = time.Now()
+	}
+}
+
+// Record that the server with the given ID got reconnected.
+func (u *uptimeTracker) Heal(id raft.ServerID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if record := u.servers[id]; record != nil {
+		record.heal(time.Now())
+	}
+}
+
+// Uptime of the current instance of the server with the given ID.
+func (u *uptimeTracker) Uptime(id raft.ServerID) time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	record := u.servers[id]
+	if record == nil || !record.stopped.IsZero() {
+		return 0
+	}
+	return time.Since(record.started)
+}
+
+// Report the availability of all servers.
+func (u *uptimeTracker) Report() map[raft.ServerID]Availability {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	report := make(map[raft.ServerID]Availability, len(u.servers))
+	for id, record := range u.servers {
+		up := record.up
+		if record.stopped.IsZero() {
+			up += now.Sub(record.started)
+		}
+		partitioned := record.partitioned
+		if !record.isolated.IsZero() {
+			partitioned += now.Sub(record.isolated)
+		}
+		report[id] = Availability{
+			Started:     record.started,
+			Stopped:     record.stopped,
+			Starts:      record.starts,
+			Crashes:     record.crashes,
+			Up:          up,
+			Down:        now.Sub(u.created) - up,
+			Partitioned: partitioned,
+		}
+	}
+	return report
+}
+
+// End the current partition, if any.
+func (r *uptimeRecord) heal(now time.Time) {
+	if r.isolated.IsZero() {
+		return
+	}
+	r.partitioned += now.Sub(r.isolated)
+	r.isolated = time.Time{}
+}
@@ -0,0 +1,454 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// WaitIndex blocks until the FSM of the server with the given ID has applied
+// all logs up to the given index.
+//
+// It fails the test if this doesn't happen within the given timeout (the
+// default one if zero, see Duration()), or as soon as it detects that the
+// server can never reach the index: because it was shut down, because it was
+// removed from the cluster configuration, or because the cluster has lost
+// quorum.
+//
+// On timeout the failure message includes the state of every server, as
+// returned by String(), and the most recent RPCs if the Trace option was used.
+func (c *Control) WaitIndex(id raft.ServerID, index uint64, timeout time.Duration) {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	c.waitIndex(context.Background(), id, index, c.newWaitDeadline(id, timeout).Expired)
+}
+
+// WaitIndexCtx is like WaitIndex, but it's bound to the given context instead
+// of a timeout.
+//
+// It fails the test if the context deadline expires, while it returns
+// context.Canceled if the context gets canceled first. If the context has no
+// deadline, the one of WaitIndex() with a zero timeout applies.
+func (c *Control) WaitIndexCtx(ctx context.Context, id raft.ServerID, index uint64) error {
+	c.t.Helper()
+
+	ctx, cancel := waitContext(ctx, c.t)
+	defer cancel()
+
+	return c.waitIndex(ctx, id, index, nil)
+}
+
+func (c *Control) waitIndex(ctx context.Context, id raft.ServerID, index uint64, expired func() bool) error {
+	c.t.Helper()
+
+	r := c.server(id)
+	if r == nil {
+		c.t.Fatalf("raft-test: wait index: server %s is shut down", id)
+	}
+	start := c.clock.Now()
+	cache := &configurationCache{}
+	for r.AppliedIndex() < index || !c.fsmApplied(id, index) {
+		if reason := c.unreachable(id, cache); reason != "" {
+			c.t.Fatalf("raft-test: wait index: server %s: can't reach index %d: %s", id, index, reason)
+		}
+		timedOut, err := waitTimedOut(ctx, expired)
+		if err != nil {
+			return err
+		}
+		if timedOut {
+			c.t.Errorf("\n\t%s", c.stacks())
+			c.t.Fatalf("raft-test: wait index: server %s: index %d not applied within %s (applied %d):\n%s", id, index, c.clock.Now().Sub(start), r.AppliedIndex(), c.diagnostics())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+// Return true if the FSM of the server with the given ID has applied all the
+// command logs up to the given index. Raft bumps its applied index before
+// handing logs to the FSM, so that alone is not enough.
+func (c *Control) fsmApplied(id raft.ServerID, index uint64) bool {
+	c.t.Helper()
+
+	// Command logs included in a snapshot were either applied by the FSM
+	// or restored along with the snapshot.
+	node := c.node(id)
+	first := uint64(0)
+	if snapshots, err := node.Snaps.List(); err == nil && len(snapshots) > 0 {
+		first = snapshots[0].Index
+	}
+
+	for i := index; i > first; i-- {
+		log := raft.Log{}
+		if err := node.Logs.GetLog(i, &log); err != nil {
+			return true // Compacted.
+		}
+		if log.Type == raft.LogCommand {
+			return c.watcher.LastIndex(id) >= i
+		}
+	}
+	return true
+}
+
+// WaitStableLeader blocks until exactly one server is the leader and all other
+// running servers in its configuration acknowledge it as such, and returns it.
+//
+// Unlike waiting for a server to acquire leadership, this doesn't return
+// during an election churn window, when a server thinks it is the leader but
+// the others don't follow it yet.
+//
+// It fails the test if this doesn't happen within the given timeout (the
+// default one if zero, see Duration()).
+func (c *Control) WaitStableLeader(timeout time.Duration) *raft.Raft {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	start := c.clock.Now()
+	expired := func() bool { return c.clock.Now().Sub(start) > timeout }
+
+	r, _ := c.waitStableLeader(context.Background(), expired)
+	return r
+}
+
+// WaitStableLeaderCtx is like WaitStableLeader, but it's bound to the given
+// context instead of a timeout.
+//
+// It fails the test if the context deadline expires, while it returns
+// context.Canceled if the context gets canceled first. If the context has no
+// deadline, the one of WaitStableLeader() with a zero timeout applies.
+func (c *Control) WaitStableLeaderCtx(ctx context.Context) (*raft.Raft, error) {
+	c.t.Helper()
+
+	ctx, cancel := waitContext(ctx, c.t)
+	defer cancel()
+
+	return c.waitStableLeader(ctx, nil)
+}
+
+func (c *Control) waitStableLeader(ctx context.Context, expired func() bool) (*raft.Raft, error) {
+	c.t.Helper()
+
+	start := c.clock.Now()
+	for {
+		if leader := c.stableLeader(); leader != "" {
+			return c.servers[leader], nil
+		}
+		timedOut, err := waitTimedOut(ctx, expired)
+		if err != nil {
+			return nil, err
+		}
+		if timedOut {
+			c.t.Fatalf("raft-test: wait stable leader: no stable leader within %s:\n%s", c.clock.Now().Sub(start), c.diagnostics())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Return the ID of the only leader, if all other running servers in its
+// configuration acknowledge it, or an empty string otherwise.
+func (c *Control) stableLeader() raft.ServerID {
+	c.t.Helper()
+
+	leader := raft.ServerID("")
+	for id, r := range c.servers {
+		if r.State() != raft.Leader {
+			continue
+		}
+		if leader != "" {
+			return ""
+		}
+		leader = id
+	}
+	if leader == "" {
+		return ""
+	}
+
+	address := c.network.Address(leader)
+	for _, server := range c.configuration(leader).Servers {
+		r, ok := c.servers[server.ID]
+		if !ok || server.ID == leader {
+			continue
+		}
+		if r.Leader() != address {
+			return ""
+		}
+	}
+
+	return leader
+}
+
+// RestoreSource tells how a server came to restore its FSM from a snapshot.
+type RestoreSource int
+
+// Available restore sources.
+const (
+	// The snapshot was shipped by a leader with an InstallSnapshot RPC.
+	RestoreInstall RestoreSource = iota
+
+	// The snapshot was found in the server's own snapshot store when it
+	// was restarted, see Restart().
+	RestoreBoot
+)
+
+func (s RestoreSource) String() string {
+	switch s {
+	case RestoreInstall:
+		return "install"
+	case RestoreBoot:
+		return "boot"
+	default:
+		return fmt.Sprintf("source %d", int(s))
+	}
+}
+
+// Restore holds information about an FSM restore, see WaitRestore().
+type Restore struct {
+	Source RestoreSource // How the snapshot got to the server
+	Leader raft.ServerID // Server that shipped the snapshot, for RestoreInstall
+	Index  uint64        // Last log index included in the snapshot
+	Term   uint64        // Term of the last log included in the snapshot
+}
+
+func (r Restore) String() string {
+	s := fmt.Sprintf("%s restore at index %d term %d", r.Source, r.Index, r.Term)
+	if r.Source == RestoreInstall {
+		s += fmt.Sprintf(" from server %s", r.Leader)
+	}
+	return s
+}
+
+// WaitRestore blocks until the FSM of the server with the given ID performs a
+// restore that was not yet returned by a previous call, and returns
+// information about the latest restore, so tests can assert the precise
+// recovery path taken.
+//
+// It fails the test if this doesn't happen within the given timeout (the
+// default one if zero, see Duration()).
+func (c *Control) WaitRestore(id raft.ServerID, timeout time.Duration) Restore {
+	c.t.Helper()
+
+	timeout = waitTimeout(c.t, timeout)
+
+	restore, _ := c.waitRestore(context.Background(), id, c.newWaitDeadline(id, timeout).Expired)
+	return restore
+}
+
+// WaitRestoreCtx is like WaitRestore, but it's bound to the given context
+// instead of a timeout.
+//
+// It fails the test if the context deadline expires, while it returns
+// context.Canceled if the context gets canceled first. If the context has no
+// deadline, the one of WaitRestore() with a zero timeout applies.
+func (c *Control) WaitRestoreCtx(ctx context.Context, id raft.ServerID) (Restore, error) {
+	c.t.Helper()
+
+	ctx, cancel := waitContext(ctx, c.t)
+	defer cancel()
+
+	return c.waitRestore(ctx, id, nil)
+}
+
+func (c *Control) waitRestore(ctx context.Context, id raft.ServerID, expired func() bool) (Restore, error) {
+	c.t.Helper()
+
+	n := c.waitedRestores[id] + 1
+	start := c.clock.Now()
+	for c.Restores(id) < n {
+		timedOut, err := waitTimedOut(ctx, expired)
+		if err != nil {
+			return Restore{}, err
+		}
+		if timedOut {
+			c.t.Fatalf("raft-test: wait restore: server %s: no restore within %s (%d restores):\n%s", id, c.clock.Now().Sub(start), c.Restores(id), c.diagnostics())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	n = c.Restores(id)
+	c.waitedRestores[id] = n
+
+	if restore, ok := c.bootRestores[id][n]; ok {
+		return restore, nil
+	}
+
+	snapshot, ok := c.network.LastSnapshotSentTo(id)
+	if !ok {
+		c.t.Fatalf("raft-test: wait restore: server %s: no snapshot was sent to it", id)
+	}
+
+	return Restore{
+		Source: RestoreInstall,
+		Leader: snapshot.Source,
+		Index:  snapshot.Index,
+		Term:   snapshot.Term,
+	}, nil
+}
+
+// Check whether a wait bound to the given context and expiration function
+// (if any) should stop. Returns context.Canceled if the context was
+// canceled, or true if it expired or the expiration function returns true.
+func waitTimedOut(ctx context.Context, expired func() bool) (bool, error) {
+	switch ctx.Err() {
+	case context.Canceled:
+		return false, ctx.Err()
+	case context.DeadlineExceeded:
+		return true, nil
+	}
+	return expired != nil && expired(), nil
+}
+
+// SetStallTimeout makes the Wait* methods of Control progress-aware: as long as
+// the server being waited for makes observable progress (its applied index
+// increases, its FSM applies a log, or snapshot data is being sent to it),
+// their deadline is extended so that they only fail after no progress was made
+// for the given amount of time. It reduces false timeouts on slow machines
+// without inflating all timeouts.
+//
+// The deadline is never extended past the test deadline, if any. A zero value
+// (the default) disables the behavior.
+func (c *Control) SetStallTimeout(timeout time.Duration) {
+	c.stallTimeout = timeout
+}
+
+// Deadline of a wait on a certain server, possibly extended as long as the
+// server makes progress.
+type waitDeadline struct {
+	control  *Control
+	id       raft.ServerID
+	deadline time.Time // Current deadline
+	limit    time.Time // Hard deadline, which is never extended
+	applied  uint64    // Applied index at the last check
+	fsm      uint64    // Index of the last log applied by the FSM at the last check
+	bytes    uint64    // Snapshot bytes sent at the last check
+}
+
+func (c *Control) newWaitDeadline(id raft.ServerID, timeout time.Duration) *waitDeadline {
+	c.t.Helper()
+
+	r := c.server(id)
+	if r == nil {
+		c.t.Fatalf("raft-test: wait: server %s is shut down", id)
+	}
+	now := c.clock.Now()
+	return &waitDeadline{
+		control:  c,
+		id:       id,
+		deadline: now.Add(timeout),
+		limit:    now.Add(timeoutBudget(c.t, 24*time.Hour)),
+		applied:  r.AppliedIndex(),
+		fsm:      c.watcher.LastIndex(id),
+		bytes:    c.network.SnapshotBytesSentTo(id),
+	}
+}
+
+// Expired returns true if the deadline has expired, extending it first if
+// progress was made since the last check and the wait is progress-aware.
+func (d *waitDeadline) Expired() bool {
+	now := d.control.clock.Now()
+
+	if r := d.control.server(d.id); r != nil && d.control.stallTimeout != 0 {
+		stall := d.control.stallTimeout
+		applied := r.AppliedIndex()
+		fsm := d.control.watcher.LastIndex(d.id)
+		bytes := d.control.network.SnapshotBytesSentTo(d.id)
+		if applied != d.applied || fsm != d.fsm || bytes != d.bytes {
+			d.applied = applied
+			d.fsm = fsm
+			d.bytes = bytes
+			if extended := now.Add(stall); extended.After(d.deadline) {
+				d.deadline = extended
+			}
+		}
+	}
+
+	if d.deadline.After(d.limit) {
+		d.deadline = d.limit
+	}
+
+	return now.After(d.deadline)
+}
+
+// Latest configuration of a server, along with its last log index when it was
+// fetched, so it's only fetched again when new logs get appended.
+type configurationCache struct {
+	id            raft.ServerID
+	index         uint64
+	configuration *raft.Configuration
+}
+
+// Return a description of the reason why the server with the given ID can't
+// make any further progress, or an empty string if it still can.
+func (c *Control) unreachable(id raft.ServerID, cache *configurationCache) string {
+	c.t.Helper()
+
+	r := c.server(id)
+	if r == nil || r.State() == raft.Shutdown {
+		return "server is shut down"
+	}
+
+	// Use the configuration of the leader, if any, since a removed server
+	// never learns about its own removal.
+	leader := c.leader()
+	if leader == "" {
+		leader = id
+	}
+	source := c.server(leader)
+	if source == nil {
+		return ""
+	}
+	index := source.LastIndex()
+	if cache.configuration == nil || cache.id != leader || cache.index != index {
+		future := source.GetConfiguration()
+		if err := c.await(future, "server %s: wait get configuration", leader); err != nil {
+			return fmt.Sprintf("can't get configuration of server %s: %v", leader, err)
+		}
+		configuration := future.Configuration()
+		cache.id = leader
+		cache.index = index
+		cache.configuration = &configuration
+	}
+
+	member := false
+	voters := 0
+	running := 0
+	servers := c.running()
+	for _, server := range cache.configuration.Servers {
+		if server.ID == id {
+			member = true
+		}
+		if server.Suffrage != raft.Voter {
+			continue
+		}
+		voters++
+		if other, ok := servers[server.ID]; ok && other.State() != raft.Shutdown {
+			running++
+		}
+	}
+	if !member {
+		return "server was removed from the configuration"
+	}
+	if running < voters/2+1 {
+		return fmt.Sprintf("cluster has lost quorum (%d of %d voters running)", running, voters)
+	}
+
+	return ""
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// SetFutureTimeout sets the maximum amount of time that harness helpers (such
+// as Barrier) wait for a raft future to resolve. If the bound is exceeded the
+// test fails with a dump of the cluster state and of all futures that are
+// still outstanding, instead of hanging until go test's own timeout.
+//
+// The default is 5 seconds, scaled by GO_RAFT_TEST_LATENCY. In any case the
+// bound never exceeds the time left before the test deadline, if any.
+func (c *Control) SetFutureTimeout(timeout time.Duration) {
+	c.watchdog.mu.Lock()
+	defer c.watchdog.mu.Unlock()
+	c.watchdog.timeout = timeout
+}
+
+// Keep track of raft futures that harness helpers are waiting for.
+type watchdog struct {
+	mu      sync.Mutex
+	timeout time.Duration             // Maximum time a future may stay unresolved
+	serial  uint64                    // Serial number of the last tracked future
+	pending map[uint64]*pendingFuture // Futures not yet resolved, by serial
+}
+
+// A future that a harness helper is waiting for.
+type pendingFuture struct {
+	what  string    // Human readable description of the future
+	start time.Time // When we started waiting
+}
+
+func newWatchdog() *watchdog {
+	return &watchdog{
+		timeout: Duration(5 * time.Second),
+		pending: make(map[uint64]*pendingFuture),
+	}
+}
+
+// Start tracking a future, returning its serial number and the current bound.
+func (w *watchdog) track(what string) (uint64, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.serial++
+	w.pending[w.serial] = &pendingFuture{what: what, start: time.Now()}
+
+	return w.serial, w.timeout
+}
+
+// Stop tracking the future with the given serial number.
+func (w *watchdog) untrack(serial uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.pending, serial)
+}
+
+// Return a description of all outstanding futures, oldest first.
+func (w *watchdog) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	serials := make([]uint64, 0, len(w.pending))
+	for serial := range w.pending {
+		serials = append(serials, serial)
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	lines := make([]string, len(serials))
+	for i, serial := range serials {
+		future := w.pending[serial]
+		lines[i] = fmt.Sprintf("%s (pending for %s)", future.what, time.Since(future.start))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Wait for the given future to resolve and return its error. The test fails
+// if the future does not resolve within the watchdog bound.
+func (c *Control) await(future raft.Future, format string, a ...interface{}) error {
+	c.t.Helper()
+
+	what := fmt.Sprintf(format, a...)
+	serial, timeout := c.watchdog.track(what)
+	defer c.watchdog.untrack(serial)
+
+	timeout = timeoutBudget(c.t, timeout)
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- future.Error()
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+	}
+
+	c.t.Errorf("\n\t%s", c.stacks())
+	c.t.Fatalf("raft-test: watchdog: %s: unresolved after %s\noutstanding futures:\n%s\ncluster:\n%s", what, timeout, c.watchdog, c.diagnostics())
+
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest
+
+import (
+	"github.com/hashicorp/raft"
+)
+
+// FSMWatcher notifies about the progress of the FSMs of a cluster, so tests
+// can block on specific FSM events instead of polling. Use
+// Control.FSMWatcher() to get one.
+//
+// Only command logs applied through the FSM's Apply() method are observed:
+// restoring an FSM from a snapshot doesn't advance the index reported by
+// LastApplied() until the next command log is applied.
+type FSMWatcher struct {
+	control *Control
+}
+
+// FSMWatcher returns an FSMWatcher for the servers of this cluster.
+func (c *Control) FSMWatcher() *FSMWatcher {
+	return &FSMWatcher{control: c}
+}
+
+// WhenApplied returns a channel that gets closed as soon as the FSM of the
+// given server applies the command log with the given index, or a later one.
+// If that already happened, the channel is closed right away.
+func (w *FSMWatcher) WhenApplied(r *raft.Raft, index uint64) <-chan struct{} {
+	w.control.t.Helper()
+
+	id := w.control.serverID(r, "fsm watcher")
+	return w.control.watcher.WhenIndex(id, index)
+}
+
+// LastApplied returns the index of the last command log applied by the FSM of
+// the given server.
+func (w *FSMWatcher) LastApplied(r *raft.Raft) uint64 {
+	w.control.t.Helper()
+
+	id := w.control.serverID(r, "fsm watcher")
+	return w.control.watcher.LastIndex(id)
+}
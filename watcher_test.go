@@ -0,0 +1,61 @@
+// Copyright 2017 Canonical Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/raft-test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// FSM progress can be observed with notifications.
+func TestFSMWatcher(t *testing.T) {
+	rafts, control := rafttest.Cluster(t, rafttest.FSMs(3), rafttest.DiscardLogger())
+	defer control.Close()
+
+	control.Elect("0")
+	watcher := control.FSMWatcher()
+
+	r := rafts["0"]
+	future := r.Apply([]byte{}, time.Second)
+	require.NoError(t, future.Error())
+	index := future.Index()
+
+	ch := watcher.WhenApplied(rafts["1"], index+1)
+	select {
+	case <-ch:
+		t.Fatal("notified before the index was applied")
+	default:
+	}
+
+	require.NoError(t, r.Apply([]byte{}, time.Second).Error())
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("not notified after the index was applied")
+	}
+	assert.Equal(t, index+1, watcher.LastApplied(rafts["1"]))
+
+	// Indexes already applied are notified right away.
+	select {
+	case <-watcher.WhenApplied(rafts["1"], index):
+	default:
+		t.Fatal("not notified for an index already applied")
+	}
+}